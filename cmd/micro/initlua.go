@@ -51,6 +51,7 @@ func luaImportMicro() *lua.LTable {
 	ulua.L.SetField(pkg, "InfoBar", luar.New(ulua.L, action.GetInfoBar))
 	ulua.L.SetField(pkg, "Log", luar.New(ulua.L, log.Println))
 	ulua.L.SetField(pkg, "SetStatusInfoFn", luar.New(ulua.L, display.SetStatusInfoFnLua))
+	ulua.L.SetField(pkg, "RegisterStatusPlaceholder", luar.New(ulua.L, display.RegisterStatusPlaceholder))
 	ulua.L.SetField(pkg, "CurPane", luar.New(ulua.L, func() action.Pane {
 		return action.MainTab().CurPane()
 	}))
@@ -87,6 +88,9 @@ func luaImportMicroConfig() *lua.LTable {
 	ulua.L.SetField(pkg, "OptionComplete", luar.New(ulua.L, action.OptionComplete))
 	ulua.L.SetField(pkg, "OptionValueComplete", luar.New(ulua.L, action.OptionValueComplete))
 	ulua.L.SetField(pkg, "NoComplete", luar.New(ulua.L, nil))
+	ulua.L.SetField(pkg, "RegisterCompleter", luar.New(ulua.L, buffer.RegisterCompleter))
+	ulua.L.SetField(pkg, "RegisterPrimaryCompleter", luar.New(ulua.L, buffer.RegisterPrimaryCompleter))
+	ulua.L.SetField(pkg, "UnregisterCompleter", luar.New(ulua.L, buffer.UnregisterCompleter))
 	ulua.L.SetField(pkg, "TryBindKey", luar.New(ulua.L, action.TryBindKey))
 	ulua.L.SetField(pkg, "Reload", luar.New(ulua.L, action.ReloadConfig))
 	ulua.L.SetField(pkg, "AddRuntimeFileFromMemory", luar.New(ulua.L, config.PluginAddRuntimeFileFromMemory))
@@ -104,6 +108,7 @@ func luaImportMicroConfig() *lua.LTable {
 	ulua.L.SetField(pkg, "GetGlobalOption", luar.New(ulua.L, config.GetGlobalOption))
 	ulua.L.SetField(pkg, "SetGlobalOption", luar.New(ulua.L, action.SetGlobalOption))
 	ulua.L.SetField(pkg, "SetGlobalOptionNative", luar.New(ulua.L, action.SetGlobalOptionNative))
+	ulua.L.SetField(pkg, "ResetOption", luar.New(ulua.L, config.ResetOption))
 	ulua.L.SetField(pkg, "ConfigDir", luar.New(ulua.L, config.ConfigDir))
 
 	return pkg
@@ -155,6 +160,7 @@ func luaImportMicroBuffer() *lua.LTable {
 		return buffer.NewBufferFromFile(path, buffer.BTDefault)
 	}))
 	ulua.L.SetField(pkg, "ByteOffset", luar.New(ulua.L, loc.ByteOffset))
+	ulua.L.SetField(pkg, "ConvertCompletions", luar.New(ulua.L, buffer.ConvertCompletions))
 	ulua.L.SetField(pkg, "Log", luar.New(ulua.L, buffer.WriteLog))
 	ulua.L.SetField(pkg, "LogBuf", luar.New(ulua.L, buffer.GetLogBuf))
 	ulua.L.SetField(pkg, "FindBufferByID", luar.New(ulua.L, buffer.FindBufferByID))
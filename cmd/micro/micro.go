@@ -278,6 +278,8 @@ func main() {
 
 	DoPluginFlags()
 
+	lsp.SetMaxMessageSize(config.GetGlobalOption("lspmaxmessage").(float64))
+
 	err = screen.Init()
 	if err != nil {
 		fmt.Println(err)
@@ -432,7 +434,6 @@ func DoEvent() {
 	screen.Screen.HideCursor()
 	action.Tabs.Display()
 	for _, ep := range action.MainTab().Panes { ep.Display() }
-	action.MainTab().Display()
 	action.InfoBar.Display()
 
 	overlay.DisplayOverlays()
@@ -445,6 +446,16 @@ func DoEvent() {
 		ulua.Lock.Lock()
 		f.Function(f.Output, f.Args)
 		ulua.Lock.Unlock()
+	case req := <-lsp.EditRequests:
+		ulua.Lock.Lock()
+		var err error = errors.New("no pane to apply the edit in")
+		if h := action.MainTab().CurPane(); h != nil {
+			if err = h.ApplyWorkspaceEdits(req.Server, req.Edit); err != nil {
+				action.InfoBar.Error(err)
+			}
+		}
+		req.Done(err)
+		ulua.Lock.Unlock()
 	case <-config.Autosave:
 		ulua.Lock.Lock()
 		for _, b := range buffer.OpenBuffers {
@@ -12,8 +12,11 @@ import (
 	"github.com/zyedidia/micro/v2/internal/action"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/lsp"
 	"github.com/zyedidia/micro/v2/internal/screen"
 	"github.com/zyedidia/tcell/v2"
+	protocol "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
 )
 
 var tempDir string
@@ -328,6 +331,29 @@ func TestSearchAndReplace(t *testing.T) {
 	assert.Equal(t, srTest3, string(data))
 }
 
+func TestApplyWorkspaceEdit(t *testing.T) {
+	file, err := createTestFile("micro_workspace_edit_test", "base content")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(file)
+
+	insert := []protocol.TextEdit{{NewText: "new "}}
+	h := action.MainTab().CurPane()
+	// files that aren't open are opened to be edited
+	err = h.ApplyWorkspaceEdits(&lsp.Server{}, lsp.WorkspaceEdit{
+		Changes: map[uri.URI][]protocol.TextEdit{uri.File(file): insert},
+	})
+	assert.NoError(t, err)
+
+	b := action.FindBuffer(file)
+	if assert.NotNil(t, b) {
+		assert.Equal(t, "new base content", string(b.Bytes()))
+	}
+
+}
+
 func TestMultiCursor(t *testing.T) {
 	// TODO
 }
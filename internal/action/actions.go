@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
-	"strconv"
 
 
 	shellquote "github.com/kballard/go-shellquote"
@@ -139,17 +141,9 @@ func (h *BufPane) MouseRelease(e *tcell.EventMouse) bool {
 	// }
 	b := h.Buf
 
-	gutterOffset := 1
-	if b.Settings["diffgutter"].(bool) {
-		gutterOffset++
-	}
-	if b.Settings["ruler"].(bool) {
-		gutterOffset += len(strconv.Itoa(b.LinesNum()))
-	}
-
 	mx, my := e.Position()
 
-	isInGutter := mx < gutterOffset
+	isInGutter := h.InGutter(mx)
 	mouseLoc := h.LocFromVisual(buffer.Loc{mx, my})
 
 	b.HasSuggestions = false
@@ -182,24 +176,85 @@ func (h *BufPane) MouseRelease(e *tcell.EventMouse) bool {
 	return true
 }
 
+// ContextMenu opens a menu of actions at the location of the click. The
+// cursor is moved to the click, unless it is inside the selection, which
+// the actions then apply to
+func (h *BufPane) ContextMenu(e *tcell.EventMouse) bool {
+	mx, my := e.Position()
+	// ignore click on the status line
+	if my >= h.BufView().Y+h.BufView().Height || h.InGutter(mx) {
+		return false
+	}
+
+	mouseLoc := h.LocFromVisual(buffer.Loc{X: mx, Y: my})
+	if !h.Cursor.HasSelection() || !mouseLoc.Between(h.Cursor.CurSelection[0], h.Cursor.CurSelection[1]) {
+		h.Buf.ClearCursors()
+		h.Cursor = h.Buf.GetActiveCursor()
+		h.Cursor.ResetSelection()
+		h.Cursor.Loc = mouseLoc
+		h.Cursor.StoreVisualX()
+		h.Relocate()
+	}
+
+	sel := h.Cursor.HasSelection()
+	hasLSP := h.Buf.HasLSP()
+	run := func(action func() bool) func() {
+		return func() {
+			action()
+			h.Relocate()
+		}
+	}
+	overlay.ContextMenu([]overlay.MenuAction{
+		{Text: "Cut", Run: run(h.Cut), Disabled: !sel},
+		{Text: "Copy", Run: run(h.Copy), Disabled: !sel},
+		{Text: "Paste", Run: run(h.Paste)},
+		{Text: "Go to Definition", Run: run(h.GotoDefinition), Disabled: !hasLSP},
+		{Text: "Find References", Run: run(h.FindReferences), Disabled: !hasLSP},
+		{Text: "Rename", Run: run(h.Rename), Disabled: !hasLSP},
+		{Text: "Format Selection", Run: run(h.AutoFormat), Disabled: !sel || !hasLSP},
+	}, overlay.V2{Loc: buffer.Loc{X: mx, Y: my + 1}})
+	return true
+}
+
+// smoothScroll runs f, which scrolls the view, and animates the scroll if
+// the smoothscroll option is on
+func (h *BufPane) smoothScroll(f func()) {
+	from := h.GetView().StartLine
+	f()
+	h.AnimateScroll(from)
+}
+
 // ScrollUpAction scrolls the view up
 func (h *BufPane) ScrollUpAction() bool {
-	h.ScrollUp(util.IntOpt(h.Buf.Settings["scrollspeed"]))
+	h.smoothScroll(func() {
+		h.ScrollUp(util.IntOpt(h.Buf.Settings["scrollspeed"]))
+	})
 	return true
 }
 
 // ScrollDownAction scrolls the view up
 func (h *BufPane) ScrollDownAction() bool {
-	h.ScrollDown(util.IntOpt(h.Buf.Settings["scrollspeed"]))
+	h.smoothScroll(func() {
+		h.ScrollDown(util.IntOpt(h.Buf.Settings["scrollspeed"]))
+	})
 	return true
 }
 
 // Center centers the view on the cursor
 func (h *BufPane) Center() bool {
-	v := h.GetView()
-	v.StartLine = h.Scroll(h.SLocFromLoc(h.Cursor.Loc), -h.BufView().Height/2)
-	h.SetView(v)
-	h.ScrollAdjust()
+	h.CenterCursor()
+	return true
+}
+
+// CursorToTop scrolls the view so that the cursor line is at the top
+func (h *BufPane) CursorToTop() bool {
+	h.TopCursor()
+	return true
+}
+
+// CursorToBottom scrolls the view so that the cursor line is at the bottom
+func (h *BufPane) CursorToBottom() bool {
+	h.BottomCursor()
 	return true
 }
 
@@ -795,16 +850,12 @@ func (h *BufPane) Autocomplete() bool {
 	}
 	r := h.Cursor.RuneUnder(h.Cursor.X)
 	prev := h.Cursor.RuneUnder(h.Cursor.X - 1)
-	if !util.IsAutocomplete(prev) || !util.IsNonAlphaNumeric(r) {
+	if !util.IsAutocomplete(prev) || !b.IsNonWordChar(r) {
 		// don't autocomplete if cursor is on alpha numeric character (middle of a word)
 		return false
 	}
 
-	ret := true
-	if !b.Autocomplete(buffer.LSPComplete) {
-		ret = b.Autocomplete(buffer.BufferComplete)
-	}
-	if ret {
+	if b.Autocomplete(buffer.DefaultComplete) {
 		h.displayCompletionDoc()
 	}
 	return true
@@ -1400,6 +1451,27 @@ func (h *BufPane) JumpLine() bool {
 	return true
 }
 
+// GotoLine opens an overlay asking for a 'line' or 'line:col' position and
+// moves the cursor there
+func (h *BufPane) GotoLine() bool {
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok {
+		InfoBar.Error("BufPane does not have a BufWindow")
+		return false
+	}
+
+	overlay.PromptMenuValidate("Goto:", "", func(resp string) error {
+		l, err := parseGotoLoc(resp, h.Buf)
+		if err != nil {
+			return err
+		}
+		h.RemoveAllMultiCursors()
+		h.GotoLoc(l)
+		return nil
+	}, overlay.CursorAnchor{Window: bw})
+	return true
+}
+
 // Start moves the viewport to the start of the buffer
 func (h *BufPane) Start() bool {
 	v := h.GetView()
@@ -1418,14 +1490,18 @@ func (h *BufPane) End() bool {
 
 // PageUp scrolls the view up a page
 func (h *BufPane) PageUp() bool {
-	h.ScrollUp(h.BufView().Height)
+	h.smoothScroll(func() {
+		h.ScrollUp(h.BufView().Height)
+	})
 	return true
 }
 
 // PageDown scrolls the view down a page
 func (h *BufPane) PageDown() bool {
-	h.ScrollDown(h.BufView().Height)
-	h.ScrollAdjust()
+	h.smoothScroll(func() {
+		h.ScrollDown(h.BufView().Height)
+		h.ScrollAdjust()
+	})
 	return true
 }
 
@@ -1481,14 +1557,18 @@ func (h *BufPane) CursorPageDown() bool {
 
 // HalfPageUp scrolls the view up half a page
 func (h *BufPane) HalfPageUp() bool {
-	h.ScrollUp(h.BufView().Height / 2)
+	h.smoothScroll(func() {
+		h.ScrollUp(h.BufView().Height / 2)
+	})
 	return true
 }
 
 // HalfPageDown scrolls the view down half a page
 func (h *BufPane) HalfPageDown() bool {
-	h.ScrollDown(h.BufView().Height / 2)
-	h.ScrollAdjust()
+	h.smoothScroll(func() {
+		h.ScrollDown(h.BufView().Height / 2)
+		h.ScrollAdjust()
+	})
 	return true
 }
 
@@ -1519,6 +1599,23 @@ func (h *BufPane) ToggleRuler() bool {
 	return true
 }
 
+// ToggleReadOnly locks or unlocks the buffer for editing. Only file buffers
+// can be unlocked
+func (h *BufPane) ToggleReadOnly() bool {
+	if h.Buf.Type.Kind != buffer.BTDefault.Kind {
+		InfoBar.Error("Only file buffers can be unlocked")
+		return false
+	}
+	if !h.Buf.Type.Readonly {
+		h.Buf.SetReadOnly(true)
+		InfoBar.Message("Enabled readonly")
+	} else {
+		h.Buf.SetReadOnly(false)
+		InfoBar.Message("Disabled readonly")
+	}
+	return true
+}
+
 // ClearStatus clears the messenger bar
 func (h *BufPane) ClearStatus() bool {
 	InfoBar.Message("")
@@ -1974,6 +2071,71 @@ func (h *BufPane) Tooltip() bool {
 	return true
 }
 
+// scheduleHover shows the hover information at the cursor once it rests
+// there for hoverdelay milliseconds. The servers are queried off the main
+// loop, and the result is dropped if the cursor has moved in the meantime
+func (h *BufPane) scheduleHover() {
+	delay := h.Buf.Settings["hoverdelay"].(float64)
+	loc := h.Buf.GetActiveCursor().Loc
+	if loc == h.hoverLoc && h.hoverTimer != nil {
+		return
+	}
+	h.hoverLoc = loc
+	if h.hoverTimer != nil {
+		h.hoverTimer.Stop()
+	}
+	if delay <= 0 || !h.Buf.HasLSP() || h.Buf.NumCursors() > 1 {
+		h.hoverTimer = nil
+		return
+	}
+
+	// the buffer is only read here, on the main loop
+	query := h.Buf.HoverQuery(h.Buf.GetActiveCursor().ToPos())
+	edits := h.Buf.Edits()
+	h.hoverTimer = time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		tip, err := query.Cached()
+		if err != nil || tip == "" {
+			return
+		}
+		shell.Jobs <- shell.JobFunction{Function: func(tip string, _ []interface{}) {
+			if !h.IsActive() || h.Buf.GetActiveCursor().Loc != loc || h.Buf.Edits() != edits {
+				return
+			}
+			if bw, ok := h.BWindow.(*display.BufWindow); ok {
+				overlay.Tooltip(tip, overlay.CursorAnchor{Window: bw})
+			}
+		}, Output: tip}
+	})
+}
+
+// DiagnosticInfo shows the full message, source and code of the
+// diagnostics under the cursor in a tooltip
+func (h *BufPane) DiagnosticInfo() bool {
+	diags := h.Buf.DiagnosticsAt(h.Cursor.Loc)
+	if len(diags) == 0 {
+		InfoBar.Message("No diagnostics under the cursor")
+		return false
+	}
+
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok {
+		InfoBar.Error("BufPane does not have a BufWindow")
+		return false
+	}
+
+	sections := make([]overlay.TooltipSection, len(diags))
+	for i := range diags {
+		d := &diags[i]
+		sections[i] = overlay.TooltipSection{
+			Header:      lsp.DiagnosticHeader(d),
+			HeaderStyle: lsp.Style(d),
+			Text:        d.Message,
+		}
+	}
+	overlay.SectionTooltip(sections, overlay.CursorAnchor{Window: bw})
+	return true
+}
+
 func (h *BufPane) Rename() bool {
 	b := h.Buf
 	rename_symbol, server, err := b.GetRenameSymbol()
@@ -1997,7 +2159,7 @@ func (h *BufPane) Rename() bool {
 			if server == nil {
 				h.ReplaceAllCmd([]string{ rename_symbol, new_name, "-l" })
 			} else {
-				res, err := server.RenameSymbol(b.AbsPath, b.GetActiveCursor().ToPos(), new_name)
+				res, err := server.RenameSymbol(b.AbsPath, b.LSPPos(server, b.GetActiveCursor().Loc), new_name)
 				if err != nil {
 					InfoBar.Error(err)
 					return
@@ -2006,7 +2168,7 @@ func (h *BufPane) Rename() bool {
 					InfoBar.Error("Cannot rename '" + rename_symbol + "'")
 					return
 				}
-				h.ApplyWorkspaceEdits(res)
+				h.previewRename(server, res)
 			}
 		},
 	)
@@ -2014,6 +2176,79 @@ func (h *BufPane) Rename() bool {
 	return true
 }
 
+// renameChange is one of the changes of a rename listed by previewRename:
+// a text edit, or a resource operation when op is set
+type renameChange struct {
+	edit lsp.FileEdit
+	op   lsp.DocumentChange
+}
+
+// previewRename lists the edits and the file operations of a rename that
+// changes other files than the current one, and applies the ones the user
+// leaves checked. Renames within the current file, and renames that only
+// operate on files, are applied directly
+func (h *BufPane) previewRename(s *lsp.Server, edit lsp.WorkspaceEdit) {
+	edits := lsp.FileEdits(edit)
+	ops := lsp.ResourceOperations(edit)
+	files := make(map[string]bool)
+	for _, e := range edits {
+		files[e.URI.Filename()] = true
+	}
+
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok || len(edits) == 0 || len(ops) == 0 && len(files) == 1 && files[h.Buf.AbsPath] {
+		if err := h.ApplyWorkspaceEdits(s, edit); err != nil {
+			InfoBar.Error(err)
+		}
+		return
+	}
+
+	options := make([]overlay.SelectMenuOption[renameChange], 0, len(edits)+len(ops))
+	for _, e := range edits {
+		l := lsp.Location{Location: protocol.Location{URI: e.URI, Range: e.Range}, Server: s}
+		options = append(options, overlay.SelectMenuOption[renameChange]{Value: renameChange{edit: e}, Text: locationName(l)})
+	}
+	for _, op := range ops {
+		options = append(options, overlay.SelectMenuOption[renameChange]{Value: renameChange{op: op}, Text: resourceOperationName(op)})
+	}
+	title := fmt.Sprintf("Rename: %d edits in %d files", len(edits), len(files))
+	if len(ops) > 0 {
+		title += fmt.Sprintf(", %d file operations", len(ops))
+	}
+	overlay.MultiSelectMenu(title, options, func(selected []overlay.SelectMenuOption[renameChange]) {
+		if len(selected) == 0 { return }
+		keepEdits := make(map[lsp.FileEdit]bool)
+		keepOps := make(map[lsp.DocumentChange]bool)
+		for _, o := range selected {
+			if o.Value.op != (lsp.DocumentChange{}) {
+				keepOps[o.Value.op] = true
+			} else {
+				keepEdits[o.Value.edit] = true
+			}
+		}
+		err := h.ApplyWorkspaceEdits(s, lsp.FilterWorkspaceEdit(edit,
+			func(e lsp.FileEdit) bool { return keepEdits[e] },
+			func(c lsp.DocumentChange) bool { return keepOps[c] }))
+		if err != nil {
+			InfoBar.Error(err)
+		}
+	}, overlay.CursorAnchor{Window: bw})
+}
+
+// resourceOperationName describes a create, rename or delete operation of a
+// workspace edit
+func resourceOperationName(c lsp.DocumentChange) string {
+	switch {
+	case c.Create != nil:
+		return "create " + relativeName(c.Create.URI.Filename())
+	case c.Rename != nil:
+		return "rename " + relativeName(c.Rename.OldURI.Filename()) + " -> " + relativeName(c.Rename.NewURI.Filename())
+	case c.Delete != nil:
+		return "delete " + relativeName(c.Delete.URI.Filename())
+	}
+	return ""
+}
+
 func FindBuffer(absPath string) *buffer.Buffer {
 	for _, b := range buffer.OpenBuffers {
 		if b.AbsPath == absPath {
@@ -2023,78 +2258,498 @@ func FindBuffer(absPath string) *buffer.Buffer {
 	return nil
 }
 
-func (h *BufPane) ApplyWorkspaceEdits(edit protocol.WorkspaceEdit) {
-	for uri, edits := range edit.Changes {
-		b := FindBuffer(uri.Filename())
-		if b == nil { continue }
-		b.ApplyEdits(edits)
+// editBuffer returns the open buffer of a file, or opens the file in a new
+// tab so that it can be edited
+func editBuffer(path string) (*buffer.Buffer, error) {
+	if b := FindBuffer(path); b != nil {
+		return b, nil
+	}
+	b, err := buffer.NewBufferFromFile(path, buffer.BTDefault)
+	if err != nil {
+		return nil, err
 	}
 
 	width, height := screen.Screen.Size()
 	iOffset := config.GetInfoBarOffset()
+	Tabs.AddTab(NewTabFromBuffer(0, 0, width, height-1-iOffset, b))
+	return b, nil
+}
+
+// ApplyWorkspaceEdits applies a workspace edit sent by server s, opening
+// the files that aren't open yet in new tabs. Document changes are applied
+// in order, since text edits and file operations can depend on each other,
+// and stop at the first change that fails, whose error is returned
+func (h *BufPane) ApplyWorkspaceEdits(s *lsp.Server, edit lsp.WorkspaceEdit) error {
+	for uri, edits := range edit.Changes {
+		b, err := editBuffer(uri.Filename())
+		if err != nil {
+			return err
+		}
+		b.ApplyEdits(b.DecodeEdits(s, edits))
+	}
 
 	for _, change := range edit.DocumentChanges {
-		fn := change.TextDocument.URI.Filename()
-		b := FindBuffer(fn)
-		if b == nil {
-			var err error
-			b, err = buffer.NewBufferFromFile(fn, buffer.BTDefault)
-			if err != nil {
-				InfoBar.Error(err)
-				continue
+		var err error
+		switch {
+		case change.Create != nil:
+			opts := change.Create.Options
+			if opts == nil { opts = &protocol.CreateFileOptions{} }
+			err = buffer.CreateFile(change.Create.URI.Filename(), opts.Overwrite, opts.IgnoreIfExists)
+		case change.Rename != nil:
+			opts := change.Rename.Options
+			if opts == nil { opts = &protocol.RenameFileOptions{} }
+			err = buffer.RenameFile(change.Rename.OldURI.Filename(), change.Rename.NewURI.Filename(), opts.Overwrite, opts.IgnoreIfExists)
+		case change.Delete != nil:
+			opts := change.Delete.Options
+			if opts == nil { opts = &protocol.DeleteFileOptions{} }
+			fn := change.Delete.URI.Filename()
+			if err = buffer.DeleteFile(fn, opts.Recursive, opts.IgnoreIfNotExists); err == nil {
+				closeFilePanes(fn)
 			}
+		case change.Edit != nil:
+			var b *buffer.Buffer
+			if b, err = editBuffer(change.Edit.TextDocument.URI.Filename()); err == nil {
+				b.ApplyEdits(b.DecodeEdits(s, change.Edit.Edits))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			new_tab := NewTabFromBuffer(0, 0, width, height-1-iOffset, b)
-			Tabs.AddTab(new_tab)
+// closeFilePanes closes the panes showing a deleted file, or a file inside
+// a deleted directory. A pane that is the last one of micro shows an empty
+// buffer instead
+func closeFilePanes(path string) {
+	for _, t := range append([]*Tab(nil), Tabs.List...) {
+		for _, p := range append([]Pane(nil), t.Panes...) {
+			bp, ok := p.(*BufPane)
+			if !ok || !buffer.InPath(bp.Buf.AbsPath, path) { continue }
+			if len(t.Panes) > 1 {
+				bp.Buf.Close()
+				bp.Unsplit()
+			} else if len(Tabs.List) > 1 {
+				bp.Buf.Close()
+				Tabs.RemoveTab(bp.splitID)
+			} else {
+				bp.OpenBuffer(buffer.NewBufferFromString("", "", buffer.BTDefault))
+			}
 		}
-		b.ApplyEdits(change.Edits)
 	}
 }
 
+// currentSelection returns the selection of the cursor, or an empty range at
+// the cursor if nothing is selected
+func (h *BufPane) currentSelection() [2]buffer.Loc {
+	if h.Cursor.HasSelection() {
+		return h.Cursor.CurSelection
+	}
+	return [2]buffer.Loc{h.Cursor.Loc, h.Cursor.Loc}
+}
+
+func (h *BufPane) setSelection(sel [2]buffer.Loc) {
+	if sel[0] == sel[1] {
+		h.Cursor.ResetSelection()
+	} else {
+		h.Cursor.SetSelectionStart(sel[0])
+		h.Cursor.SetSelectionEnd(sel[1])
+		h.Cursor.OrigSelection = sel
+	}
+	h.Cursor.GotoLoc(sel[1])
+	h.expandedSel = sel
+	h.Relocate()
+}
+
+// ExpandSelection grows the selection to the next enclosing syntactic range
+// reported by the language server
+func (h *BufPane) ExpandSelection() bool {
+	if !h.Buf.HasLSP() { return false }
+
+	cur := h.currentSelection()
+	if cur != h.expandedSel {
+		// the selection was changed by something else
+		h.selectionStack = nil
+	}
+
+	ranges, err := h.Buf.LSPSelectionRanges(cur[0])
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+
+	for _, r := range ranges {
+		if r != cur && r[0].LessEqual(cur[0]) && cur[1].LessEqual(r[1]) {
+			h.selectionStack = append(h.selectionStack, cur)
+			h.setSelection(r)
+			return true
+		}
+	}
+	return false
+}
+
+// ShrinkSelection restores the selection that was replaced by the last
+// ExpandSelection
+func (h *BufPane) ShrinkSelection() bool {
+	n := len(h.selectionStack)
+	if n == 0 || h.currentSelection() != h.expandedSel {
+		h.selectionStack = nil
+		return false
+	}
+
+	prev := h.selectionStack[n-1]
+	h.selectionStack = h.selectionStack[:n-1]
+	h.setSelection(prev)
+	return true
+}
+
+// NextDiagnostic moves the cursor to the next LSP diagnostic in the buffer
+func (h *BufPane) NextDiagnostic() bool {
+	d, ok := h.Buf.NextDiagnostic(h.Cursor.Loc)
+	if !ok { return false }
+	h.gotoDiagnostic(d)
+	return true
+}
+
+// PreviousDiagnostic moves the cursor to the previous LSP diagnostic in the buffer
+func (h *BufPane) PreviousDiagnostic() bool {
+	d, ok := h.Buf.PrevDiagnostic(h.Cursor.Loc)
+	if !ok { return false }
+	h.gotoDiagnostic(d)
+	return true
+}
+
+func (h *BufPane) gotoDiagnostic(d *lsp.Diagnostic) {
+	h.jumpTo(buffer.Loc{X: int(d.Range.Start.Character), Y: int(d.Range.Start.Line)})
+}
+
+// jumpTo moves the cursor to l, clamped to the buffer, and scrolls to it
+func (h *BufPane) jumpTo(l buffer.Loc) {
+	y := util.Clamp(l.Y, 0, h.Buf.LinesNum()-1)
+	x := util.Clamp(l.X, 0, util.CharacterCount(h.Buf.LineBytes(y)))
+	h.Cursor.ResetSelection()
+	h.Cursor.GotoLoc(buffer.Loc{X: x, Y: y})
+	h.Relocate()
+}
+
+type diagnosticEntry struct {
+	file string
+	diag lsp.Diagnostic
+}
+
+// DiagnosticsList shows a searchable list of the diagnostics reported by
+// every running language server
+func (h *BufPane) DiagnosticsList() bool {
+	all := lsp.AllDiagnostics()
+	if len(all) == 0 {
+		InfoBar.Message("No diagnostics")
+		return false
+	}
+
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok {
+		InfoBar.Error("BufPane does not have a BufWindow")
+		return false
+	}
+
+	files := make([]string, 0, len(all))
+	for fn := range all { files = append(files, fn) }
+	sort.Strings(files)
+
+	wd, _ := os.Getwd()
+	var options []overlay.SelectMenuOption[diagnosticEntry]
+	for _, fn := range files {
+		diags := all[fn]
+		sort.SliceStable(diags, func(i, j int) bool {
+			a, b := diags[i].Range.Start, diags[j].Range.Start
+			if a.Line != b.Line { return a.Line < b.Line }
+			return a.Character < b.Character
+		})
+
+		name := fn
+		if rel, err := filepath.Rel(wd, fn); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		for _, d := range diags {
+			msg := strings.ReplaceAll(d.Message, "\n", " ")
+			options = append(options, overlay.SelectMenuOption[diagnosticEntry]{
+				Value: diagnosticEntry{fn, d},
+				Text:  fmt.Sprintf("%s:%d: %s", name, d.Range.Start.Line+1, msg),
+			})
+		}
+	}
+
+	overlay.SearchMenu(options, func(o overlay.SelectMenuOption[diagnosticEntry]) {
+		h.openDiagnostic(o.Value.file, &o.Value.diag)
+	}, overlay.CursorAnchor{Window: bw})
+
+	return true
+}
+
+// openDiagnostic focuses the pane showing the given file, opening it in a
+// new tab if necessary, and moves the cursor to the diagnostic
+func (h *BufPane) openDiagnostic(fn string, d *lsp.Diagnostic) {
+	bp := h.openFile(fn)
+	if bp == nil { return }
+
+	decoded := bp.Buf.DecodeDiagnostic(*d)
+	bp.gotoDiagnostic(&decoded)
+}
+
+// openFile focuses the pane showing the given file, opening it in a new tab
+// if necessary. It returns nil if the file can't be opened
+func (h *BufPane) openFile(fn string) *BufPane {
+	bp := h.findFilePane(fn)
+	if bp != nil { return bp }
+
+	b, err := buffer.NewBufferFromFile(fn, buffer.BTDefault)
+	if err != nil {
+		InfoBar.Error(err)
+		return nil
+	}
+
+	width, height := screen.Screen.Size()
+	iOffset := config.GetInfoBarOffset()
+	tp := NewTabFromBuffer(0, 0, width, height-1-iOffset, b)
+	Tabs.AddTab(tp)
+	Tabs.SetActive(len(Tabs.List) - 1)
+	return tp.CurPane()
+}
+
+// findFilePane returns the pane showing the given file and makes it the
+// active one, or nil if the file isn't open
+func (h *BufPane) findFilePane(fn string) *BufPane {
+	if h.Buf.AbsPath == fn { return h }
+
+	for i, t := range Tabs.List {
+		for j, p := range t.Panes {
+			bp, ok := p.(*BufPane)
+			if !ok || bp.Buf.AbsPath != fn { continue }
+			Tabs.SetActive(i)
+			t.SetActive(j)
+			return bp
+		}
+	}
+	return nil
+}
+
+// GotoDefinition jumps to the definition of the symbol under the cursor.
+// If there are several, they are listed in a navigator
+func (h *BufPane) GotoDefinition() bool {
+	locs, err := h.Buf.LSPDefinition()
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+	return h.navigateLocations(locs, "No definition found")
+}
+
+// FindReferences lists the references to the symbol under the cursor in a
+// navigator
+func (h *BufPane) FindReferences() bool {
+	locs, err := h.Buf.LSPReferences()
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+	return h.navigateLocations(locs, "No references found")
+}
+
+// previewContext is the number of lines shown above and below a location
+// in the navigator preview
+const previewContext = 3
+
+// navigateLocations jumps to the location if there is only one, or shows
+// a menu of the locations with a preview of the lines around each of them
+func (h *BufPane) navigateLocations(locs []lsp.Location, none string) bool {
+	if len(locs) == 0 {
+		InfoBar.Message(none)
+		return false
+	}
+	if len(locs) == 1 {
+		h.openLocation(locs[0])
+		return true
+	}
+
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok {
+		InfoBar.Error("BufPane does not have a BufWindow")
+		return false
+	}
+
+	options := locationOptions(locs)
+	preview := func(o overlay.SelectMenuOption[lsp.Location]) overlay.Preview {
+		line := int(o.Value.Range.Start.Line)
+		start := util.Max(line-previewContext, 0)
+		lines, err := buffer.ReadLines(o.Value.URI.Filename(), start, line+previewContext+1)
+		if err != nil {
+			return overlay.Preview{Lines: []string{err.Error()}, Focus: -1}
+		}
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%4d %s", start+i+1, lines[i])
+		}
+		return overlay.Preview{Lines: lines, Focus: line - start}
+	}
+
+	overlay.PreviewMenu(options, preview, func(o overlay.SelectMenuOption[lsp.Location]) {
+		h.openLocation(o.Value)
+	}, overlay.CursorAnchor{Window: bw})
+	return true
+}
+
+// locationName returns the file and line of a location, with the file
+// relative to the working directory if it's inside it
+func locationName(l lsp.Location) string {
+	return fmt.Sprintf("%s:%d", relativeName(l.URI.Filename()), l.Range.Start.Line+1)
+}
+
+// relativeName returns a path relative to the working directory when it is
+// inside it
+func relativeName(name string) string {
+	wd, _ := os.Getwd()
+	if rel, err := filepath.Rel(wd, name); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return name
+}
+
+func locationOptions(locs []lsp.Location) []overlay.SelectMenuOption[lsp.Location] {
+	options := make([]overlay.SelectMenuOption[lsp.Location], len(locs))
+	for i, l := range locs {
+		options[i] = overlay.SelectMenuOption[lsp.Location]{Value: l, Text: locationName(l)}
+	}
+	return options
+}
+
+// peekContext is the number of lines shown after the start of a definition
+// by PeekDefinition, which can be scrolled through
+const peekContext = 30
+
+// PeekDefinition shows the definition of the symbol under the cursor in an
+// overlay, without leaving the current buffer. Enter jumps to it. If there
+// are several definitions, one of them is chosen in a menu first
+func (h *BufPane) PeekDefinition() bool {
+	locs, err := h.Buf.LSPDefinition()
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+	if len(locs) == 0 {
+		InfoBar.Message("No definition found")
+		return false
+	}
+
+	bw, ok := h.BWindow.(*display.BufWindow)
+	if !ok {
+		InfoBar.Error("BufPane does not have a BufWindow")
+		return false
+	}
+
+	if len(locs) == 1 {
+		return h.peekLocation(locs[0], bw)
+	}
+	overlay.SelectMenu(locationOptions(locs), func(o overlay.SelectMenuOption[lsp.Location]) {
+		h.peekLocation(o.Value, bw)
+	}, overlay.CursorAnchor{Window: bw})
+	return true
+}
+
+// peekLocation shows the lines around a location in a Peek overlay. They
+// are highlighted if the file is open in a buffer
+func (h *BufPane) peekLocation(l lsp.Location, bw *display.BufWindow) bool {
+	path := l.URI.Filename()
+	line := int(l.Range.Start.Line)
+	start := util.Max(line-previewContext, 0)
+	end := util.Max(int(l.Range.End.Line), line) + peekContext
+	lines, err := buffer.ReadLines(path, start, end)
+	if err != nil {
+		InfoBar.Error(err)
+		return false
+	}
+
+	b := buffer.FindBufferByAbsPath(path)
+	peek := make([]overlay.PeekLine, len(lines))
+	for i, text := range lines {
+		peek[i].Text = text
+		if b != nil && b.Settings["syntax"].(bool) {
+			peek[i].Styles = make(map[int]tcell.Style)
+			for x, group := range b.Match(start + i) {
+				peek[i].Styles[x] = config.GetColor(group.String())
+			}
+		}
+	}
+
+	overlay.Peek(locationName(l), peek, start, line-start, func() {
+		h.openLocation(l)
+	}, overlay.CursorAnchor{Window: bw})
+	return true
+}
+
+// openLocation opens the file of a location sent by a language server and
+// moves the cursor to the start of the location
+func (h *BufPane) openLocation(l lsp.Location) {
+	bp := h.openFile(l.URI.Filename())
+	if bp == nil { return }
+	bp.jumpTo(bp.Buf.LSPLoc(l.Server, l.Range.Start))
+}
+
 func (h *BufPane) LSPResync() bool {
 	if !h.Buf.HasLSP() { return false }
 	h.Buf.LSPResync()
 	return true
 }
 
-// AutoFormat automatically formats the document using LSP
+// AutoFormat formats the selections with the language servers, or the whole
+// document if nothing is selected. Each range is formatted by the first
+// server that supports it, since the edits of several formatters would
+// conflict
 func (h *BufPane) AutoFormat() bool {
 	if !h.Buf.HasLSP() {
 		return false
 	}
 
-	var err error
-	var edits []protocol.TextEdit
-
 	fmtopt := protocol.FormattingOptions{
 		InsertSpaces: h.Buf.Settings["tabstospaces"].(bool),
-		TabSize:      h.Buf.Settings["tabsize"].(uint32),
+		TabSize:      uint32(h.Buf.Settings["tabsize"].(float64)),
 	}
 
-	if h.Cursor.HasSelection() {
-		prange := protocol.Range{
-			Start: h.Cursor.CurSelection[0].ToPos(),
-			End:   h.Cursor.CurSelection[1].ToPos(),
-		}
+	var ranges [][2]buffer.Loc
+	for _, c := range h.Buf.GetCursors() {
+		if !c.HasSelection() { continue }
+		start, end := c.CurSelection[0], c.CurSelection[1]
+		if start.GreaterThan(end) { start, end = end, start }
+		ranges = append(ranges, [2]buffer.Loc{start, end})
+	}
 
-		edits = util.Fold(util.ChanMapAll(h.Buf.Servers, func (s *lsp.Server) ([]protocol.TextEdit, bool) {
-			res, e := s.DocumentRangeFormat(h.Buf.AbsPath, prange, fmtopt)
-			if e == nil { return res, true }
-			return nil, false
-		})...)
-	} else {
-		edits = util.Fold(util.ChanMapAll(h.Buf.Servers, func (s *lsp.Server) ([]protocol.TextEdit, bool) {
-			res, e := s.DocumentFormat(h.Buf.AbsPath, fmtopt)
-			if e == nil { return res, true }
-			return nil, false
-		})...)
+	// an empty range stands for the whole document
+	if len(ranges) == 0 {
+		ranges = append(ranges, [2]buffer.Loc{})
+	}
+	format := func(s *lsp.Server, r [2]buffer.Loc) ([]protocol.TextEdit, error) {
+		if r[0] == r[1] { return s.DocumentFormat(h.Buf.AbsPath, fmtopt) }
+		return s.DocumentRangeFormat(h.Buf.AbsPath, h.Buf.LSPRange(s, r[0], r[1]), fmtopt)
 	}
 
-	if err != nil {
-		InfoBar.Error(err)
-		return false
+	var err error
+	var edits []protocol.TextEdit
+	for _, r := range ranges {
+		for _, s := range h.Buf.ActiveServers() {
+			var res []protocol.TextEdit
+			res, err = format(s, r)
+			if err == nil {
+				edits = append(edits, h.Buf.DecodeEdits(s, res)...)
+				break
+			}
+		}
+		if err != nil {
+			InfoBar.Error(err)
+			return false
+		}
 	}
 
+	// ApplyEdits applies the edits from the end of the document, so that
+	// the positions of the remaining ones stay valid
 	h.Buf.ApplyEdits(edits)
 	return true
 }
@@ -251,6 +251,16 @@ type BufPane struct {
 	// remember original location of a search in case the search is canceled
 	searchOrig buffer.Loc
 
+	// Selections replaced by ExpandSelection, so that ShrinkSelection can
+	// restore them, and the last selection set by either of them
+	selectionStack [][2]buffer.Loc
+	expandedSel    [2]buffer.Loc
+
+	// The automatic hover shown when the cursor rests at hoverLoc, see
+	// the hoverdelay option
+	hoverTimer *time.Timer
+	hoverLoc   buffer.Loc
+
 	// The pane may not yet be fully initialized after its creation
 	// since we may not know the window geometry yet. In such case we finish
 	// its initialization a bit later, after the initial resize.
@@ -381,6 +391,17 @@ func (h *BufPane) GotoLoc(loc buffer.Loc) {
 }
 
 func (h *BufPane) initialRelocate() {
+	if sv, ok := h.Buf.StartView(); ok {
+		v := h.GetView()
+		v.StartLine = display.SLoc{Line: sv.StartLine, Row: 0}
+		v.StartCol = 0
+		if !h.Buf.Settings["softwrap"].(bool) {
+			v.StartCol = sv.StartCol
+		}
+		h.Relocate()
+		return
+	}
+
 	sloc := h.SLocFromLoc(h.Cursor.Loc)
 	height := h.BufView().Height
 
@@ -483,6 +504,10 @@ func (h *BufPane) HandleEvent(event tcell.Event) {
 	}
 	h.Buf.MergeCursors()
 
+	if h.Buf.EditRejected() {
+		InfoBar.Error("Buffer is read-only")
+	}
+
 	if h.IsActive() {
 		// Display any gutter messages for this line
 		c := h.Buf.GetActiveCursor()
@@ -495,7 +520,7 @@ func (h *BufPane) HandleEvent(event tcell.Event) {
 			}
 		}
 		if none && h.Buf.HasLSP() {
-			diags := h.Buf.GetDiagnostics()
+			diags := h.Buf.VisibleDiagnostics()
 			if diags != nil {
 				for _, d := range diags {
 					if c.Y == int(d.Range.Start.Line) || c.Y == int(d.Range.End.Line) {
@@ -510,6 +535,7 @@ func (h *BufPane) HandleEvent(event tcell.Event) {
 		if none && InfoBar.HasGutter {
 			InfoBar.ClearGutter()
 		}
+		h.scheduleHover()
 	}
 
 	cursors := h.Buf.GetCursors()
@@ -615,6 +641,14 @@ func (h *BufPane) DoMouseEvent(e MouseEvent, te *tcell.EventMouse) bool {
 // (possibly multiple times for multiple cursors)
 func (h *BufPane) DoRuneInsert(r rune) {
 	cursors := h.Buf.GetCursors()
+
+	// an incomplete list of completions is not kept while the word gets
+	// longer: the servers are asked again for the word as typed
+	requery := h.Buf.CompletionsIncomplete() && len(cursors) == 1 && !h.Buf.IsNonWordChar(r)
+	if requery {
+		h.Buf.UndoCompletion()
+	}
+
 	for _, c := range cursors {
 		// Insert a character
 		h.Buf.SetCurCursor(c.Num)
@@ -640,6 +674,10 @@ func (h *BufPane) DoRuneInsert(r rune) {
 		h.Relocate()
 		h.PluginCBRune("onRune", r)
 	}
+
+	if requery && h.Buf.Autocomplete(buffer.DefaultComplete) {
+		h.displayCompletionDoc()
+	}
 }
 
 // VSplitIndex opens the given buffer in a vertical split on the given side.
@@ -674,7 +712,13 @@ func (h *BufPane) HSplitBuf(buf *buffer.Buffer) *BufPane {
 
 // Close this pane.
 func (h *BufPane) Close() {
+	if h.hoverTimer != nil {
+		h.hoverTimer.Stop()
+	}
 	h.Buf.Close()
+	if bw, ok := h.BWindow.(*display.BufWindow); ok {
+		bw.Close()
+	}
 
 	for i, pane := range OpenBufPanes {
 		if h == pane {
@@ -701,7 +745,7 @@ func (h *BufPane) SetActive(b bool) {
 			}
 		}
 		if none && h.Buf.HasLSP() {
-			diags := h.Buf.GetDiagnostics()
+			diags := h.Buf.VisibleDiagnostics()
 			if diags != nil {
 				for _, d := range diags {
 					if c.Y == int(d.Range.Start.Line) || c.Y == int(d.Range.End.Line) {
@@ -716,6 +760,7 @@ func (h *BufPane) SetActive(b bool) {
 		if none && InfoBar.HasGutter {
 			InfoBar.ClearGutter()
 		}
+		h.scheduleHover()
 	}
 
 }
@@ -761,6 +806,8 @@ var BufKeyActions = map[string]BufKeyAction{
 	"FindNext":                  (*BufPane).FindNext,
 	"FindPrevious":              (*BufPane).FindPrevious,
 	"Center":                    (*BufPane).Center,
+	"CursorToTop":               (*BufPane).CursorToTop,
+	"CursorToBottom":            (*BufPane).CursorToBottom,
 	"Undo":                      (*BufPane).Undo,
 	"Redo":                      (*BufPane).Redo,
 	"Copy":                      (*BufPane).Copy,
@@ -797,6 +844,7 @@ var BufKeyActions = map[string]BufKeyAction{
 	"ToggleKeyMenu":             (*BufPane).ToggleKeyMenu,
 	"ToggleDiffGutter":          (*BufPane).ToggleDiffGutter,
 	"ToggleRuler":               (*BufPane).ToggleRuler,
+	"ToggleReadOnly":            (*BufPane).ToggleReadOnly,
 	"ToggleHighlightSearch":     (*BufPane).ToggleHighlightSearch,
 	"UnhighlightSearch":         (*BufPane).UnhighlightSearch,
 	"ClearStatus":               (*BufPane).ClearStatus,
@@ -829,11 +877,21 @@ var BufKeyActions = map[string]BufKeyAction{
 	"SkipMultiCursor":           (*BufPane).SkipMultiCursor,
 	"JumpToMatchingBrace":       (*BufPane).JumpToMatchingBrace,
 	"JumpLine":                  (*BufPane).JumpLine,
+	"GotoLine":                  (*BufPane).GotoLine,
 	"Deselect":                  (*BufPane).Deselect,
 	"ClearInfo":                 (*BufPane).ClearInfo,
 	"SemanticInfo":              (*BufPane).Tooltip,
 	"Tooltip":                   (*BufPane).Tooltip,
 	"LSPResync":                 (*BufPane).LSPResync,
+	"ExpandSelection":           (*BufPane).ExpandSelection,
+	"ShrinkSelection":           (*BufPane).ShrinkSelection,
+	"NextDiagnostic":            (*BufPane).NextDiagnostic,
+	"PreviousDiagnostic":        (*BufPane).PreviousDiagnostic,
+	"DiagnosticsList":           (*BufPane).DiagnosticsList,
+	"DiagnosticInfo":            (*BufPane).DiagnosticInfo,
+	"GotoDefinition":            (*BufPane).GotoDefinition,
+	"FindReferences":            (*BufPane).FindReferences,
+	"PeekDefinition":            (*BufPane).PeekDefinition,
 	"AutoFormat":                (*BufPane).AutoFormat,
 	"None":                      (*BufPane).None,
 
@@ -847,6 +905,7 @@ var BufMouseActions = map[string]BufMouseAction{
 	"MouseDrag":        (*BufPane).MouseDrag,
 	"MouseRelease":     (*BufPane).MouseRelease,
 	"MouseMultiCursor": (*BufPane).MouseMultiCursor,
+	"ContextMenu":      (*BufPane).ContextMenu,
 }
 
 // MultiActions is a list of actions that should be executed multiple
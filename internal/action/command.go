@@ -2,12 +2,15 @@ package action
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,6 +18,8 @@ import (
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/clipboard"
 	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/loc"
+	"github.com/zyedidia/micro/v2/internal/lsp"
 	"github.com/zyedidia/micro/v2/internal/screen"
 	"github.com/zyedidia/micro/v2/internal/shell"
 	"github.com/zyedidia/micro/v2/internal/util"
@@ -30,11 +35,14 @@ type Command struct {
 var commands map[string]Command
 
 func InitCommands() {
+	config.GlobalOptionCallback = applyGlobalOption
+
 	commands = map[string]Command{
 		"set":        {(*BufPane).SetCmd, OptionValueComplete},
 		"reset":      {(*BufPane).ResetCmd, OptionValueComplete},
 		"setlocal":   {(*BufPane).SetLocalCmd, OptionValueComplete},
 		"show":       {(*BufPane).ShowCmd, OptionComplete},
+		"settings":   {(*BufPane).SettingsCmd, nil},
 		"showkey":    {(*BufPane).ShowKeyCmd, nil},
 		"run":        {(*BufPane).RunCmd, nil},
 		"bind":       {(*BufPane).BindCmd, nil},
@@ -59,11 +67,13 @@ func InitCommands() {
 		"open":       {(*BufPane).OpenCmd, buffer.FileComplete},
 		"tabmove":    {(*BufPane).TabMoveCmd, nil},
 		"tabswitch":  {(*BufPane).TabSwitchCmd, nil},
+		"tabpin":     {(*BufPane).TabPinCmd, nil},
 		"term":       {(*BufPane).TermCmd, nil},
 		"memusage":   {(*BufPane).MemUsageCmd, nil},
 		"retab":      {(*BufPane).RetabCmd, nil},
 		"raw":        {(*BufPane).RawCmd, nil},
 		"textfilter": {(*BufPane).TextFilterCmd, nil},
+		"format":     {(*BufPane).FormatCmd, nil},
 	}
 }
 
@@ -120,6 +130,16 @@ func (h *BufPane) RetabCmd(args []string) {
 	h.Buf.Retab()
 }
 
+// FormatCmd formats the selections, or the whole buffer, with the language
+// servers
+func (h *BufPane) FormatCmd(args []string) {
+	if !h.Buf.HasLSP() {
+		InfoBar.Error("No language server is running for this buffer")
+		return
+	}
+	h.AutoFormat()
+}
+
 // RawCmd opens a new raw view which displays the escape sequences micro
 // is receiving in real-time
 func (h *BufPane) RawCmd(args []string) {
@@ -204,6 +224,7 @@ func (h *BufPane) TabMoveCmd(args []string) {
 	Tabs.List[idxTo] = activeTab
 	Tabs.UpdateNames()
 	Tabs.SetActive(idxTo)
+	Tabs.orderPinned()
 	// InfoBar.Message(fmt.Sprintf("Moved tab from slot %d to %d", idxFrom+1, idxTo+1))
 }
 
@@ -235,6 +256,11 @@ func (h *BufPane) TabSwitchCmd(args []string) {
 	}
 }
 
+// TabPinCmd pins the current tab to the left of the tab bar, or unpins it
+func (h *BufPane) TabPinCmd(args []string) {
+	Tabs.TogglePin(Tabs.Active())
+}
+
 // CdCmd changes the current working directory
 func (h *BufPane) CdCmd(args []string) {
 	if len(args) > 0 {
@@ -463,7 +489,27 @@ func SetGlobalOptionNative(option string, nativeValue interface{}) error {
 	if !local {
 		config.GlobalSettings[option] = nativeValue
 		config.ModifiedSettings[option] = true
+	}
+
+	if err := applyGlobalOption(option, nativeValue); err != nil {
+		return err
+	}
+
+	return config.WriteSettings(filepath.Join(config.ConfigDir, "settings.json"))
+}
+
+// applyGlobalOption makes the editor and the open buffers use the new
+// global value of an option
+func applyGlobalOption(option string, nativeValue interface{}) error {
+	local := false
+	for _, s := range config.LocalSettings {
+		if s == option {
+			local = true
+			break
+		}
+	}
 
+	if !local {
 		if option == "colorscheme" {
 			// LoadSyntaxFiles()
 			config.InitColorscheme()
@@ -487,6 +533,8 @@ func SetGlobalOptionNative(option string, nativeValue interface{}) error {
 			}
 		} else if option == "paste" {
 			screen.Screen.SetPaste(nativeValue.(bool))
+		} else if option == "lspmaxmessage" {
+			lsp.SetMaxMessageSize(nativeValue.(float64))
 		} else if option == "clipboard" {
 			m := clipboard.SetMethod(nativeValue.(string))
 			err := clipboard.Initialize(m)
@@ -517,7 +565,7 @@ func SetGlobalOptionNative(option string, nativeValue interface{}) error {
 		b.SetOptionNative(option, nativeValue)
 	}
 
-	return config.WriteSettings(filepath.Join(config.ConfigDir, "settings.json"))
+	return nil
 }
 
 func SetGlobalOption(option, value string) error {
@@ -542,18 +590,13 @@ func (h *BufPane) ResetCmd(args []string) {
 
 	option := args[0]
 
-	defaultGlobals := config.DefaultGlobalSettings()
-	defaultLocals := config.DefaultCommonSettings()
-
-	if _, ok := defaultGlobals[option]; ok {
-		SetGlobalOptionNative(option, defaultGlobals[option])
-		return
+	err := config.ResetOption(option)
+	if err == config.ErrLocalOption {
+		err = h.Buf.SetOptionNative(option, config.DefaultCommonSettings()[option])
 	}
-	if _, ok := defaultLocals[option]; ok {
-		h.Buf.SetOptionNative(option, defaultLocals[option])
-		return
+	if err != nil {
+		InfoBar.Error(err)
 	}
-	InfoBar.Error(config.ErrInvalidOption)
 }
 
 // SetCmd sets an option
@@ -620,6 +663,47 @@ func (h *BufPane) ShowCmd(args []string) {
 	}
 }
 
+// EffectiveSettings returns the value of every option in buf and where it
+// comes from (see config.ResolveSettings). Values that differ from what the
+// settings files give, because they were set with setlocal, by a plugin or
+// detected from the file, have the source "buffer"
+func EffectiveSettings(buf *buffer.Buffer) map[string]config.Setting {
+	settings := config.ResolveSettings(buf.Settings["filetype"].(string), buf.AbsPath)
+	for k, v := range buf.Settings {
+		if s, ok := settings[k]; !ok || !reflect.DeepEqual(s.Value, v) {
+			settings[k] = config.Setting{Value: v, Source: "buffer"}
+		}
+	}
+	return settings
+}
+
+// SettingsCmd lists the options of the current buffer, with their values
+// and where they come from, in a scratch buffer
+func (h *BufPane) SettingsCmd(args []string) {
+	settings := EffectiveSettings(h.Buf)
+
+	names := make([]string, 0, len(settings))
+	values := make(map[string]string, len(settings))
+	namew, valuew := 0, 0
+	for k, s := range settings {
+		v, err := json.Marshal(s.Value)
+		if err != nil { v = []byte(fmt.Sprint(s.Value)) }
+		values[k] = string(v)
+		names = append(names, k)
+		namew = util.Max(namew, len(k))
+		valuew = util.Max(valuew, len(v))
+	}
+	sort.Strings(names)
+	valuew = util.Min(valuew, 30)
+
+	var text strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&text, "%-*s  %-*s  %s\n", namew, k, valuew, values[k], settings[k].Source)
+	}
+
+	h.HSplitBuf(buffer.OpenScratch("Settings of "+h.Buf.GetName(), text.String()))
+}
+
 // ShowKeyCmd displays the action that a key is bound to
 func (h *BufPane) ShowKeyCmd(args []string) {
 	if len(args) < 1 {
@@ -690,38 +774,38 @@ func (h *BufPane) GotoCmd(args []string) {
 	if len(args) <= 0 {
 		InfoBar.Error("Not enough arguments")
 	} else {
+		l, err := parseGotoLoc(args[0], h.Buf)
+		if err != nil {
+			InfoBar.Error(err)
+			return
+		}
 		h.RemoveAllMultiCursors()
-		if strings.Contains(args[0], ":") {
-			parts := strings.SplitN(args[0], ":", 2)
-			line, err := strconv.Atoi(parts[0])
-			if err != nil {
-				InfoBar.Error(err)
-				return
-			}
-			col, err := strconv.Atoi(parts[1])
-			if err != nil {
-				InfoBar.Error(err)
-				return
-			}
-			if line < 0 {
-				line = h.Buf.LinesNum() + 1 + line
-			}
-			line = util.Clamp(line-1, 0, h.Buf.LinesNum()-1)
-			col = util.Clamp(col-1, 0, util.CharacterCount(h.Buf.LineBytes(line)))
-			h.GotoLoc(buffer.Loc{col, line})
-		} else {
-			line, err := strconv.Atoi(args[0])
-			if err != nil {
-				InfoBar.Error(err)
-				return
-			}
-			if line < 0 {
-				line = h.Buf.LinesNum() + 1 + line
-			}
-			line = util.Clamp(line-1, 0, h.Buf.LinesNum()-1)
-			h.GotoLoc(buffer.Loc{0, line})
+		h.GotoLoc(l)
+	}
+}
+
+// parseGotoLoc parses a 'line' or 'line:col' position, both counted from 1,
+// and clamps it to the buffer. Negative line numbers count from the end
+func parseGotoLoc(s string, b *buffer.Buffer) (buffer.Loc, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	line, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return buffer.Loc{}, errors.New("Invalid line number: " + parts[0])
+	}
+	col := 1
+	if len(parts) > 1 {
+		col, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return buffer.Loc{}, errors.New("Invalid column number: " + parts[1])
 		}
 	}
+
+	if line < 0 {
+		line = b.LinesNum() + 1 + line
+	}
+	l := loc.Clamp(buffer.Loc{X: 0, Y: line - 1}, b)
+	l.X = util.Clamp(col-1, 0, util.CharacterCount(b.LineBytes(l.Y)))
+	return l, nil
 }
 
 // SaveCmd saves the buffer optionally with an argument file name
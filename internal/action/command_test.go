@@ -0,0 +1,54 @@
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	ulua "github.com/zyedidia/micro/v2/internal/lua"
+)
+
+func init() {
+	ulua.L = lua.NewState()
+	config.InitGlobalSettings()
+	config.GlobalSettings["backup"] = false
+}
+
+func TestParseGotoLoc(t *testing.T) {
+	b := buffer.NewBufferFromString("first\nsecond\nthird", "", buffer.BTDefault)
+	defer b.Close()
+
+	tests := []struct {
+		in      string
+		want    buffer.Loc
+		wantErr bool
+	}{
+		{"1", buffer.Loc{X: 0, Y: 0}, false},
+		{"2", buffer.Loc{X: 0, Y: 1}, false},
+		{" 3 ", buffer.Loc{X: 0, Y: 2}, false},
+		{"10", buffer.Loc{X: 0, Y: 2}, false},
+		{"0", buffer.Loc{X: 0, Y: 0}, false},
+		{"2:3", buffer.Loc{X: 2, Y: 1}, false},
+		{"1:100", buffer.Loc{X: 5, Y: 0}, false},
+		{"1:0", buffer.Loc{X: 0, Y: 0}, false},
+		{"-1", buffer.Loc{X: 0, Y: 2}, false},
+		{"-3:2", buffer.Loc{X: 1, Y: 0}, false},
+		{"-10", buffer.Loc{X: 0, Y: 0}, false},
+		{"2:-4", buffer.Loc{X: 0, Y: 1}, false},
+		{"", buffer.Loc{}, true},
+		{"abc", buffer.Loc{}, true},
+		{"1:x", buffer.Loc{}, true},
+		{"1:2:3", buffer.Loc{}, true},
+	}
+	for _, tt := range tests {
+		l, err := parseGotoLoc(tt.in, b)
+		if tt.wantErr {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, l, tt.in)
+	}
+}
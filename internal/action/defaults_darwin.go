@@ -96,6 +96,7 @@ var bufdefaults = map[string]string{
 	"MouseLeftDrag":    "MouseDrag",
 	"MouseLeftRelease": "MouseRelease",
 	"MouseMiddle":      "PastePrimary",
+	"MouseRight":       "ContextMenu",
 	"Ctrl-MouseLeft":   "MouseMultiCursor",
 
 	"Alt-n":        "SpawnMultiCursor",
@@ -99,6 +99,7 @@ var bufdefaults = map[string]string{
 	"MouseLeftDrag":    "MouseDrag",
 	"MouseLeftRelease": "MouseRelease",
 	"MouseMiddle":      "PastePrimary",
+	"MouseRight":       "ContextMenu",
 	"Ctrl-MouseLeft":   "MouseMultiCursor",
 
 	"Alt-n":        "SpawnMultiCursor",
@@ -17,7 +17,7 @@ import (
 // CommandComplete autocompletes commands
 func CommandComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	var suggestions []string
 	for cmd := range commands {
@@ -29,7 +29,7 @@ func CommandComplete(b *buffer.Buffer) []buffer.Completion {
 	sort.Strings(suggestions)
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 
 	return buffer.ConvertCompletions(completions, suggestions, c)
@@ -38,7 +38,7 @@ func CommandComplete(b *buffer.Buffer) []buffer.Completion {
 // HelpComplete autocompletes help topics
 func HelpComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	var suggestions []string
 
@@ -52,7 +52,7 @@ func HelpComplete(b *buffer.Buffer) []buffer.Completion {
 	sort.Strings(suggestions)
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 	return buffer.ConvertCompletions(completions, suggestions, c)
 }
@@ -89,7 +89,7 @@ func contains(s []string, e string) bool {
 // OptionComplete autocompletes options
 func OptionComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	var suggestions []string
 	for option := range config.GlobalSettings {
@@ -101,7 +101,7 @@ func OptionComplete(b *buffer.Buffer) []buffer.Completion {
 	sort.Strings(suggestions)
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 	return buffer.ConvertCompletions(completions, suggestions, c)
 }
@@ -111,7 +111,7 @@ func OptionValueComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
 	l := b.LineBytes(c.Y)
 	l = util.SliceStart(l, c.X)
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	completeValue := false
 	args := bytes.Split(l, []byte{' '})
@@ -186,7 +186,7 @@ func OptionValueComplete(b *buffer.Buffer) []buffer.Completion {
 
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 	return buffer.ConvertCompletions(completions, suggestions, c)
 }
@@ -194,7 +194,7 @@ func OptionValueComplete(b *buffer.Buffer) []buffer.Completion {
 // PluginCmdComplete autocompletes the plugin command
 func PluginCmdComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	var suggestions []string
 	for _, cmd := range PluginCmds {
@@ -206,7 +206,7 @@ func PluginCmdComplete(b *buffer.Buffer) []buffer.Completion {
 	sort.Strings(suggestions)
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 	return buffer.ConvertCompletions(completions, suggestions, c)
 }
@@ -216,7 +216,7 @@ func PluginComplete(b *buffer.Buffer) []buffer.Completion {
 	c := b.GetActiveCursor()
 	l := b.LineBytes(c.Y)
 	l = util.SliceStart(l, c.X)
-	input, argstart := buffer.GetArg(b)
+	input, _ := buffer.GetArg(b)
 
 	completeValue := false
 	args := bytes.Split(l, []byte{' '})
@@ -242,7 +242,7 @@ func PluginComplete(b *buffer.Buffer) []buffer.Completion {
 
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 	}
 	return buffer.ConvertCompletions(completions, suggestions, c)
 }
@@ -250,7 +250,7 @@ func PluginComplete(b *buffer.Buffer) []buffer.Completion {
 // PluginNameComplete completes with the names of loaded plugins
 // func PluginNameComplete(b *buffer.Buffer) ([]string, []string) {
 // 	c := b.GetActiveCursor()
-// 	input, argstart := buffer.GetArg(b)
+// 	input, _ := buffer.GetArg(b)
 //
 // 	var suggestions []string
 // 	for _, pp := range config.GetAllPluginPackages(nil) {
@@ -262,7 +262,7 @@ func PluginComplete(b *buffer.Buffer) []buffer.Completion {
 // 	sort.Strings(suggestions)
 // 	completions := make([]string, len(suggestions))
 // 	for i := range suggestions {
-// 		completions[i] = util.SliceEndStr(suggestions[i], c.X-argstart)
+// 		completions[i] = util.SliceEndStr(suggestions[i], util.CharacterCountInString(input))
 // 	}
 // 	return completions, suggestions
 // }
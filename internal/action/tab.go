@@ -1,9 +1,12 @@
 package action
 
 import (
+	"sort"
+
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/display"
+	"github.com/zyedidia/micro/v2/internal/overlay"
 	"github.com/zyedidia/micro/v2/internal/screen"
 	"github.com/zyedidia/micro/v2/internal/views"
 	"github.com/zyedidia/tcell/v2"
@@ -40,8 +43,32 @@ func NewTabList(bufs []*buffer.Buffer) *TabList {
 // correct
 func (t *TabList) UpdateNames() {
 	t.Names = t.Names[:0]
+	t.Pinned = t.Pinned[:0]
 	for _, p := range t.List {
 		t.Names = append(t.Names, p.Panes[p.active].Name())
+		t.Pinned = append(t.Pinned, p.Pinned)
+	}
+}
+
+// TogglePin pins or unpins the tab with the given index. Pinned tabs are
+// kept in front of the other tabs, so the tab may move
+func (t *TabList) TogglePin(i int) {
+	t.List[i].Pinned = !t.List[i].Pinned
+	t.orderPinned()
+}
+
+// orderPinned moves the pinned tabs in front of the others, keeping the
+// active tab active
+func (t *TabList) orderPinned() {
+	active := t.List[t.Active()]
+	sort.SliceStable(t.List, func(i, j int) bool {
+		return t.List[i].Pinned && !t.List[j].Pinned
+	})
+	t.UpdateNames()
+	for i, p := range t.List {
+		if p == active {
+			t.SetActive(i)
+		}
 	}
 }
 
@@ -104,12 +131,15 @@ func (t *TabList) HandleEvent(event tcell.Event) {
 		mx, my := e.Position()
 		switch e.Buttons() {
 		case tcell.Button1:
-			if my == t.Y && mx == 0 {
+			if my == t.Y && mx == t.PinnedWidth() {
 				t.Scroll(-4)
 				return
-			} else if my == t.Y && mx == t.Width-1 {
+			} else if my == t.Y && mx == t.TabsWidth()-1 {
 				t.Scroll(4)
 				return
+			} else if my == t.Y && mx == t.Width-1 && t.Overflows() {
+				t.TabMenu()
+				return
 			}
 			if len(t.List) > 1 {
 				ind := t.LocFromVisual(buffer.Loc{X: mx, Y: my})
@@ -121,6 +151,13 @@ func (t *TabList) HandleEvent(event tcell.Event) {
 					return
 				}
 			}
+		case tcell.ButtonMiddle:
+			if len(t.List) > 1 {
+				if ind := t.LocFromVisual(buffer.Loc{X: mx, Y: my}); ind != -1 {
+					t.TogglePin(ind)
+					return
+				}
+			}
 		case tcell.WheelUp:
 			if my == t.Y {
 				t.Scroll(4)
@@ -136,6 +173,25 @@ func (t *TabList) HandleEvent(event tcell.Event) {
 	t.List[t.Active()].HandleEvent(event)
 }
 
+// TabMenu opens a menu below the right end of the tab bar listing the paths
+// of all tabs, which can be searched to switch to one of them
+func (t *TabList) TabMenu() {
+	t.UpdateNames()
+	options := make([]overlay.SelectMenuOption[int], len(t.List))
+	for i, tab := range t.List {
+		name := t.Names[i]
+		if bp := tab.CurPane(); bp != nil && bp.Buf.Path != "" {
+			name = bp.Buf.Path
+		}
+		options[i] = overlay.SelectMenuOption[int]{Value: i, Text: name}
+	}
+	overlay.SearchMenu(options, func(o overlay.SelectMenuOption[int]) {
+		if o.Value < len(t.List) {
+			t.SetActive(o.Value)
+		}
+	}, overlay.V2{Loc: buffer.Loc{X: t.Width - 1, Y: t.Y + 1}})
+}
+
 // Display updates the names and then displays the tab bar
 func (t *TabList) Display() {
 	t.UpdateNames()
@@ -181,6 +237,9 @@ type Tab struct {
 	Panes  []Pane
 	active int
 
+	// Pinned tabs stay at the left of the tab bar
+	Pinned bool
+
 	resizing *views.Node // node currently being resized
 	// captures whether the mouse is released
 	release bool
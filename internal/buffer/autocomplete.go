@@ -2,11 +2,11 @@ package buffer
 
 import (
 	"bytes"
-	"io/fs"
 	"os"
 	"sort"
 	"strings"
 
+	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/loc"
 	"github.com/zyedidia/micro/v2/internal/lsp"
 	"github.com/zyedidia/micro/v2/internal/util"
@@ -31,10 +31,128 @@ type Completion struct {
 	Filter      string
 	Detail      string
 	Doc         string
+
+	// KindEnum is the kind sent by a language server, or 0 for completions
+	// from other sources. Kind holds its name
+	KindEnum protocol.CompletionItemKind
+}
+
+// KindGroup returns the colorscheme group of the completion's kind, such
+// as completion.function, or "" if it doesn't have a kind
+func (c *Completion) KindGroup() string {
+	if c.KindEnum != 0 {
+		return "completion." + toKindStr(c.KindEnum)
+	}
+	if c.Kind != "" {
+		return "completion." + c.Kind
+	}
+	return ""
+}
+
+type registeredCompleter struct {
+	name      string
+	complete  Completer
+	primary   bool
+	filetypes []string
+}
+
+// completers are the completers registered by plugins, in registration order
+var completers []registeredCompleter
+
+func registerCompleter(name string, c Completer, primary bool, filetypes []string) {
+	rc := registeredCompleter{name, c, primary, filetypes}
+	for i := range completers {
+		if completers[i].name == name {
+			completers[i] = rc
+			return
+		}
+	}
+	completers = append(completers, rc)
+}
+
+// RegisterCompleter registers an additive completer. Its suggestions are
+// merged with the ones from the language servers in buffers with one of the
+// given filetypes, or in all buffers if no filetype is given. Registering a
+// completer with the same name again replaces it
+func RegisterCompleter(name string, c Completer, filetypes ...string) {
+	registerCompleter(name, c, false, filetypes)
+}
+
+// RegisterPrimaryCompleter registers an exclusive completer: when it has
+// suggestions, they are the only ones shown
+func RegisterPrimaryCompleter(name string, c Completer, filetypes ...string) {
+	registerCompleter(name, c, true, filetypes)
+}
+
+// UnregisterCompleter removes the completer with the given name
+func UnregisterCompleter(name string) {
+	for i := range completers {
+		if completers[i].name == name {
+			completers = append(completers[:i], completers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (rc *registeredCompleter) activeFor(ft string) bool {
+	if len(rc.filetypes) == 0 {
+		return true
+	}
+	for _, f := range rc.filetypes {
+		if f == ft {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultComplete is the completer used when autocompleting in a buffer.
+// The first primary completer with suggestions wins; otherwise the
+// language server suggestions are merged with the ones from additive
+// completers and sorted by similarity to the word being completed. Words
+// from the buffers are used when nothing else has suggestions
+func DefaultComplete(b *Buffer) []Completion {
+	ft := b.Settings["filetype"].(string)
+
+	var active []*registeredCompleter
+	for i := range completers {
+		if completers[i].activeFor(ft) {
+			active = append(active, &completers[i])
+		}
+	}
+
+	for _, rc := range active {
+		if rc.primary {
+			if comp := rc.complete(b); len(comp) > 0 {
+				return comp
+			}
+		}
+	}
+
+	comp := LSPComplete(b)
+	merged := false
+	for _, rc := range active {
+		if !rc.primary {
+			if extra := rc.complete(b); len(extra) > 0 {
+				comp = append(comp, extra...)
+				merged = true
+			}
+		}
+	}
+	if merged {
+		input, _ := GetWord(b)
+		sort.Stable(completionSort{comp, string(input)})
+	}
+
+	if len(comp) == 0 {
+		return BufferComplete(b)
+	}
+	return comp
 }
 
 // Autocomplete starts the autocomplete process
 func (b *Buffer) Autocomplete(c Completer) bool {
+	b.incompleteCompletions = false
 	b.Completions = c(b)
 	if len(b.Completions) == 0 {
 		return false
@@ -59,18 +177,7 @@ func (b *Buffer) CycleAutocomplete(forward bool) {
 		b.CurCompletion = len(b.Completions) - 1
 	}
 
-	// undo prev completion
-	if prevCompletion != -1 {
-		prev := b.Completions[prevCompletion]
-		for i := 0; i < len(prev.Edits); i++ {
-			if len(prev.Edits[i].Text) != 0 {
-				b.UndoOneEvent()
-			}
-			if !prev.Edits[i].Start.Equal(prev.Edits[i].End) {
-				b.UndoOneEvent()
-			}
-		}
-	}
+	b.undoCompletion(prevCompletion)
 
 	// apply current completion
 	comp := b.Completions[b.CurCompletion]
@@ -80,97 +187,255 @@ func (b *Buffer) CycleAutocomplete(forward bool) {
 	}
 }
 
+// undoCompletion undoes the edits of the completion at index i, if it is
+// one of the completions
+func (b *Buffer) undoCompletion(i int) {
+	if i < 0 || i >= len(b.Completions) {
+		return
+	}
+	prev := b.Completions[i]
+	for i := 0; i < len(prev.Edits); i++ {
+		if len(prev.Edits[i].Text) != 0 {
+			b.UndoOneEvent()
+		}
+		if !prev.Edits[i].Start.Equal(prev.Edits[i].End) {
+			b.UndoOneEvent()
+		}
+	}
+}
+
+// CompletionsIncomplete returns true if the suggestions being cycled
+// include an incomplete list from a language server, which must be asked
+// again instead of filtering the list as the word gets longer
+func (b *Buffer) CompletionsIncomplete() bool {
+	return b.HasSuggestions && b.incompleteCompletions
+}
+
+// UndoCompletion removes the suggestion inserted by the last
+// autocompletion, so the word is back to what the user typed
+func (b *Buffer) UndoCompletion() {
+	b.undoCompletion(b.CurCompletion)
+	b.CurCompletion = -1
+	b.HasSuggestions = false
+}
+
+// IsNonWordChar returns whether r separates words in this buffer. This is
+// any non alphanumeric character that is not listed in the wordchars option
+func (b *Buffer) IsNonWordChar(r rune) bool {
+	return util.NonWordCharFunc(b.Settings["wordchars"].(string))(r)
+}
+
 // GetWord gets the most recent word separated by any separator
-// (whitespace, punctuation, any non alphanumeric character)
+// (whitespace, punctuation, any non alphanumeric character that is not
+// part of the buffer's wordchars)
 func GetWord(b *Buffer) ([]byte, int) {
 	c := b.GetActiveCursor()
 	l := b.LineBytes(c.Y)
 	l = util.SliceStart(l, c.X)
 
 	la := b.GetLineArray()
+	isSep := util.NonWordCharFunc(b.Settings["wordchars"].(string))
 
 	if c.X == 0 || util.IsWhitespace(b.RuneAt(c.Loc.MoveLA(-1, la))) {
 		return []byte{}, -1
 	}
 
-	if util.IsNonAlphaNumeric(b.RuneAt(c.Loc.MoveLA(-1, la))) {
+	if isSep(b.RuneAt(c.Loc.MoveLA(-1, la))) {
 		return []byte{}, c.X
 	}
 
-	args := bytes.FieldsFunc(l, util.IsNonAlphaNumeric)
+	args := bytes.FieldsFunc(l, isSep)
 	input := args[len(args)-1]
 	return input, c.X - util.CharacterCount(input)
 }
 
-// GetArg gets the most recent word (separated by ' ' only)
+// GetArg gets the argument of the command being typed before the cursor,
+// and where it starts. Arguments are split like the command line is, so
+// quotes and backslashes are removed from the returned argument, and an
+// unterminated quote makes the rest of the line a single argument. Unlike
+// GetWord, the wordchars option doesn't apply: only unquoted whitespace
+// separates arguments, so paths and option values stay whole
 func GetArg(b *Buffer) (string, int) {
+	input, argstart, _ := parseArg(b)
+	return input, argstart
+}
+
+// parseArg is GetArg, and also returns the quote that is still open at the
+// cursor, or 0 if there is none
+func parseArg(b *Buffer) (string, int, rune) {
 	c := b.GetActiveCursor()
 	l := b.LineBytes(c.Y)
 	l = util.SliceStart(l, c.X)
 
-	args := bytes.Split(l, []byte{' '})
-	input := string(args[len(args)-1])
+	var arg []byte
 	argstart := 0
-	for i, a := range args {
-		if i == len(args)-1 {
-			break
+	var quote rune
+	escaped := false
+	for i := 0; len(l) > 0; i++ {
+		r, combc, size := util.DecodeCharacter(l)
+		ch := l[:size]
+		l = l[size:]
+
+		switch {
+		case escaped:
+			escaped = false
+			// in double quotes, backslashes only escape these characters
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				arg = append(arg, '\\')
+			}
+			arg = append(arg, ch...)
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg = append(arg, ch...)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg = append(arg, ch...)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' && len(combc) == 0:
+			arg = arg[:0]
+			argstart = i + 1
+		default:
+			arg = append(arg, ch...)
 		}
-		argstart += util.CharacterCount(a) + 1
 	}
 
-	return input, argstart
+	return string(arg), argstart, quote
+}
+
+// quoteArg escapes the characters of s that would end or split an argument
+// in which quote is open, or an unquoted argument if quote is 0
+func quoteArg(s string, quote rune) string {
+	var special string
+	switch quote {
+	case '\'':
+		return strings.ReplaceAll(s, "'", `'\''`)
+	case '"':
+		special = "$`\"\\"
+	default:
+		special = " \t'\"\\"
+	}
+
+	var quoted strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	return quoted.String()
+}
+
+// expandPath expands a leading ~ and any environment variables in path.
+// Unknown variables and unresolvable home directories are left as typed
+func expandPath(path, sep string) string {
+	path = os.Expand(path, func(v string) string {
+		if val, ok := os.LookupEnv(v); ok {
+			return val
+		}
+		return "$" + v
+	})
+
+	if strings.HasPrefix(path, "~") {
+		end := strings.Index(path, sep)
+		if end == -1 {
+			end = len(path)
+		}
+		if home, err := util.ReplaceHome(path[:end]); err == nil {
+			path = home + path[end:]
+		}
+	}
+
+	return path
+}
+
+// splitPath splits path into its directory part (including the trailing
+// separator) and the final, possibly incomplete, element
+func splitPath(path, sep string) (string, string) {
+	i := strings.LastIndex(path, sep)
+	if i == -1 {
+		return "", path
+	}
+	return path[:i+len(sep)], path[i+len(sep):]
 }
 
 // FileComplete autocompletes filenames
 func FileComplete(b *Buffer) []Completion {
 	c := b.GetActiveCursor()
-	input, argstart := GetArg(b)
+	input, _, quote := parseArg(b)
 
 	sep := string(os.PathSeparator)
-	dirs := strings.Split(input, sep)
+	dir, base := splitPath(expandPath(input, sep), sep)
 
-	var files []fs.DirEntry
-	var err error
-	if len(dirs) > 1 {
-		directories := strings.Join(dirs[:len(dirs)-1], sep) + sep
-
-		directories, _ = util.ReplaceHome(directories)
-		files, err = os.ReadDir(directories)
-	} else {
-		files, err = os.ReadDir(".")
+	if dir == "" {
+		dir = "."
 	}
-
+	files, err := os.ReadDir(dir)
 	if err != nil {
 		return nil
 	}
 
+	// Dotfiles are only offered when asked for explicitly, like in a shell
+	showHidden := b.Settings["completehidden"].(bool) || strings.HasPrefix(base, ".")
+
 	var suggestions []string
 	for _, f := range files {
 		name := f.Name()
+		if !showHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
 		if f.IsDir() {
 			name += sep
 		}
-		if strings.HasPrefix(name, dirs[len(dirs)-1]) {
+		if strings.HasPrefix(name, base) {
 			suggestions = append(suggestions, name)
 		}
 	}
 
+	// Only the part after what the user has typed is inserted, so the
+	// original (unexpanded) input is left untouched. It is escaped to stay
+	// in the same argument
 	sort.Strings(suggestions)
 	completions := make([]string, len(suggestions))
 	for i := range suggestions {
-		var complete string
-		if len(dirs) > 1 {
-			complete = strings.Join(dirs[:len(dirs)-1], sep) + sep + suggestions[i]
-		} else {
-			complete = suggestions[i]
-		}
-		completions[i] = util.SliceEndStr(complete, c.X-argstart)
+		completions[i] = quoteArg(util.SliceEndStr(suggestions[i], util.CharacterCountInString(base)), quote)
 	}
 
 	return ConvertCompletions(completions, suggestions, c)
 }
 
-// BufferComplete autocompletes based on previous words in the buffer
+// maxBufferCompletions caps the number of suggestions BufferComplete
+// gathers once it starts looking at other open buffers
+const maxBufferCompletions = 500
+
+// wordSuggestions appends to suggestions every word in l that starts with
+// input and has not been suggested yet
+func wordSuggestions(suggestions []string, seen map[string]struct{}, l, input []byte, isSep func(rune) bool) []string {
+	inputLen := util.CharacterCount(input)
+	words := bytes.FieldsFunc(l, isSep)
+	for _, w := range words {
+		if bytes.HasPrefix(w, input) && util.CharacterCount(w) > inputLen {
+			strw := string(w)
+			if _, ok := seen[strw]; !ok {
+				seen[strw] = struct{}{}
+				suggestions = append(suggestions, strw)
+			}
+		}
+	}
+	return suggestions
+}
+
+// BufferComplete autocompletes based on previous words in the buffer, and
+// in the other open buffers if completeallbuffers is enabled. Words from
+// the current buffer are always suggested first
 func BufferComplete(b *Buffer) []Completion {
 	c := b.GetActiveCursor()
 	input, argstart := GetWord(b)
@@ -179,37 +444,38 @@ func BufferComplete(b *Buffer) []Completion {
 		return nil
 	}
 
-	inputLen := util.CharacterCount(input)
-
 	suggestionsSet := make(map[string]struct{})
+	isSep := util.NonWordCharFunc(b.Settings["wordchars"].(string))
 
 	var suggestions []string
 	for i := c.Y; i >= 0; i-- {
-		l := b.LineBytes(i)
-		words := bytes.FieldsFunc(l, util.IsNonAlphaNumeric)
-		for _, w := range words {
-			if bytes.HasPrefix(w, input) && util.CharacterCount(w) > inputLen {
-				strw := string(w)
-				if _, ok := suggestionsSet[strw]; !ok {
-					suggestionsSet[strw] = struct{}{}
-					suggestions = append(suggestions, strw)
-				}
-			}
-		}
+		suggestions = wordSuggestions(suggestions, suggestionsSet, b.LineBytes(i), input, isSep)
 	}
 	for i := c.Y + 1; i < b.LinesNum(); i++ {
-		l := b.LineBytes(i)
-		words := bytes.FieldsFunc(l, util.IsNonAlphaNumeric)
-		for _, w := range words {
-			if bytes.HasPrefix(w, input) && util.CharacterCount(w) > inputLen {
-				strw := string(w)
-				if _, ok := suggestionsSet[strw]; !ok {
-					suggestionsSet[strw] = struct{}{}
-					suggestions = append(suggestions, strw)
+		suggestions = wordSuggestions(suggestions, suggestionsSet, b.LineBytes(i), input, isSep)
+	}
+
+	if b.Settings["completeallbuffers"].(bool) {
+		scanned := map[*SharedBuffer]bool{b.SharedBuffer: true}
+	outer:
+		for _, buf := range OpenBuffers {
+			if scanned[buf.SharedBuffer] || buf.Type == BTInfo || buf.Type == BTLog || buf.Type == BTRaw {
+				continue
+			}
+			scanned[buf.SharedBuffer] = true
+
+			for i := 0; i < buf.LinesNum(); i++ {
+				if len(suggestions) >= maxBufferCompletions {
+					break outer
 				}
+				suggestions = wordSuggestions(suggestions, suggestionsSet, buf.LineBytes(i), input, isSep)
 			}
 		}
+		if len(suggestions) > maxBufferCompletions {
+			suggestions = suggestions[:maxBufferCompletions]
+		}
 	}
+
 	if len(suggestions) > 1 {
 		suggestions = append(suggestions, string(input))
 	}
@@ -273,27 +539,43 @@ func LSPComplete(b *Buffer) []Completion {
 	}
 
 	c := b.GetActiveCursor()
-	l := c
-	pos := l.ToPos()
 
-	fn := func(s *lsp.Server) ([]protocol.CompletionItem, bool) {
-		res, err := s.Completion(b.AbsPath, pos)
-		if err == nil { return res, true }
+	fn := func(s *lsp.Server) (lsp.CompletionResult, bool) {
+		res, err := s.Completion(b.AbsPath, b.LSPPos(s, c.Loc))
+		if err == nil {
+			items := res.Items
+			for i := range items {
+				if items[i].TextEdit != nil {
+					te := *items[i].TextEdit
+					te.Range = b.decodeRange(s, te.Range)
+					items[i].TextEdit = &te
+				}
+				items[i].AdditionalTextEdits = b.DecodeEdits(s, items[i].AdditionalTextEdits)
+			}
+			return res, true
+		}
 		s.Log(s.GetLanguage().Name, "[LSP ERROR]: ", err.Error())
-		return nil, false
+		return lsp.CompletionResult{}, false
 	}
 
-	items := util.Fold(util.ChanMapAll(b.Servers, fn)...)
+	var items []protocol.CompletionItem
+	for _, res := range util.ChanMapAll(b.Servers, fn) {
+		items = append(items, res.Items...)
+		if res.Incomplete {
+			b.incompleteCompletions = true
+		}
+	}
 
 	completions := make([]Completion, len(items))
 	input, argstart := GetWord(b)
 
 	for i, item := range items {
 		completions[i] = Completion{
-			Label:  item.Label,
-			Detail: item.Detail,
-			Kind:   toKindStr(item.Kind),
-			Doc:    getDoc(item.Documentation),
+			Label:    item.Label,
+			Detail:   item.Detail,
+			Kind:     toKindStr(item.Kind),
+			KindEnum: item.Kind,
+			Doc:      getDoc(item.Documentation),
 		}
 
 		if item.TextEdit != nil && len(item.TextEdit.NewText) > 0 {
@@ -359,6 +641,20 @@ func toKindStr(k protocol.CompletionItemKind) string {
 	return strings.ToLower(s)
 }
 
+// KindIcon returns the glyph the completionicons option assigns to a
+// completion kind (as produced by toKindStr), or the kind itself if no
+// icon is configured for it
+func KindIcon(kind string) string {
+	icons, ok := config.GetGlobalOption("completionicons").(map[string]interface{})
+	if !ok {
+		return kind
+	}
+	if icon, ok := icons[kind].(string); ok && icon != "" {
+		return icon
+	}
+	return kind
+}
+
 // returns documentation from a string | MarkupContent item
 func getDoc(documentation interface{}) string {
 	var doc string
@@ -0,0 +1,240 @@
+package buffer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+func TestSplitPath(t *testing.T) {
+	dir, base := splitPath("~/pro", "/")
+	assert.Equal(t, "~/", dir)
+	assert.Equal(t, "pro", base)
+
+	dir, base = splitPath("pro", "/")
+	assert.Equal(t, "", dir)
+	assert.Equal(t, "pro", base)
+
+	dir, base = splitPath("/usr/local/", "/")
+	assert.Equal(t, "/usr/local/", dir)
+	assert.Equal(t, "", base)
+
+	dir, base = splitPath(`C:\Users\fo`, `\`)
+	assert.Equal(t, `C:\Users\`, dir)
+	assert.Equal(t, "fo", base)
+
+	dir, base = splitPath(`C:`, `\`)
+	assert.Equal(t, "", dir)
+	assert.Equal(t, "C:", base)
+}
+
+func TestExpandPath(t *testing.T) {
+	home, err := util.ReplaceHome("~")
+	assert.NoError(t, err)
+
+	assert.Equal(t, home, expandPath("~", "/"))
+	assert.Equal(t, home+"/pro", expandPath("~/pro", "/"))
+	assert.Equal(t, home+`\pro`, expandPath(`~\pro`, `\`))
+
+	t.Setenv("MICRO_TEST_DIR", "/tmp/micro")
+	assert.Equal(t, "/tmp/micro/pro", expandPath("$MICRO_TEST_DIR/pro", "/"))
+	assert.Equal(t, "/tmp/micro/pro", expandPath("${MICRO_TEST_DIR}/pro", "/"))
+	assert.Equal(t, `/tmp/micro\pro`, expandPath(`$MICRO_TEST_DIR\pro`, `\`))
+
+	assert.Equal(t, "$MICRO_UNSET_VAR/pro", expandPath("$MICRO_UNSET_VAR/pro", "/"))
+}
+
+func TestGetWordWordChars(t *testing.T) {
+	b := NewBufferFromString("a { color: var(--main-co", "", BTDefault)
+	b.GetActiveCursor().GotoLoc(Loc{X: 24, Y: 0})
+
+	word, start := GetWord(b)
+	assert.Equal(t, []byte("co"), word)
+	assert.Equal(t, 22, start)
+
+	b.Settings["wordchars"] = "-"
+	word, start = GetWord(b)
+	assert.Equal(t, []byte("--main-co"), word)
+	assert.Equal(t, 15, start)
+}
+
+func TestKindIcon(t *testing.T) {
+	defer func() {
+		config.GlobalSettings["completionicons"] = map[string]interface{}{}
+	}()
+
+	assert.Equal(t, "function", KindIcon("function"))
+
+	config.GlobalSettings["completionicons"] = map[string]interface{}{
+		"function": "ƒ",
+	}
+	assert.Equal(t, "ƒ", KindIcon("function"))
+	assert.Equal(t, "variable", KindIcon("variable"))
+}
+
+func TestDefaultCompleteRegistered(t *testing.T) {
+	defer func() { completers = nil }()
+
+	b := NewBufferFromString("foobar\nfo", "", BTDefault)
+	b.Settings["filetype"] = "go"
+	b.GetActiveCursor().GotoLoc(Loc{X: 2, Y: 1})
+
+	labels := func(comp []Completion) []string {
+		var out []string
+		for _, c := range comp {
+			out = append(out, c.Label)
+		}
+		return out
+	}
+	fixed := func(words ...string) Completer {
+		return func(b *Buffer) []Completion {
+			return ConvertCompletions(words, words, b.GetActiveCursor())
+		}
+	}
+
+	// falls back to words from the buffer
+	assert.Equal(t, []string{"foobar"}, labels(DefaultComplete(b)))
+
+	RegisterCompleter("snippets", fixed("xyz", "for"))
+	RegisterCompleter("python", fixed("from"), "python")
+	assert.Equal(t, []string{"for", "xyz"}, labels(DefaultComplete(b)))
+
+	RegisterPrimaryCompleter("primary", fixed("format"), "go")
+	assert.Equal(t, []string{"format"}, labels(DefaultComplete(b)))
+
+	UnregisterCompleter("primary")
+	RegisterCompleter("snippets", fixed("fox"))
+	assert.Equal(t, []string{"fox"}, labels(DefaultComplete(b)))
+}
+
+func TestBufferCompleteAllBuffers(t *testing.T) {
+	var many []string
+	for i := 0; i < maxBufferCompletions+100; i++ {
+		many = append(many, fmt.Sprintf("fo%d", i))
+	}
+
+	type other struct {
+		text  string
+		btype BufType
+	}
+	tests := []struct {
+		name   string
+		all    bool
+		text   string
+		others []other
+		want   []string
+		// the number of suggestions, checked instead of want if set
+		count int
+	}{
+		{"off", false, "fo", []other{{"foobar", BTDefault}}, nil, 0},
+		{"other buffer", true, "fo", []other{{"foobar", BTDefault}}, []string{"foobar"}, 0},
+		{"current buffer first", true, "fox\nfo", []other{{"foobar", BTDefault}}, []string{"fox", "foobar", "fo"}, 0},
+		{"dedup", true, "foo fo", []other{{"foo fox", BTDefault}, {"fox foo", BTDefault}}, []string{"foo", "fox", "fo"}, 0},
+		{"skips log buffers", true, "fo", []other{{"foobar", BTLog}}, nil, 0},
+		{"cap", true, "fo", []other{{strings.Join(many, " "), BTDefault}}, nil, maxBufferCompletions + 1},
+	}
+	// buffers left open by other tests would add suggestions
+	oldOpen := OpenBuffers
+	OpenBuffers = nil
+	t.Cleanup(func() { OpenBuffers = oldOpen })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBufferFromString(tt.text, "", BTDefault)
+			defer b.Close()
+			b.Settings["completeallbuffers"] = tt.all
+			last := b.LinesNum() - 1
+			b.GetActiveCursor().GotoLoc(Loc{X: util.CharacterCount(b.LineBytes(last)), Y: last})
+			for _, o := range tt.others {
+				ob := NewBufferFromString(o.text, "", o.btype)
+				defer ob.Close()
+			}
+
+			var labels []string
+			for _, c := range BufferComplete(b) {
+				labels = append(labels, c.Label)
+			}
+			if tt.count > 0 {
+				assert.Len(t, labels, tt.count)
+			} else {
+				assert.Equal(t, tt.want, labels)
+			}
+		})
+	}
+}
+
+func TestUndoIncompleteCompletion(t *testing.T) {
+	b := NewBufferFromString("fo", "", BTDefault)
+	defer b.Close()
+	b.GetActiveCursor().GotoLoc(Loc{X: 2, Y: 0})
+
+	complete := func(b *Buffer) []Completion {
+		return ConvertCompletions([]string{"o", "obar"}, []string{"foo", "foobar"}, b.GetActiveCursor())
+	}
+	assert.True(t, b.Autocomplete(complete))
+	assert.Equal(t, "foo", string(b.Bytes()))
+	assert.False(t, b.CompletionsIncomplete())
+
+	// only lists marked incomplete by a server are asked for again
+	b.incompleteCompletions = true
+	assert.True(t, b.CompletionsIncomplete())
+	b.UndoCompletion()
+	assert.Equal(t, "fo", string(b.Bytes()))
+	assert.False(t, b.CompletionsIncomplete())
+	assert.Equal(t, -1, b.CurCompletion)
+
+	// a new autocompletion resets the flag
+	assert.True(t, b.Autocomplete(complete))
+	assert.False(t, b.CompletionsIncomplete())
+}
+
+func TestGetArgQuoted(t *testing.T) {
+	arg := func(line string) (string, int) {
+		b := NewBufferFromString(line, "", BTInfo)
+		b.GetActiveCursor().GotoLoc(b.End())
+		return GetArg(b)
+	}
+	check := func(line, input string, argstart int) {
+		in, start := arg(line)
+		assert.Equal(t, input, in, line)
+		assert.Equal(t, argstart, start, line)
+	}
+
+	check("open my", "my", 5)
+	check("open ", "", 5)
+	check(`open "my fi`, "my fi", 5)
+	check(`open 'my fi`, "my fi", 5)
+	check(`open "my file" ot`, "ot", 15)
+	check(`open my\ fi`, "my fi", 5)
+	check(`open "a\"b\c`, `a"b\c`, 5)
+	check(`open 'a\b`, `a\b`, 5)
+	check(`open a"b c"d`, "ab cd", 5)
+	// non-word characters don't split arguments
+	check("open ../src/main.go", "../src/main.go", 5)
+	check("set tabsize=4", "tabsize=4", 4)
+}
+
+func TestFileCompleteQuoted(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "my file.txt"), nil, 0644)
+	complete := func(line string) string {
+		b := NewBufferFromString(line, "", BTInfo)
+		b.GetActiveCursor().GotoLoc(b.End())
+		comps := FileComplete(b)
+		if !assert.Len(t, comps, 1, line) { return "" }
+		return string(comps[0].Edits[0].Text)
+	}
+
+	assert.Equal(t, "le.txt", complete(`open "`+dir+`/my fi`))
+	assert.Equal(t, "le.txt", complete(`open '`+dir+`/my fi`))
+	assert.Equal(t, "le.txt", complete(`open `+dir+`/my\ fi`))
+	// completions are escaped to stay in the argument
+	assert.Equal(t, `\ file.txt`, complete(`open `+dir+`/my`))
+	assert.Equal(t, " file.txt", complete(`open "`+dir+`/my`))
+}
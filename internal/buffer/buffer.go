@@ -30,7 +30,6 @@ import (
 	lspt "go.lsp.dev/protocol"
 	"golang.org/x/text/encoding/htmlindex"
 	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
 	luar "layeh.com/gopher-luar"
 )
 
@@ -107,14 +106,27 @@ type SharedBuffer struct {
 
 	Completions   []Completion
 	CurCompletion int
+	// Whether a language server sent an incomplete list of Completions
+	incompleteCompletions bool
 
 	Messages []*Message
+	// highlight ranges by owner, see SetHighlights
+	highlights map[string][]HighlightRange
+	// virtual text added with AddVirtualText
+	virtualText []*VirtualText
+	// closed folds ordered by first line, see FoldToLevel
+	folds []Fold
+	// the file looked binary or couldn't be decoded with the encoding
+	// option, so the buffer was made readonly to avoid corrupting it
+	guardedReadonly bool
 
 	updateDiffTimer   *time.Timer
 	diffBase          []byte
 	diffBaseLineCount int
 	diffLock          sync.RWMutex
 	diff              map[int]DiffStatus
+	// the number of added, modified and deleted lines in diff
+	diffSummary [3]int
 
 	requestedBackup bool
 
@@ -134,12 +146,35 @@ type SharedBuffer struct {
 	SyntaxDef *highlight.Def
 
 	ModifiedThisFrame bool
+	// Number of times the text was modified, see Edits
+	edits uint64
+	// the counts of Stats for the whole buffer, valid while edits is
+	// statsEdits, so that the statusline doesn't count on every redraw
+	stats      [3]int
+	statsEdits uint64
+	hasStats   bool
 
 	// Hash of the original buffer -- empty if fastdirty is on
 	origHash [md5.Size]byte
 
 	Servers  []*lsp.Server
 	version int32
+
+	// Time of the last edit and the diagnostics displayed at that time,
+	// used to hold back new diagnostics while the user is typing
+	lastEdit   time.Time
+	shownDiags []lsp.Diagnostic
+	diagTimer  *time.Timer
+
+	// Inlay hints for the lines and version described by hintsKey
+	hintLock   sync.Mutex
+	hintsKey   inlayHintsKey
+	inlayHints []lsp.InlayHint
+}
+
+type inlayHintsKey struct {
+	start, end int
+	version    int32
 }
 
 func (b *SharedBuffer) insert(pos Loc, value []byte) {
@@ -149,8 +184,9 @@ func (b *SharedBuffer) insert(pos Loc, value []byte) {
 
 
 	inslines := bytes.Count(value, []byte{'\n'})
+	b.updateFolds(pos.Y, pos.Y, inslines)
 	b.MarkModified(pos.Y, pos.Y+inslines)
-	b.lspDidChange(pos, pos, string(value))
+	b.lspDidChange(b.lspRanges(pos, pos), string(value))
 }
 func (b *SharedBuffer) remove(start, end Loc) []byte {
 	b.isModified = true
@@ -158,28 +194,104 @@ func (b *SharedBuffer) remove(start, end Loc) []byte {
 	defer b.MarkModified(start.Y, end.Y)
 
 
+	// the range must be converted while the removed text is still there
+	ranges := b.lspRanges(start, end)
 	sub := b.LineArray.Remove(start, end)
-	b.lspDidChange(start, end, "")
+	b.updateFolds(start.Y, end.Y, start.Y-end.Y)
+	b.lspDidChange(ranges, "")
 	return sub
 }
 
-func (b *SharedBuffer) lspDidChange(start, end Loc, text string) {
+// lspRanges converts the range from start to end for each of the servers
+func (b *SharedBuffer) lspRanges(start, end Loc) []lspt.Range {
+	ranges := make([]lspt.Range, len(b.Servers))
+	for i, s := range b.Servers {
+		ranges[i] = b.LSPRange(s, start, end)
+	}
+	return ranges
+}
+
+func (b *SharedBuffer) lspDidChange(ranges []lspt.Range, text string) {
 	if b.HasLSP() {
 		b.version++
-		change := lspt.TextDocumentContentChangeEvent{
-			Range: lspt.Range{
-				Start: start.ToPos(),
-				End:   end.ToPos(),
-			},
-			Text: text,
+		for i, s := range b.Servers {
+			change := lspt.TextDocumentContentChangeEvent{
+				Range: ranges[i],
+				Text:  text,
+			}
+			s.DidChange(b.AbsPath, b.version, []lspt.TextDocumentContentChangeEvent{change})
 		}
 
-		for _, s := range b.Servers {
-			s.DidChange(b.AbsPath, b.version, []lspt.TextDocumentContentChangeEvent{change})
+		b.lastEdit = time.Now()
+		if delay := b.diagDelay(); delay > 0 {
+			// redraw once the user stops typing so held back diagnostics appear
+			if b.diagTimer == nil {
+				b.diagTimer = time.AfterFunc(delay, screen.Redraw)
+			} else {
+				b.diagTimer.Reset(delay)
+			}
 		}
 	}
 }
 
+// lspLine returns line y, or nil if it is out of bounds
+func (b *SharedBuffer) lspLine(y int) []byte {
+	if y < 0 || y >= b.LinesNum() { return nil }
+	return b.LineBytes(y)
+}
+
+// LSPPos converts l to a position in the encoding negotiated with s
+func (b *SharedBuffer) LSPPos(s *lsp.Server, l Loc) lspt.Position {
+	return lspt.Position{
+		Line:      uint32(l.Y),
+		Character: s.EncodeCharacter(b.lspLine(l.Y), l.X),
+	}
+}
+
+// LSPRange converts the range from start to end to a range in the encoding
+// negotiated with s
+func (b *SharedBuffer) LSPRange(s *lsp.Server, start, end Loc) lspt.Range {
+	return lspt.Range{Start: b.LSPPos(s, start), End: b.LSPPos(s, end)}
+}
+
+// LSPLoc converts a position sent by s to a location in the buffer
+func (b *SharedBuffer) LSPLoc(s *lsp.Server, p lspt.Position) Loc {
+	return Loc{
+		X: s.DecodeCharacter(b.lspLine(int(p.Line)), p.Character),
+		Y: int(p.Line),
+	}
+}
+
+// decodeRange converts a range sent by s to a range whose characters are
+// counted like buffer locations
+func (b *SharedBuffer) decodeRange(s *lsp.Server, r lspt.Range) lspt.Range {
+	start, end := b.LSPLoc(s, r.Start), b.LSPLoc(s, r.End)
+	return lspt.Range{Start: start.ToPos(), End: end.ToPos()}
+}
+
+// DecodeEdits converts the ranges of edits sent by s so that they can be
+// passed to ApplyEdits
+func (b *SharedBuffer) DecodeEdits(s *lsp.Server, edits []lspt.TextEdit) []lspt.TextEdit {
+	out := make([]lspt.TextEdit, len(edits))
+	for i, e := range edits {
+		out[i] = lspt.TextEdit{Range: b.decodeRange(s, e.Range), NewText: e.NewText}
+	}
+	return out
+}
+
+// DecodeDiagnostic converts the range of a diagnostic so that its
+// characters are counted like buffer locations
+func (b *SharedBuffer) DecodeDiagnostic(d lsp.Diagnostic) lsp.Diagnostic {
+	if d.Server != nil {
+		d.Range = b.decodeRange(d.Server, d.Range)
+	}
+	return d
+}
+
+func (b *SharedBuffer) diagDelay() time.Duration {
+	return time.Duration(b.Settings["lspdiagdelay"].(float64)) * time.Millisecond
+}
+
 func (b *SharedBuffer) ActiveServers() []*lsp.Server {
 	var servers []*lsp.Server
 	for _, s := range b.Servers {
@@ -190,15 +302,34 @@ func (b *SharedBuffer) ActiveServers() []*lsp.Server {
 	return servers
 }
 
-// HasLSP returns whether this buffer is communicating with an LSP server
+// ServerStatuses describes the language servers attached to the buffer,
+// with their name, version and state
+func (b *SharedBuffer) ServerStatuses() []string {
+	var statuses []string
+	for _, s := range b.Servers {
+		statuses = append(statuses, s.Status())
+	}
+	return statuses
+}
+
+// HasLSP returns whether this buffer is communicating with an LSP server.
+// It is always false when the lsp option is off
 func (b *SharedBuffer) HasLSP() bool {
-	return len(b.ActiveServers()) > 0
+	return b.Settings["lsp"].(bool) && len(b.ActiveServers()) > 0
+}
+
+// Edits returns a counter that changes every time the text of the buffer is
+// modified, so that data computed from the text can be cached
+func (b *SharedBuffer) Edits() uint64 {
+	return b.edits
 }
 
 // MarkModified marks the buffer as modified for this frame
 // and performs rehighlighting if syntax highlighting is enabled
 func (b *SharedBuffer) MarkModified(start, end int) {
 	b.ModifiedThisFrame = true
+	b.edits++
+	hovers.invalidate(b.AbsPath)
 
 	start = util.Clamp(start, 0, b.Len()-1)
 	end = util.Clamp(end, 0, b.Len()-1)
@@ -247,6 +378,9 @@ type Buffer struct {
 	curCursor   int
 	StartCursor Loc
 
+	// an edit was rejected because the buffer is read-only
+	editRejected bool
+
 	// OptionCallback is called after a buffer option value is changed.
 	// The display module registers its OptionCallback to ensure the buffer window
 	// is properly updated when needed. This is a workaround for the fact that
@@ -260,6 +394,13 @@ type Buffer struct {
 	// from buffer to display, but it would require rewriting a lot of code.
 	GetVisualX func(loc Loc) int
 
+	// The display module registers GetViewStart to report the first line
+	// and column shown in the window, which are saved by SaveView
+	GetViewStart func() (line, col int)
+
+	// view restored by the saveview option, see StartView
+	startView *ViewState
+
 	// Last search stores the last successful search
 	LastSearch      string
 	LastSearchRegex bool
@@ -346,6 +487,17 @@ func NewBufferFromString(text, path string, btype BufType) *Buffer {
 	return NewBuffer(strings.NewReader(text), int64(len(text)), path, Loc{-1, -1}, btype)
 }
 
+// OpenScratch creates a read-only scratch buffer named title, for showing
+// the output of commands. Scratch buffers are never modified, so closing
+// them doesn't ask to save
+func OpenScratch(title, content string) *Buffer {
+	b := NewBufferFromString(content, "", BTScratch)
+	b.SetName(title)
+	b.Type.Readonly = true
+	b.Settings["readonly"] = true
+	return b
+}
+
 // NewBuffer creates a new buffer from a given reader with a given path
 // Ensure that ReadSettings and InitGlobalSettings have been called before creating
 // a new buffer
@@ -400,7 +552,9 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 		b.Settings["filetype"] = settings["filetype"]
 		b.Settings["syntax"] = settings["syntax"]
 
-		enc, err := htmlindex.Get(settings["encoding"].(string))
+		encoding := config.NormalizeEncoding(settings["encoding"].(string))
+		b.Settings["encoding"] = encoding
+		enc, err := htmlindex.Get(encoding)
 		if err != nil {
 			enc = unicode.UTF8
 			b.Settings["encoding"] = "utf-8"
@@ -413,7 +567,10 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 			return NewBufferFromString("", "", btype)
 		}
 		if !hasBackup {
-			reader := bufio.NewReader(transform.NewReader(r, enc.NewDecoder()))
+			reader, check := newDecodeCheck(r, enc, util.IntOpt(settings["binarychecksize"])*binaryCheckUnit)
+			if stripBOM(reader) {
+				b.Settings["bom"] = true
+			}
 
 			var ff FileFormat = FFAuto
 
@@ -429,6 +586,12 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 			}
 
 			b.LineArray = linearray.NewLineArray(uint64(size), ff, reader)
+
+			if reason := b.readonlyReason(check, settings); reason != "" {
+				b.guardedReadonly = true
+				b.Settings["readonly"] = true
+				if prompt != nil { prompt.Message(reason) }
+			}
 		}
 		b.EventHandler = NewEventHandler(b.SharedBuffer, b.cursors)
 
@@ -457,10 +620,15 @@ func NewBuffer(r io.Reader, size int64, path string, startcursor Loc, btype BufT
 
 	if startcursor.X != -1 && startcursor.Y != -1 {
 		b.StartCursor = startcursor
-	} else if b.Settings["savecursor"].(bool) || b.Settings["saveundo"].(bool) {
-		err := b.Unserialize()
-		if err != nil {
-			screen.TermMessage(err)
+	} else {
+		if b.Settings["savecursor"].(bool) || b.Settings["saveundo"].(bool) {
+			err := b.Unserialize()
+			if err != nil {
+				screen.TermMessage(err)
+			}
+		}
+		if b.Settings["saveview"].(bool) {
+			b.loadView()
 		}
 	}
 
@@ -516,29 +684,92 @@ func (b *Buffer) ReloadSettings() error {
 // or calls didOpen on them
 func (b *Buffer) lspInit() {
 	ft := lsp.Filetype(b.Settings["filetype"].(string))
-	languages := lsp.GetLanguages(ft)
-	if (len(languages) == 0) { WriteLogLn("No server found for language'", ft, "'"); return }
 
 	wd, err := os.Getwd()
 	if (err != nil) { return; }
 
-	util.ChanMapAll(languages, func(l lsp.LSPConfig) (bool, bool) {
-		if (!l.Installed()) {
-			WriteLogLn("Language server", l.Name, "is not installed!")
-			return false, false
+	// the workspace config is read from the project root of the file
+	root := lsp.WorkspaceRoot(ft, b.AbsPath, wd)
+	languages, err := lsp.GetWorkspaceLanguages(ft, root)
+	if err != nil {
+		WriteLogLn(err)
+		if prompt != nil { prompt.Message(err.Error()) }
+	}
+	if (len(languages) == 0) { WriteLogLn("No server found for language'", ft, "'"); return }
+
+	servers := util.ChanMapAll(languages, func(l lsp.LSPConfig) (*lsp.Server, bool) {
+		if err := l.CheckInstalled(); err != nil {
+			WriteLogLn("Language server", l.Name, "is not installed:", err)
+			reportCommandError(err)
+			return nil, false
 		}
 
-		s := lsp.GetOrStartServer(l, wd, b.AbsPath)
+		s, err := lsp.GetOrStartServer(l, wd, b.AbsPath)
+		if err != nil { reportCommandError(err) }
+		return s, s != nil
+	})
+	for _, s := range servers {
+		b.AttachServer(s)
+	}
+}
 
-		if s != nil {
-			bytes := b.Bytes()
-			if len(bytes) == 0 { bytes = []byte{'\n'} }
-			s.DidOpen(b.AbsPath, ft, string(bytes), b.version)
-			b.Servers = append(b.Servers, s)
+// AttachServer adds a server to the buffer and opens the file on it. Nothing
+// is done if the server is already attached or the lsp option is off
+func (b *Buffer) AttachServer(s *lsp.Server) {
+	// the option may have been turned off while the server was starting
+	if !b.Settings["lsp"].(bool) { return }
+	for _, attached := range b.Servers {
+		if attached == s { return }
+	}
+
+	bytes := b.Bytes()
+	if len(bytes) == 0 { bytes = []byte{'\n'} }
+	s.DidOpen(b.AbsPath, lsp.Filetype(b.Settings["filetype"].(string)), string(bytes), b.version)
+	pullDiagnostics(s, b.AbsPath)
+	b.Servers = append(b.Servers, s)
+	screen.Redraw()
+}
+
+// DetachServer closes the file on a server and removes the server from the
+// buffer. The server keeps running for the other buffers using it
+func (b *Buffer) DetachServer(s *lsp.Server) {
+	for i, attached := range b.Servers {
+		if attached != s { continue }
+		b.Servers = append(b.Servers[:i:i], b.Servers[i+1:]...)
+		s.DidClose(b.AbsPath)
+		screen.Redraw()
+		return
+	}
+}
+
+// pullDiagnostics requests the diagnostics of a file in the background, if
+// the server uses the pull model instead of publishing them
+func pullDiagnostics(s *lsp.Server, filename string) {
+	go func() {
+		// the server capabilities are only known once it is ready
+		if !s.WaitReady(5 * time.Second) || !s.SupportsPullDiagnostics() { return }
+		if _, err := s.PullDiagnostics(filename); err != nil {
+			WriteLogLn("Failed to pull diagnostics from", s.GetLanguage().Name+":", err)
 		}
+	}()
+}
 
-		return false, false
-	})
+// reportCommandError tells the user about misconfigured server commands,
+// which would otherwise only show up in the log
+func reportCommandError(err error) {
+	var empty *lsp.EmptyCommandError
+	if errors.As(err, &empty) && prompt != nil {
+		prompt.Message(err.Error())
+	}
+}
+
+func init() {
+	// lets restarted servers reopen the documents they had open
+	lsp.DocumentContents = func(filename string) (string, int32, bool) {
+		b := FindBufferByAbsPath(filename)
+		if b == nil { return "", 0, false }
+		return string(b.Bytes()), b.version, true
+	}
 }
 
 func (b *Buffer) LSPRestart() {
@@ -591,6 +822,7 @@ func (b *Buffer) Fini() {
 	if !b.Modified() {
 		b.Serialize()
 	}
+	b.SaveView()
 	b.RemoveBackup()
 
 	if b.Type == BTStdout {
@@ -628,9 +860,34 @@ func (b *Buffer) SetName(s string) {
 	b.name = s
 }
 
+// SetReadOnly locks or unlocks the buffer for editing, keeping its cursors
+// and undo history. Like the readonly option, it only changes the lock of
+// file buffers: help, log and other special buffers stay read-only
+func (b *Buffer) SetReadOnly(ro bool) {
+	b.SetOptionNative("readonly", ro)
+}
+
+// canEdit returns true if the buffer can be edited. Otherwise the attempt is
+// recorded, see EditRejected
+func (b *Buffer) canEdit() bool {
+	if b.Type.Readonly {
+		b.editRejected = true
+		return false
+	}
+	return true
+}
+
+// EditRejected returns true if an edit was rejected because the buffer is
+// read-only since the last call
+func (b *Buffer) EditRejected() bool {
+	r := b.editRejected
+	b.editRejected = false
+	return r
+}
+
 // Insert inserts the given string of text at the start location
 func (b *Buffer) Insert(start Loc, text string) {
-	if !b.Type.Readonly {
+	if b.canEdit() {
 		b.EventHandler.cursors = b.cursors
 		b.EventHandler.active = b.curCursor
 		b.EventHandler.Insert(start, text)
@@ -642,7 +899,7 @@ func (b *Buffer) Insert(start Loc, text string) {
 
 // Remove removes the characters between the start and end locations
 func (b *Buffer) Remove(start, end Loc) {
-	if !b.Type.Readonly {
+	if b.canEdit() {
 		b.EventHandler.cursors = b.cursors
 		b.EventHandler.active = b.curCursor
 		b.EventHandler.Remove(start, end)
@@ -652,6 +909,41 @@ func (b *Buffer) Remove(start, end Loc) {
 	}
 }
 
+// Replace replaces the text between start and end with the given string
+func (b *Buffer) Replace(start, end Loc, replace string) {
+	if b.canEdit() {
+		b.EventHandler.Replace(start, end, replace)
+	}
+}
+
+// ReplaceBytes is like Replace with a byte slice
+func (b *Buffer) ReplaceBytes(start, end Loc, replace []byte) {
+	if b.canEdit() {
+		b.EventHandler.ReplaceBytes(start, end, replace)
+	}
+}
+
+// MultipleReplace performs several replacements as a single undo step
+func (b *Buffer) MultipleReplace(deltas []Delta) {
+	if b.canEdit() {
+		b.EventHandler.MultipleReplace(deltas)
+	}
+}
+
+// Undo undoes the last edit, unless the buffer is read-only
+func (b *Buffer) Undo() {
+	if b.canEdit() {
+		b.EventHandler.Undo()
+	}
+}
+
+// Redo redoes the last undone edit, unless the buffer is read-only
+func (b *Buffer) Redo() {
+	if b.canEdit() {
+		b.EventHandler.Redo()
+	}
+}
+
 // ApplyEdit performs a LSP text edit on the buffer
 func (b *Buffer) ApplyEdit(e lspt.TextEdit) {
 	if len(e.NewText) == 0 {
@@ -664,7 +956,7 @@ func (b *Buffer) ApplyEdit(e lspt.TextEdit) {
 }
 
 func (b *Buffer) ApplyEdits(edits []lspt.TextEdit) {
-	if !b.Type.Readonly {
+	if b.canEdit() {
 		locs := make([]struct {
 			t          string
 			start, end Loc
@@ -698,7 +990,7 @@ func (b *Buffer) ApplyEdits(edits []lspt.TextEdit) {
 }
 
 func (b *Buffer) ApplyDeltas(deltas []Delta) {
-	if !b.Type.Readonly {
+	if b.canEdit() {
 		sort.Slice(deltas, func(i, j int) bool {
 			return deltas[i].Start.GreaterThan(deltas[j].Start)
 		})
@@ -738,7 +1030,7 @@ func (b *Buffer) GetRenameSymbol() (string, *lsp.Server, error) {
 	}
 
 	syms := util.ChanMapAll(b.Servers, func (s *lsp.Server) (ServerRenameSymbol, bool) {
-		sym, err := s.GetRenameSymbol(b.AbsPath, cur.ToPos())
+		sym, err := s.GetRenameSymbol(b.AbsPath, b.LSPPos(s, cur.Loc))
 		if err != nil {
 			sym = lsp.RenameSymbol{
 				CanRename: true,
@@ -771,8 +1063,10 @@ func (b *Buffer) GetRenameSymbol() (string, *lsp.Server, error) {
 	if sym.Placeholder != "" {
 		prompt_string = sym.Placeholder
 	} else if sym.UseRange {
-		line := b.Line(int(sym.Range.Start.Line))
-		prompt_string = line[sym.Range.Start.Character:sym.Range.End.Character]
+		r := b.decodeRange(syms[0].server, sym.Range)
+		line := b.LineBytes(int(r.Start.Line))
+		line = util.SliceStart(line, int(r.End.Character))
+		prompt_string = string(util.SliceEnd(line, int(r.Start.Character)))
 	} else if sym.UseDefault {
 		prompt_string = string(b.WordAt(cur.Loc))
 	} else {
@@ -833,13 +1127,27 @@ func (b *Buffer) ReOpen() error {
 		return err
 	}
 
-	reader := bufio.NewReader(transform.NewReader(file, enc.NewDecoder()))
+	reader, check := newDecodeCheck(file, enc, util.IntOpt(b.Settings["binarychecksize"])*binaryCheckUnit)
+	b.Settings["bom"] = stripBOM(reader)
 	data, err := io.ReadAll(reader)
 	txt := string(data)
 
 	if err != nil {
 		return err
 	}
+
+	// the buffer stays readonly until the file is reopened with an
+	// encoding that can decode it, or with openbinary
+	if reason := b.readonlyReason(check, b.Settings); reason != "" {
+		b.guardedReadonly = true
+		b.Settings["readonly"] = true
+		b.Type.Readonly = true
+		if prompt != nil { prompt.Message(reason) }
+	} else if b.guardedReadonly {
+		b.guardedReadonly = false
+		b.Settings["readonly"] = false
+		b.Type.Readonly = false
+	}
 	b.EventHandler.ApplyDiff(txt)
 
 	err = b.UpdateModTime()
@@ -937,6 +1245,64 @@ func (b *Buffer) Size() int {
 	return nb
 }
 
+// Stats returns the number of lines, words and characters in the buffer, or
+// in the active cursor's selection if sel is true. Words are separated by
+// whitespace, and line endings aren't counted as characters
+func (b *Buffer) Stats(sel bool) (lines, words, chars int) {
+	if sel {
+		return b.countStats(true)
+	}
+	if !b.hasStats || b.statsEdits != b.Edits() {
+		lines, words, chars = b.countStats(false)
+		b.stats, b.statsEdits, b.hasStats = [3]int{lines, words, chars}, b.Edits(), true
+	}
+	return b.stats[0], b.stats[1], b.stats[2]
+}
+
+func (b *Buffer) countStats(sel bool) (lines, words, chars int) {
+	start, end := b.Start(), b.End()
+	if sel {
+		c := b.GetActiveCursor()
+		if !c.HasSelection() || !InBounds(c.CurSelection[0], b) || !InBounds(c.CurSelection[1], b) {
+			return 0, 0, 0
+		}
+		start, end = c.CurSelection[0], c.CurSelection[1]
+		if start.GreaterThan(end) {
+			start, end = end, start
+		}
+	}
+
+	lines = end.Y - start.Y + 1
+	if sel && end.X == 0 && end.Y > start.Y {
+		// the selection ends at the start of the line after its last one
+		lines--
+	}
+
+	for y := start.Y; y <= end.Y; y++ {
+		line := b.LineBytes(y)
+		if y == end.Y {
+			line = util.SliceStart(line, end.X)
+		}
+		if y == start.Y {
+			line = util.SliceEnd(line, start.X)
+		}
+
+		inWord := false
+		for len(line) > 0 {
+			r, _, size := util.DecodeCharacter(line)
+			line = line[size:]
+			chars++
+			if util.IsWhitespace(r) {
+				inWord = false
+			} else if !inWord {
+				inWord = true
+				words++
+			}
+		}
+	}
+	return lines, words, chars
+}
+
 // calcHash calculates md5 hash of all lines in the buffer
 func calcHash(b *Buffer, out *[md5.Size]byte) error {
 	h := md5.New()
@@ -1441,6 +1807,7 @@ func (b *Buffer) updateDiffSync() {
 	defer b.diffLock.Unlock()
 
 	b.diff = make(map[int]DiffStatus)
+	b.diffSummary = [3]int{}
 
 	if b.diffBase == nil {
 		return
@@ -1450,6 +1817,9 @@ func (b *Buffer) updateDiffSync() {
 	baseRunes, bufferRunes, _ := differ.DiffLinesToRunes(string(b.diffBase), string(b.Bytes()))
 	diffs := differ.DiffMainRunes(baseRunes, bufferRunes, false)
 	lineN := 0
+	// lines deleted right before the current position, which count as
+	// modified rather than deleted if lines are inserted in their place
+	deleted := 0
 
 	for _, diff := range diffs {
 		lineCount := len([]rune(diff.Text))
@@ -1457,12 +1827,17 @@ func (b *Buffer) updateDiffSync() {
 		switch diff.Type {
 		case dmp.DiffEqual:
 			lineN += lineCount
+			b.diffSummary[2] += deleted
+			deleted = 0
 		case dmp.DiffInsert:
 			var status DiffStatus
 			if b.diff[lineN] == DSDeletedAbove {
 				status = DSModified
+				b.diffSummary[1] += lineCount
+				deleted = util.Max(deleted-lineCount, 0)
 			} else {
 				status = DSAdded
+				b.diffSummary[0] += lineCount
 			}
 			for i := 0; i < lineCount; i++ {
 				b.diff[lineN] = status
@@ -1470,8 +1845,10 @@ func (b *Buffer) updateDiffSync() {
 			}
 		case dmp.DiffDelete:
 			b.diff[lineN] = DSDeletedAbove
+			deleted += lineCount
 		}
 	}
+	b.diffSummary[2] += deleted
 }
 
 // UpdateDiff computes the diff between the diff base and the buffer content.
@@ -1505,6 +1882,7 @@ func (b *Buffer) UpdateDiff(callback func(bool)) {
 		// Don't compute diffs for very large files
 		b.diffLock.Lock()
 		b.diff = make(map[int]DiffStatus)
+		b.diffSummary = [3]int{}
 		b.diffLock.Unlock()
 		callback(true)
 	}
@@ -1531,109 +1909,324 @@ func (b *Buffer) DiffStatus(lineN int) DiffStatus {
 	return b.diff[lineN]
 }
 
+// DiffSummary returns the number of lines added, modified and deleted
+// compared to the diff base, as of the last diff update
+func (b *Buffer) DiffSummary() (added, modified, deleted int) {
+	b.diffLock.RLock()
+	defer b.diffLock.RUnlock()
+	return b.diffSummary[0], b.diffSummary[1], b.diffSummary[2]
+}
+
+// hoverSeparator separates the hover information of different servers
+const hoverSeparator = "\n---\n"
+
 func (b *Buffer) LSPHover() (string, error) {
 	if !b.HasLSP() {
 		return "", nil
 	}
 
-	cur := b.GetActiveCursor()
-	cp := cur.ToPos()
+	return b.CachedHover(b.GetActiveCursor().ToPos())
+}
 
-	fn := func (s *lsp.Server) (string, bool) {
-		info, err := s.Hover(b.AbsPath, cp)
-		if err == nil && info != "" {
-			return info, true
-		}
-		if err != nil {
-			WriteLogLn("LSP Hover Error (" + s.GetLanguage().Name + ")", err)
+// hoverCacheSize is the number of hover results kept by CachedHover
+const hoverCacheSize = 32
+
+type hoverKey struct {
+	path      string
+	line, col uint32
+	// results from before an edit never match, even if they are stored
+	// after the edit invalidated the file
+	edits uint64
+}
+
+// hoverCache keeps the most recently used hover results. The results of a
+// file are removed when it is edited
+type hoverCache struct {
+	lock sync.Mutex
+	// least recently used first
+	keys  []hoverKey
+	infos map[hoverKey]string
+}
+
+var hovers hoverCache
+
+func (c *hoverCache) get(k hoverKey) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	info, ok := c.infos[k]
+	if ok {
+		c.touch(k)
+	}
+	return info, ok
+}
+
+func (c *hoverCache) put(k hoverKey, info string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.infos == nil {
+		c.infos = make(map[hoverKey]string)
+	}
+	if _, ok := c.infos[k]; ok {
+		c.touch(k)
+	} else {
+		if len(c.keys) == hoverCacheSize {
+			delete(c.infos, c.keys[0])
+			c.keys = c.keys[1:]
 		}
-		return "", false
+		c.keys = append(c.keys, k)
 	}
+	c.infos[k] = info
+}
 
-	infostr := strings.Join(util.ChanMapAll(b.Servers, fn), "\n")
-	splits := strings.Split(infostr, "\n")
+// touch moves k to the end of the keys, as the most recently used
+func (c *hoverCache) touch(k hoverKey) {
+	for i, key := range c.keys {
+		if key == k {
+			c.keys = append(append(c.keys[:i:i], c.keys[i+1:]...), k)
+			return
+		}
+	}
+}
 
-	var filtered_splits []string
-	for _, str := range splits {
-		if strings.TrimSpace(str) != "" {
-			filtered_splits = append(filtered_splits, str)
+// invalidate removes the results of the file at path
+func (c *hoverCache) invalidate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	keys := c.keys[:0]
+	for _, k := range c.keys {
+		if k.path == path {
+			delete(c.infos, k)
+		} else {
+			keys = append(keys, k)
 		}
 	}
+	c.keys = keys
+}
 
-	return strings.Join(filtered_splits, "\n"), nil
+// CachedHover is like Hover, but returns the previous result if the same
+// position was hovered since the buffer was last edited
+func (b *Buffer) CachedHover(pos lspt.Position) (string, error) {
+	return b.HoverQuery(pos).Cached()
 }
 
-func (b *Buffer) LSPDefinition() ([]lspt.Location, error) {
-	if !b.HasLSP() {
-		return nil, nil
+// Hover queries all servers attached to the buffer for hover information at
+// pos, given in buffer characters, and combines the non-empty results.
+// Servers that don't support hover are skipped; an error is only returned
+// if no server produced a result
+func (b *Buffer) Hover(pos lspt.Position) (string, error) {
+	return b.HoverQuery(pos).Run()
+}
+
+// A HoverQuery holds what a hover request needs from the buffer, so that
+// the servers can be queried off the main loop without reading the buffer
+type HoverQuery struct {
+	key     hoverKey
+	targets []hoverTarget
+}
+
+// hoverTarget is a server and the hovered position in its encoding
+type hoverTarget struct {
+	server *lsp.Server
+	pos    lspt.Position
+}
+
+// HoverQuery prepares a hover request at pos, given in buffer characters
+func (b *Buffer) HoverQuery(pos lspt.Position) HoverQuery {
+	q := HoverQuery{key: hoverKey{path: b.AbsPath, line: pos.Line, col: pos.Character, edits: b.Edits()}}
+	for _, s := range b.Servers {
+		q.targets = append(q.targets, hoverTarget{server: s, pos: b.LSPPos(s, loc.ToLoc(pos))})
 	}
+	return q
+}
 
-	cur := b.GetActiveCursor()
-	cp := cur.ToPos()
+// Cached is like Run, but returns the previous result if the same position
+// was hovered since the buffer was last edited
+func (q HoverQuery) Cached() (string, error) {
+	if info, ok := hovers.get(q.key); ok {
+		return info, nil
+	}
+
+	info, err := q.Run()
+	if err == nil {
+		hovers.put(q.key, info)
+	}
+	return info, err
+}
 
-	fn := func(s *lsp.Server) ([]lspt.Location, bool) {
-		res, err := s.GetDefinition(b.AbsPath, cp)
-		if err == nil { return res, true }
-		return nil, false
+// Run queries the servers for hover information, like Hover
+func (q HoverQuery) Run() (string, error) {
+	type hoverResult struct {
+		info string
+		err  error
 	}
-	res := util.Fold(util.ChanMapAll(b.Servers, fn)...)
 
-	return res, nil
+	fn := func (t hoverTarget) (hoverResult, bool) {
+		s := t.server
+		info, err := s.Hover(q.key.path, t.pos)
+		if err == lsp.ErrNotSupported {
+			return hoverResult{}, false
+		}
+		if err != nil {
+			WriteLogLn("LSP Hover Error (" + s.GetLanguage().Name + ")", err)
+			return hoverResult{err: err}, true
+		}
+		return hoverResult{info: trimBlankLines(info)}, true
+	}
+
+	var infos []string
+	var err error
+	for _, res := range util.ChanMapAll(q.targets, fn) {
+		if res.err != nil {
+			if err == nil { err = res.err }
+			continue
+		}
+		if res.info != "" {
+			infos = append(infos, res.info)
+		}
+	}
+
+	if len(infos) == 0 {
+		return "", err
+	}
+	return strings.Join(infos, hoverSeparator), nil
 }
 
-func (b *Buffer) LSPDeclaration() ([]lspt.Location, error) {
-	if !b.HasLSP() {
-		return nil, nil
+// trimBlankLines removes all lines that only contain whitespace
+func trimBlankLines(str string) string {
+	var lines []string
+	for _, line := range strings.Split(str, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
 	}
+	return strings.Join(lines, "\n")
+}
 
+// lspLocations sends a location request for the cursor position to all
+// servers and joins the locations they answer with
+func (b *Buffer) lspLocations(req func(*lsp.Server, string, lspt.Position) ([]lspt.Location, error)) []lsp.Location {
 	cur := b.GetActiveCursor()
-	cp := cur.ToPos()
 
-	fn := func(s *lsp.Server) ([]lspt.Location, bool) {
-			res, err := s.GetDeclaration(b.AbsPath, cp)
-			if err == nil { return res, true }
-			return nil, false
+	fn := func(s *lsp.Server) ([]lsp.Location, bool) {
+		res, err := req(s, b.AbsPath, b.LSPPos(s, cur.Loc))
+		if err != nil { return nil, false }
+		locs := make([]lsp.Location, len(res))
+		for i, l := range res {
+			locs[i] = lsp.Location{Location: l, Server: s}
+		}
+		return locs, true
 	}
+	return util.Fold(util.ChanMapAll(b.Servers, fn)...)
+}
 
-	res := util.Fold(util.ChanMapAll(b.Servers, fn)...)
+func (b *Buffer) LSPDefinition() ([]lsp.Location, error) {
+	if !b.HasLSP() {
+		return nil, nil
+	}
+	return b.lspLocations((*lsp.Server).GetDefinition), nil
+}
 
-	return res, nil
+func (b *Buffer) LSPDeclaration() ([]lsp.Location, error) {
+	if !b.HasLSP() {
+		return nil, nil
+	}
+	return b.lspLocations((*lsp.Server).GetDeclaration), nil
 }
 
-func (b *Buffer) LSPTypeDefinition() ([]lspt.Location, error) {
+func (b *Buffer) LSPTypeDefinition() ([]lsp.Location, error) {
 	if !b.HasLSP() {
 		return nil, nil
 	}
+	return b.lspLocations((*lsp.Server).GetTypeDefinition), nil
+}
 
-	cur := b.GetActiveCursor()
-	cp := cur.ToPos()
+// UpdateInlayHints requests the inlay hints for the lines from start up to
+// end if the lspinlayhints option is on and the lines or the text changed
+// since the last request. The hints are fetched in the background and the
+// screen is redrawn once they arrive
+func (b *Buffer) UpdateInlayHints(start, end int) {
+	if !b.Settings["lspinlayhints"].(bool) || !b.HasLSP() { return }
 
-	fn := func(s *lsp.Server) ([]lspt.Location, bool) {
-		res, err := s.GetTypeDefinition(b.AbsPath, cp)
-		if err == nil { return res, true }
-		return nil, false
+	key := inlayHintsKey{start, end, b.version}
+	b.hintLock.Lock()
+	if b.hintsKey == key {
+		b.hintLock.Unlock()
+		return
 	}
+	b.hintsKey = key
+	b.hintLock.Unlock()
 
-	res := util.Fold(util.ChanMapAll(b.Servers, fn)...)
-	return res, nil
+	r := lspt.Range{
+		Start: lspt.Position{Line: uint32(start)},
+		End:   lspt.Position{Line: uint32(end)},
+	}
+	servers := b.Servers
+
+	go func() {
+		fn := func(s *lsp.Server) ([]lsp.InlayHint, bool) {
+			// the server capabilities are only known once it is ready
+			if !s.WaitReady(5 * time.Second) { return nil, false }
+			hints, err := s.InlayHints(b.AbsPath, r)
+			if err != nil { return nil, false }
+			for i := range hints {
+				hints[i].Server = s
+			}
+			return hints, true
+		}
+		hints := util.Fold(util.ChanMapAll(servers, fn)...)
+
+		b.hintLock.Lock()
+		// drop the result if a newer request was made in the meantime
+		if b.hintsKey == key {
+			b.inlayHints = hints
+		}
+		b.hintLock.Unlock()
+		screen.Redraw()
+	}()
 }
 
-func (b *Buffer) LSPReferences() ([]lspt.Location, error) {
-	if !b.HasLSP() {
-		return nil, nil
-	}
+// InlayHints returns the most recently received inlay hints, with their
+// positions counted in buffer characters
+func (b *Buffer) InlayHints() []lsp.InlayHint {
+	if !b.Settings["lspinlayhints"].(bool) { return nil }
 
-	cur := b.GetActiveCursor()
-	cp := cur.ToPos()
+	b.hintLock.Lock()
+	hints := b.inlayHints
+	b.hintLock.Unlock()
 
-	fn := func(s *lsp.Server) ([]lspt.Location, bool) {
-		res, err := s.FindReferences(b.AbsPath, cp)
-		if err == nil { return res, true }
-		return nil, false
+	out := make([]lsp.InlayHint, len(hints))
+	for i, h := range hints {
+		l := b.LSPLoc(h.Server, h.Position)
+		h.Position = l.ToPos()
+		out[i] = h
 	}
+	return out
+}
 
-	res := util.Fold(util.ChanMapAll(b.Servers, fn)...)
-	return res, nil
+// LSPSelectionRanges returns the syntactic ranges containing l, from the
+// innermost to the outermost, as reported by the first server that
+// supports selection ranges
+func (b *Buffer) LSPSelectionRanges(l Loc) ([][2]Loc, error) {
+	for _, s := range b.Servers {
+		res, err := s.SelectionRanges(b.AbsPath, []lspt.Position{b.LSPPos(s, l)})
+		if err == lsp.ErrNotSupported { continue }
+		if err != nil { return nil, err }
+		if len(res) == 0 { continue }
+
+		var ranges [][2]Loc
+		for _, r := range lsp.SelectionRangeChain(&res[0]) {
+			ranges = append(ranges, [2]Loc{b.LSPLoc(s, r.Start), b.LSPLoc(s, r.End)})
+		}
+		return ranges, nil
+	}
+	return nil, nil
+}
+
+func (b *Buffer) LSPReferences() ([]lsp.Location, error) {
+	if !b.HasLSP() {
+		return nil, nil
+	}
+	return b.lspLocations((*lsp.Server).FindReferences), nil
 }
 
 // SearchMatch returns true if the given location is within a match of the last search.
@@ -1644,12 +2237,56 @@ func (b *Buffer) SearchMatch(pos Loc) bool {
 
 func (b *Buffer) GetDiagnostics() []lsp.Diagnostic  {
 	fn := func (s *lsp.Server) ([]lsp.Diagnostic, bool) {
-		return s.GetDiagnostics(b.AbsPath), true
+		// copy, as the server's slice must not be modified
+		diags := s.GetDiagnostics(b.AbsPath)
+		decoded := make([]lsp.Diagnostic, len(diags))
+		for i, d := range diags {
+			decoded[i] = b.DecodeDiagnostic(d)
+		}
+		return decoded, true
 	}
 
 	return util.Fold(util.ChanMapAll(b.Servers, fn)...)
 }
 
+// AllDiagnostics returns the union of the diagnostics reported for this
+// buffer by all of its servers, without duplicates
+func (b *Buffer) AllDiagnostics() []lsp.Diagnostic {
+	return lsp.DedupDiagnostics(b.GetDiagnostics())
+}
+
+// DiagnosticsAt returns the diagnostics of all servers whose range contains
+// l, the most severe first
+func (b *Buffer) DiagnosticsAt(l Loc) []lsp.Diagnostic {
+	return lsp.DiagnosticsAt(b.AllDiagnostics(), l.ToPos())
+}
+
+// VisibleDiagnostics returns the diagnostics that should be displayed: those
+// at least as severe as the lspdiagseverity option. Until lspdiagdelay
+// milliseconds have passed since the last edit, the previously displayed
+// diagnostics are returned instead
+func (b *Buffer) VisibleDiagnostics() []lsp.Diagnostic {
+	if delay := b.diagDelay(); delay > 0 && time.Since(b.lastEdit) < delay {
+		return b.shownDiags
+	}
+
+	min := lsp.ParseSeverity(b.Settings["lspdiagseverity"].(string))
+	b.shownDiags = lsp.FilterDiagnostics(b.AllDiagnostics(), min)
+	return b.shownDiags
+}
+
+// NextDiagnostic returns the nearest diagnostic after l reported by any of
+// the buffer's servers, wrapping around at the end of the buffer
+func (b *Buffer) NextDiagnostic(l Loc) (*lsp.Diagnostic, bool) {
+	return lsp.FindNextDiagnostic(b.VisibleDiagnostics(), l.ToPos())
+}
+
+// PrevDiagnostic returns the nearest diagnostic before l reported by any of
+// the buffer's servers, wrapping around at the start of the buffer
+func (b *Buffer) PrevDiagnostic(l Loc) (*lsp.Diagnostic, bool) {
+	return lsp.FindPrevDiagnostic(b.VisibleDiagnostics(), l.ToPos())
+}
+
 func (b *Buffer) GetLineArray() *LineArray {
 	return b.LineArray
 }
@@ -1685,3 +2322,38 @@ func FindBufferByAbsPath(path string) *Buffer {
 	}
 	return nil
 }
+
+// ReadLines returns lines start up to end (exclusive) of the file at path,
+// or fewer if the file is shorter. If the file is open, the lines are taken
+// from its buffer so that unsaved changes are included
+func ReadLines(path string, start, end int) ([]string, error) {
+	start = util.Max(start, 0)
+	if b := FindBufferByAbsPath(path); b != nil {
+		var lines []string
+		for y := start; y < end && y < b.LinesNum(); y++ {
+			lines = append(lines, b.Line(y))
+		}
+		return lines, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	r := bufio.NewReader(f)
+	for y := 0; y < end; y++ {
+		line, err := r.ReadString('\n')
+		if y >= start && (line != "" || err == nil) {
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
@@ -2,14 +2,20 @@ package buffer
 
 import (
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zyedidia/micro/v2/internal/config"
 	ulua "github.com/zyedidia/micro/v2/internal/lua"
+	"github.com/zyedidia/micro/v2/internal/lsp"
 	"github.com/zyedidia/micro/v2/internal/util"
+	lspt "go.lsp.dev/protocol"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 type operation struct {
@@ -320,3 +326,465 @@ func BenchmarkEdit1000000Lines100Cursors(b *testing.B) {
 func BenchmarkEdit1000000Lines1000Cursors(b *testing.B) {
 	benchEdit(b, 1000000, 1000)
 }
+
+func TestVisibleDiagnosticsDelay(t *testing.T) {
+	b := NewBufferFromString("", "", BTDefault)
+	b.Settings["lspdiagdelay"] = float64(1000)
+
+	held := []lsp.Diagnostic{{}}
+	b.shownDiags = held
+	b.lastEdit = time.Now()
+	assert.Equal(t, held, b.VisibleDiagnostics())
+
+	b.lastEdit = time.Now().Add(-2 * time.Second)
+	assert.Empty(t, b.VisibleDiagnostics())
+}
+
+func TestTrimBlankLines(t *testing.T) {
+	assert.Equal(t, "a\n  b", trimBlankLines("\na\n   \n  b\n"))
+	assert.Equal(t, "", trimBlankLines(" \n\t\n"))
+}
+
+func TestStats(t *testing.T) {
+	b := NewBufferFromString("hello  wörld\n\n  foo bar baz", "", BTDefault)
+
+	lines, words, chars := b.Stats(false)
+	assert.Equal(t, 3, lines)
+	assert.Equal(t, 5, words)
+	assert.Equal(t, 25, chars)
+
+	_, words, chars = b.Stats(true)
+	assert.Equal(t, 0, words)
+	assert.Equal(t, 0, chars)
+
+	c := b.GetActiveCursor()
+	c.SetSelectionStart(Loc{X: 9, Y: 0})
+	c.SetSelectionEnd(Loc{X: 7, Y: 2})
+	lines, words, chars = b.Stats(true)
+	assert.Equal(t, 3, lines)
+	assert.Equal(t, 3, words)
+	assert.Equal(t, 10, chars)
+
+	// the counts of the whole buffer are updated after edits
+	b.Insert(Loc{X: 0, Y: 1}, "new words")
+	lines, words, chars = b.Stats(false)
+	assert.Equal(t, 3, lines)
+	assert.Equal(t, 7, words)
+	assert.Equal(t, 34, chars)
+}
+
+func TestDiffSummary(t *testing.T) {
+	b := NewBufferFromString("a\nB\nc\nd\nnew\n", "", BTDefault)
+	b.SetDiffBase([]byte("a\nb\nc\nd\ne\nf\n"))
+
+	added, modified, deleted := b.DiffSummary()
+	assert.Equal(t, 0, added)
+	assert.Equal(t, 2, modified)
+	assert.Equal(t, 1, deleted)
+
+	b.Insert(b.Start(), "x\n")
+	b.UpdateDiff(func(bool) {})
+	added, modified, deleted = b.DiffSummary()
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 2, modified)
+	assert.Equal(t, 1, deleted)
+
+	b.SetDiffBase(nil)
+	added, modified, deleted = b.DiffSummary()
+	assert.Equal(t, 0, added+modified+deleted)
+}
+
+func TestSetReadOnly(t *testing.T) {
+	b := NewBufferFromString("abc", "", BTDefault)
+	b.Insert(Loc{X: 3, Y: 0}, "d")
+
+	var changed []interface{}
+	b.OptionCallback = func(option string, v interface{}) {
+		if option == "readonly" { changed = append(changed, v) }
+	}
+
+	b.SetReadOnly(true)
+	assert.True(t, b.Type.Readonly)
+	assert.False(t, b.EditRejected())
+
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+	b.Replace(Loc{X: 0, Y: 0}, Loc{X: 1, Y: 0}, "x")
+	b.Undo()
+	assert.Equal(t, "abcd", string(b.Bytes()))
+	assert.True(t, b.EditRejected())
+	assert.False(t, b.EditRejected())
+
+	// the undo history is still there once the buffer is unlocked
+	b.SetReadOnly(false)
+	b.Undo()
+	assert.Equal(t, "abc", string(b.Bytes()))
+	assert.False(t, b.EditRejected())
+	assert.Equal(t, []interface{}{true, false}, changed)
+
+	// special buffers can't be made writable
+	b = NewBufferFromString("help", "", BTHelp)
+	b.SetReadOnly(false)
+	assert.True(t, b.Type.Readonly)
+	b.Insert(b.Start(), "x")
+	assert.Equal(t, "help", string(b.Bytes()))
+}
+
+func TestOpenScratch(t *testing.T) {
+	b := OpenScratch("Output", "line")
+	defer b.Close()
+
+	assert.Equal(t, "Output", b.GetName())
+	assert.True(t, b.Settings["readonly"].(bool))
+
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+	assert.Equal(t, "line", string(b.Bytes()))
+	assert.True(t, b.EditRejected())
+	assert.False(t, b.Modified())
+}
+
+func TestSaveView(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir := config.ConfigDir
+	config.ConfigDir = dir
+	config.GlobalSettings["saveview"] = true
+	defer func() {
+		config.ConfigDir = oldConfigDir
+		config.GlobalSettings["saveview"] = false
+	}()
+
+	gone := filepath.Join(dir, "gone.txt")
+	os.WriteFile(filepath.Join(dir, "views.json"), []byte(`{"`+gone+`":{"StartLine":3}}`), 0644)
+
+	fn := filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte(strings.Repeat("line\n", 50)), 0644)
+
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	_, ok := b.StartView()
+	assert.False(t, ok)
+
+	b.GetActiveCursor().GotoLoc(Loc{X: 2, Y: 30})
+	b.GetViewStart = func() (int, int) { return 25, 0 }
+	assert.NoError(t, b.SaveView())
+	b.Close()
+
+	states := readViewStates()
+	assert.Len(t, states, 1)
+	assert.Equal(t, ViewState{Loc{X: 2, Y: 30}, 25, 0}, states[b.AbsPath])
+
+	// the saved view is clamped to the new contents of the file
+	os.WriteFile(fn, []byte(strings.Repeat("line\n", 20)), 0644)
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	sv, ok := b.StartView()
+	assert.True(t, ok)
+	assert.Equal(t, ViewState{Loc{X: 0, Y: 20}, 20, 0}, sv)
+	assert.Equal(t, Loc{X: 0, Y: 20}, b.GetActiveCursor().Loc)
+
+	// the file is still saved when the view can't be
+	os.Remove(filepath.Join(dir, "views.json"))
+	os.Mkdir(filepath.Join(dir, "views.json"), 0755)
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+	assert.NoError(t, b.Save())
+	data, _ := os.ReadFile(fn)
+	assert.Equal(t, "xline\n", string(data[:6]))
+	b.Close()
+}
+
+func TestReadLines(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(fn, []byte("one\r\ntwo\nthree\nfour"), 0644)
+
+	lines, err := ReadLines(fn, -2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, lines)
+
+	lines, err = ReadLines(fn, 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"three", "four"}, lines)
+
+	// open files are read from their buffer
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	b.Insert(Loc{X: 0, Y: 3}, "4: ")
+	lines, _ = ReadLines(b.AbsPath, 3, 4)
+	assert.Equal(t, []string{"4: four"}, lines)
+	b.Close()
+
+	_, err = ReadLines(filepath.Join(t.TempDir(), "missing"), 0, 1)
+	assert.Error(t, err)
+}
+
+func TestHoverCache(t *testing.T) {
+	var c hoverCache
+	key := func(path string, line uint32) hoverKey { return hoverKey{path: path, line: line} }
+
+	for i := 0; i < hoverCacheSize; i++ {
+		c.put(key("a", uint32(i)), "info")
+	}
+	// using the oldest result keeps it when the cache is full
+	_, ok := c.get(key("a", 0))
+	assert.True(t, ok)
+	c.put(key("b", 0), "other")
+	_, ok = c.get(key("a", 0))
+	assert.True(t, ok)
+	_, ok = c.get(key("a", 1))
+	assert.False(t, ok)
+	assert.Len(t, c.keys, hoverCacheSize)
+
+	// editing a file only drops its own results
+	c.invalidate("a")
+	_, ok = c.get(key("a", 0))
+	assert.False(t, ok)
+	info, ok := c.get(key("b", 0))
+	assert.True(t, ok)
+	assert.Equal(t, "other", info)
+	assert.Len(t, c.keys, 1)
+}
+
+func TestHoverCacheInvalidatedOnEdit(t *testing.T) {
+	b := NewBufferFromString("abc", "", BTDefault)
+	defer b.Close()
+	k := hoverKey{path: b.AbsPath, line: 0, col: 1, edits: b.Edits()}
+	hovers.put(k, "info")
+
+	info, err := b.CachedHover(lspt.Position{Line: 0, Character: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "info", info)
+
+	// a query prepared before an edit doesn't match the results after it
+	query := b.HoverQuery(lspt.Position{Line: 0, Character: 1})
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+	_, ok := hovers.get(k)
+	assert.False(t, ok)
+	hovers.put(query.key, "stale")
+	info, _ = b.CachedHover(lspt.Position{Line: 0, Character: 1})
+	assert.Equal(t, "", info)
+}
+
+func TestAttachServer(t *testing.T) {
+	b := NewBufferFromString("abc", "", BTDefault)
+	defer b.Close()
+	s := &lsp.Server{}
+
+	b.AttachServer(s)
+	b.AttachServer(s)
+	assert.Equal(t, []*lsp.Server{s}, b.Servers)
+
+	b.DetachServer(s)
+	assert.Empty(t, b.Servers)
+	b.DetachServer(s)
+	assert.Empty(t, b.Servers)
+}
+
+func TestLSPOptionOff(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir, oldLSP := config.ConfigDir, config.GlobalSettings["lsp"]
+	config.ConfigDir = dir
+	config.GlobalSettings["lsp"] = false
+	t.Cleanup(func() {
+		config.ConfigDir = oldConfigDir
+		config.GlobalSettings["lsp"] = oldLSP
+	})
+
+	fn := filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte("abc\n"), 0644)
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	assert.False(t, b.Settings["lsp"].(bool))
+	assert.Empty(t, b.Servers)
+	assert.False(t, b.HasLSP())
+	assert.Empty(t, lsp.GetActiveServerNames())
+
+	// servers can't be attached while the option is off
+	s := &lsp.Server{}
+	b.AttachServer(s)
+	assert.Empty(t, b.Servers)
+
+	assert.NoError(t, b.SetOptionNative("lsp", true))
+	b.AttachServer(s)
+	assert.Equal(t, []*lsp.Server{s}, b.Servers)
+	assert.NoError(t, b.SetOptionNative("lsp", false))
+	assert.Empty(t, b.Servers)
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir := config.ConfigDir
+	config.ConfigDir = dir
+	config.GlobalSettings["encoding"] = "sjis"
+	defer func() {
+		config.ConfigDir = oldConfigDir
+		config.GlobalSettings["encoding"] = "utf-8"
+	}()
+
+	sjis, _ := htmlindex.Get("shift_jis")
+	data, _ := sjis.NewEncoder().String("こんにちは\n世界\n")
+	fn := filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte(data), 0644)
+
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, "shift_jis", b.Settings["encoding"])
+	assert.Equal(t, "こんにちは", string(b.LineBytes(0)))
+
+	b.Insert(Loc{X: 2, Y: 1}, "の人")
+	assert.NoError(t, b.Save())
+	b.Close()
+
+	saved, _ := os.ReadFile(fn)
+	text, err := sjis.NewDecoder().String(string(saved))
+	assert.NoError(t, err)
+	assert.Equal(t, "こんにちは\n世界の人\n", text)
+
+	// changing the encoding converts the file when it is saved
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.NoError(t, b.SetOption("encoding", "utf8"))
+	assert.NoError(t, b.Save())
+	b.Close()
+	saved, _ = os.ReadFile(fn)
+	assert.Equal(t, "こんにちは\n世界の人\n", string(saved))
+}
+
+func TestBOM(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir := config.ConfigDir
+	config.ConfigDir = dir
+	config.GlobalSettings["encoding"] = "utf-16le"
+	defer func() {
+		config.ConfigDir = oldConfigDir
+		config.GlobalSettings["encoding"] = "utf-8"
+	}()
+
+	fn := filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte("\xff\xfea\x00b\x00\n\x00"), 0644)
+
+	// the byte order mark isn't part of the text, and is kept when saving
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", string(b.LineBytes(0)))
+	assert.True(t, b.Settings["bom"].(bool))
+	assert.NoError(t, b.Save())
+	saved, _ := os.ReadFile(fn)
+	assert.Equal(t, "\xff\xfea\x00b\x00\n\x00", string(saved))
+
+	assert.NoError(t, b.SetOptionNative("bom", false))
+	assert.True(t, b.Modified())
+	assert.NoError(t, b.Save())
+	saved, _ = os.ReadFile(fn)
+	assert.Equal(t, "a\x00b\x00\n\x00", string(saved))
+
+	// it is written in the encoding of the buffer
+	assert.NoError(t, b.SetOptionNative("bom", true))
+	assert.NoError(t, b.SetOption("encoding", "utf-8"))
+	assert.NoError(t, b.Save())
+	saved, _ = os.ReadFile(fn)
+	assert.Equal(t, "\xef\xbb\xbfab\n", string(saved))
+	assert.False(t, config.GlobalSettings["bom"].(bool))
+
+	// reopening follows the file
+	os.WriteFile(fn, []byte("ab\n"), 0644)
+	assert.NoError(t, b.ReOpen())
+	assert.False(t, b.Settings["bom"].(bool))
+	os.WriteFile(fn, []byte("\xef\xbb\xbfab\n"), 0644)
+	assert.NoError(t, b.ReOpen())
+	assert.True(t, b.Settings["bom"].(bool))
+	assert.Equal(t, "ab", string(b.LineBytes(0)))
+	b.Close()
+}
+
+func TestDecodeErrors(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir := config.ConfigDir
+	config.ConfigDir = dir
+	defer func() { config.ConfigDir = oldConfigDir }()
+
+	// replacement characters that are in the file aren't errors
+	fn := filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte("a\xef\xbf\xbdb\n"), 0644)
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.False(t, b.Type.Readonly)
+	b.Close()
+
+	os.WriteFile(fn, []byte("caf\xe9\n"), 0644)
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.True(t, b.Type.Readonly)
+	assert.True(t, b.Settings["readonly"].(bool))
+	assert.Contains(t, b.decodeError([]byte("caf\xe9\n")), "windows-1252")
+
+	// reopening the file with the right encoding makes it writable again
+	assert.NoError(t, b.SetOption("encoding", "latin1"))
+	assert.NoError(t, b.ReOpen())
+	assert.False(t, b.Type.Readonly)
+	assert.Equal(t, "café", string(b.LineBytes(0)))
+	b.Close()
+}
+
+func TestDetectEncoding(t *testing.T) {
+	sjis, _ := htmlindex.Get("shift_jis")
+	jp, _ := sjis.NewEncoder().String("日本語のテキスト")
+
+	assert.Equal(t, "utf-8", DetectEncoding([]byte("\xef\xbb\xbfabc")))
+	assert.Equal(t, "utf-16le", DetectEncoding([]byte("\xff\xfea\x00")))
+	assert.Equal(t, "utf-16be", DetectEncoding([]byte("\xfe\xff\x00a")))
+	assert.Equal(t, "utf-8", DetectEncoding([]byte("héllo")))
+	assert.Equal(t, "utf-16le", DetectEncoding([]byte("h\x00e\x00l\x00l\x00o\x00")))
+	assert.Equal(t, "utf-16be", DetectEncoding([]byte("\x00h\x00e\x00l\x00l\x00o")))
+	assert.Equal(t, "shift_jis", DetectEncoding([]byte(jp)))
+	assert.Equal(t, "windows-1252", DetectEncoding([]byte("caf\xe9\n")))
+}
+
+func TestBinaryGuard(t *testing.T) {
+	dir := t.TempDir()
+	oldConfigDir := config.ConfigDir
+	config.ConfigDir = dir
+	defer func() {
+		config.ConfigDir = oldConfigDir
+		config.GlobalSettings["openbinary"] = false
+		config.GlobalSettings["binarychecksize"] = float64(8)
+		config.GlobalSettings["encoding"] = "utf-8"
+	}()
+
+	fn := filepath.Join(dir, "a.bin")
+	os.WriteFile(fn, []byte(strings.Repeat("x", 2000)+"\x00\x01\x02\n"), 0644)
+	b, err := NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.True(t, b.Type.Readonly)
+	b.Close()
+
+	// null bytes after the checked size aren't looked at
+	config.GlobalSettings["binarychecksize"] = float64(1)
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.False(t, b.Type.Readonly)
+	b.Close()
+
+	config.GlobalSettings["binarychecksize"] = float64(8)
+	config.GlobalSettings["openbinary"] = true
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.False(t, b.Type.Readonly)
+	b.Close()
+	config.GlobalSettings["openbinary"] = false
+
+	// UTF-16 has null bytes, and isn't binary when it is the encoding
+	fn = filepath.Join(dir, "a.txt")
+	os.WriteFile(fn, []byte("a\x00b\x00\n\x00"), 0644)
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.True(t, b.Type.Readonly)
+	assert.Contains(t, b.readonlyReason(&decodeCheck{raw: &countingReader{head: []byte("a\x00b\x00\n\x00")}, decoded: &countingReader{}}, b.Settings), "utf-16le")
+	b.Close()
+
+	config.GlobalSettings["encoding"] = "utf-16le"
+	b, err = NewBufferFromFile(fn, BTDefault)
+	assert.NoError(t, err)
+	assert.False(t, b.Type.Readonly)
+	assert.Equal(t, "ab", string(b.LineBytes(0)))
+	b.Close()
+}
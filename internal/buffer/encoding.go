@@ -0,0 +1,165 @@
+package buffer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// decodedBOM is the byte order mark once decoded, which is the same for all
+// Unicode encodings
+var decodedBOM = []byte("\ufeff")
+
+// replacementChar is what decoders produce for bytes that aren't valid in
+// their encoding
+var replacementChar = []byte("\ufffd")
+
+// detectSize is the number of bytes at the start of a file that
+// DetectEncoding is given when the file can't be decoded
+const detectSize = 8192
+
+// binaryCheckUnit is the unit of the binarychecksize option
+const binaryCheckUnit = 1024
+
+// stripBOM discards the byte order mark at the start of the decoded text
+// read by r, and returns true if there was one
+func stripBOM(r *bufio.Reader) bool {
+	if start, err := r.Peek(len(decodedBOM)); err == nil && bytes.Equal(start, decodedBOM) {
+		r.Discard(len(decodedBOM))
+		return true
+	}
+	return false
+}
+
+// isUnicodeEncoding returns true if the encoding, as normalized by
+// config.NormalizeEncoding, can start with a byte order mark
+func isUnicodeEncoding(encoding string) bool {
+	return strings.HasPrefix(encoding, "utf-")
+}
+
+// countingReader counts the occurrences of a pattern in the bytes read
+// through it, and keeps the first headSize bytes read
+type countingReader struct {
+	r       io.Reader
+	pattern []byte
+	count   int
+	// the end of the previous read, for matches spanning two reads
+	tail     []byte
+	head     []byte
+	headSize int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 && len(c.head) < c.headSize {
+		c.head = append(c.head, p[:util.Min(n, c.headSize-len(c.head))]...)
+	}
+	if n > 0 && len(c.pattern) > 0 {
+		data := append(c.tail, p[:n]...)
+		c.count += bytes.Count(data, c.pattern)
+		keep := util.Min(len(c.pattern)-1, len(data))
+		c.tail = append(c.tail[:0], data[len(data)-keep:]...)
+	}
+	return n, err
+}
+
+// decodeCheck finds out whether a file could be decoded, by comparing the
+// replacement characters in the file with the ones in its decoded text
+type decodeCheck struct {
+	raw, decoded *countingReader
+}
+
+// newDecodeCheck returns a reader decoding r with enc, and the check of the
+// text read from it, which keeps at least the first headSize bytes of r
+func newDecodeCheck(r io.Reader, enc encoding.Encoding, headSize int) (*bufio.Reader, *decodeCheck) {
+	// the replacement character may not exist in enc
+	encoded, _ := enc.NewEncoder().Bytes(replacementChar)
+	raw := &countingReader{r: r, pattern: encoded, headSize: util.Max(headSize, detectSize)}
+	decoded := &countingReader{r: transform.NewReader(raw, enc.NewDecoder()), pattern: replacementChar}
+	return bufio.NewReader(decoded), &decodeCheck{raw, decoded}
+}
+
+// failed returns true if some bytes of the file were invalid
+func (d *decodeCheck) failed() bool {
+	return d.decoded.count > d.raw.count
+}
+
+// DetectEncoding guesses the encoding of text, from its byte order mark or
+// else from the encodings it is valid in. UTF-8 is preferred, and
+// windows-1252 is returned if nothing else fits, since any text is valid in it
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	}
+
+	// text in UTF-16 has a null byte in most characters of Latin scripts,
+	// which is the high byte. Null bytes are valid UTF-8 too, so this is
+	// checked first
+	var nulls [2]int
+	for i, c := range data {
+		if c == 0 { nulls[i%2]++ }
+	}
+	if nulls[1] > len(data)/4 && nulls[0] == 0 {
+		return "utf-16le"
+	} else if nulls[0] > len(data)/4 && nulls[1] == 0 {
+		return "utf-16be"
+	}
+
+	// the end of data may cut a character
+	if utf8.Valid(data) || (len(data) >= detectSize && utf8.Valid(data[:len(data)-utf8.UTFMax])) {
+		return "utf-8"
+	}
+
+	for _, name := range []string{"shift_jis", "euc-kr", "gbk"} {
+		enc, _ := htmlindex.Get(name)
+		text, err := enc.NewDecoder().Bytes(data)
+		if err == nil && !bytes.Contains(text, replacementChar) {
+			return name
+		}
+	}
+	return "windows-1252"
+}
+
+// decodeError returns the message shown when the file of the buffer can't be
+// decoded with its encoding
+func (b *Buffer) decodeError(head []byte) string {
+	return fmt.Sprintf("Warning: %s is not valid %s and was opened readonly; it may be %s, set the encoding option and reopen it",
+		b.GetName(), b.Settings["encoding"], DetectEncoding(head))
+}
+
+// readonlyReason returns why the file read through check must be opened
+// readonly, which is that it looks binary or can't be decoded, or "" if it
+// can be edited. The openbinary and binarychecksize options are taken from
+// settings
+func (b *Buffer) readonlyReason(check *decodeCheck, settings map[string]interface{}) string {
+	if settings["openbinary"].(bool) {
+		return ""
+	}
+
+	head := check.raw.head
+	sample := head[:util.Min(len(head), util.IntOpt(settings["binarychecksize"])*binaryCheckUnit)]
+	// text files only have null bytes in UTF-16
+	if bytes.IndexByte(sample, 0) >= 0 && !strings.HasPrefix(b.Settings["encoding"].(string), "utf-16") {
+		if strings.HasPrefix(DetectEncoding(head), "utf-16") {
+			return b.decodeError(head)
+		}
+		return fmt.Sprintf("Warning: %s is a binary file and was opened readonly; set the openbinary option and reopen it to edit it", b.GetName())
+	}
+	if check.failed() {
+		return b.decodeError(head)
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package buffer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zyedidia/micro/v2/internal/lsp"
+)
+
+// The file operations below implement the resource operations of the
+// workspace edits sent by language servers
+
+// CreateFile creates an empty file. An existing file is emptied if overwrite
+// is true, left alone if ignoreIfExists is true, and is an error otherwise
+func CreateFile(path string, overwrite, ignoreIfExists bool) error {
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		if ignoreIfExists { return nil }
+		return errors.New(path + " already exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// RenameFile moves a file or directory, and the open buffers of the files
+// that were moved, which keep their unsaved changes. An existing target is
+// replaced if overwrite is true, left alone if ignoreIfExists is true, and
+// is an error otherwise
+func RenameFile(oldPath, newPath string, overwrite, ignoreIfExists bool) error {
+	if _, err := os.Stat(newPath); err == nil && !overwrite {
+		if ignoreIfExists { return nil }
+		return errors.New(newPath + " already exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	for _, b := range OpenBuffers {
+		if moved, ok := movedPath(b.AbsPath, oldPath, newPath); ok {
+			b.move(moved)
+		}
+	}
+	return nil
+}
+
+// DeleteFile removes a file, or a directory if recursive is true. A missing
+// file is an error unless ignoreIfNotExists is true
+func DeleteFile(path string, recursive, ignoreIfNotExists bool) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) && ignoreIfNotExists {
+		return nil
+	}
+	if recursive {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+// InPath returns true if the file is path itself or is inside the directory
+// path
+func InPath(file, path string) bool {
+	_, ok := movedPath(file, path, path)
+	return ok
+}
+
+// movedPath returns where file ends up when oldPath is moved to newPath, if
+// it is oldPath itself or is inside it
+func movedPath(file, oldPath, newPath string) (string, bool) {
+	rel, err := filepath.Rel(oldPath, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(newPath, rel), true
+}
+
+// move changes the path of the buffer after its file was moved. The file is
+// reopened on the language servers under its new name
+func (b *Buffer) move(absPath string) {
+	servers := append([]*lsp.Server(nil), b.Servers...)
+	for _, s := range servers {
+		b.DetachServer(s)
+	}
+
+	path := absPath
+	if !filepath.IsAbs(b.Path) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, absPath); err == nil { path = rel }
+		}
+	}
+	b.Path = path
+	b.AbsPath = absPath
+	b.UpdateRules()
+
+	for _, s := range servers {
+		b.AttachServer(s)
+	}
+}
@@ -0,0 +1,64 @@
+package buffer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameFile(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "a.txt")
+	os.WriteFile(old, []byte("abc\n"), 0644)
+
+	b, err := NewBufferFromFile(old, BTDefault)
+	assert.NoError(t, err)
+	defer b.Close()
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+
+	moved := filepath.Join(dir, "sub", "b.txt")
+	assert.NoError(t, RenameFile(old, moved, false, false))
+	_, err = os.Stat(old)
+	assert.True(t, os.IsNotExist(err))
+	data, _ := os.ReadFile(moved)
+	assert.Equal(t, "abc\n", string(data))
+
+	// the buffer follows the file and keeps its changes
+	assert.Equal(t, moved, b.AbsPath)
+	assert.Equal(t, moved, b.Path)
+	assert.Equal(t, "xabc\n", string(b.Bytes()))
+	assert.True(t, b.Modified())
+
+	// moving the directory moves the buffer too
+	assert.NoError(t, RenameFile(filepath.Join(dir, "sub"), filepath.Join(dir, "dir"), false, false))
+	assert.Equal(t, filepath.Join(dir, "dir", "b.txt"), b.AbsPath)
+
+	other := filepath.Join(dir, "c.txt")
+	assert.NoError(t, CreateFile(other, false, false))
+	assert.Error(t, RenameFile(b.AbsPath, other, false, false))
+	assert.NoError(t, RenameFile(b.AbsPath, other, false, true))
+	assert.Equal(t, filepath.Join(dir, "dir", "b.txt"), b.AbsPath)
+	assert.NoError(t, RenameFile(b.AbsPath, other, true, false))
+	assert.Equal(t, other, b.AbsPath)
+}
+
+func TestCreateDeleteFile(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "sub", "a.txt")
+
+	assert.NoError(t, CreateFile(fn, false, false))
+	assert.Error(t, CreateFile(fn, false, false))
+	assert.NoError(t, CreateFile(fn, false, true))
+
+	assert.True(t, InPath(fn, filepath.Join(dir, "sub")))
+	assert.False(t, InPath(fn, filepath.Join(dir, "su")))
+
+	assert.Error(t, DeleteFile(filepath.Join(dir, "sub"), false, false))
+	assert.NoError(t, DeleteFile(filepath.Join(dir, "sub"), true, false))
+	_, err := os.Stat(fn)
+	assert.True(t, os.IsNotExist(err))
+	assert.Error(t, DeleteFile(fn, false, false))
+	assert.NoError(t, DeleteFile(fn, false, true))
+}
@@ -0,0 +1,132 @@
+package buffer
+
+import (
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// A Fold is a closed region of lines. Its first line stays visible as the
+// header of the fold and the lines after it, up to and including End, are
+// hidden
+type Fold struct {
+	Start int
+	End   int
+}
+
+// foldRegion is a region that can be folded, at a nesting depth where the
+// outermost regions have level 1
+type foldRegion struct {
+	Fold
+	level int
+}
+
+// foldRegions returns the regions that can be folded, found by indentation:
+// a region starts on a line followed by more indented lines and ends on the
+// last of them. Blank lines after the region are not part of it
+func (b *Buffer) foldRegions() []foldRegion {
+	tabsize := util.IntOpt(b.Settings["tabsize"])
+	indent := make([]int, b.LinesNum())
+	for i := range indent {
+		l := b.LineBytes(i)
+		if util.IsSpacesOrTabs(l) {
+			indent[i] = -1
+			continue
+		}
+		ws := util.GetLeadingWhitespace(l)
+		indent[i] = util.StringWidth(ws, util.CharacterCount(ws), tabsize)
+	}
+
+	var regions []foldRegion
+	// indexes in regions of the regions that haven't ended yet
+	var open []int
+	last := -1
+	for i, ind := range indent {
+		if ind < 0 {
+			continue
+		}
+		for len(open) > 0 && indent[regions[open[len(open)-1]].Start] >= ind {
+			regions[open[len(open)-1]].End = last
+			open = open[:len(open)-1]
+		}
+		if last >= 0 && ind > indent[last] {
+			regions = append(regions, foldRegion{Fold{last, -1}, len(open) + 1})
+			open = append(open, len(regions)-1)
+		}
+		last = i
+	}
+	for _, r := range open {
+		regions[r].End = last
+	}
+	return regions
+}
+
+// Folds returns the closed folds, ordered by their first line. Nested folds
+// are included
+func (b *Buffer) Folds() []Fold {
+	return append([]Fold(nil), b.folds...)
+}
+
+// IsFolded returns whether the given line is hidden by a closed fold
+func (b *Buffer) IsFolded(y int) bool {
+	return b.foldHeader(y) != y
+}
+
+// foldHeader returns the header of the outermost fold hiding the given line,
+// or the line itself if it isn't hidden
+func (b *Buffer) foldHeader(y int) int {
+	for _, f := range b.folds {
+		if f.Start < y && y <= f.End {
+			return f.Start
+		}
+	}
+	return y
+}
+
+// FoldAll closes every region of the buffer
+func (b *Buffer) FoldAll() {
+	b.FoldToLevel(0)
+}
+
+// UnfoldAll opens every fold of the buffer
+func (b *Buffer) UnfoldAll() {
+	b.folds = nil
+}
+
+// FoldToLevel closes the regions nested deeper than n and opens the others,
+// so FoldToLevel(1) only shows the outermost regions. Cursors on lines that
+// become hidden are moved to the header of the fold
+func (b *Buffer) FoldToLevel(n int) {
+	b.folds = nil
+	for _, r := range b.foldRegions() {
+		if r.level > n {
+			b.folds = append(b.folds, r.Fold)
+		}
+	}
+
+	for _, c := range b.cursors {
+		if y := b.foldHeader(c.Y); y != c.Y {
+			c.ResetSelection()
+			c.GotoLoc(Loc{util.Min(c.X, util.CharacterCount(b.LineBytes(y))), y})
+		}
+	}
+	b.MergeCursors()
+}
+
+// updateFolds moves the folds after lines start to end were replaced by
+// lines that number delta more. The header of a fold may be edited, but the
+// folds with edited hidden lines are opened
+func (b *SharedBuffer) updateFolds(start, end, delta int) {
+	if delta == 0 || len(b.folds) == 0 {
+		return
+	}
+	folds := b.folds[:0]
+	for _, f := range b.folds {
+		if f.Start >= end {
+			f.Start += delta
+			f.End += delta
+		} else if f.End >= start {
+			continue
+		}
+		folds = append(folds, f)
+	}
+	b.folds = folds
+}
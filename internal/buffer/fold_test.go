@@ -0,0 +1,96 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const foldText = `func a() {
+	if x {
+		y()
+
+	}
+}
+
+func b() {
+	z()
+}
+`
+
+func TestFoldRegions(t *testing.T) {
+	b := NewBufferFromString(foldText, "", BTDefault)
+	defer b.Close()
+
+	assert.Equal(t, []foldRegion{
+		{Fold{0, 4}, 1},
+		{Fold{1, 2}, 2},
+		{Fold{7, 8}, 1},
+	}, b.foldRegions())
+}
+
+func TestFoldToLevel(t *testing.T) {
+	b := NewBufferFromString(foldText, "", BTDefault)
+	defer b.Close()
+
+	b.FoldAll()
+	assert.Equal(t, []Fold{{0, 4}, {1, 2}, {7, 8}}, b.Folds())
+	for y, hidden := range []bool{false, true, true, true, true, false, false, false, true} {
+		assert.Equal(t, hidden, b.IsFolded(y), y)
+	}
+
+	b.FoldToLevel(1)
+	assert.Equal(t, []Fold{{1, 2}}, b.Folds())
+	assert.True(t, b.IsFolded(2))
+	assert.False(t, b.IsFolded(3))
+
+	b.FoldToLevel(2)
+	assert.Empty(t, b.Folds())
+
+	b.FoldAll()
+	b.UnfoldAll()
+	assert.Empty(t, b.Folds())
+	assert.False(t, b.IsFolded(1))
+}
+
+func TestFoldMovesCursor(t *testing.T) {
+	b := NewBufferFromString(foldText, "", BTDefault)
+	defer b.Close()
+
+	c := b.GetActiveCursor()
+	c.GotoLoc(Loc{X: 3, Y: 2})
+	c.SetSelectionStart(Loc{X: 0, Y: 2})
+	c.SetSelectionEnd(Loc{X: 3, Y: 2})
+	b.FoldToLevel(1)
+	assert.Equal(t, Loc{X: 3, Y: 1}, c.Loc)
+	assert.False(t, c.HasSelection())
+
+	// the cursor goes to the header of the outermost fold, clamped to it
+	c.GotoLoc(Loc{X: 5, Y: 2})
+	b.FoldAll()
+	assert.Equal(t, Loc{X: 5, Y: 0}, c.Loc)
+	c.GotoLoc(Loc{X: 3, Y: 8})
+	b.FoldAll()
+	assert.Equal(t, Loc{X: 3, Y: 7}, c.Loc)
+}
+
+func TestFoldsFollowEdits(t *testing.T) {
+	b := NewBufferFromString(foldText, "", BTDefault)
+	defer b.Close()
+
+	b.FoldAll()
+	// lines inserted before the folds or on their header move them
+	b.Insert(Loc{X: 0, Y: 0}, "// a\n// b\n")
+	assert.Equal(t, []Fold{{2, 6}, {3, 4}, {9, 10}}, b.Folds())
+
+	// an edit in a fold opens it, and those around it
+	b.Insert(Loc{X: 0, Y: 4}, "\n")
+	assert.Equal(t, []Fold{{10, 11}}, b.Folds())
+
+	b.Remove(Loc{X: 0, Y: 0}, Loc{X: 0, Y: 2})
+	assert.Equal(t, []Fold{{8, 9}}, b.Folds())
+
+	// edits that keep the number of lines don't change the folds
+	b.Insert(Loc{X: 0, Y: 0}, "x")
+	assert.Equal(t, []Fold{{8, 9}}, b.Folds())
+}
@@ -0,0 +1,74 @@
+package buffer
+
+import (
+	"sort"
+)
+
+// Priorities of the highlights drawn by the buffer window, from lowest to
+// highest. Everything with a higher priority is drawn over what has a lower
+// priority:
+//   - ranges below HighlightSyntax only change the background, and only
+//     where the syntax highlighting has no background of its own, like the
+//     cursor line
+//   - ranges from HighlightSyntax on replace the syntax highlighting
+//   - search matches replace them at HighlightSearch, and the selection at
+//     HighlightSelection
+const (
+	HighlightCursorLine = 10
+	HighlightSyntax     = 20
+	HighlightSearch     = 30
+	HighlightSelection  = 40
+)
+
+// A HighlightRange is a range of the buffer drawn with the style of a
+// colorscheme group. Features such as reference highlighting add them with
+// SetHighlights instead of changing how the buffer is drawn
+type HighlightRange struct {
+	Start, End Loc
+	// the colorscheme group, ranges whose group isn't in the colorscheme
+	// aren't drawn
+	Style    string
+	Priority int
+}
+
+// SetHighlights replaces the highlight ranges of the given owner, which is
+// the name of the feature that added them
+func (b *Buffer) SetHighlights(owner string, ranges []HighlightRange) {
+	if b.highlights == nil {
+		b.highlights = make(map[string][]HighlightRange)
+	}
+	if len(ranges) == 0 {
+		delete(b.highlights, owner)
+	} else {
+		b.highlights[owner] = ranges
+	}
+}
+
+// ClearHighlights removes the highlight ranges of the given owner
+func (b *Buffer) ClearHighlights(owner string) {
+	delete(b.highlights, owner)
+}
+
+// HighlightsOnLine returns the highlight ranges that include part of the
+// given line, from the lowest priority to the highest. Ranges with the same
+// priority are ordered by owner
+func (b *Buffer) HighlightsOnLine(y int) []HighlightRange {
+	owners := make([]string, 0, len(b.highlights))
+	for owner := range b.highlights {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	var ranges []HighlightRange
+	for _, owner := range owners {
+		for _, r := range b.highlights[owner] {
+			if r.Start.Y <= y && r.End.Y >= y {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Priority < ranges[j].Priority
+	})
+	return ranges
+}
@@ -131,6 +131,10 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 	defer func() {
 		b.ModTime, _ = util.GetModTime(filename)
 		err = b.Serialize()
+		// the file was saved, failing to remember the view doesn't change that
+		if verr := b.SaveView(); verr != nil {
+			WriteLogLn("Failed to save the view of", b.AbsPath+":", verr)
+		}
 	}()
 
 	// Removes any tilde and replaces with the absolute path to home
@@ -160,7 +164,13 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 		return err
 	}
 
+	// the byte order mark is encoded like the text
+	writeBOM := b.Settings["bom"].(bool) && isUnicodeEncoding(b.Settings["encoding"].(string))
+
 	fwriter := func(file io.Writer) (e error) {
+		if writeBOM {
+			if _, e = file.Write(decodedBOM); e != nil { return }
+		}
 		if b.Len() == 0 { return }
 
 		// end of line
@@ -209,6 +219,7 @@ func (b *Buffer) saveToFile(filename string, withSudo bool) error {
 	if b.HasLSP() {
 		fn := func(s *lsp.Server) (bool, bool) {
 			s.DidSave(b.AbsPath)
+			pullDiagnostics(s, b.AbsPath)
 			return false, false
 		}
 		util.ChanMapAll(b.Servers, fn)
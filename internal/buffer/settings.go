@@ -3,7 +3,6 @@ package buffer
 import (
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/screen"
-	"github.com/zyedidia/micro/v2/internal/util"
 	"github.com/zyedidia/micro/v2/internal/lsp"
 )
 
@@ -37,20 +36,17 @@ func (b *Buffer) SetOptionNative(option string, nativeValue interface{}) error {
 		} else {
 			b.UpdateRules()
 		}
-	} else if option == "encoding" {
+	} else if option == "encoding" || option == "bom" {
 		b.isModified = true
 	} else if option == "readonly" && b.Type.Kind == BTDefault.Kind {
 		b.Type.Readonly = nativeValue.(bool)
 	} else if option == "lsp" && b.Type.Kind == BTDefault.Kind {
-		if nativeValue.(bool) && !b.HasLSP() {
+		if nativeValue.(bool) {
 			b.lspInit()
-		} else if b.HasLSP() {
-			fn := func (s *lsp.Server) (bool, bool) {
-				s.Shutdown()
-				return false, false
+		} else {
+			for _, s := range append([]*lsp.Server(nil), b.Servers...) {
+				b.DetachServer(s)
 			}
-			util.ChanMapAll(b.Servers, fn)
-			b.Servers = []*lsp.Server{}
 		}
 	} else if option == "hlsearch" {
 		for _, buf := range OpenBuffers {
@@ -0,0 +1,89 @@
+package buffer
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/text/encoding"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+// ViewState is the part of the view of a file that is restored when the file
+// is opened again, with the saveview option. Folds are not saved
+type ViewState struct {
+	Cursor    Loc
+	StartLine int
+	StartCol  int
+}
+
+// viewStateFile stores the view states of all files, keyed by absolute path
+func viewStateFile() string {
+	return filepath.Join(config.ConfigDir, "views.json")
+}
+
+func readViewStates() map[string]ViewState {
+	states := make(map[string]ViewState)
+	data, err := os.ReadFile(viewStateFile())
+	if err == nil {
+		json.Unmarshal(data, &states)
+	}
+	return states
+}
+
+// SaveView stores the cursor and scroll position of the buffer, if the
+// saveview option is on. Entries of files that no longer exist are removed
+func (b *Buffer) SaveView() error {
+	if !b.Settings["saveview"].(bool) || b.Path == "" || b.Type != BTDefault {
+		return nil
+	}
+
+	state := ViewState{Cursor: b.GetActiveCursor().Loc}
+	if b.GetViewStart != nil {
+		state.StartLine, state.StartCol = b.GetViewStart()
+	}
+
+	states := readViewStates()
+	for path := range states {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(states, path)
+		}
+	}
+	states[b.AbsPath] = state
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return overwriteFile(viewStateFile(), encoding.Nop, func(file io.Writer) error {
+		_, err := file.Write(data)
+		return err
+	}, false)
+}
+
+// loadView restores the cursor and scroll position saved by SaveView,
+// clamped to the current contents of the file
+func (b *Buffer) loadView() {
+	state, ok := readViewStates()[b.AbsPath]
+	if !ok {
+		return
+	}
+
+	b.StartCursor = clamp(state.Cursor, b.LineArray)
+	state.Cursor = b.StartCursor
+	state.StartLine = util.Clamp(state.StartLine, 0, b.LinesNum()-1)
+	state.StartCol = util.Max(state.StartCol, 0)
+	b.startView = &state
+}
+
+// StartView returns the view saved the last time the file was closed, if
+// the saveview option is on and there is one
+func (b *Buffer) StartView() (ViewState, bool) {
+	if b.startView == nil {
+		return ViewState{}, false
+	}
+	return *b.startView, true
+}
@@ -0,0 +1,85 @@
+package buffer
+
+import (
+	"sort"
+
+	"github.com/zyedidia/micro/v2/internal/config"
+)
+
+// VTPos is where virtual text is drawn relative to its location
+type VTPos int
+
+const (
+	// VTEndOfLine text is drawn after the end of the line of its location,
+	// separated from it by a space
+	VTEndOfLine VTPos = iota
+	// VTInline text is drawn before the character at its location, and
+	// pushes the rest of the line to the right
+	VTInline
+)
+
+// VirtualText is text drawn in the buffer window that isn't part of the
+// buffer, such as inlay hints or annotations of plugins. It doesn't change
+// the buffer or the columns of its characters
+type VirtualText struct {
+	Loc  Loc
+	Text string
+	// the colorscheme group of the text
+	Style string
+	Pos   VTPos
+}
+
+// AddVirtualText adds virtual text to the buffer. The returned value can be
+// passed to RemoveVirtualText
+func (b *Buffer) AddVirtualText(loc Loc, text, style string, pos VTPos) *VirtualText {
+	vt := &VirtualText{loc, text, style, pos}
+	b.virtualText = append(b.virtualText, vt)
+	return vt
+}
+
+// RemoveVirtualText removes virtual text added with AddVirtualText
+func (b *Buffer) RemoveVirtualText(vt *VirtualText) {
+	for i, v := range b.virtualText {
+		if v == vt {
+			b.virtualText = append(b.virtualText[:i], b.virtualText[i+1:]...)
+			return
+		}
+	}
+}
+
+// ClearVirtualText removes all virtual text added with AddVirtualText
+func (b *Buffer) ClearVirtualText() {
+	b.virtualText = nil
+}
+
+// VirtualTextOnLine returns the virtual text of the given line ordered by
+// column, including the inlay hints of the language servers
+func (b *Buffer) VirtualTextOnLine(y int) []*VirtualText {
+	var line []*VirtualText
+	for _, vt := range b.virtualText {
+		if vt.Loc.Y == y {
+			line = append(line, vt)
+		}
+	}
+
+	style := "inlay-hint"
+	if _, ok := config.Colorscheme[style]; !ok {
+		style = "comment"
+	}
+	for _, h := range b.InlayHints() {
+		if int(h.Position.Line) != y {
+			continue
+		}
+		text := string(h.Label)
+		if h.PaddingLeft {
+			text = " " + text
+		}
+		if h.PaddingRight {
+			text += " "
+		}
+		line = append(line, &VirtualText{Loc: Loc{X: int(h.Position.Character), Y: y}, Text: text, Style: style, Pos: VTInline})
+	}
+
+	sort.SliceStable(line, func(i, j int) bool { return line[i].Loc.X < line[j].Loc.X })
+	return line
+}
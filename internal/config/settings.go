@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"log"
@@ -23,6 +24,7 @@ type optionValidator func(string, interface{}) error
 var (
 	ErrInvalidOption = errors.New("Invalid option")
 	ErrInvalidValue  = errors.New("Invalid value")
+	ErrLocalOption   = errors.New("Option can only be set locally")
 
 	// The options that the user can set
 	GlobalSettings map[string]interface{}
@@ -34,6 +36,10 @@ var (
 	// ModifiedSettings is a map of settings which should be written to disk
 	// because they have been modified by the user in this session
 	ModifiedSettings map[string]bool
+
+	// GlobalOptionCallback is called after the global value of an option is
+	// changed from this package, so that the editor can apply the new value
+	GlobalOptionCallback func(option string, nativeValue interface{}) error
 )
 
 func init() {
@@ -43,17 +49,25 @@ func init() {
 
 // Options with validators
 var optionValidators = map[string]optionValidator{
-	"autosave":     validateGreaterEqual(0),
-	"clipboard":    validateStringLiteral("internal", "external", "terminal"),
-	"tabsize":      validateGreater(0),
-	"scrollmargin": validateGreaterEqual(0),
-	"scrollspeed":  validateGreaterEqual(0),
-	"colorscheme":  validateCalculatedStringLiteral(GetColorschemeNames),
-	"colorcolumn":  validateAny(
+	"autosave":        validateGreaterEqual(0),
+	"clipboard":       validateStringLiteral("internal", "external", "terminal"),
+	"tabsize":         validateGreater(0),
+	"scrollmargin":    validateGreaterEqual(0),
+	"scrollspeed":     validateGreaterEqual(0),
+	"colorscheme":     validateCalculatedStringLiteral(GetColorschemeNames),
+	"colorcolumn":     validateAny(
 		validateArray(validateGreaterEqual(0)),
 		validateGreaterEqual(0)),
-	"fileformat":   validateStringLiteral("unix", "dos"),
-	"encoding":     validateEncoding,
+	"fileformat":      validateStringLiteral("unix", "dos"),
+	"encoding":        validateEncoding,
+	"lspdiagdelay":    validateGreaterEqual(0),
+	"lspmaxmessage":   validateGreater(0),
+	"hoverdelay":      validateGreaterEqual(0),
+	"binarychecksize": validateGreaterEqual(0),
+	"lspdiagseverity": validateStringLiteral("error", "warning", "info", "hint"),
+	"rulerside":       validateStringLiteral("left", "right"),
+	"tabnumbers":      validateStringLiteral("off", "on", "all"),
+	"tabindicator":    validateStringLiteral("none", "arrow", "fill"),
 }
 
 func ReadSettings() error {
@@ -91,6 +105,18 @@ func ReadSettings() error {
 var interfaceArr []interface{}
 var InterfaceArr = reflect.TypeOf(interfaceArr)
 
+// Options whose value is a json object. These are not confused with
+// filetype or glob sections in settings.json
+var mapOptions = map[string]bool{
+	"completionicons": true,
+}
+
+// isLocalSection returns true if the settings.json entry k with value v
+// is a section of local (filetype or glob) settings rather than an option
+func isLocalSection(k string, v interface{}) bool {
+	return strings.HasPrefix(reflect.TypeOf(v).String(), "map") && !mapOptions[k]
+}
+
 func verifySetting(option string, value interface{}, def reflect.Type) bool {
 	vtype := reflect.TypeOf(value)
 
@@ -98,6 +124,10 @@ func verifySetting(option string, value interface{}, def reflect.Type) bool {
 		return vtype.AssignableTo(InterfaceArr)
 	}
 
+	if def.Kind() == reflect.Map && vtype.Kind() == reflect.Map {
+		return true
+	}
+
 	if def.Kind() == reflect.Slice && vtype.Kind() == reflect.Slice {
 		varray := value.([]interface{})
 		if len(varray) == 0 { return true }
@@ -115,7 +145,7 @@ func InitGlobalSettings() error {
 	GlobalSettings = DefaultGlobalSettings()
 
 	for k, v := range parsedSettings {
-		if !strings.HasPrefix(reflect.TypeOf(v).String(), "map") {
+		if !isLocalSection(k, v) {
 			if _, ok := GlobalSettings[k]; ok {
 				gtype := reflect.TypeOf(GlobalSettings[k])
 
@@ -135,38 +165,69 @@ func InitGlobalSettings() error {
 	return err
 }
 
+// globSpecificity is the number of characters of a glob that aren't
+// wildcards or other special characters
+func globSpecificity(g string) int {
+	n := 0
+	for _, r := range g {
+		if !strings.ContainsRune("*?[]{},!\\", r) { n++ }
+	}
+	return n
+}
+
+// localSections returns the names of the local sections of settings.json in
+// the order they are applied, so that later sections take precedence: first
+// the filetype sections, then the globs from the least to the most specific.
+// Globs are more specific when they have more characters that aren't
+// wildcards. Ties are broken alphabetically
+func localSections() []string {
+	var sections []string
+	for k, v := range parsedSettings {
+		if isLocalSection(k, v) { sections = append(sections, k) }
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		a, b := sections[i], sections[j]
+		aft, bft := strings.HasPrefix(a, "ft:"), strings.HasPrefix(b, "ft:")
+		if aft != bft { return aft }
+		if !aft {
+			if sa, sb := globSpecificity(a), globSpecificity(b); sa != sb { return sa < sb }
+		}
+		return a < b
+	})
+	return sections
+}
+
 // InitLocalSettings scans the json in settings.json and sets the options locally based
 // on whether the filetype or path matches ft or glob local settings
 // Must be called after ReadSettings
 func InitLocalSettings(settings map[string]interface{}, path string) error {
 	var parseError error
-	for k, v := range parsedSettings {
-		if strings.HasPrefix(reflect.TypeOf(v).String(), "map") {
-			if strings.HasPrefix(k, "ft:") {
-				if settings["filetype"].(string) == k[3:] {
-					for k1, v1 := range v.(map[string]interface{}) {
-						if _, ok := settings[k1]; ok && !verifySetting(k1, v1, reflect.TypeOf(settings[k1])) {
-							parseError = fmt.Errorf("Error: setting '%s' has incorrect type (%s), using default value: %v (%s)", k, reflect.TypeOf(v1), settings[k1], reflect.TypeOf(settings[k1]))
-							continue
-						}
-						settings[k1] = v1
+	for _, k := range localSections() {
+		v := parsedSettings[k]
+		if strings.HasPrefix(k, "ft:") {
+			if settings["filetype"].(string) == k[3:] {
+				for k1, v1 := range v.(map[string]interface{}) {
+					if _, ok := settings[k1]; ok && !verifySetting(k1, v1, reflect.TypeOf(settings[k1])) {
+						parseError = fmt.Errorf("Error: setting '%s' has incorrect type (%s), using default value: %v (%s)", k, reflect.TypeOf(v1), settings[k1], reflect.TypeOf(settings[k1]))
+						continue
 					}
+					settings[k1] = v1
 				}
-			} else {
-				g, err := glob.Compile(k)
-				if err != nil {
-					parseError = errors.New("Error with glob setting " + k + ": " + err.Error())
-					continue
-				}
+			}
+		} else {
+			g, err := glob.Compile(k)
+			if err != nil {
+				parseError = errors.New("Error with glob setting " + k + ": " + err.Error())
+				continue
+			}
 
-				if g.MatchString(path) {
-					for k1, v1 := range v.(map[string]interface{}) {
-						if _, ok := settings[k1]; ok && !verifySetting(k1, v1, reflect.TypeOf(settings[k1])) {
-							parseError = fmt.Errorf("Error: setting '%s' has incorrect type (%s), using default value: %v (%s)", k, reflect.TypeOf(v1), settings[k1], reflect.TypeOf(settings[k1]))
-							continue
-						}
-						settings[k1] = v1
+			if g.MatchString(path) {
+				for k1, v1 := range v.(map[string]interface{}) {
+					if _, ok := settings[k1]; ok && !verifySetting(k1, v1, reflect.TypeOf(settings[k1])) {
+						parseError = fmt.Errorf("Error: setting '%s' has incorrect type (%s), using default value: %v (%s)", k, reflect.TypeOf(v1), settings[k1], reflect.TypeOf(settings[k1]))
+						continue
 					}
+					settings[k1] = v1
 				}
 			}
 		}
@@ -190,7 +251,7 @@ func WriteSettings(filename string) error {
 
 		// remove any options froms parsedSettings that have since been marked as default
 		for k, v := range parsedSettings {
-			if !strings.HasPrefix(reflect.TypeOf(v).String(), "map") {
+			if !isLocalSection(k, v) {
 				cur, okcur := GlobalSettings[k]
 				if def, ok := defaults[k]; ok && okcur && reflect.DeepEqual(cur, def) {
 					delete(parsedSettings, k)
@@ -235,6 +296,82 @@ func OverwriteSettings(filename string) error {
 	return err
 }
 
+// ResetOption restores the global value of an option to its default and
+// removes it from settings.json
+func ResetOption(name string) error {
+	def, ok := DefaultAllSettings()[name]
+	if !ok {
+		return ErrInvalidOption
+	}
+	for _, s := range LocalSettings {
+		if s == name {
+			return ErrLocalOption
+		}
+	}
+
+	GlobalSettings[name] = def
+	delete(parsedSettings, name)
+	delete(ModifiedSettings, name)
+
+	var err error
+	if GlobalOptionCallback != nil {
+		err = GlobalOptionCallback(name, def)
+	}
+	if werr := WriteSettings(filepath.Join(ConfigDir, "settings.json")); werr != nil {
+		return errors.New("Error writing settings.json file: " + werr.Error())
+	}
+	return err
+}
+
+// Setting is the value of an option along with where it comes from
+type Setting struct {
+	Value  interface{}
+	Source string
+}
+
+// ResolveSettings returns the value every option takes for a file with the
+// given filetype and path, and where it comes from: "default", "plugin:name"
+// for the defaults of plugin options, "global", or the "ft:filetype" or
+// "glob:pattern" sections of settings.json that set it. When several
+// sections set an option, they are all listed, the one that wins last
+func ResolveSettings(filetype, path string) map[string]Setting {
+	settings := make(map[string]Setting)
+	defaults := DefaultAllSettings()
+	for k, v := range GlobalSettings {
+		source := "default"
+		if def, ok := defaults[k]; !ok || !reflect.DeepEqual(v, def) || ModifiedSettings[k] {
+			source = "global"
+		} else if _, ok := parsedSettings[k]; ok {
+			source = "global"
+		} else if i := strings.Index(k, "."); i > 0 {
+			source = "plugin:" + k[:i]
+		}
+		settings[k] = Setting{v, source}
+	}
+
+	local := make(map[string]bool)
+	for _, k := range localSections() {
+		var source string
+		if strings.HasPrefix(k, "ft:") {
+			if filetype != k[3:] { continue }
+			source = k
+		} else {
+			g, err := glob.Compile(k)
+			if err != nil || !g.MatchString(path) { continue }
+			source = "glob:" + k
+		}
+
+		for k1, v1 := range parsedSettings[k].(map[string]interface{}) {
+			if cur, ok := settings[k1]; ok && !verifySetting(k1, v1, reflect.TypeOf(cur.Value)) { continue }
+			src := source
+			if local[k1] { src = settings[k1].Source + ", " + source }
+			settings[k1] = Setting{v1, src}
+			local[k1] = true
+		}
+	}
+	return settings
+}
+
 // RegisterCommonOptionPlug creates a new option (called pl.name). This is meant to be called by plugins to add options.
 func RegisterCommonOptionPlug(pl string, name string, defaultvalue interface{}) error {
 	name = pl + "." + name
@@ -277,54 +414,70 @@ func GetGlobalOption(name string) interface{} {
 }
 
 var defaultCommonSettings = map[string]interface{}{
-	"autoindent":     true,
-	"autosu":         false,
-	"backup":         true,
-	"backupdir":      "",
-	"basename":       false,
-	"colorcolumn":    []float64{0},
-	"cursorline":     true,
-	"diffgutter":     false,
-	"encoding":       "utf-8",
-	"eofnewline":     true,
-	"fastdirty":      false,
-	"fileformat":     "unix",
-	"filetype":       "unknown",
-	"hidecursor":     false,
-	"hlsearch":       false,
-	"hltaberrors":    false,
-	"hltrailingws":   false,
-	"incsearch":      true,
-	"ignorecase":     true,
-	"indentchar":     " ",
-	"keepautoindent": false,
-	"lsp":            true,
-	"lsp-autoimport": false,
-	"matchbrace":     true,
-	"mkparents":      false,
-	"permbackup":     false,
-	"readonly":       false,
-	"rmtrailingws":   false,
-	"ruler":          true,
-	"relativeruler":  false,
-	"savecursor":     false,
-	"saveundo":       false,
-	"scrollbar":      false,
-	"scrollmargin":   float64(3),
-	"scrollspeed":    float64(2),
-	"smartpaste":     true,
-	"softwrap":       true,
-	"splitbottom":    true,
-	"splitright":     true,
-	"statusformatl":  "$(filename) $(modified)($(line),$(col)) $(status.paste)| ft:$(opt:filetype) | $(opt:fileformat) | $(opt:encoding)",
-	"statusformatr":  "$(bind:ToggleKeyMenu): bindings, $(bind:ToggleHelp): help",
-	"statusline":     true,
-	"syntax":         true,
-	"tabmovement":    false,
-	"tabsize":        float64(4),
-	"tabstospaces":   false,
-	"useprimary":     true,
-	"wordwrap":       true,
+	"autoindent":         true,
+	"autosu":             false,
+	"backup":             true,
+	"backupdir":          "",
+	"basename":           false,
+	"binarychecksize":    float64(8),
+	"bom":                false,
+	"colorcolumn":        []float64{0},
+	"completeallbuffers": false,
+	"completehidden":     false,
+	"cursorline":         true,
+	"diffgutter":         false,
+	"encoding":           "utf-8",
+	"eofnewline":         true,
+	"fastdirty":          false,
+	"fileformat":         "unix",
+	"filetype":           "unknown",
+	"hidecursor":         false,
+	"hlsearch":           false,
+	"hltaberrors":        false,
+	"hltrailingws":       false,
+	"hoverdelay":         float64(0),
+	"incsearch":          true,
+	"ignorecase":         true,
+	"indentchar":         " ",
+	"keepautoindent":     false,
+	"lsp":                true,
+	"lsp-autoimport":     false,
+	"lspinlayhints":      false,
+	"lspdiagdelay":       float64(0),
+	"lspdiagseverity":    "hint",
+	"matchbrace":         true,
+	"matchbraceindicator": false,
+	"mkparents":          false,
+	"openbinary":         false,
+	"permbackup":         false,
+	"readonly":           false,
+	"rmtrailingws":       false,
+	"ruler":              true,
+	"rulerside":          "left",
+	"relativeruler":      false,
+	"savecursor":         false,
+	"saveundo":           false,
+	"saveview":           false,
+	"scrollbar":          false,
+	"scrollmargin":       float64(3),
+	"scrollspeed":        float64(2),
+	"signcolumn":         false,
+	"smartpaste":         true,
+	"smoothscroll":       false,
+	"softwrap":           true,
+	"splitbottom":        true,
+	"splitright":         true,
+	"statusformatl":      "$(filename) $(modified)($(line),$(col)) $(status.paste)| ft:$(opt:filetype) | $(opt:fileformat) | $(opt:encoding)",
+	"statusformatr":      "$(bind:ToggleKeyMenu): bindings, $(bind:ToggleHelp): help",
+	"statusline":         true,
+	"syntax":             true,
+	"tabindicator":       "fill",
+	"tabmovement":        false,
+	"tabsize":            float64(4),
+	"tabstospaces":       false,
+	"useprimary":         true,
+	"wordchars":          "",
+	"wordwrap":           true,
 }
 
 func GetInfoBarOffset() int {
@@ -351,22 +504,25 @@ func DefaultCommonSettings() map[string]interface{} {
 // a list of settings that should only be globally modified and their
 // default values
 var DefaultGlobalOnlySettings = map[string]interface{}{
-	"autosave":       float64(0),
-	"clipboard":      "external",
-	"colorscheme":    "default",
-	"divchars":       "|-",
-	"divreverse":     true,
-	"infobar":        true,
-	"keymenu":        false,
-	"tabbar":         true,
-	"mouse":          true,
-	"parsecursor":    false,
-	"paste":          false,
-	"pluginchannels": []string{"https://raw.githubusercontent.com/micro-editor/plugin-channel/master/channel.json"},
-	"pluginrepos":    []string{},
-	"savehistory":    true,
-	"sucmd":          "sudo",
-	"xterm":          false,
+	"autosave":        float64(0),
+	"clipboard":       "external",
+	"colorscheme":     "default",
+	"completionicons": map[string]interface{}{},
+	"divchars":        "|-",
+	"divreverse":      true,
+	"infobar":         true,
+	"keymenu":         false,
+	"lspmaxmessage":   float64(64),
+	"tabbar":          true,
+	"tabnumbers":      "off",
+	"mouse":           true,
+	"parsecursor":     false,
+	"paste":           false,
+	"pluginchannels":  []string{"https://raw.githubusercontent.com/micro-editor/plugin-channel/master/channel.json"},
+	"pluginrepos":     []string{},
+	"savehistory":     true,
+	"sucmd":           "sudo",
+	"xterm":           false,
 }
 
 // a list of settings that should never be globally modified
@@ -418,6 +574,9 @@ func GetNativeValue(option string, realValue interface{}, value string) (interfa
 		native = b
 	} else if kind == reflect.String {
 		native = value
+		if option == "encoding" {
+			native = NormalizeEncoding(value)
+		}
 	} else if kind == reflect.Float64 {
 		i, err := strconv.Atoi(value)
 		if err != nil {
@@ -440,7 +599,7 @@ func GetNativeValue(option string, realValue interface{}, value string) (interfa
 			strvals := strings.Split(value, ",")
 			vals := []float64{}
 			for _, str := range(strvals) {
-				num, err := strconv.Atoi(str)
+				num, err := strconv.Atoi(strings.TrimSpace(str))
 				if err != nil {
 					log.Println("Not a float string")
 					return nil, ErrInvalidValue
@@ -476,9 +635,24 @@ func ErrExpected(text string) error {
 	return errors.New(text)
 }
 
+// toFloat converts the numbers that an option can hold, which are float64
+// when parsed from json but may be ints when set from a plugin, to float64
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
 func validateGreater(number float64) optionValidator {
 	return func (option string, value interface{}) error {
-		val, ok := value.(float64)
+		val, ok := toFloat(value)
 		if !ok { return ErrExpected("to be a number")}
 		if val > number { return nil }
 		return ErrExpected("to be >" + strconv.FormatFloat(number, 'f', -1, 64))
@@ -487,7 +661,7 @@ func validateGreater(number float64) optionValidator {
 
 func validateLess(number float64) optionValidator {
 	return func (option string, value interface{}) error {
-		val, ok := value.(float64)
+		val, ok := toFloat(value)
 		if !ok { return ErrExpected("to be a number")}
 		if val < number { return nil }
 		return ErrExpected("to be <" + strconv.FormatFloat(number, 'f', -1, 64))
@@ -496,7 +670,7 @@ func validateLess(number float64) optionValidator {
 
 func validateGreaterEqual(number float64) optionValidator {
 	return func (option string, value interface{}) error {
-		val, ok := value.(float64)
+		val, ok := toFloat(value)
 		if !ok { return ErrExpected("to be a number")}
 		if val >= number { return nil }
 		return ErrExpected("to be >=" + strconv.FormatFloat(number, 'f', -1, 64))
@@ -505,7 +679,7 @@ func validateGreaterEqual(number float64) optionValidator {
 
 func validateLessEqual(number float64) optionValidator {
 	return func (option string, value interface{}) error {
-		val, ok := value.(float64)
+		val, ok := toFloat(value)
 		if !ok { return ErrExpected("to be a number")}
 		if val <= number { return nil }
 		return ErrExpected("to be <=" + strconv.FormatFloat(number, 'f', -1, 64))
@@ -617,8 +791,45 @@ func validateCalculatedStringLiteral(fn func() []string) optionValidator {
 	}
 }
 
+// encodingAliases maps spellings of encoding names that users commonly type
+// to the names known by htmlindex
+var encodingAliases = map[string]string{
+	"utf8":      "utf-8",
+	"utf16":     "utf-16",
+	"utf16le":   "utf-16le",
+	"utf16be":   "utf-16be",
+	"latin1":    "iso-8859-1",
+	"latin-1":   "iso-8859-1",
+	"iso8859-1": "iso-8859-1",
+	"iso88591":  "iso-8859-1",
+	"latin2":    "iso-8859-2",
+	"latin-2":   "iso-8859-2",
+	"cp1250":    "windows-1250",
+	"cp1251":    "windows-1251",
+	"cp1252":    "windows-1252",
+	"sjis":      "shift_jis",
+	"shiftjis":  "shift_jis",
+	"shift-jis": "shift_jis",
+	"eucjp":     "euc-jp",
+	"euckr":     "euc-kr",
+}
+
+// NormalizeEncoding returns the canonical name of the encoding name, with
+// surrounding whitespace and case ignored and common aliases resolved
+func NormalizeEncoding(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := encodingAliases[strings.ReplaceAll(name, "_", "-")]; ok {
+		return alias
+	}
+	return name
+}
+
 func validateEncoding(option string, value interface{}) error {
-	_, err := htmlindex.Get(value.(string))
-	if err != nil { return ErrExpected("to be a valid encoding") }
+	str, ok := value.(string)
+	if !ok { return ErrExpected("to be a string") }
+	_, err := htmlindex.Get(NormalizeEncoding(str))
+	if err != nil {
+		return ErrExpected("to be a valid encoding, such as utf-8, utf-16, iso-8859-1, windows-1252 or shift_jis")
+	}
 	return nil
 }
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodingAliases(t *testing.T) {
+	aliases := map[string]string{
+		"utf8":      "utf-8",
+		"UTF-8":     "utf-8",
+		" Utf8 ":    "utf-8",
+		"latin1":    "iso-8859-1",
+		"Latin-1":   "iso-8859-1",
+		"ISO8859_1": "iso-8859-1",
+		"cp1252":    "windows-1252",
+		"SJIS":      "shift_jis",
+		"Shift-JIS": "shift_jis",
+		"utf16":     "utf-16",
+		"gbk":       "gbk",
+	}
+	for alias, name := range aliases {
+		assert.Equal(t, name, NormalizeEncoding(alias), alias)
+
+		native, err := GetNativeValue("encoding", "utf-8", alias)
+		assert.Nil(t, err, alias)
+		assert.Equal(t, name, native, alias)
+	}
+}
+
+func TestInvalidEncoding(t *testing.T) {
+	_, err := GetNativeValue("encoding", "utf-8", "notanencoding")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "utf-8")
+}
+
+func TestResetOption(t *testing.T) {
+	GlobalSettings = DefaultGlobalSettings()
+	GlobalSettings["tabsize"] = float64(8)
+	parsedSettings["tabsize"] = float64(8)
+	ModifiedSettings["tabsize"] = true
+
+	var called string
+	GlobalOptionCallback = func(option string, nativeValue interface{}) error {
+		called = option
+		return nil
+	}
+	defer func() { GlobalOptionCallback = nil }()
+
+	assert.Nil(t, ResetOption("tabsize"))
+	assert.Equal(t, float64(4), GlobalSettings["tabsize"])
+	assert.NotContains(t, parsedSettings, "tabsize")
+	assert.NotContains(t, ModifiedSettings, "tabsize")
+	assert.Equal(t, "tabsize", called)
+
+	assert.Equal(t, ErrInvalidOption, ResetOption("notanoption"))
+	assert.Equal(t, ErrLocalOption, ResetOption("filetype"))
+}
+
+func TestNumericArrayOptions(t *testing.T) {
+	_, err := GetNativeValue("colorcolumn", []float64{0}, "[80,-1]")
+	assert.NotNil(t, err)
+
+	native, err := GetNativeValue("colorcolumn", []float64{0}, "[80, 100]")
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{80, 100}, native)
+
+	assert.Nil(t, OptionIsValid("colorcolumn", []interface{}{float64(80), 100}))
+	assert.NotNil(t, OptionIsValid("colorcolumn", []interface{}{80, -1}))
+	assert.NotNil(t, OptionIsValid("colorcolumn", []interface{}{float64(80), "foo"}))
+	assert.Nil(t, OptionIsValid("colorcolumn", 80))
+	assert.NotNil(t, OptionIsValid("colorcolumn", -1))
+}
+
+func TestResolveSettings(t *testing.T) {
+	GlobalSettings = DefaultGlobalSettings()
+	GlobalSettings["tabsize"] = float64(8)
+	RegisterCommonOptionPlug("pl", "opt", true)
+	oldParsed := parsedSettings
+	parsedSettings = map[string]interface{}{
+		"tabsize":  float64(8),
+		"ft:go":    map[string]interface{}{"tabstospaces": true, "ruler": false},
+		"*.go":     map[string]interface{}{"ruler": true, "tabsize": "wrong"},
+		"ft:rust":  map[string]interface{}{"tabsize": float64(2)},
+	}
+	defer func() {
+		delete(defaultCommonSettings, "pl.opt")
+		GlobalSettings = DefaultGlobalSettings()
+		parsedSettings = oldParsed
+	}()
+
+	settings := ResolveSettings("go", "main.go")
+	assert.Equal(t, Setting{float64(8), "global"}, settings["tabsize"])
+	assert.Equal(t, Setting{true, "ft:go"}, settings["tabstospaces"])
+	assert.Equal(t, Setting{true, "ft:go, glob:*.go"}, settings["ruler"])
+	assert.Equal(t, Setting{true, "plugin:pl"}, settings["pl.opt"])
+	assert.Equal(t, "default", settings["autoindent"].Source)
+
+	settings = ResolveSettings("rust", "main.rs")
+	assert.Equal(t, Setting{float64(2), "ft:rust"}, settings["tabsize"])
+	assert.Equal(t, "default", settings["ruler"].Source)
+}
+
+func TestLocalSettingsPrecedence(t *testing.T) {
+	oldParsed := parsedSettings
+	defer func() { parsedSettings = oldParsed }()
+	parsedSettings = map[string]interface{}{
+		"**/vendor/**/*.go": map[string]interface{}{"tabsize": float64(8)},
+		"*.go":              map[string]interface{}{"tabsize": float64(2), "ruler": false},
+		"ft:go":             map[string]interface{}{"tabsize": float64(3), "ruler": true},
+	}
+
+	for i := 0; i < 20; i++ {
+		settings := map[string]interface{}{"filetype": "go", "tabsize": float64(4), "ruler": true}
+		assert.Nil(t, InitLocalSettings(settings, "/src/vendor/pkg/a.go"))
+		assert.Equal(t, float64(8), settings["tabsize"])
+		assert.Equal(t, false, settings["ruler"])
+
+		settings = map[string]interface{}{"filetype": "go", "tabsize": float64(4), "ruler": true}
+		assert.Nil(t, InitLocalSettings(settings, "/src/main.go"))
+		assert.Equal(t, float64(2), settings["tabsize"])
+	}
+	assert.Equal(t, []string{"ft:go", "*.go", "**/vendor/**/*.go"}, localSections())
+}
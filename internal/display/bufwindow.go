@@ -1,8 +1,10 @@
 package display
 
 import (
+	"math"
 	"strconv"
 	"strings"
+	"time"
 	runewidth "github.com/mattn/go-runewidth"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	. "github.com/zyedidia/micro/v2/internal/loc"
@@ -11,6 +13,7 @@ import (
 	"github.com/zyedidia/micro/v2/internal/util"
 	"github.com/zyedidia/micro/v2/internal/lsp"
 	"github.com/zyedidia/tcell/v2"
+	lspt "go.lsp.dev/protocol"
 )
 
 // The BufWindow provides a way of displaying a certain section of a buffer.
@@ -22,18 +25,32 @@ type BufWindow struct {
 	completeBox buffer.Loc
 
 	active bool
+	closed bool
 
 	sline *StatusLine
 
 	bufWidth         int
 	bufHeight        int
 	gutterOffset     int
+	rightGutter      int
 	hasMessage       bool
 	maxLineNumLength int
-	drawDivider      bool
+	drawDivider      dividerSide
 	cursorVisual     buffer.Loc
+
+	// scrollOffset is the number of lines between StartLine and the line
+	// drawn at the top of the window while a smooth scroll is animated.
+	// It shrinks towards 0 with every frame
+	scrollOffset float64
+
+	// cache of visualRows
+	rows    []int
+	rowsKey visualRowsKey
 }
 
+// scrollFrame is the delay between the frames of smooth scrolling
+const scrollFrame = 15 * time.Millisecond
+
 // NewBufWindow creates a new window at a location in the screen with a width and height
 func NewBufWindow(x, y, width, height int, buf *buffer.Buffer) *BufWindow {
 	w := new(BufWindow)
@@ -50,6 +67,7 @@ func NewBufWindow(x, y, width, height int, buf *buffer.Buffer) *BufWindow {
 // SetBuffer sets this window's buffer.
 func (w *BufWindow) SetBuffer(b *buffer.Buffer) {
 	w.Buf = b
+	w.scrollOffset = 0
 	b.OptionCallback = func(option string, nativeValue interface{}) {
 		if option == "softwrap" {
 			if nativeValue.(bool) {
@@ -69,6 +87,9 @@ func (w *BufWindow) SetBuffer(b *buffer.Buffer) {
 	b.GetVisualX = func(loc buffer.Loc) int {
 		return w.VLocFromLoc(loc).VisualX
 	}
+	b.GetViewStart = func() (int, int) {
+		return w.StartLine.Line, w.StartCol
+	}
 }
 
 // GetView gets the view.
@@ -84,8 +105,6 @@ func (w *BufWindow) SetView(view *View) {
 // Resize resizes this window.
 func (w *BufWindow) Resize(width, height int) {
 	w.Width, w.Height = width, height
-	w.updateDisplayInfo()
-
 	w.Relocate()
 }
 
@@ -99,6 +118,23 @@ func (w *BufWindow) IsActive() bool {
 	return w.active
 }
 
+// Close marks the window as closed. Anything still referencing the
+// window (such as anchored overlays) should stop using it.
+func (w *BufWindow) Close() {
+	w.closed = true
+	w.active = false
+}
+
+// IsClosed returns true if this window has been closed.
+func (w *BufWindow) IsClosed() bool {
+	return w.closed
+}
+
+// TabSize returns the tabsize of the buffer shown in the window.
+func (w *BufWindow) TabSize() int {
+	return util.IntOpt(w.Buf.Settings["tabsize"])
+}
+
 // BufView returns the width, height and x,y location of the actual buffer.
 // It is not exactly the same as the whole window which also contains gutter,
 // ruler, scrollbar and statusline.
@@ -116,7 +152,7 @@ func (w *BufWindow) BufView() View {
 func (w *BufWindow) updateDisplayInfo() {
 	b := w.Buf
 
-	w.drawDivider = false
+	w.drawDivider = 0
 	if !b.Settings["statusline"].(bool) {
 		_, h := screen.Screen.Size()
 		infoY := h
@@ -124,12 +160,15 @@ func (w *BufWindow) updateDisplayInfo() {
 			infoY--
 		}
 		if w.Y+w.Height != infoY {
-			w.drawDivider = true
+			w.drawDivider |= divBottom
 		}
 	}
+	if hasRightNeighbor(w.X, w.Width) {
+		w.drawDivider |= divRight
+	}
 
 	w.bufHeight = w.Height
-	if b.Settings["statusline"].(bool) || w.drawDivider {
+	if b.Settings["statusline"].(bool) || w.drawDivider&divBottom != 0 {
 		w.bufHeight--
 	}
 
@@ -139,18 +178,20 @@ func (w *BufWindow) updateDisplayInfo() {
 	// so we can pad appropriately when displaying line numbers
 	w.maxLineNumLength = len(strconv.Itoa(b.LinesNum()))
 
-	w.gutterOffset = 0
-	if b.Settings["diffgutter"].(bool) {
-		w.gutterOffset++
-	}
-	if b.Settings["ruler"].(bool) {
-		w.gutterOffset += w.maxLineNumLength + 1
+	gutterWidth := w.signWidth() + w.rulerWidth()
+
+	// the gutter only offsets the start of the buffer when it's on the left
+	w.gutterOffset, w.rightGutter = gutterWidth, 0
+	if b.Settings["rulerside"] == "right" {
+		w.gutterOffset, w.rightGutter = 0, gutterWidth
 	}
 
 	prevBufWidth := w.bufWidth
 
-	w.bufWidth = w.Width - w.gutterOffset
-	if w.Buf.Settings["scrollbar"].(bool) && w.Buf.LinesNum() > w.Height {
+	w.bufWidth = w.Width - gutterWidth
+	// with softwrap, narrowing the buffer for the scrollbar can only add
+	// rows, so the scrollbar is still needed afterwards
+	if w.Buf.Settings["scrollbar"].(bool) && w.overflows() {
 		w.bufWidth--
 	}
 
@@ -161,6 +202,24 @@ func (w *BufWindow) updateDisplayInfo() {
 	}
 }
 
+// signWidth returns the width of the sign column, or of the diff gutter when
+// the sign column is off. Both are shown independently of the line numbers
+func (w *BufWindow) signWidth() int {
+	if w.Buf.Settings["signcolumn"].(bool) || w.Buf.Settings["diffgutter"].(bool) {
+		return 1
+	}
+	return 0
+}
+
+// rulerWidth returns the width of the line numbers and of the mark column
+// that follows them, or 0 if the ruler is off
+func (w *BufWindow) rulerWidth() int {
+	if !w.Buf.Settings["ruler"].(bool) {
+		return 0
+	}
+	return w.maxLineNumLength + 1
+}
+
 func (w *BufWindow) getStartInfo(n, lineN int) ([]byte, int, int, *tcell.Style) {
 	tabsize := util.IntOpt(w.Buf.Settings["tabsize"])
 	width := 0
@@ -189,7 +248,11 @@ func (w *BufWindow) getStartInfo(n, lineN int) ([]byte, int, int, *tcell.Style)
 		}
 		width += w
 		b = b[size:]
-		bloc.X++
+		// Combining marks are decoded together with their base character,
+		// and a mark without a base does not count as a character either
+		if !util.IsMark(r) {
+			bloc.X++
+		}
 	}
 	return b, n - width, bloc.X, s
 }
@@ -208,6 +271,9 @@ func (w *BufWindow) Clear() {
 // Returns true if the window location is moved
 func (w *BufWindow) Relocate() bool {
 	b := w.Buf
+	// the gutter may have changed since the last redraw, for example when
+	// the number of lines gets another digit or an option is toggled
+	w.updateDisplayInfo()
 	height := w.bufHeight
 	ret := false
 	activeC := w.Buf.GetActiveCursor()
@@ -244,14 +310,94 @@ func (w *BufWindow) Relocate() bool {
 			w.StartCol = cx
 			ret = true
 		}
-		if cx+w.gutterOffset+rw > w.StartCol+w.Width {
-			w.StartCol = cx - w.Width + w.gutterOffset + rw
+		if cx+w.gutterOffset+w.rightGutter+rw > w.StartCol+w.Width {
+			w.StartCol = cx - w.Width + w.gutterOffset + w.rightGutter + rw
 			ret = true
 		}
 	}
+	if ret {
+		w.scrollOffset = 0
+	}
 	return ret
 }
 
+// AnimateScroll starts a smooth scroll from the view starting at from to
+// the current StartLine, if the smoothscroll option is on. Only the drawing
+// is animated: StartLine is already at its final position
+func (w *BufWindow) AnimateScroll(from SLoc) {
+	if !w.Buf.Settings["smoothscroll"].(bool) {
+		w.scrollOffset = 0
+		return
+	}
+	// continue from the line currently drawn at the top if another
+	// animation is still running
+	w.scrollOffset += float64(w.Diff(w.StartLine, from))
+	if w.scrollOffset != 0 { screen.Redraw() }
+}
+
+// drawStartLine returns the line drawn at the top of the window, which is
+// StartLine unless a smooth scroll is running. Each call advances the
+// animation by one frame and schedules the next one
+func (w *BufWindow) drawStartLine() SLoc {
+	if w.scrollOffset == 0 { return w.StartLine }
+
+	start := w.Scroll(w.StartLine, int(math.Round(w.scrollOffset)))
+	w.scrollOffset /= 2
+	if math.Abs(w.scrollOffset) < 0.5 {
+		w.scrollOffset = 0
+	}
+	time.AfterFunc(scrollFrame, screen.Redraw)
+	return start
+}
+
+// InGutter returns true if the screen column x is part of the window's
+// gutter, on whichever side it is
+func (w *BufWindow) InGutter(x int) bool {
+	x -= w.X
+	if w.rightGutter > 0 {
+		return x >= w.bufWidth && x < w.bufWidth+w.rightGutter
+	}
+	return x >= 0 && x < w.gutterOffset
+}
+
+// CenterCursor scrolls the view so that the line of the active cursor is in
+// the middle of the window
+func (w *BufWindow) CenterCursor() {
+	w.scrollCursorTo(w.bufHeight / 2)
+}
+
+// TopCursor scrolls the view so that the line of the active cursor is at
+// the top of the window, leaving scrollmargin lines above it
+func (w *BufWindow) TopCursor() {
+	w.scrollCursorTo(w.cursorMargin())
+}
+
+// BottomCursor scrolls the view so that the line of the active cursor is at
+// the bottom of the window, leaving scrollmargin lines below it
+func (w *BufWindow) BottomCursor() {
+	w.scrollCursorTo(w.bufHeight - 1 - w.cursorMargin())
+}
+
+// cursorMargin returns the scrollmargin, limited so that it can be kept
+// above and below the cursor at the same time
+func (w *BufWindow) cursorMargin() int {
+	scrollmargin := int(w.Buf.Settings["scrollmargin"].(float64))
+	return util.Clamp(scrollmargin, 0, (w.bufHeight-1)/2)
+}
+
+// scrollCursorTo sets StartLine so that the visual line of the active cursor
+// is the given row of the window. The view never starts past the point
+// where the last line is at the bottom of the window
+func (w *BufWindow) scrollCursorTo(row int) {
+	c := w.SLocFromLoc(w.Buf.GetActiveCursor().Loc)
+	w.StartLine = w.Scroll(c, -row)
+
+	end := w.SLocFromLoc(w.Buf.End())
+	if w.Diff(w.StartLine, end) < w.bufHeight-1 {
+		w.StartLine = w.Scroll(end, -w.bufHeight+1)
+	}
+}
+
 // LocFromVisual takes a visual location (x and y position) and returns the
 // position in the buffer corresponding to the visual location
 // If the requested position does not correspond to a buffer location it returns
@@ -270,7 +416,7 @@ func (w *BufWindow) LocFromVisual(svloc buffer.Loc) buffer.Loc {
 
 
 func (w *BufWindow) hasDiagnosticAt(vloc *buffer.Loc, bloc *buffer.Loc) (bool, tcell.Style) {
-	for _, d := range w.Buf.GetDiagnostics() {
+	for _, d := range w.Buf.VisibleDiagnostics() {
 		if int(d.Range.Start.Line) == bloc.Y {
 			return true, lsp.Style(&d)
 		}
@@ -321,7 +467,8 @@ func (w *BufWindow) drawMarkGutter(vloc *buffer.Loc, bloc *buffer.Loc, style tce
 	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, char, nil, style)
 }
 
-func (w *BufWindow) drawDiffGutter(backgroundStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
+// diffSign returns the diff indicator of a line and its style
+func (w *BufWindow) diffSign(backgroundStyle tcell.Style, softwrapped bool, bloc *buffer.Loc) (rune, tcell.Style) {
 	symbol := ' '
 	styleName := ""
 
@@ -344,11 +491,99 @@ func (w *BufWindow) drawDiffGutter(backgroundStyle tcell.Style, softwrapped bool
 		foreground, _, _ := s.Decompose()
 		style = style.Foreground(foreground)
 	}
+	return symbol, style
+}
 
+func (w *BufWindow) drawDiffGutter(backgroundStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
+	symbol, style := w.diffSign(backgroundStyle, softwrapped, bloc)
 	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, symbol, nil, style)
 	vloc.X++
 }
 
+// diagnosticSigns are the glyphs shown in the sign column for each
+// diagnostic severity
+var diagnosticSigns = map[lspt.DiagnosticSeverity]rune{
+	lspt.DiagnosticSeverityError:       'E',
+	lspt.DiagnosticSeverityWarning:     'W',
+	lspt.DiagnosticSeverityInformation: 'I',
+	lspt.DiagnosticSeverityHint:        'H',
+}
+
+// sign returns the glyph shown in the sign column for a line and its style.
+// Errors and warnings take priority over diff indicators, which take
+// priority over less severe diagnostics
+func (w *BufWindow) sign(diags []lsp.Diagnostic, backgroundStyle tcell.Style, softwrapped bool, bloc *buffer.Loc) (rune, tcell.Style) {
+	var d *lsp.Diagnostic
+	if !softwrapped {
+		d = lsp.MostSevereOnLine(diags, uint32(bloc.Y))
+	}
+	diagSign := func() (rune, tcell.Style) {
+		r, ok := diagnosticSigns[d.Severity]
+		if !ok { r = diagnosticSigns[lspt.DiagnosticSeverityHint] }
+		return r, lsp.Style(d)
+	}
+
+	if d != nil && d.Severity != 0 && d.Severity <= lspt.DiagnosticSeverityWarning {
+		return diagSign()
+	}
+	if w.Buf.Settings["diffgutter"].(bool) {
+		if symbol, style := w.diffSign(backgroundStyle, softwrapped, bloc); symbol != ' ' {
+			return symbol, style
+		}
+	}
+	if d != nil {
+		return diagSign()
+	}
+	return ' ', backgroundStyle
+}
+
+func (w *BufWindow) drawSignColumn(diags []lsp.Diagnostic, backgroundStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
+	symbol, style := w.sign(diags, backgroundStyle, softwrapped, bloc)
+	screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, symbol, nil, style)
+	vloc.X++
+}
+
+// drawGutter draws the sign column or diff gutter and the line numbers of a
+// row. On the left they start at vloc.X, which is moved past them. On the
+// right they are drawn after the text area and vloc.X is left unchanged,
+// with the columns in mirrored order so the mark column is next to the text
+func (w *BufWindow) drawGutter(diags []lsp.Diagnostic, s tcell.Style, markStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
+	b := w.Buf
+	sign := func() {
+		if b.Settings["signcolumn"].(bool) {
+			w.drawSignColumn(diags, s, softwrapped, vloc, bloc)
+		} else if b.Settings["diffgutter"].(bool) {
+			w.drawDiffGutter(s, softwrapped, vloc, bloc)
+		}
+	}
+
+	ruler := b.Settings["ruler"].(bool)
+	numStyle := s
+	if ruler {
+		if hasMsg, msgStyle := w.hasMessageOrDiagnosticAt(vloc, bloc); hasMsg {
+			numStyle = msgStyle
+		}
+	}
+
+	if w.rightGutter == 0 {
+		sign()
+		if ruler {
+			w.drawLineNum(numStyle, markStyle, softwrapped, vloc, bloc)
+			w.drawMarkColumn(numStyle, markStyle, softwrapped, vloc, bloc)
+		}
+		return
+	}
+
+	x := vloc.X
+	vloc.X = w.bufWidth
+	if ruler {
+		w.drawMarkColumn(numStyle, markStyle, softwrapped, vloc, bloc)
+		w.drawLineNum(numStyle, markStyle, softwrapped, vloc, bloc)
+	}
+	sign()
+	vloc.X = x
+}
+
 func (w *BufWindow) drawLineNum(lineNumStyle tcell.Style, markStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
 	cursorLine := w.Buf.GetActiveCursor().Loc.Y
 	var lineInt int
@@ -373,8 +608,11 @@ func (w *BufWindow) drawLineNum(lineNumStyle tcell.Style, markStyle tcell.Style,
 		}
 		vloc.X++
 	}
+}
 
-	// Write the mark gutter
+// drawMarkColumn draws the column between the line numbers and the text,
+// which shows the marks of the line
+func (w *BufWindow) drawMarkColumn(lineNumStyle tcell.Style, markStyle tcell.Style, softwrapped bool, vloc *buffer.Loc, bloc *buffer.Loc) {
 	if softwrapped {
 		screen.SetContent(w.X+vloc.X, w.Y+vloc.Y, ' ', nil, lineNumStyle)
 	} else {
@@ -438,6 +676,44 @@ func (w *BufWindow) showCursor(x, y int, main bool) {
 	}
 }
 
+// rangeStyle replaces the style with the style of the highlight ranges
+// containing loc whose priority is in [from, to)
+func rangeStyle(style tcell.Style, ranges []buffer.HighlightRange, loc buffer.Loc, from, to int) tcell.Style {
+	for _, r := range ranges {
+		if r.Priority < from || r.Priority >= to || !loc.Between(r.Start, r.End) {
+			continue
+		}
+		if s, ok := config.Colorscheme[r.Style]; ok {
+			style = s
+		}
+	}
+	return style
+}
+
+// rangeBackground is like rangeStyle, but only changes the background, with
+// the foreground of the style of the ranges like the cursor line
+func rangeBackground(style tcell.Style, ranges []buffer.HighlightRange, loc buffer.Loc, from, to int) tcell.Style {
+	for _, r := range ranges {
+		if r.Priority < from || r.Priority >= to || !loc.Between(r.Start, r.End) {
+			continue
+		}
+		if s, ok := config.Colorscheme[r.Style]; ok {
+			fg, _, _ := s.Decompose()
+			style = style.Background(fg)
+		}
+	}
+	return style
+}
+
+// virtualTextStyle returns the style of the colorscheme group of virtual
+// text, or the default style if the colorscheme doesn't have it
+func virtualTextStyle(group string) tcell.Style {
+	if s, ok := config.Colorscheme[group]; ok {
+		return s
+	}
+	return config.DefStyle
+}
+
 // displayBuffer draws the buffer being shown in this window on the screen.Screen
 func (w *BufWindow) displayBuffer() {
 	b := w.Buf
@@ -518,15 +794,20 @@ func (w *BufWindow) displayBuffer() {
 	spacerune := rune(' ')
 	if len(indentrunes) > 0 { spacerune = indentrunes[0] }
 
+	// tabrune is drawn in the first column of tabs
 	tabrune := rune('|')
 	if len(indentrunes) > 1 { tabrune = indentrunes[1] }
+	switch b.Settings["tabindicator"] {
+	case "none":
+		tabrune = ' '
+	case "arrow":
+		tabrune = '→'
+	}
 
 	nlrune := rune(' ')
 	if len(indentrunes) > 2 { nlrune = indentrunes[2] }
 
 	tabstospaces := b.Settings["tabstospaces"].(bool)
-	diffgutter := b.Settings["diffgutter"].(bool)
-	ruler := b.Settings["ruler"].(bool)
 	cursorline := b.Settings["cursorline"].(bool)
 
 	tabsize := util.IntOpt(b.Settings["tabsize"])
@@ -534,24 +815,24 @@ func (w *BufWindow) displayBuffer() {
 
 	// this represents the current draw position
 	// within the current window
+	startLine := w.drawStartLine()
 	vloc := buffer.Loc{X: 0, Y: 0}
 	if softwrap {
 		// the start line may be partially out of the current window
-		vloc.Y = -w.StartLine.Row
+		vloc.Y = -startLine.Row
 	}
 
 	// this represents the current draw position in the buffer (char positions)
-	bloc := buffer.Loc{X: -1, Y: w.StartLine.Line}
+	bloc := buffer.Loc{X: -1, Y: startLine.Line}
 
 	cursors := b.GetCursors()
 
-	diags := b.GetDiagnostics()
+	diags := b.VisibleDiagnostics()
+	b.UpdateInlayHints(startLine.Line, startLine.Line+w.bufHeight)
 
 	curStyle := config.DefStyle
 	for ; vloc.Y < w.bufHeight; vloc.Y++ {
 		vloc.X = 0
-		whiteSpace := true
-
 		currentLine := false
 		for _, c := range cursors {
 			if bloc.Y == c.Y && w.active {
@@ -566,26 +847,14 @@ func (w *BufWindow) displayBuffer() {
 		}
 
 		if vloc.Y >= 0 {
-			if diffgutter {
-				w.drawDiffGutter(s, false, &vloc, &bloc)
-			}
-
-			if ruler {
-				hasMsg, msgStyle := w.hasMessageOrDiagnosticAt(&vloc, &bloc)
-				if hasMsg {
-					s = msgStyle
-				}
-
-				w.drawLineNum(s, markStyle, false, &vloc, &bloc)
-			}
+			w.drawGutter(diags, s, markStyle, false, &vloc, &bloc)
 		} else {
 			vloc.X = w.gutterOffset
 		}
 
-		w.gutterOffset = vloc.X
-
 		bline := b.LineBytes(bloc.Y)
 		blineLen := util.CharacterCount(bline)
+		lineHighlights := b.HighlightsOnLine(bloc.Y)
 
 		leadingwsEnd := len(util.GetLeadingWhitespace(bline))
 		trailingwsStart := blineLen - util.CharacterCount(util.GetTrailingWhitespace(bline))
@@ -596,15 +865,30 @@ func (w *BufWindow) displayBuffer() {
 		}
 		bloc.X = bslice
 
-		draw := func(r rune, combc []rune, style tcell.Style, highlight bool, showcursor bool, tabstart bool, first bool) {
+		// indentation and alignment are told apart by the position in the
+		// buffer rather than by what was drawn before, which may be scrolled
+		// out of view. Tab stops are counted from the start of the text,
+		// including the columns scrolled out of view
+		isIndent := func() bool {
+			return bloc.X < leadingwsEnd
+		}
+		isTabStop := func() bool {
+			return (vloc.X-w.gutterOffset+w.StartCol)%tabsize == 0
+		}
+
+		draw := func(r rune, combc []rune, style tcell.Style, highlight bool, showcursor bool, first bool) {
 			if nColsBeforeStart <= 0 && vloc.Y >= 0 {
 				if highlight {
+					// see the buffer.Highlight priorities for the order in
+					// which highlights are drawn
+					style = rangeStyle(style, lineHighlights, bloc, buffer.HighlightSyntax, buffer.HighlightSearch)
 					if w.Buf.HighlightSearch && w.Buf.SearchMatch(bloc) {
 						style = config.DefStyle.Reverse(true)
 						if s, ok := config.Colorscheme["hlsearch"]; ok {
 							style = s
 						}
 					}
+					style = rangeStyle(style, lineHighlights, bloc, buffer.HighlightSearch, buffer.HighlightSelection)
 
 					_, origBg, _ := style.Decompose()
 					_, defBg, _ := config.DefStyle.Decompose()
@@ -644,6 +928,20 @@ func (w *BufWindow) displayBuffer() {
 						}
 					}
 
+					if !dontOverrideBackground {
+						style = rangeBackground(style, lineHighlights, bloc, math.MinInt, buffer.HighlightCursorLine)
+						for _, c := range cursors {
+							if cursorline && w.active && !c.HasSelection() && c.Y == bloc.Y {
+								if s, ok := config.Colorscheme["cursor-line"]; ok {
+									fg, _, _ := s.Decompose()
+									style = style.Background(fg)
+								}
+								break
+							}
+						}
+						style = rangeBackground(style, lineHighlights, bloc, buffer.HighlightCursorLine, buffer.HighlightSyntax)
+					}
+
 					for _, c := range cursors {
 						if c.HasSelection() && bloc.Between(c.CurSelection[0], c.CurSelection[1]) {
 							// The current character is selected
@@ -653,15 +951,8 @@ func (w *BufWindow) displayBuffer() {
 								style = s
 							}
 						}
-
-						if cursorline && w.active && !dontOverrideBackground &&
-							!c.HasSelection() && c.Y == bloc.Y {
-							if s, ok := config.Colorscheme["cursor-line"]; ok {
-								fg, _, _ := s.Decompose()
-								style = style.Background(fg)
-							}
-						}
 					}
+					style = rangeStyle(style, lineHighlights, bloc, buffer.HighlightSelection, math.MaxInt)
 
 					for _, m := range b.Messages {
 						if bloc.Between(m.Start, m.End) {
@@ -680,26 +971,23 @@ func (w *BufWindow) displayBuffer() {
 					}
 
 					if r == ' ' || r == '\t' {
-						if r == ' ' {
-							if !tabstospaces {
-								r = spacerune
-							} else {
-								if (whiteSpace && tabstart) {
-									r = spacerune
-								} else {
-									r = ' '
-								}
-							}
-						} else {
-							if tabstart || first {
-								r = tabrune
-							} else {
-								r = ' '
-							}
+						// spaces are marked at every column, unless they
+						// stand for tabs with tabstospaces, in which case
+						// only indentation at tab stops is. Tabs are marked
+						// at their first column only
+						switch {
+						case r == '\t' && first:
+							r = tabrune
+						case r == '\t':
+							r = ' '
+						case !tabstospaces || (isIndent() && isTabStop()):
+							r = spacerune
+						default:
+							r = ' '
 						}
 
 						cs_name := "indent-char"
-						if !whiteSpace { cs_name = "whitespace-char" }
+						if !isIndent() { cs_name = "whitespace-char" }
 
 						if s, ok := config.Colorscheme[cs_name]; ok {
 							fg, _, _ := s.Decompose()
@@ -757,19 +1045,8 @@ func (w *BufWindow) displayBuffer() {
 
 		wrap := func() {
 			vloc.X = 0
-			if diffgutter {
-				w.drawDiffGutter(lineNumStyle, true, &vloc, &bloc)
-			}
-
 			// This will draw an empty line number because the current line is wrapped
-			if ruler {
-				hasMsg, msgStyle := w.hasMessageOrDiagnosticAt(&vloc, &bloc)
-				if hasMsg {
-					w.drawLineNum(msgStyle, markStyle, true, &vloc, &bloc)
-				} else {
-					w.drawLineNum(lineNumStyle, markStyle, true, &vloc, &bloc)
-				}
-			}
+			w.drawGutter(diags, lineNumStyle, markStyle, true, &vloc, &bloc)
 		}
 
 		type glyph struct {
@@ -777,6 +1054,8 @@ func (w *BufWindow) displayBuffer() {
 			combc []rune
 			style tcell.Style
 			width int
+			// virtual glyphs aren't part of the buffer
+			virtual bool
 		}
 
 		var word []glyph
@@ -786,16 +1065,39 @@ func (w *BufWindow) displayBuffer() {
 			word = make([]glyph, 0, 1)
 		}
 		wordwidth := 0
+		// the number of characters of the buffer in word
+		wordchars := 0
 
 		totalwidth := w.StartCol - nColsBeforeStart
 
+		// virtual text before the start of the view isn't drawn
+		vtexts := b.VirtualTextOnLine(bloc.Y)
+		for len(vtexts) > 0 && vtexts[0].Loc.X < bloc.X {
+			vtexts = vtexts[1:]
+		}
+		var eolTexts []*buffer.VirtualText
+
 		for len(line) > 0 {
 			r, combc, size := util.DecodeCharacter(line)
 			line = line[size:]
 
-			loc := buffer.Loc{X: bloc.X + len(word), Y: bloc.Y}
+			loc := buffer.Loc{X: bloc.X + wordchars, Y: bloc.Y}
 			curStyle, _ = w.getStyle(curStyle, loc)
 
+			for len(vtexts) > 0 && vtexts[0].Loc.X <= loc.X {
+				if vt := vtexts[0]; vt.Pos == buffer.VTInline {
+					style := virtualTextStyle(vt.Style)
+					for _, vr := range vt.Text {
+						vw := runewidth.RuneWidth(vr)
+						word = append(word, glyph{vr, nil, style, vw, true})
+						wordwidth += vw
+					}
+				} else {
+					eolTexts = append(eolTexts, vt)
+				}
+				vtexts = vtexts[1:]
+			}
+
 			width := 0
 
 			switch r {
@@ -804,18 +1106,14 @@ func (w *BufWindow) displayBuffer() {
 				width = util.Min(ts, maxWidth-vloc.X)
 				totalwidth += ts
 
-			case ' ':
-				width = runewidth.RuneWidth(r)
-				totalwidth += width
-
 			default:
-				whiteSpace = false
 				width = runewidth.RuneWidth(r)
 				totalwidth += width
 			}
 
-			word = append(word, glyph{r, combc, curStyle, width})
+			word = append(word, glyph{r, combc, curStyle, width, false})
 			wordwidth += width
+			wordchars++
 
 			// Collect a complete word to know its width.
 			// If wordwrap is off, every single character is a complete "word".
@@ -825,12 +1123,10 @@ func (w *BufWindow) displayBuffer() {
 				}
 			}
 
-			tabstart := whiteSpace && (vloc.X + 1) % tabsize == 0
 			// If a word (or just a wide rune) does not fit in the window
 			if vloc.X+wordwidth > maxWidth && vloc.X > w.gutterOffset {
 				for vloc.X < maxWidth {
-					tabstart = whiteSpace && (vloc.X - w.gutterOffset) % tabsize == 0
-					draw(' ', nil, config.DefStyle, false, false, tabstart, false)
+					draw(' ', nil, config.DefStyle, false, false, false)
 				}
 
 				// We either stop or we wrap to draw the word in the next line
@@ -846,8 +1142,13 @@ func (w *BufWindow) displayBuffer() {
 			}
 
 			for _, r := range word {
-				tabstart = whiteSpace && (vloc.X - w.gutterOffset) % tabsize == 0
-				draw(r.r, r.combc, r.style, true, true, tabstart, true)
+				if r.virtual {
+					for i := 0; i < r.width; i++ {
+						if vloc.X < maxWidth { draw(r.r, nil, r.style, false, false, false) }
+					}
+					continue
+				}
+				draw(r.r, r.combc, r.style, true, true, true)
 
 				// Draw any extra characters either tabs or @ for incomplete wide runes
 				if r.width > 1 {
@@ -857,15 +1158,17 @@ func (w *BufWindow) displayBuffer() {
 					}
 
 					for i := 1; i < r.width; i++ {
-						tabstart = whiteSpace && (vloc.X - w.gutterOffset) % tabsize == 0
-						draw(char, nil, r.style, true, false, tabstart, false)
+						draw(char, nil, r.style, true, false, false)
 					}
 				}
-				bloc.X++
+				if !util.IsMark(r.r) {
+					bloc.X++
+				}
 			}
 
 			word = word[:0]
 			wordwidth = 0
+			wordchars = 0
 
 			// If we reach the end of the window then we either stop or we wrap for softwrap
 			if vloc.X >= maxWidth {
@@ -881,6 +1184,21 @@ func (w *BufWindow) displayBuffer() {
 			}
 		}
 
+		// the rest of the virtual text is at the end of the line, inline text
+		// is drawn before the newline, and end of line text after it
+		visibleRow := vloc.Y >= 0 && vloc.Y < w.bufHeight
+		for _, vt := range vtexts {
+			if vt.Pos != buffer.VTInline {
+				eolTexts = append(eolTexts, vt)
+				continue
+			}
+			style := virtualTextStyle(vt.Style)
+			for _, vr := range vt.Text {
+				if !visibleRow || vloc.X+runewidth.RuneWidth(vr) > maxWidth { break }
+				draw(vr, nil, style, false, false, false)
+			}
+		}
+
 		style := config.DefStyle
 		for _, c := range cursors {
 			if cursorline && w.active &&
@@ -906,7 +1224,21 @@ func (w *BufWindow) displayBuffer() {
 
 		if vloc.X != maxWidth {
 			// Display newline within a selection
-			draw('\n', nil, config.DefStyle, true, true, false, false)
+			draw('\n', nil, config.DefStyle, true, true, false)
+
+			// end of line text is cut off at the end of the row rather than
+			// wrapped
+			for _, vt := range eolTexts {
+				if !visibleRow { break }
+				style := virtualTextStyle(vt.Style)
+				x := vloc.X
+				for _, vr := range vt.Text {
+					if x+runewidth.RuneWidth(vr) > maxWidth { break }
+					screen.SetContent(w.X+x, w.Y+vloc.Y, vr, nil, style)
+					x += runewidth.RuneWidth(vr)
+				}
+				vloc.X = x + 1
+			}
 		}
 
 		bloc.X = w.StartCol
@@ -915,56 +1247,98 @@ func (w *BufWindow) displayBuffer() {
 			break
 		}
 	}
-}
 
-func (w *BufWindow) displayStatusLine() {
-	if w.Buf.Settings["statusline"].(bool) {
-		w.sline.Display()
-	} else if w.drawDivider {
-		divchars := config.GetGlobalOption("divchars").(string)
-		if util.CharacterCountInString(divchars) != 2 {
-			divchars = "|-"
-		}
-
-		_, _, size := util.DecodeCharacterInString(divchars)
-		divchar, combc, _ := util.DecodeCharacterInString(divchars[size:])
+	if b.Settings["matchbraceindicator"].(bool) {
+		w.displayBraceIndicator(matchingBraces)
+	}
+}
 
-		dividerStyle := config.DefStyle
-		if style, ok := config.Colorscheme["divider"]; ok {
-			dividerStyle = style
-		}
+// displayBraceIndicator draws an arrow pointing to the matching braces that
+// are scrolled out of the window, on its first or last row. It is drawn in
+// the gutter, or in the last column if there is no gutter
+func (w *BufWindow) displayBraceIndicator(matchingBraces []buffer.Loc) {
+	style := config.DefStyle.Bold(true)
+	if s, ok := config.Colorscheme["match-brace"]; ok {
+		style = s
+	}
+	x := w.X
+	if w.gutterOffset == 0 {
+		x = w.X + w.bufWidth - 1
+	}
 
-		divreverse := config.GetGlobalOption("divreverse").(bool)
-		if divreverse {
-			dividerStyle = dividerStyle.Reverse(true)
+	for _, mb := range matchingBraces {
+		d := w.Diff(w.StartLine, w.SLocFromLoc(mb))
+		if d < 0 {
+			screen.SetContent(x, w.Y, '▲', nil, style)
+		} else if d >= w.bufHeight {
+			screen.SetContent(x, w.Y+w.bufHeight-1, '▼', nil, style)
 		}
+	}
+}
 
+func (w *BufWindow) displayStatusLine() {
+	if w.Buf.Settings["statusline"].(bool) {
+		w.sline.Display()
+	} else if w.drawDivider&divBottom != 0 {
+		divchar, combc := dividerChar(false)
+		style := dividerStyle()
 		for x := w.X; x < w.X+w.Width; x++ {
-			screen.SetContent(x, w.Y+w.Height-1, divchar, combc, dividerStyle)
+			screen.SetContent(x, w.Y+w.Height-1, divchar, combc, style)
 		}
 	}
 }
 
+// displayDivider draws the divider column between this window and the
+// window to its right
+func (w *BufWindow) displayDivider() {
+	if w.drawDivider&divRight != 0 {
+		displayRightDivider(w.X, w.Y, w.Width, w.Height)
+	}
+}
+
 func (w *BufWindow) displayScrollBar() {
-	if w.Buf.Settings["scrollbar"].(bool) && w.Buf.LinesNum() > w.Height {
+	if w.Buf.Settings["scrollbar"].(bool) && w.VisualLineCount() > w.bufHeight {
 		scrollX := w.X + w.Width - 1
-		barsize := int(float64(w.Height) / float64(w.Buf.LinesNum()) * float64(w.Height))
-		if barsize < 1 {
-			barsize = 1
-		}
-		barstart := w.Y + int(float64(w.StartLine.Line)/float64(w.Buf.LinesNum())*float64(w.Height))
+		barstart, barsize := w.scrollBarThumb()
 
 		scrollBarStyle := config.DefStyle.Reverse(true)
 		if style, ok := config.Colorscheme["scrollbar"]; ok {
 			scrollBarStyle = style
 		}
 
-		for y := barstart; y < util.Min(barstart+barsize, w.Y+w.bufHeight); y++ {
+		for y := w.Y + barstart; y < w.Y + barstart + barsize; y++ {
 			screen.SetContent(scrollX, y, '|', nil, scrollBarStyle)
 		}
 	}
 }
 
+// scrollBarThumb returns the first row of the scroll bar thumb, relative to
+// the top of the window, and its height. The thumb always fits in the buffer
+// area, and reaches its last row when the last line of the buffer is visible
+func (w *BufWindow) scrollBarThumb() (int, int) {
+	height := w.bufHeight
+	lines := w.VisualLineCount()
+	if height <= 0 || lines <= height { return 0, height }
+
+	barsize := util.Clamp(int(float64(height) / float64(lines) * float64(height)), 1, height)
+
+	// the top row goes from 0 to lines-height while the thumb goes from 0 to
+	// height-barsize
+	scrolled := float64(w.VisualRow(w.StartLine)) / float64(lines-height)
+	barstart := int(math.Round(scrolled * float64(height-barsize)))
+	return util.Clamp(barstart, 0, height-barsize), barsize
+}
+
+// kindStyle returns the style of a completion.<kind> group, or of the
+// completion group if the colorscheme doesn't have the subgroup
+func kindStyle(group string) (tcell.Style, bool) {
+	if style, ok := config.Colorscheme[group]; ok {
+		return style, true
+	}
+	style, ok := config.Colorscheme["completion"]
+	return style, ok
+}
+
 func (w *BufWindow) displayCompleteBox() {
 	if !w.Buf.HasSuggestions || w.Buf.NumCursors() > 1 {
 		return
@@ -984,7 +1358,7 @@ func (w *BufWindow) displayCompleteBox() {
 				detailw = charcount
 			}
 		}
-		charcount = util.CharacterCountInString(comp.Kind)
+		charcount = util.CharacterCountInString(buffer.KindIcon(comp.Kind))
 		if charcount > kindw {
 			kindw = charcount
 		}
@@ -992,6 +1366,19 @@ func (w *BufWindow) displayCompleteBox() {
 	labelw++
 	kindw++
 
+	// the box is at most as wide as the text area. Long details, such as
+	// full signatures, are shrunk first, then the labels
+	maxw := w.bufWidth
+	if labelw+kindw+detailw > maxw {
+		detailw = util.Max(maxw-labelw-kindw, 0)
+		labelw = util.Max(util.Min(labelw, maxw-kindw), 0)
+		kindw = util.Min(kindw, maxw-labelw)
+	}
+
+	// move the box left if it would go past the right edge of the window
+	right := w.X + w.gutterOffset + w.bufWidth
+	boxX := util.Max(util.Min(w.completeBox.X, right-labelw-kindw-detailw), w.X)
+
 	defstyle := config.DefStyle.Reverse(true)
 	curstyle := config.DefStyle
 	if style, ok:= config.Colorscheme["statusline"]; ok {
@@ -999,28 +1386,42 @@ func (w *BufWindow) displayCompleteBox() {
 		curstyle = style.Reverse(true)
 	}
 
-	display := func(s string, width, x, y int, cur bool) {
-		for j := 0; j < width; j++ {
+	// display draws s in a column of the given width, ending it with an
+	// ellipsis if it doesn't fit. Nothing is drawn past the window's edge
+	display := func(s string, width, x, y int, cur bool, group string) {
+		overflow := util.CharacterCountInString(s) > width
+		for j := 0; j < width && boxX+x+j < right; j++ {
 			r := ' '
 			var combc []rune
 			var size int
-			if len(s) > 0 {
+			if overflow && j == width-1 {
+				r = '…'
+			} else if len(s) > 0 {
 				r, combc, size = util.DecodeCharacterInString(s)
 				s = s[size:]
 			}
 			st := defstyle
-			if cur { st = curstyle }
-			screen.SetContent(w.completeBox.X+x+j, w.completeBox.Y+y, r, combc, st)
+			if cur {
+				st = curstyle
+			} else if group != "" {
+				if style, ok := kindStyle(group); ok {
+					fg, _, _ := style.Decompose()
+					st = st.Foreground(fg)
+				}
+			}
+			screen.SetContent(boxX+x+j, w.completeBox.Y+y, r, combc, st)
 		}
 	}
 
 	for i, comp := range w.Buf.Completions {
 		if w.completeBox.Y+i+1 > w.bufHeight { break }
 		cur := i == w.Buf.CurCompletion
-		display(comp.Label+" ", labelw, 0, i+1, cur)
-		display(comp.Kind+" ", kindw, labelw, i+1, cur)
+		display(comp.Label+" ", labelw, 0, i+1, cur, "")
+		// the kind is colored with the completion.<kind> group, if the
+		// colorscheme has it
+		display(buffer.KindIcon(comp.Kind)+" ", kindw, labelw, i+1, cur, comp.KindGroup())
 		if comp.Detail != comp.Kind {
-			display(comp.Detail, detailw, labelw+kindw, i+1, cur)
+			display(comp.Detail, detailw, labelw+kindw, i+1, cur, "")
 		}
 	}
 }
@@ -1091,6 +1492,7 @@ func WrapString(text string, width int) []string {
 func (w *BufWindow) Display() {
 	w.updateDisplayInfo()
 	w.displayStatusLine()
+	w.displayDivider()
 	w.displayScrollBar()
 	w.displayBuffer()
 	w.displayCompleteBox()
@@ -1116,6 +1518,17 @@ func (w *BufWindow) LocToVisual(X, Y int) buffer.Loc {
 	return l
 }
 
+// LocVisible returns true if the buffer location is shown in the visible
+// part of the window, which excludes the gutter and the status line
+func (w *BufWindow) LocVisible(X, Y int) bool {
+	if Y < 0 || Y >= w.Buf.LinesNum() {
+		return false
+	}
+	l := w.LocToVisual(X, Y)
+	v := w.BufView()
+	return l.X >= v.X && l.X < v.X+v.Width && l.Y >= v.Y && l.Y < v.Y+v.Height
+}
+
 func (w *BufWindow) CursorVisual() buffer.Loc {
 	return w.cursorVisual
 }
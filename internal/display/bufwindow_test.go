@@ -0,0 +1,609 @@
+package display
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	ulua "github.com/zyedidia/micro/v2/internal/lua"
+	"github.com/zyedidia/micro/v2/internal/lsp"
+	"github.com/zyedidia/micro/v2/internal/screen"
+	"github.com/zyedidia/tcell/v2"
+	lspt "go.lsp.dev/protocol"
+)
+
+func init() {
+	ulua.L = lua.NewState()
+	config.InitGlobalSettings()
+	config.GlobalSettings["backup"] = false
+}
+
+// screenRow returns the first w cells of the row y of the screen
+func screenRow(y, w int) string {
+	var sb strings.Builder
+	for x := 0; x < w; x++ {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func TestGetStartInfoCombining(t *testing.T) {
+	// "e" followed by a combining acute accent is a single character
+	b := buffer.NewBufferFromString("xe\u0301yz", "", buffer.BTDefault)
+	w := NewBufWindow(0, 0, 80, 24, b)
+
+	line, offset, x, _ := w.getStartInfo(1, 0)
+	assert.Equal(t, []byte("e\u0301yz"), line)
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, 1, x)
+
+	line, offset, x, _ = w.getStartInfo(2, 0)
+	assert.Equal(t, []byte("yz"), line)
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, 2, x)
+
+	line, offset, x, _ = w.getStartInfo(3, 0)
+	assert.Equal(t, []byte("z"), line)
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, 3, x)
+
+	// a combining mark without a base character does not count either
+	b = buffer.NewBufferFromString("\u0301yz", "", buffer.BTDefault)
+	w = NewBufWindow(0, 0, 80, 24, b)
+
+	line, _, x, _ = w.getStartInfo(1, 0)
+	assert.Equal(t, []byte("z"), line)
+	assert.Equal(t, 1, x)
+}
+
+func TestScrollPercent(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("line\n", 99), "", buffer.BTDefault)
+	w := NewBufWindow(0, 0, 80, 11, b)
+	w.bufHeight = 10
+
+	assert.Equal(t, "Top", scrollPercent(w))
+
+	w.StartLine.Line = 45
+	assert.Equal(t, "50%", scrollPercent(w))
+
+	w.StartLine.Line = 90
+	assert.Equal(t, "Bot", scrollPercent(w))
+
+	w.StartLine.Line = 0
+	w.bufHeight = 100
+	assert.Equal(t, "All", scrollPercent(w))
+}
+
+func TestRegisterStatusPlaceholder(t *testing.T) {
+	b := buffer.NewBufferFromString("", "", buffer.BTDefault)
+	w := NewBufWindow(0, 0, 80, 24, b)
+
+	RegisterStatusPlaceholder("test.name", func(b *buffer.Buffer) string {
+		return "placeholder"
+	})
+	defer delete(statusInfo, "test.name")
+
+	assert.Equal(t, []byte("placeholder"), w.sline.expand([]byte("$(test.name)")))
+	assert.Equal(t, []byte("1"), w.sline.expand([]byte("$(line)")))
+	assert.Equal(t, []byte{}, w.sline.expand([]byte("$(test.unknown)")))
+}
+
+func TestSignPriority(t *testing.T) {
+	b := buffer.NewBufferFromString("a\nb\nc\n", "", buffer.BTDefault)
+	b.Settings["diffgutter"] = true
+	b.SetDiffBase([]byte("a\nx\nc\n"))
+	w := NewBufWindow(0, 0, 80, 24, b)
+
+	diag := func(line uint32, severity lspt.DiagnosticSeverity) lsp.Diagnostic {
+		var d lsp.Diagnostic
+		d.Range.Start.Line = line
+		d.Severity = severity
+		return d
+	}
+	diags := []lsp.Diagnostic{
+		diag(0, lspt.DiagnosticSeverityHint),
+		diag(1, lspt.DiagnosticSeverityWarning),
+		diag(1, lspt.DiagnosticSeverityError),
+	}
+
+	sign := func(line int) rune {
+		r, _ := w.sign(diags, config.DefStyle, false, &buffer.Loc{X: 0, Y: line})
+		return r
+	}
+
+	// errors beat warnings and diff indicators
+	assert.Equal(t, 'E', sign(1))
+	// lesser diagnostics are shown when there is no diff indicator
+	assert.Equal(t, 'H', sign(0))
+	assert.Equal(t, ' ', sign(2))
+
+	diags = diags[:1]
+	assert.Equal(t, '▌', sign(1))
+}
+
+func TestLocVisible(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("line\n", 99), "", buffer.BTDefault)
+	w := NewBufWindow(0, 0, 80, 11, b)
+	w.bufHeight = 10
+	w.bufWidth = 80
+
+	assert.True(t, w.LocVisible(2, 9))
+	assert.False(t, w.LocVisible(2, 10))
+	assert.False(t, w.LocVisible(0, 200))
+
+	w.StartLine.Line = 45
+	assert.False(t, w.LocVisible(2, 44))
+	assert.True(t, w.LocVisible(2, 45))
+
+	w.StartCol = 3
+	assert.False(t, w.LocVisible(2, 45))
+}
+
+func TestBraceIndicator(t *testing.T) {
+	screen.InitSimScreen()
+
+	b := buffer.NewBufferFromString("{\n"+strings.Repeat("line\n", 30)+"}", "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	w := NewBufWindow(0, 0, 20, 6, b)
+	runeAt := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+
+	w.Display()
+	assert.Equal(t, ' ', runeAt(19, 4))
+
+	// the match is below the window, and there is no gutter
+	b.Settings["matchbraceindicator"] = true
+	w.Display()
+	assert.Equal(t, '▼', runeAt(19, 4))
+	assert.Equal(t, ' ', runeAt(19, 0))
+
+	b.Settings["ruler"] = true
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 31})
+	w.StartLine.Line = 27
+	w.Display()
+	assert.Equal(t, '▲', runeAt(0, 0))
+}
+
+func TestCenterCursor(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("line\n", 99), "", buffer.BTDefault)
+	b.Settings["scrollmargin"] = float64(3)
+	w := NewBufWindow(0, 0, 80, 11, b)
+	w.bufHeight = 10
+
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 50})
+	w.CenterCursor()
+	assert.Equal(t, 45, w.StartLine.Line)
+	w.TopCursor()
+	assert.Equal(t, 47, w.StartLine.Line)
+	w.BottomCursor()
+	assert.Equal(t, 44, w.StartLine.Line)
+
+	// the view doesn't scroll past the end or before the start
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 97})
+	w.TopCursor()
+	assert.Equal(t, 90, w.StartLine.Line)
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 1})
+	w.BottomCursor()
+	assert.Equal(t, 0, w.StartLine.Line)
+
+	// buffers shorter than the window always start at the first line
+	b = buffer.NewBufferFromString("a\nb\nc", "", buffer.BTDefault)
+	w = NewBufWindow(0, 0, 80, 11, b)
+	w.bufHeight = 10
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 2})
+	w.TopCursor()
+	assert.Equal(t, 0, w.StartLine.Line)
+	w.CenterCursor()
+	assert.Equal(t, 0, w.StartLine.Line)
+}
+
+func TestSmoothScroll(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("line\n", 99), "", buffer.BTDefault)
+	w := NewBufWindow(0, 0, 80, 11, b)
+	w.bufHeight = 10
+
+	w.StartLine.Line = 20
+	w.AnimateScroll(SLoc{0, 0})
+	assert.Equal(t, SLoc{20, 0}, w.drawStartLine())
+
+	b.Settings["smoothscroll"] = true
+	w.AnimateScroll(SLoc{0, 0})
+	var lines []int
+	for i := 0; i < 7; i++ {
+		lines = append(lines, w.drawStartLine().Line)
+	}
+	assert.Equal(t, []int{0, 10, 15, 17, 19, 19, 20}, lines)
+
+	// moving the view to the cursor stops the animation
+	w.AnimateScroll(SLoc{0, 0})
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 0, Y: 60})
+	assert.True(t, w.Relocate())
+	assert.Equal(t, w.StartLine, w.drawStartLine())
+}
+
+func TestScrollBarThumb(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("\n", 999999), "", buffer.BTDefault)
+	b.Settings["scrollbar"] = true
+	w := NewBufWindow(0, 0, 80, 24, b)
+	w.updateDisplayInfo()
+	assert.Equal(t, 1000000, b.LinesNum())
+	assert.Equal(t, 23, w.bufHeight)
+
+	start, size := w.scrollBarThumb()
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 1, size)
+
+	// the thumb reaches the last row of the buffer area at the end
+	w.StartLine.Line = b.LinesNum() - w.bufHeight
+	start, size = w.scrollBarThumb()
+	assert.Equal(t, w.bufHeight-1, start+size-1)
+
+	w.StartLine.Line = (b.LinesNum() - w.bufHeight) / 2
+	start, _ = w.scrollBarThumb()
+	assert.Equal(t, 11, start)
+
+	// the thumb never goes past the buffer area
+	w.StartLine.Line = b.LinesNum() - 1
+	start, size = w.scrollBarThumb()
+	assert.Equal(t, w.bufHeight-1, start+size-1)
+
+	b = buffer.NewBufferFromString(strings.Repeat("\n", 45), "", buffer.BTDefault)
+	w = NewBufWindow(0, 0, 80, 24, b)
+	w.updateDisplayInfo()
+	start, size = w.scrollBarThumb()
+	assert.Equal(t, 0, start)
+	assert.Equal(t, 11, size)
+	w.StartLine.Line = 23
+	start, size = w.scrollBarThumb()
+	assert.Equal(t, 12, start)
+}
+
+func TestRulerSide(t *testing.T) {
+	screen.InitSimScreen()
+
+	b := buffer.NewBufferFromString("abc\ndef", "", buffer.BTDefault)
+	b.Settings["diffgutter"] = true
+	w := NewBufWindow(0, 0, 20, 5, b)
+
+	w.Display()
+	assert.Equal(t, 3, w.gutterOffset)
+	assert.Equal(t, 17, w.bufWidth)
+	assert.Equal(t, " 1 abc", screenRow(0, 20)[:6])
+	assert.True(t, w.InGutter(2))
+	assert.False(t, w.InGutter(3))
+
+	b.Settings["rulerside"] = "right"
+	w.Display()
+	assert.Equal(t, 0, w.gutterOffset)
+	assert.Equal(t, 17, w.bufWidth)
+	assert.Equal(t, "abc", screenRow(0, 20)[:3])
+	assert.Equal(t, " 1 ", screenRow(0, 20)[17:])
+	assert.Equal(t, " 2 ", screenRow(1, 20)[17:])
+	assert.False(t, w.InGutter(16))
+	assert.True(t, w.InGutter(17))
+	assert.True(t, w.InGutter(19))
+	assert.Equal(t, buffer.Loc{X: 1, Y: 0}, w.LocFromVisual(buffer.Loc{X: 1, Y: 0}))
+}
+
+func TestVisualLineCount(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat("x", 25)+"\nshort\n"+strings.Repeat("y", 10), "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	b.Settings["softwrap"] = false
+	w := NewBufWindow(0, 0, 10, 6, b)
+	w.updateDisplayInfo()
+	assert.Equal(t, 3, w.VisualLineCount())
+
+	// a line as wide as the window takes an extra row for the cursor
+	b.Settings["softwrap"] = true
+	assert.Equal(t, 3+1+2, w.VisualLineCount())
+	assert.Equal(t, 3, w.VisualRow(SLoc{1, 0}))
+	assert.Equal(t, 5, w.VisualRow(SLoc{2, 1}))
+
+	// the count is updated after edits and resizes
+	b.Insert(buffer.Loc{X: 0, Y: 1}, strings.Repeat("z", 10))
+	assert.Equal(t, 7, w.VisualLineCount())
+	w.Resize(20, 6)
+	w.updateDisplayInfo()
+	assert.Equal(t, 4, w.VisualLineCount())
+}
+
+func TestVisualRowsCacheWidth(t *testing.T) {
+	b := buffer.NewBufferFromString(strings.Repeat(strings.Repeat("x", 15)+"\n", 10), "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	b.Settings["softwrap"] = true
+	b.Settings["scrollbar"] = true
+	w := NewBufWindow(0, 0, 10, 6, b)
+	w.updateDisplayInfo()
+	assert.Equal(t, 9, w.bufWidth)
+
+	// the rows are only computed at the width left by the scrollbar, so the
+	// cache stays valid across redraws
+	count := w.VisualLineCount()
+	rows := w.rows
+	w.updateDisplayInfo()
+	w.scrollBarThumb()
+	assert.Equal(t, count, w.VisualLineCount())
+	assert.Equal(t, w.bufWidth, w.rowsKey.width)
+	assert.True(t, &rows[0] == &w.rows[0])
+}
+
+func TestDividers(t *testing.T) {
+	screen.InitSimScreen()
+
+	b := buffer.NewBufferFromString("abc", "", buffer.BTDefault)
+	b.Settings["statusline"] = false
+	cell := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+
+	// a window at the top left, with windows below and to the right
+	w := NewBufWindow(0, 0, 40, 10, b)
+	w.Display()
+	assert.Equal(t, divBottom|divRight, w.drawDivider)
+	assert.Equal(t, 9, w.bufHeight)
+	assert.Equal(t, '-', cell(0, 9))
+	assert.Equal(t, '|', cell(40, 0))
+	assert.Equal(t, '|', cell(40, 9))
+
+	config.GlobalSettings["divchars"] = "│─"
+	defer func() { config.GlobalSettings["divchars"] = "|-" }()
+	w.Display()
+	assert.Equal(t, '─', cell(0, 9))
+	assert.Equal(t, '│', cell(40, 5))
+
+	// the rightmost window doesn't have a right divider
+	w = NewBufWindow(41, 0, 39, 10, b)
+	w.Display()
+	assert.Equal(t, divBottom, w.drawDivider)
+}
+
+func TestGutterOptions(t *testing.T) {
+	screen.InitSimScreen()
+
+	b := buffer.NewBufferFromString(strings.Repeat("x", 100)+"\n"+strings.Repeat("\n", 98), "", buffer.BTDefault)
+	b.Settings["softwrap"] = false
+	w := NewBufWindow(0, 0, 40, 10, b)
+	b.GetActiveCursor().GotoLoc(buffer.Loc{X: 100, Y: 0})
+
+	for _, tc := range []struct {
+		ruler, diffgutter, signcolumn bool
+		side                          string
+		offset, right                 int
+	}{
+		{false, false, false, "left", 0, 0},
+		{true, false, false, "left", 4, 0},
+		{false, true, false, "left", 1, 0},
+		{false, false, true, "left", 1, 0},
+		{false, true, true, "left", 1, 0},
+		{true, true, false, "left", 5, 0},
+		{true, true, true, "left", 5, 0},
+		{true, false, false, "right", 0, 4},
+		{false, true, false, "right", 0, 1},
+		{true, true, true, "right", 0, 5},
+	} {
+		b.Settings["ruler"] = tc.ruler
+		b.Settings["diffgutter"] = tc.diffgutter
+		b.Settings["signcolumn"] = tc.signcolumn
+		b.Settings["rulerside"] = tc.side
+		w.StartCol = 0
+
+		// Relocate must see the new gutter before the window is redrawn
+		w.Relocate()
+		assert.Equal(t, tc.offset, w.gutterOffset, tc)
+		assert.Equal(t, tc.right, w.rightGutter, tc)
+		assert.Equal(t, 40-tc.offset-tc.right, w.bufWidth, tc)
+		assert.Equal(t, 100-w.bufWidth+1, w.StartCol, tc)
+
+		// the cursor is on the last column of the text area
+		w.Display()
+		assert.Equal(t, tc.offset+w.bufWidth-1, w.CursorVisual().X, tc)
+	}
+
+	// another digit widens the line numbers
+	b.Settings["ruler"] = true
+	b.Settings["rulerside"] = "left"
+	b.Insert(b.End(), "\n")
+	w.Relocate()
+	assert.Equal(t, 5, w.gutterOffset)
+}
+
+func TestCompleteBoxWidth(t *testing.T) {
+	s, _ := screen.InitSimScreen()
+	s.SetSize(120, 24)
+
+	b := buffer.NewBufferFromString("fo", "", buffer.BTDefault)
+	b.Completions = []buffer.Completion{
+		{Edits: []buffer.Delta{{Start: buffer.Loc{X: 0, Y: 0}}}, Label: "foo", Kind: "fn", Detail: strings.Repeat("x", 300)},
+		{Edits: []buffer.Delta{{Start: buffer.Loc{X: 0, Y: 0}}}, Label: "fob", Kind: "fn", Detail: "short"},
+	}
+	b.HasSuggestions = true
+	b.CurCompletion = -1
+
+	// the window is followed by a divider column at x = 80
+	w := NewBufWindow(0, 0, 80, 10, b)
+	w.Display()
+	cell := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+	assert.Equal(t, 78, w.bufWidth)
+	assert.Equal(t, "foo fn xxx", screenRow(1, 80)[2:12])
+	assert.Equal(t, '…', cell(79, 1))
+	assert.Equal(t, 'x', cell(78, 1))
+	assert.Equal(t, "fob fn short ", screenRow(2, 80)[2:15])
+	assert.Equal(t, ' ', cell(79, 2))
+	assert.Equal(t, '|', cell(80, 1))
+	assert.Equal(t, '|', cell(80, 2))
+}
+
+func TestCompleteBoxKindStyle(t *testing.T) {
+	screen.InitSimScreen()
+
+	red := config.DefStyle.Foreground(tcell.ColorRed)
+	config.Colorscheme = map[string]tcell.Style{"completion.function": red}
+	defer func() { config.Colorscheme = nil }()
+
+	b := buffer.NewBufferFromString("f", "", buffer.BTDefault)
+	b.Completions = []buffer.Completion{
+		{Edits: []buffer.Delta{{Start: buffer.Loc{X: 0, Y: 0}}}, Label: "foo", Kind: "function", KindEnum: lspt.CompletionItemKindFunction},
+		{Edits: []buffer.Delta{{Start: buffer.Loc{X: 0, Y: 0}}}, Label: "for", Kind: "keyword", KindEnum: lspt.CompletionItemKindKeyword},
+	}
+	b.HasSuggestions = true
+	b.CurCompletion = -1
+	assert.Equal(t, "completion.function", b.Completions[0].KindGroup())
+
+	w := NewBufWindow(0, 0, 40, 10, b)
+	w.Display()
+	styleAt := func(x, y int) tcell.Style {
+		_, _, s, _ := screen.Screen.GetContent(x, y)
+		return s
+	}
+
+	// only the kind column of the function is colored
+	fg, _, _ := styleAt(6, 1).Decompose()
+	assert.Equal(t, tcell.ColorRed, fg)
+	fg, _, _ = styleAt(2, 1).Decompose()
+	assert.NotEqual(t, tcell.ColorRed, fg)
+	fg, _, _ = styleAt(6, 2).Decompose()
+	assert.NotEqual(t, tcell.ColorRed, fg)
+}
+
+func TestHighlightRanges(t *testing.T) {
+	screen.InitSimScreen()
+
+	sel := config.DefStyle.Foreground(tcell.ColorGreen)
+	ref := config.DefStyle.Foreground(tcell.ColorRed)
+	under := config.DefStyle.Foreground(tcell.ColorBlue)
+	config.Colorscheme = map[string]tcell.Style{"selection": sel, "ref": ref, "under": under}
+	defer func() { config.Colorscheme = nil }()
+
+	b := buffer.NewBufferFromString("abcdefgh", "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	b.SetHighlights("refs", []buffer.HighlightRange{
+		{Start: buffer.Loc{X: 0, Y: 0}, End: buffer.Loc{X: 4, Y: 0}, Style: "ref", Priority: buffer.HighlightSearch},
+	})
+	b.SetHighlights("under", []buffer.HighlightRange{
+		{Start: buffer.Loc{X: 3, Y: 0}, End: buffer.Loc{X: 6, Y: 0}, Style: "under", Priority: buffer.HighlightCursorLine},
+	})
+	c := b.GetActiveCursor()
+	c.SetSelectionStart(buffer.Loc{X: 0, Y: 0})
+	c.SetSelectionEnd(buffer.Loc{X: 2, Y: 0})
+
+	w := NewBufWindow(0, 0, 20, 5, b)
+	w.Display()
+	styleAt := func(x int) tcell.Style {
+		_, _, s, _ := screen.Screen.GetContent(x, 0)
+		return s
+	}
+
+	// the selection is drawn over the ranges below it, and background
+	// ranges show through the ranges that have no background
+	assert.Equal(t, sel, styleAt(0))
+	assert.Equal(t, ref, styleAt(2))
+	assert.Equal(t, ref.Background(tcell.ColorBlue), styleAt(3))
+	_, bg, _ := styleAt(6).Decompose()
+	assert.NotEqual(t, tcell.ColorBlue, bg)
+
+	b.ClearHighlights("refs")
+	w.Display()
+	assert.Equal(t, config.DefStyle, styleAt(2))
+}
+
+func TestVirtualText(t *testing.T) {
+	screen.InitSimScreen()
+
+	hint := config.DefStyle.Foreground(tcell.ColorGreen)
+	config.Colorscheme = map[string]tcell.Style{"hint": hint}
+	defer func() { config.Colorscheme = nil }()
+
+	b := buffer.NewBufferFromString("abcd\nefgh", "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	b.AddVirtualText(buffer.Loc{X: 2, Y: 0}, "xy", "hint", buffer.VTInline)
+	eol := b.AddVirtualText(buffer.Loc{X: 0, Y: 1}, "note", "hint", buffer.VTEndOfLine)
+
+	w := NewBufWindow(0, 0, 20, 5, b)
+	w.Display()
+	styleAt := func(x, y int) tcell.Style {
+		_, _, s, _ := screen.Screen.GetContent(x, y)
+		return s
+	}
+
+	// inline text is drawn before the character it is anchored to, and end
+	// of line text a space after the end of the line
+	assert.Equal(t, "abxycd      ", screenRow(0, 12))
+	assert.Equal(t, hint, styleAt(2, 0))
+	assert.Equal(t, "efgh note   ", screenRow(1, 12))
+	assert.Equal(t, hint, styleAt(5, 1))
+
+	b.RemoveVirtualText(eol)
+	w.Display()
+	assert.Equal(t, "efgh        ", screenRow(1, 12))
+
+	b.ClearVirtualText()
+	w.Display()
+	assert.Equal(t, "abcd        ", screenRow(0, 12))
+}
+
+func TestTabIndicator(t *testing.T) {
+	screen.InitSimScreen()
+
+	b := buffer.NewBufferFromString("\tx\ta", "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	w := NewBufWindow(0, 0, 20, 5, b)
+	// tabs are only marked at their first column, whether they are
+	// indentation or not
+	for mode, want := range map[string]string{
+		"fill":  "|   x|  a ",
+		"arrow": "→   x→  a ",
+		"none":  "    x   a ",
+	} {
+		b.Settings["tabindicator"] = mode
+		w.Display()
+		assert.Equal(t, want, screenRow(0, 10), mode)
+	}
+}
+
+func TestWhitespaceChars(t *testing.T) {
+	screen.InitSimScreen()
+
+	indent := config.DefStyle.Foreground(tcell.ColorGreen)
+	ws := config.DefStyle.Foreground(tcell.ColorRed)
+	config.Colorscheme = map[string]tcell.Style{"indent-char": indent, "whitespace-char": ws}
+	defer func() { config.Colorscheme = nil }()
+
+	b := buffer.NewBufferFromString("        x  y  ", "", buffer.BTDefault)
+	b.Settings["ruler"] = false
+	b.Settings["indentchar"] = "."
+	b.Settings["tabsize"] = float64(4)
+	w := NewBufWindow(0, 0, 20, 5, b)
+	styleAt := func(x int) tcell.Style {
+		_, _, s, _ := screen.Screen.GetContent(x, 0)
+		return s
+	}
+
+	w.Display()
+	assert.Equal(t, "........x..y..", screenRow(0, 14))
+	assert.Equal(t, indent, styleAt(0))
+	assert.Equal(t, ws, styleAt(9))
+
+	// with tabstospaces only indentation at tab stops is marked
+	b.Settings["tabstospaces"] = true
+	w.Display()
+	assert.Equal(t, ".   .   x  y  ", screenRow(0, 14))
+
+	// tab stops and indentation don't depend on the part of the line that
+	// is scrolled out of view
+	w.StartCol = 2
+	w.Display()
+	assert.Equal(t, "  .   x  y  ", screenRow(0, 12))
+	w.StartCol = 9
+	w.Display()
+	assert.Equal(t, ws, styleAt(0))
+}
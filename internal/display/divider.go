@@ -0,0 +1,66 @@
+package display
+
+import (
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/screen"
+	"github.com/zyedidia/micro/v2/internal/util"
+	"github.com/zyedidia/tcell/v2"
+)
+
+// dividerSide is a bitset of the edges of a window that are drawn as
+// dividers: the bottom edge when the statusline is off and another window
+// is below, and the column to the right when another window is beside it
+type dividerSide int
+
+const (
+	divBottom dividerSide = 1 << iota
+	divRight
+)
+
+// dividerChar returns the character of the divchars option used for
+// vertical dividers if vertical is true, and for horizontal ones otherwise
+func dividerChar(vertical bool) (rune, []rune) {
+	divchars := config.GetGlobalOption("divchars").(string)
+	if util.CharacterCountInString(divchars) != 2 {
+		divchars = "|-"
+	}
+
+	if !vertical {
+		_, _, size := util.DecodeCharacterInString(divchars)
+		divchars = divchars[size:]
+	}
+	divchar, combc, _ := util.DecodeCharacterInString(divchars)
+	return divchar, combc
+}
+
+func dividerStyle() tcell.Style {
+	dividerStyle := config.DefStyle
+	if style, ok := config.Colorscheme["divider"]; ok {
+		dividerStyle = style
+	}
+
+	divreverse := config.GetGlobalOption("divreverse").(bool)
+	if divreverse {
+		dividerStyle = dividerStyle.Reverse(true)
+	}
+	return dividerStyle
+}
+
+// hasRightNeighbor returns true if a window ending at the given column has
+// another window to its right, separated by a divider column
+func hasRightNeighbor(x, width int) bool {
+	if screen.Screen == nil {
+		return false
+	}
+	sw, _ := screen.Screen.Size()
+	return x+width < sw
+}
+
+// displayRightDivider draws the divider column to the right of a window
+func displayRightDivider(x, y, width, height int) {
+	divchar, combc := dividerChar(true)
+	style := dividerStyle()
+	for h := 0; h < height; h++ {
+		screen.SetContent(x+width, y+h, divchar, combc, style)
+	}
+}
@@ -64,6 +64,12 @@ func (i *InfoWindow) GetView() *View   { return i.View }
 func (i *InfoWindow) SetView(v *View)  {}
 func (i *InfoWindow) SetActive(b bool) {}
 func (i *InfoWindow) IsActive() bool   { return true }
+func (i *InfoWindow) CenterCursor()    {}
+func (i *InfoWindow) TopCursor()       {}
+func (i *InfoWindow) BottomCursor()    {}
+
+func (i *InfoWindow) AnimateScroll(from SLoc) {}
+func (i *InfoWindow) InGutter(x int) bool     { return false }
 
 func (i *InfoWindow) LocFromVisual(vloc buffer.Loc) buffer.Loc {
 	c := i.Buffer.GetActiveCursor()
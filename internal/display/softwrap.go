@@ -228,6 +228,77 @@ func (w *BufWindow) getRowCount(line int) int {
 	return w.getVLocFromLoc(eol).Row + 1
 }
 
+// visualRowsKey describes everything the number of rows of the buffer
+// depends on
+type visualRowsKey struct {
+	buf      *buffer.Buffer
+	edits    uint64
+	width    int
+	tabsize  int
+	wordwrap bool
+}
+
+// visualRows returns, for each line of the buffer, the number of rows
+// before it when softwrap is on. The last entry is the total number of rows.
+// The result is cached until the buffer, the width or the options change
+func (w *BufWindow) visualRows() []int {
+	key := visualRowsKey{
+		buf:      w.Buf,
+		edits:    w.Buf.Edits(),
+		width:    w.bufWidth,
+		tabsize:  util.IntOpt(w.Buf.Settings["tabsize"]),
+		wordwrap: w.Buf.Settings["wordwrap"].(bool),
+	}
+	if w.rows != nil && key == w.rowsKey {
+		return w.rows
+	}
+
+	lines := w.Buf.LinesNum()
+	rows := make([]int, lines+1)
+	for i := 0; i < lines; i++ {
+		rows[i+1] = rows[i] + w.getRowCount(i)
+	}
+	w.rows, w.rowsKey = rows, key
+	return rows
+}
+
+// overflows returns true if the buffer needs more rows than the window has
+// at the current width. It only measures the lines up to the first row that
+// doesn't fit, without the cache of visualRows, which is keyed on the width
+// the buffer is finally displayed at
+func (w *BufWindow) overflows() bool {
+	lines := w.Buf.LinesNum()
+	if lines > w.bufHeight || !w.Buf.Settings["softwrap"].(bool) {
+		return lines > w.bufHeight
+	}
+	rows := 0
+	for i := 0; i < lines && rows <= w.bufHeight; i++ {
+		rows += w.getRowCount(i)
+	}
+	return rows > w.bufHeight
+}
+
+// VisualLineCount returns the number of rows needed to display the whole
+// buffer at the current width, which is the number of lines unless softwrap
+// is on
+func (w *BufWindow) VisualLineCount() int {
+	if !w.Buf.Settings["softwrap"].(bool) {
+		return w.Buf.LinesNum()
+	}
+	rows := w.visualRows()
+	return rows[len(rows)-1]
+}
+
+// VisualRow returns the number of rows above s, counting from the start of
+// the buffer
+func (w *BufWindow) VisualRow(s SLoc) int {
+	if !w.Buf.Settings["softwrap"].(bool) {
+		return s.Line
+	}
+	rows := w.visualRows()
+	return rows[util.Clamp(s.Line, 0, len(rows)-1)] + s.Row
+}
+
 func (w *BufWindow) scrollUp(s SLoc, n int) SLoc {
 	for n > 0 {
 		if n <= s.Row {
@@ -53,6 +53,69 @@ var statusInfo = map[string]func(*buffer.Buffer) string{
 	"percentage": func(b *buffer.Buffer) string {
 		return strconv.Itoa((b.GetActiveCursor().Y + 1) * 100 / b.LinesNum())
 	},
+	"words": func(b *buffer.Buffer) string {
+		_, words, _ := b.Stats(false)
+		return strconv.Itoa(words)
+	},
+	"chars": func(b *buffer.Buffer) string {
+		_, _, chars := b.Stats(false)
+		return strconv.Itoa(chars)
+	},
+	"selwords": func(b *buffer.Buffer) string {
+		_, words, _ := b.Stats(true)
+		return strconv.Itoa(words)
+	},
+	"selchars": func(b *buffer.Buffer) string {
+		_, _, chars := b.Stats(true)
+		return strconv.Itoa(chars)
+	},
+	"diff": func(b *buffer.Buffer) string {
+		added, modified, deleted := b.DiffSummary()
+		var parts []string
+		if added > 0 {
+			parts = append(parts, "+"+strconv.Itoa(added))
+		}
+		if modified > 0 {
+			parts = append(parts, "~"+strconv.Itoa(modified))
+		}
+		if deleted > 0 {
+			parts = append(parts, "-"+strconv.Itoa(deleted))
+		}
+		return strings.Join(parts, " ")
+	},
+	"lsp": func(b *buffer.Buffer) string {
+		return strings.Join(b.ServerStatuses(), ", ")
+	},
+}
+
+// statusWindowInfo holds the directives that depend on the window showing
+// the buffer rather than on the buffer alone
+var statusWindowInfo = map[string]func(*BufWindow) string{
+	"percent": scrollPercent,
+}
+
+// scrollPercent returns the position of the view in the buffer: "All" if
+// the whole buffer is visible, "Top" or "Bot" if its first or last line is,
+// and otherwise the percentage of lines above the view, like vim's ruler
+func scrollPercent(w *BufWindow) string {
+	last := w.VisualLineCount() - 1
+	top := w.VisualRow(w.StartLine)
+	bottom := util.Min(top+w.bufHeight-1, last)
+
+	if top <= 0 && bottom >= last {
+		return "All"
+	} else if top <= 0 {
+		return "Top"
+	} else if bottom >= last {
+		return "Bot"
+	}
+	return strconv.Itoa(top*100/(top+last-bottom)) + "%"
+}
+
+// RegisterStatusPlaceholder makes $(name) in the statusline format options
+// expand to the result of fn for the buffer shown in the window
+func RegisterStatusPlaceholder(name string, fn func(b *buffer.Buffer) string) {
+	statusInfo[name] = fn
 }
 
 func SetStatusInfoFnLua(fn string) {
@@ -65,7 +128,7 @@ func SetStatusInfoFnLua(fn string) {
 	if pl == nil {
 		return
 	}
-	statusInfo[fn] = func(b *buffer.Buffer) string {
+	RegisterStatusPlaceholder(fn, func(b *buffer.Buffer) string {
 		if pl == nil || !pl.IsEnabled() {
 			return ""
 		}
@@ -79,7 +142,7 @@ func SetStatusInfoFnLua(fn string) {
 			}
 		}
 		return ""
-	}
+	})
 }
 
 // NewStatusLine returns a statusline bound to a window
@@ -99,6 +162,32 @@ func (s *StatusLine) FindOpt(opt string) interface{} {
 
 var formatParser = regexp.MustCompile(`\$\(.+?\)`)
 
+// expand returns the text a $(name) directive of the statusline format
+// options is replaced with. Unknown directives expand to nothing
+func (s *StatusLine) expand(match []byte) []byte {
+	name := match[2 : len(match)-1]
+	if bytes.HasPrefix(name, []byte("opt:")) {
+		option := name[4:]
+		return []byte(fmt.Sprint(s.FindOpt(string(option))))
+	} else if bytes.HasPrefix(name, []byte("bind:")) {
+		binding := string(name[5:])
+		for k, v := range config.Bindings["buffer"] {
+			if v == binding {
+				return []byte(k)
+			}
+		}
+		return []byte("null")
+	}
+
+	if fn, ok := statusWindowInfo[string(name)]; ok {
+		return []byte(fn(s.win))
+	}
+	if fn, ok := statusInfo[string(name)]; ok {
+		return []byte(fn(s.win.Buf))
+	}
+	return []byte{}
+}
+
 // Display draws the statusline to the screen
 func (s *StatusLine) Display() {
 	// We'll draw the line at the lowest line in the window
@@ -106,31 +195,10 @@ func (s *StatusLine) Display() {
 
 	winX := s.win.X
 
-	formatter := func(match []byte) []byte {
-		name := match[2 : len(match)-1]
-		if bytes.HasPrefix(name, []byte("opt")) {
-			option := name[4:]
-			return []byte(fmt.Sprint(s.FindOpt(string(option))))
-		} else if bytes.HasPrefix(name, []byte("bind")) {
-			binding := string(name[5:])
-			for k, v := range config.Bindings["buffer"] {
-				if v == binding {
-					return []byte(k)
-				}
-			}
-			return []byte("null")
-		} else {
-			if fn, ok := statusInfo[string(name)]; ok {
-				return []byte(fn(s.win.Buf))
-			}
-			return []byte{}
-		}
-	}
-
 	leftText := []byte(s.win.Buf.Settings["statusformatl"].(string))
-	leftText = formatParser.ReplaceAllFunc(leftText, formatter)
+	leftText = formatParser.ReplaceAllFunc(leftText, s.expand)
 	rightText := []byte(s.win.Buf.Settings["statusformatr"].(string))
-	rightText = formatParser.ReplaceAllFunc(rightText, formatter)
+	rightText = formatParser.ReplaceAllFunc(rightText, s.expand)
 
 	statusLineStyle := config.DefStyle.Reverse(true)
 	if style, ok := config.Colorscheme["statusline"]; ok {
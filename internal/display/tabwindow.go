@@ -1,6 +1,8 @@
 package display
 
 import (
+	"strconv"
+
 	"github.com/zyedidia/tcell/v2"
 	runewidth "github.com/mattn/go-runewidth"
 	"github.com/zyedidia/micro/v2/internal/buffer"
@@ -9,8 +11,15 @@ import (
 	"github.com/zyedidia/micro/v2/internal/util"
 )
 
+// pinGlyph is drawn before the names of pinned tabs
+const pinGlyph = '•'
+
 type TabWindow struct {
 	Names   []string
+	// Pinned tabs are drawn at the left of the tab bar, in front of the
+	// other tabs, and don't scroll. It is parallel to Names, and may be
+	// shorter if the last tabs aren't pinned
+	Pinned  []bool
 	active  int
 	Y       int
 	Width   int
@@ -28,19 +37,71 @@ func (w *TabWindow) Resize(width, height int) {
 	w.Width = width
 }
 
-func (w *TabWindow) LocFromVisual(vloc buffer.Loc) int {
-	x := -w.hscroll
+// label returns the text shown for the tab with the given index, which is
+// prefixed with its number if the tabnumbers option is on
+func (w *TabWindow) label(i int) string {
+	switch config.GetGlobalOption("tabnumbers") {
+	case "all":
+	case "on":
+		if i >= 9 { return w.Names[i] }
+	default:
+		return w.Names[i]
+	}
+	return strconv.Itoa(i+1) + ":" + w.Names[i]
+}
+
+// IsPinned returns true if the tab with the given index is pinned
+func (w *TabWindow) IsPinned(i int) bool {
+	return i < len(w.Pinned) && w.Pinned[i]
+}
+
+// pinnedSize returns the width of a pinned tab, including the separator
+func pinnedSize(n string) int {
+	return runewidth.StringWidth(n) + runewidth.RuneWidth(pinGlyph) + 3
+}
 
+// PinnedWidth returns the width of the zone at the left of the tab bar
+// showing the pinned tabs, which is where the scrolling tabs start
+func (w *TabWindow) PinnedWidth() int {
+	width := 0
+	for i := range w.Names {
+		if w.IsPinned(i) { width += pinnedSize(w.label(i)) }
+	}
+	return util.Min(width, w.TabsWidth())
+}
+
+// scrollWidth returns the width of the zone showing the tabs that aren't
+// pinned
+func (w *TabWindow) scrollWidth() int {
+	return w.TabsWidth() - w.PinnedWidth()
+}
+
+func (w *TabWindow) LocFromVisual(vloc buffer.Loc) int {
 	if vloc.Y != w.Y {
 		return -1
 	}
 
-	for i, n := range w.Names {
+	pinned := w.PinnedWidth()
+	if vloc.X < pinned {
+		x := 0
+		for i := range w.Names {
+			if !w.IsPinned(i) { continue }
+			x += pinnedSize(w.label(i))
+			if vloc.X < x-1 { return i }
+			if vloc.X < x { return -1 }
+		}
+		return -1
+	}
+
+	x := -w.hscroll
+	for i := range w.Names {
+		if w.IsPinned(i) { continue }
+		n := w.label(i)
 		s := util.CharacterCountInString(n)
 		x += s+2
-		if vloc.X < x { return i }
+		if vloc.X-pinned < x { return i }
 		x++
-		if x >= w.Width {
+		if x >= w.scrollWidth() {
 			break
 		}
 	}
@@ -50,21 +111,42 @@ func (w *TabWindow) LocFromVisual(vloc buffer.Loc) int {
 func (w *TabWindow) Scroll(amt int) {
 	w.hscroll += amt
 	s := w.TotalSize()
-	w.hscroll = util.Clamp(w.hscroll, 0, s-w.Width+4)
+	w.hscroll = util.Clamp(w.hscroll, 0, s-w.scrollWidth()+4)
 
-	if s-w.Width <= 0 {
+	if s-w.scrollWidth() <= 0 {
 		w.hscroll = 0
 	}
 }
 
+// TotalSize returns the size of the tabs that aren't pinned, which are the
+// ones that scroll
 func (w *TabWindow) TotalSize() int {
 	sum := 2
-	for _, n := range w.Names {
+	for i := range w.Names {
+		if w.IsPinned(i) { continue }
+		n := w.label(i)
 		sum += runewidth.StringWidth(n) + 3
 	}
 	return sum - 5
 }
 
+// Overflows returns true if the tabs don't fit in the tab bar, in which case
+// a button listing all of them is shown at its right end
+func (w *TabWindow) Overflows() bool {
+	pinned := 0
+	for i := range w.Names {
+		if w.IsPinned(i) { pinned += pinnedSize(w.label(i)) }
+	}
+	return pinned+w.TotalSize() > w.Width
+}
+
+// TabsWidth returns the width of the part of the tab bar showing the tabs,
+// which excludes the button listing them
+func (w *TabWindow) TabsWidth() int {
+	if w.Overflows() { return w.Width - 1 }
+	return w.Width
+}
+
 func (w *TabWindow) Active() int {
 	return w.active
 }
@@ -73,29 +155,29 @@ func (w *TabWindow) SetActive(a int) {
 	w.active = a
 	x := 2
 	s := w.TotalSize()
+	width := w.scrollWidth()
 
-	for i, n := range w.Names {
+	for i := range w.Names {
+		if w.IsPinned(i) { continue }
+		n := w.label(i)
 		c := util.CharacterCountInString(n)
 		if i == a {
-			if x+c >= w.hscroll+w.Width {
-				w.hscroll = util.Clamp(x+c+1-w.Width, 0, s-w.Width+4)
+			if x+c >= w.hscroll+width {
+				w.hscroll = util.Clamp(x+c+1-width, 0, s-width+4)
 			} else if x < w.hscroll {
-				w.hscroll = util.Clamp(x-4, 0, s-w.Width+4)
+				w.hscroll = util.Clamp(x-4, 0, s-width+4)
 			}
 			break
 		}
 		x += c + 4
 	}
 
-	if s-w.Width <= 0 {
+	if s-width <= 0 {
 		w.hscroll = 0
 	}
 }
 
 func (w *TabWindow) Display() {
-	x := -w.hscroll
-	done := false
-
 	tabBarStyle := config.DefStyle.Reverse(true)
 	if style, ok := config.Colorscheme["tabbar"]; ok {
 		tabBarStyle = style
@@ -108,6 +190,34 @@ func (w *TabWindow) Display() {
 	if style, ok := config.Colorscheme["tabbar.inactive"]; ok {
 		tabBarInactiveStyle = style
 	}
+	tabStyle := func(i int) tcell.Style {
+		if i == w.active { return tabBarActiveStyle }
+		return tabBarInactiveStyle
+	}
+
+	// the pinned tabs are drawn first, and are cut off at the end of the
+	// tab bar if they don't fit
+	pinned := w.PinnedWidth()
+	x := 0
+	put := func(r rune, style tcell.Style) {
+		if x < pinned { screen.SetContent(x, w.Y, r, nil, style) }
+		x += runewidth.RuneWidth(r)
+	}
+	for i := range w.Names {
+		if !w.IsPinned(i) { continue }
+		n := w.label(i)
+		put(' ', tabStyle(i))
+		put(pinGlyph, tabStyle(i))
+		for _, c := range n {
+			put(c, tabStyle(i))
+		}
+		put(' ', tabStyle(i))
+		put(' ', tabBarStyle)
+	}
+
+	x = -w.hscroll
+	done := false
+	width := w.scrollWidth()
 
 	draw := func(r rune, n int, style tcell.Style) {
 		for i := 0; i < n; i++ {
@@ -117,47 +227,46 @@ func (w *TabWindow) Display() {
 				if j > 0 {
 					c = ' '
 				}
-				if x == w.Width-2 && !done {
-					screen.SetContent(w.Width-2, w.Y, ' ', nil, tabBarStyle)
-					screen.SetContent(w.Width-1, w.Y, '⮞', nil, tabBarInactiveStyle)
+				if x == width-2 && !done {
+					screen.SetContent(pinned+width-2, w.Y, ' ', nil, tabBarStyle)
+					screen.SetContent(pinned+width-1, w.Y, '⮞', nil, tabBarInactiveStyle)
 					x += 2
 					break
 				} else if x == 0 && w.hscroll > 0 {
-					screen.SetContent(1, w.Y, ' ', nil, tabBarStyle)
-					screen.SetContent(0, w.Y, '⮜', nil, tabBarInactiveStyle)
+					screen.SetContent(pinned+1, w.Y, ' ', nil, tabBarStyle)
+					screen.SetContent(pinned, w.Y, '⮜', nil, tabBarInactiveStyle)
 					x++
-				} else if x >= 0 && x < w.Width {
-					screen.SetContent(x, w.Y, c, nil, style)
+				} else if x >= 0 && x < width {
+					screen.SetContent(pinned+x, w.Y, c, nil, style)
 				}
 				x++
 			}
 		}
 	}
 
-	for i, n := range w.Names {
-		if i == w.active {
-			draw(' ', 1, tabBarActiveStyle)
-			for _, c := range n {
-				draw(c, 1, tabBarActiveStyle)
-			}
-			if i == len(w.Names)-1 { done = true }
-			draw(' ', 1, tabBarActiveStyle)
-			draw(' ', 1, tabBarStyle)
-		} else {
-			draw(' ', 1, tabBarInactiveStyle)
-			for _, c := range n {
-				draw(c, 1, tabBarInactiveStyle)
-			}
-			if i == len(w.Names)-1 { done = true }
-			draw(' ', 1, tabBarInactiveStyle)
-			if !done { draw(' ', 1, tabBarStyle) }
+	last := -1
+	for i := range w.Names {
+		if !w.IsPinned(i) { last = i }
+	}
+	for i := range w.Names {
+		if w.IsPinned(i) { continue }
+		n := w.label(i)
+		draw(' ', 1, tabStyle(i))
+		for _, c := range n {
+			draw(c, 1, tabStyle(i))
 		}
-		if x >= w.Width {
+		if i == last { done = true }
+		draw(' ', 1, tabStyle(i))
+		if i == w.active || !done { draw(' ', 1, tabBarStyle) }
+		if x >= width {
 			break
 		}
 	}
 
-	if x < w.Width {
-		draw(' ', w.Width-x, tabBarStyle)
+	if x < width {
+		draw(' ', width-x, tabBarStyle)
+	}
+	if w.Overflows() {
+		screen.SetContent(w.Width-1, w.Y, '▾', nil, tabBarInactiveStyle)
 	}
 }
@@ -0,0 +1,82 @@
+package display
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zyedidia/micro/v2/internal/buffer"
+	"github.com/zyedidia/micro/v2/internal/config"
+	"github.com/zyedidia/micro/v2/internal/screen"
+)
+
+func TestTabWindowOverflow(t *testing.T) {
+	screen.InitSimScreen()
+
+	w := NewTabWindow(20, 0)
+	w.Names = []string{"a.go", "b.go"}
+	w.Display()
+	assert.False(t, w.Overflows())
+	assert.Equal(t, 20, w.TabsWidth())
+	assert.Equal(t, " a.go   b.go       ", screenRow(0, 20)[:19])
+
+	// the tab list button is drawn after the scroll arrow
+	w.Names = []string{"first.go", "second.go", "third.go"}
+	w.Display()
+	assert.True(t, w.Overflows())
+	assert.Equal(t, 19, w.TabsWidth())
+	assert.True(t, strings.HasSuffix(screenRow(0, 20), " ⮞▾"))
+
+	// the active tab is scrolled into the space left of the button
+	w.SetActive(2)
+	w.Display()
+	assert.Contains(t, screenRow(0, 20), "third.go")
+	assert.True(t, strings.HasSuffix(screenRow(0, 20), "▾"))
+}
+
+func TestTabWindowPinned(t *testing.T) {
+	screen.InitSimScreen()
+
+	w := NewTabWindow(30, 0)
+	w.Names = []string{"pin.go", "first.go", "second.go", "third.go"}
+	w.Pinned = []bool{true}
+	assert.Equal(t, 10, w.PinnedWidth())
+	assert.Equal(t, 31, w.TotalSize())
+	assert.True(t, w.Overflows())
+
+	// the pinned tab stays in place when the other tabs scroll
+	w.SetActive(3)
+	w.Display()
+	assert.True(t, strings.HasPrefix(screenRow(0, 30), " •pin.go  ⮜"))
+	assert.Contains(t, screenRow(0, 30), "third.go")
+	assert.Equal(t, 0, w.LocFromVisual(buffer.Loc{X: 3, Y: 0}))
+	assert.Equal(t, -1, w.LocFromVisual(buffer.Loc{X: 9, Y: 0}))
+
+	w.SetActive(0)
+	w.Scroll(-100)
+	w.Display()
+	assert.Equal(t, " •pin.go   first.go   secon ⮞▾", screenRow(0, 30))
+	assert.Equal(t, 1, w.LocFromVisual(buffer.Loc{X: 12, Y: 0}))
+}
+
+func TestTabWindowNumbers(t *testing.T) {
+	screen.InitSimScreen()
+	defer func() { config.GlobalSettings["tabnumbers"] = "off" }()
+
+	w := NewTabWindow(80, 0)
+	w.Names = make([]string, 10)
+	for i := range w.Names {
+		w.Names[i] = "f"
+	}
+	config.GlobalSettings["tabnumbers"] = "on"
+	w.Display()
+	assert.Equal(t, " 1:f   2:f ", screenRow(0, 11))
+	assert.Equal(t, 1, w.LocFromVisual(buffer.Loc{X: 9, Y: 0}))
+	assert.Equal(t, 2, w.LocFromVisual(buffer.Loc{X: 12, Y: 0}))
+	assert.Equal(t, 55, w.TotalSize())
+
+	config.GlobalSettings["tabnumbers"] = "all"
+	w.Display()
+	assert.Equal(t, " 10:f ", screenRow(0, 60)[54:])
+	assert.Equal(t, 9, w.LocFromVisual(buffer.Loc{X: 58, Y: 0}))
+}
@@ -108,6 +108,13 @@ func (w *TermWindow) Display() {
 			}
 		}
 	}
+	if hasRightNeighbor(w.X, w.Width) {
+		height := w.Height
+		if config.GetGlobalOption("statusline").(bool) {
+			height++
+		}
+		displayRightDivider(w.X, w.Y, w.Width, height)
+	}
 	if w.State.CursorVisible() && w.active {
 		curx, cury := w.State.Cursor()
 		screen.ShowCursor(curx+w.X, cury+w.Y)
@@ -2,12 +2,11 @@ package display
 
 import (
 	"github.com/zyedidia/micro/v2/internal/buffer"
-	"github.com/zyedidia/micro/v2/internal/config"
-	"github.com/zyedidia/micro/v2/internal/screen"
-	"github.com/zyedidia/micro/v2/internal/util"
 	"github.com/zyedidia/micro/v2/internal/views"
 )
 
+// UIWindow keeps the layout of the splits of a tab, to find the divider
+// under the mouse. The dividers themselves are drawn by the windows
 type UIWindow struct {
 	root *views.Node
 }
@@ -18,41 +17,6 @@ func NewUIWindow(n *views.Node) *UIWindow {
 	return uw
 }
 
-func (w *UIWindow) drawNode(n *views.Node) {
-	cs := n.Children()
-	dividerStyle := config.DefStyle
-	if style, ok := config.Colorscheme["divider"]; ok {
-		dividerStyle = style
-	}
-
-	divchars := config.GetGlobalOption("divchars").(string)
-	if util.CharacterCountInString(divchars) != 2 {
-		divchars = "|-"
-	}
-
-	divchar, combc, _ := util.DecodeCharacterInString(divchars)
-
-	divreverse := config.GetGlobalOption("divreverse").(bool)
-	if divreverse {
-		dividerStyle = dividerStyle.Reverse(true)
-	}
-
-	for i, c := range cs {
-		if c.Kind == views.STVert {
-			if i != len(cs)-1 {
-				for h := 0; h < c.H; h++ {
-					screen.SetContent(c.X+c.W, c.Y+h, divchar, combc, dividerStyle)
-				}
-			}
-		}
-		w.drawNode(c)
-	}
-}
-
-func (w *UIWindow) Display() {
-	w.drawNode(w.root)
-}
-
 func (w *UIWindow) GetMouseSplitNode(vloc buffer.Loc) *views.Node {
 	var mouseLoc func(*views.Node) *views.Node
 	mouseLoc = func(n *views.Node) *views.Node {
@@ -34,4 +34,9 @@ type BWindow interface {
 	SoftWrap
 	SetBuffer(b *buffer.Buffer)
 	BufView() View
+	CenterCursor()
+	TopCursor()
+	BottomCursor()
+	AnimateScroll(from SLoc)
+	InGutter(x int) bool
 }
@@ -1,4 +1,4 @@
-package buffer
+package linearray
 
 import (
 	"strings"
@@ -21,7 +21,7 @@ func init() {
 }
 
 func TestSplit(t *testing.T) {
-	la.insert(Loc{17, 1}, []byte{'\n'})
+	la.Insert(Loc{X: 17, Y: 1}, []byte{'\n'})
 	assert.Equal(t, len(la.lines), 6)
 	sub1 := la.Substr(Loc{0, 1}, Loc{17, 1})
 	sub2 := la.Substr(Loc{0, 2}, Loc{30, 2})
@@ -31,7 +31,7 @@ func TestSplit(t *testing.T) {
 }
 
 func TestJoin(t *testing.T) {
-	la.remove(Loc{47, 1}, Loc{0, 2})
+	la.Remove(Loc{X: 47, Y: 1}, Loc{X: 0, Y: 2})
 	assert.Equal(t, len(la.lines), 5)
 	sub := la.Substr(Loc{0, 1}, Loc{47, 1})
 	bytes := la.Bytes()
@@ -41,20 +41,20 @@ func TestJoin(t *testing.T) {
 }
 
 func TestInsert(t *testing.T) {
-	la.insert(Loc{20, 3}, []byte(" foobar"))
+	la.Insert(Loc{X: 20, Y: 3}, []byte(" foobar"))
 	sub1 := la.Substr(Loc{0, 3}, Loc{50, 3})
 
 	assert.Equal(t, []byte("Uppen Sevarne staþe, foobar sel þar him þuhte,"), sub1)
 
-	la.insert(Loc{25, 2}, []byte("H̼̥̯͇͙̕͘͞e̸̦̞̠̣̰͙̼̥̦̼̖̬͕͕̰̯̫͇̕ĺ̜̠̩̯̯͙̼̭̠͕̮̞͜l̶͓̫̞̮͈͞ͅo̸͔͙̳̠͈̮̼̳͙̥̲͜͠"))
+	la.Insert(Loc{X: 25, Y: 2}, []byte("H̼̥̯͇͙̕͘͞e̸̦̞̠̣̰͙̼̥̦̼̖̬͕͕̰̯̫͇̕ĺ̜̠̩̯̯͙̼̭̠͕̮̞͜l̶͓̫̞̮͈͞ͅo̸͔͙̳̠͈̮̼̳͙̥̲͜͠"))
 
 	sub2 := la.Substr(Loc{0, 2}, Loc{60, 2})
 	assert.Equal(t, []byte("He wonede at Ernleȝe at æH̼̥̯͇͙̕͘͞e̸̦̞̠̣̰͙̼̥̦̼̖̬͕͕̰̯̫͇̕ĺ̜̠̩̯̯͙̼̭̠͕̮̞͜l̶͓̫̞̮͈͞ͅo̸͔͙̳̠͈̮̼̳͙̥̲͜͠ðelen are chirechen,"), sub2)
 }
 
 func TestRemove(t *testing.T) {
-	la.remove(Loc{20, 3}, Loc{27, 3})
-	la.remove(Loc{25, 2}, Loc{30, 2})
+	la.Remove(Loc{X: 20, Y: 3}, Loc{X: 27, Y: 3})
+	la.Remove(Loc{X: 25, Y: 2}, Loc{X: 30, Y: 2})
 
 	bytes := la.Bytes()
 	assert.Equal(t, unicode_txt, string(bytes))
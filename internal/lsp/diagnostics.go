@@ -0,0 +1,227 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// posLess returns true if position a comes before position b
+func posLess(a, b lsp.Position) bool {
+	if a.Line != b.Line { return a.Line < b.Line }
+	return a.Character < b.Character
+}
+
+// moreSevere returns true if a should be preferred over b. A lower
+// severity value is more severe, and an unset severity is the least severe
+func moreSevere(a, b *Diagnostic) bool {
+	as, bs := a.Severity, b.Severity
+	if as == 0 { as = lsp.DiagnosticSeverityHint + 1 }
+	if bs == 0 { bs = lsp.DiagnosticSeverityHint + 1 }
+	return as < bs
+}
+
+// stepsBefore orders diagnostics in the order FindNextDiagnostic visits
+// them: by line, and within a line the most severe first, then by position
+func stepsBefore(a, b *Diagnostic) bool {
+	if a.Range.Start.Line != b.Range.Start.Line { return a.Range.Start.Line < b.Range.Start.Line }
+	if moreSevere(a, b) != moreSevere(b, a) { return moreSevere(a, b) }
+	return a.Range.Start.Character < b.Range.Start.Character
+}
+
+// diagnosticAt returns the most severe diagnostic that starts at pos, or nil
+func diagnosticAt(diags []Diagnostic, pos lsp.Position) *Diagnostic {
+	var at *Diagnostic
+	for i := range diags {
+		d := &diags[i]
+		if d.Range.Start == pos && (at == nil || moreSevere(d, at)) { at = d }
+	}
+	return at
+}
+
+// MostSevereOnLine returns the most severe of the diagnostics that start on
+// the given line, or nil if there are none
+func MostSevereOnLine(diags []Diagnostic, line uint32) *Diagnostic {
+	var best *Diagnostic
+	for i := range diags {
+		d := &diags[i]
+		if d.Range.Start.Line != line { continue }
+		if best == nil || moreSevere(d, best) { best = d }
+	}
+	return best
+}
+
+// FindNextDiagnostic returns the next diagnostic after from, wrapping
+// around to the first diagnostic of the file. Among the diagnostics that
+// start on the next line the most severe one is returned, and the other
+// ones of that line follow it by severity. Only the most severe of the
+// diagnostics that start at the same position is returned.
+func FindNextDiagnostic(diags []Diagnostic, from lsp.Position) (*Diagnostic, bool) {
+	if len(diags) == 0 { return nil, false }
+
+	at := diagnosticAt(diags, from)
+	var next, first *Diagnostic
+	for i := range diags {
+		d := &diags[i]
+		if first == nil || stepsBefore(d, first) { first = d }
+		if d.Range.Start == from { continue }
+		if at != nil && !stepsBefore(at, d) || at == nil && !posLess(from, d.Range.Start) { continue }
+		if next == nil || stepsBefore(d, next) { next = d }
+	}
+	if next == nil { next = first }
+	return next, true
+}
+
+// FindPrevDiagnostic returns the previous diagnostic before from, wrapping
+// around to the last line with diagnostics. Among the diagnostics of the
+// previous line the most severe one is returned, so that the other ones of
+// a line are reached by going back within the line from one of them.
+func FindPrevDiagnostic(diags []Diagnostic, from lsp.Position) (*Diagnostic, bool) {
+	if len(diags) == 0 { return nil, false }
+
+	at := diagnosticAt(diags, from)
+	if at != nil {
+		// the diagnostic visited before at on its line
+		var prev *Diagnostic
+		for i := range diags {
+			d := &diags[i]
+			if d.Range.Start.Line != from.Line || d.Range.Start == from || !stepsBefore(d, at) { continue }
+			if prev == nil || stepsBefore(prev, d) { prev = d }
+		}
+		if prev != nil { return prev, true }
+	}
+
+	// enters orders the diagnostics by line backwards, keeping the most
+	// severe of a line first
+	enters := func(a, b *Diagnostic) bool {
+		if a.Range.Start.Line != b.Range.Start.Line { return a.Range.Start.Line > b.Range.Start.Line }
+		return stepsBefore(a, b)
+	}
+
+	var prev, last *Diagnostic
+	for i := range diags {
+		d := &diags[i]
+		if last == nil || enters(d, last) { last = d }
+		if at != nil && d.Range.Start.Line >= from.Line || at == nil && !posLess(d.Range.Start, from) { continue }
+		if prev == nil || enters(d, prev) { prev = d }
+	}
+	if prev == nil { prev = last }
+	return prev, true
+}
+
+// NextDiagnostic returns the nearest diagnostic this server reported for
+// the file after the given position, wrapping around at the end
+func (s *Server) NextDiagnostic(filename string, from lsp.Position) (*Diagnostic, bool) {
+	return FindNextDiagnostic(s.GetDiagnostics(filename), from)
+}
+
+// PrevDiagnostic returns the nearest diagnostic this server reported for
+// the file before the given position, wrapping around at the start
+func (s *Server) PrevDiagnostic(filename string, from lsp.Position) (*Diagnostic, bool) {
+	return FindPrevDiagnostic(s.GetDiagnostics(filename), from)
+}
+
+// ParseSeverity converts a severity name as used by the lspdiagseverity
+// option to a diagnostic severity. Unknown names are treated as "hint"
+func ParseSeverity(name string) lsp.DiagnosticSeverity {
+	switch name {
+	case "error": return lsp.DiagnosticSeverityError
+	case "warning": return lsp.DiagnosticSeverityWarning
+	case "info": return lsp.DiagnosticSeverityInformation
+	}
+	return lsp.DiagnosticSeverityHint
+}
+
+// FilterDiagnostics returns the diagnostics that are at least as severe as
+// min. Diagnostics without a severity are only kept when min is a hint
+func FilterDiagnostics(diags []Diagnostic, min lsp.DiagnosticSeverity) []Diagnostic {
+	if min >= lsp.DiagnosticSeverityHint { return diags }
+
+	var out []Diagnostic
+	for _, d := range diags {
+		if d.Severity != 0 && d.Severity <= min {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// AllDiagnostics returns a snapshot of the diagnostics published by every
+// server, keyed by filename. The slices are copied so that the caller may
+// use them freely while servers keep receiving new diagnostics
+func AllDiagnostics() map[string][]Diagnostic {
+	slock.Lock()
+	defer slock.Unlock()
+
+	all := make(map[string][]Diagnostic)
+	for _, s := range servers {
+		s.diagnostics.Range(func(k, v interface{}) bool {
+			diags := v.([]Diagnostic)
+			if len(diags) == 0 { return true }
+			fn := k.(uri.URI).Filename()
+			all[fn] = append(all[fn], diags...)
+			return true
+		})
+	}
+	return all
+}
+
+// DedupDiagnostics removes diagnostics with the same range and message as an
+// earlier one, which happens when several servers report the same problem
+func DedupDiagnostics(diags []Diagnostic) []Diagnostic {
+	type key struct {
+		r   lsp.Range
+		msg string
+	}
+
+	seen := make(map[key]bool, len(diags))
+	var out []Diagnostic
+	for _, d := range diags {
+		k := key{d.Range, d.Message}
+		if seen[k] { continue }
+		seen[k] = true
+		out = append(out, d)
+	}
+	return out
+}
+
+// DiagnosticsAt returns the diagnostics whose range contains pos, the most
+// severe first
+func DiagnosticsAt(diags []Diagnostic, pos lsp.Position) []Diagnostic {
+	var out []Diagnostic
+	for _, d := range diags {
+		if posLess(pos, d.Range.Start) || posLess(d.Range.End, pos) { continue }
+		out = append(out, d)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return moreSevere(&out[i], &out[j]) })
+	return out
+}
+
+// SeverityName returns the name of a diagnostic severity, as used by the
+// lspdiagseverity option, or "" if the severity is unset
+func SeverityName(s lsp.DiagnosticSeverity) string {
+	switch s {
+	case lsp.DiagnosticSeverityError: return "error"
+	case lsp.DiagnosticSeverityWarning: return "warning"
+	case lsp.DiagnosticSeverityInformation: return "info"
+	case lsp.DiagnosticSeverityHint: return "hint"
+	}
+	return ""
+}
+
+// DiagnosticHeader describes where a diagnostic comes from: its severity,
+// followed by its source and code when the server sent them, for example
+// "error: compiler [E0308]"
+func DiagnosticHeader(d *Diagnostic) string {
+	header := SeverityName(d.Severity)
+	if header == "" { header = "diagnostic" }
+	if d.Source != "" {
+		header += ": " + d.Source
+	}
+	if d.Code != nil && d.Code != "" {
+		header += fmt.Sprintf(" [%v]", d.Code)
+	}
+	return header
+}
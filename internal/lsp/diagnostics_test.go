@@ -0,0 +1,153 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func diag(line, char uint32, sev lsp.DiagnosticSeverity, msg string) Diagnostic {
+	var d Diagnostic
+	d.Range.Start = lsp.Position{Line: line, Character: char}
+	d.Range.End = d.Range.Start
+	d.Severity = sev
+	d.Message = msg
+	return d
+}
+
+func TestFindNextPrevDiagnostic(t *testing.T) {
+	diags := []Diagnostic{
+		diag(10, 0, lsp.DiagnosticSeverityWarning, "c"),
+		diag(2, 4, lsp.DiagnosticSeverityHint, "a-hint"),
+		diag(2, 8, lsp.DiagnosticSeverityError, "a-error"),
+		diag(5, 1, lsp.DiagnosticSeverityInformation, "b"),
+	}
+
+	// the most severe diagnostic of a line is visited first
+	d, ok := FindNextDiagnostic(diags, lsp.Position{Line: 0, Character: 0})
+	assert.True(t, ok)
+	assert.Equal(t, "a-error", d.Message)
+
+	d, _ = FindNextDiagnostic(diags, lsp.Position{Line: 2, Character: 5})
+	assert.Equal(t, "a-error", d.Message)
+
+	// the other diagnostics of the line follow it
+	d, _ = FindNextDiagnostic(diags, lsp.Position{Line: 2, Character: 8})
+	assert.Equal(t, "a-hint", d.Message)
+
+	d, _ = FindNextDiagnostic(diags, lsp.Position{Line: 2, Character: 4})
+	assert.Equal(t, "b", d.Message)
+
+	d, _ = FindNextDiagnostic(diags, lsp.Position{Line: 10, Character: 0})
+	assert.Equal(t, "a-error", d.Message)
+
+	d, _ = FindPrevDiagnostic(diags, lsp.Position{Line: 10, Character: 0})
+	assert.Equal(t, "b", d.Message)
+
+	d, _ = FindPrevDiagnostic(diags, lsp.Position{Line: 5, Character: 1})
+	assert.Equal(t, "a-error", d.Message)
+
+	d, _ = FindPrevDiagnostic(diags, lsp.Position{Line: 3, Character: 0})
+	assert.Equal(t, "a-error", d.Message)
+
+	// going back within a line returns to its most severe diagnostic
+	d, _ = FindPrevDiagnostic(diags, lsp.Position{Line: 2, Character: 4})
+	assert.Equal(t, "a-error", d.Message)
+
+	d, _ = FindPrevDiagnostic(diags, lsp.Position{Line: 2, Character: 8})
+	assert.Equal(t, "c", d.Message)
+
+	// at the same position, only the most severe diagnostic is visited
+	same := append(diags, diag(5, 1, lsp.DiagnosticSeverityError, "b-error"))
+	d, _ = FindNextDiagnostic(same, lsp.Position{Line: 2, Character: 4})
+	assert.Equal(t, "b-error", d.Message)
+	d, _ = FindNextDiagnostic(same, lsp.Position{Line: 5, Character: 1})
+	assert.Equal(t, "c", d.Message)
+	d, _ = FindPrevDiagnostic(same, lsp.Position{Line: 10, Character: 0})
+	assert.Equal(t, "b-error", d.Message)
+
+	_, ok = FindNextDiagnostic(nil, lsp.Position{})
+	assert.False(t, ok)
+}
+
+func TestAllDiagnostics(t *testing.T) {
+	s := &Server{}
+	file := uri.File("/tmp/a.go")
+	s.storeDiagnostics(file, []Diagnostic{diag(1, 0, lsp.DiagnosticSeverityError, "a")})
+
+	isolateServers(t)
+	slock.Lock()
+	servers["test-all"] = s
+	slock.Unlock()
+
+	all := AllDiagnostics()
+	assert.Len(t, all["/tmp/a.go"], 1)
+
+	// mutating the snapshot must not affect the stored diagnostics
+	all["/tmp/a.go"][0].Message = "changed"
+	assert.Equal(t, "a", s.loadDiagnostics(file)[0].Message)
+}
+
+func TestDedupDiagnostics(t *testing.T) {
+	a := diag(1, 0, lsp.DiagnosticSeverityError, "a")
+	b := diag(1, 0, lsp.DiagnosticSeverityWarning, "b")
+	dup := a
+	dup.Server = &Server{}
+
+	out := DedupDiagnostics([]Diagnostic{a, b, dup})
+	assert.Len(t, out, 2)
+	assert.Equal(t, "a", out[0].Message)
+	assert.Equal(t, "b", out[1].Message)
+}
+
+func TestFilterDiagnostics(t *testing.T) {
+	diags := []Diagnostic{
+		diag(1, 0, lsp.DiagnosticSeverityError, "error"),
+		diag(2, 0, lsp.DiagnosticSeverityWarning, "warning"),
+		diag(3, 0, lsp.DiagnosticSeverityHint, "hint"),
+		diag(4, 0, 0, "unset"),
+	}
+
+	assert.Len(t, FilterDiagnostics(diags, ParseSeverity("hint")), 4)
+	assert.Len(t, FilterDiagnostics(diags, ParseSeverity("info")), 2)
+
+	out := FilterDiagnostics(diags, ParseSeverity("error"))
+	assert.Len(t, out, 1)
+	assert.Equal(t, "error", out[0].Message)
+}
+
+func TestDiagnosticsAt(t *testing.T) {
+	wide := diag(3, 2, lsp.DiagnosticSeverityWarning, "wide")
+	wide.Range.End = lsp.Position{Line: 4, Character: 1}
+	diags := []Diagnostic{
+		wide,
+		diag(3, 5, lsp.DiagnosticSeverityError, "point"),
+		diag(3, 9, lsp.DiagnosticSeverityError, "other"),
+	}
+
+	at := DiagnosticsAt(diags, lsp.Position{Line: 3, Character: 5})
+	assert.Len(t, at, 2)
+	assert.Equal(t, "point", at[0].Message)
+	assert.Equal(t, "wide", at[1].Message)
+
+	at = DiagnosticsAt(diags, lsp.Position{Line: 4, Character: 0})
+	assert.Len(t, at, 1)
+	assert.Equal(t, "wide", at[0].Message)
+
+	assert.Empty(t, DiagnosticsAt(diags, lsp.Position{Line: 3, Character: 0}))
+}
+
+func TestDiagnosticHeader(t *testing.T) {
+	d := diag(0, 0, lsp.DiagnosticSeverityError, "m")
+	assert.Equal(t, "error", DiagnosticHeader(&d))
+
+	d.Source = "compiler"
+	d.Code = float64(308)
+	assert.Equal(t, "error: compiler [308]", DiagnosticHeader(&d))
+
+	d.Severity = 0
+	d.Code = "E1"
+	assert.Equal(t, "diagnostic: compiler [E1]", DiagnosticHeader(&d))
+}
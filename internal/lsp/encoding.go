@@ -0,0 +1,90 @@
+package lsp
+
+import (
+	"unicode/utf8"
+
+	"github.com/zyedidia/micro/v2/internal/util"
+)
+
+type PositionEncodingKind string
+
+const (
+	PEK_UTF8  PositionEncodingKind = "utf-8"
+	PEK_UTF16 PositionEncodingKind = "utf-16"
+	PEK_UTF32 PositionEncodingKind = "utf-32"
+)
+
+// supportedEncodings are the position encodings advertised to servers, in
+// order of preference
+var supportedEncodings = []PositionEncodingKind{PEK_UTF8, PEK_UTF32, PEK_UTF16}
+
+// negotiatedEncoding returns the encoding a server picked during
+// initialization. Servers that don't pick one use UTF-16, as per the spec
+func negotiatedEncoding(enc PositionEncodingKind) PositionEncodingKind {
+	switch enc {
+	case PEK_UTF8, PEK_UTF32: return enc
+	}
+	return PEK_UTF16
+}
+
+// PositionEncoding returns the position encoding negotiated with the server
+func (s *Server) PositionEncoding() PositionEncodingKind {
+	return negotiatedEncoding(s.encoding)
+}
+
+// EncodeCharacter converts the character offset x within line to a
+// character offset for this server
+func (s *Server) EncodeCharacter(line []byte, x int) uint32 {
+	return EncodeCharacter(s.PositionEncoding(), line, x)
+}
+
+// DecodeCharacter converts a character offset sent by this server to a
+// character offset within line
+func (s *Server) DecodeCharacter(line []byte, c uint32) int {
+	return DecodeCharacter(s.PositionEncoding(), line, c)
+}
+
+func codeUnits(enc PositionEncodingKind, r rune, size int) uint32 {
+	switch enc {
+	case PEK_UTF8: return uint32(size)
+	case PEK_UTF32: return 1
+	}
+	if r >= 0x10000 { return 2 }
+	return 1
+}
+
+// EncodeCharacter converts the character offset x within line, where
+// combining marks belong to the preceding character, to an offset in code
+// units of the given encoding. Offsets past the end of the line are
+// extended by one code unit per character
+func EncodeCharacter(enc PositionEncodingKind, line []byte, x int) uint32 {
+	var n uint32
+	chars := 0
+	for len(line) > 0 {
+		r, size := utf8.DecodeRune(line)
+		if !util.IsMark(r) {
+			if chars == x { return n }
+			chars++
+		}
+		n += codeUnits(enc, r, size)
+		line = line[size:]
+	}
+	if x > chars { n += uint32(x - chars) }
+	return n
+}
+
+// DecodeCharacter converts an offset in code units of the given encoding
+// within line to a character offset. Offsets past the end of the line are
+// extended by one character per code unit
+func DecodeCharacter(enc PositionEncodingKind, line []byte, c uint32) int {
+	var n uint32
+	x := 0
+	for len(line) > 0 && n < c {
+		r, size := utf8.DecodeRune(line)
+		if !util.IsMark(r) { x++ }
+		n += codeUnits(enc, r, size)
+		line = line[size:]
+	}
+	if c > n { x += int(c - n) }
+	return x
+}
@@ -0,0 +1,77 @@
+package lsp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestEncodeDecodeCharacter(t *testing.T) {
+	// 'é' is two bytes in UTF-8, '𝄞' is four bytes and a surrogate pair in
+	// UTF-16, and the combining accent belongs to the preceding 'e'
+	line := []byte("a\u00e9\U0001D11Ee\u0301b")
+
+	tests := []struct {
+		enc   PositionEncodingKind
+		units []uint32
+	}{
+		{PEK_UTF8, []uint32{0, 1, 3, 7, 10, 11}},
+		{PEK_UTF16, []uint32{0, 1, 2, 4, 6, 7}},
+		{PEK_UTF32, []uint32{0, 1, 2, 3, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		for x, c := range tt.units {
+			assert.Equal(t, c, EncodeCharacter(tt.enc, line, x), "%s encode %d", tt.enc, x)
+			assert.Equal(t, x, DecodeCharacter(tt.enc, line, c), "%s decode %d", tt.enc, c)
+		}
+		// past the end of the line
+		last := tt.units[len(tt.units)-1]
+		assert.Equal(t, last+2, EncodeCharacter(tt.enc, line, len(tt.units)+1))
+		assert.Equal(t, len(tt.units)+1, DecodeCharacter(tt.enc, line, last+2))
+	}
+}
+
+func TestNegotiatedEncoding(t *testing.T) {
+	assert.Equal(t, PEK_UTF16, negotiatedEncoding(""))
+	assert.Equal(t, PEK_UTF16, negotiatedEncoding("utf-7"))
+	assert.Equal(t, PEK_UTF8, negotiatedEncoding(PEK_UTF8))
+	assert.Equal(t, PEK_UTF32, (&Server{encoding: PEK_UTF32}).PositionEncoding())
+
+	var enc RPCInitExtra
+	err := json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":0,"result":{"capabilities":{"positionEncoding":"utf-8"}}}`), &enc)
+	assert.NoError(t, err)
+	assert.Equal(t, PEK_UTF8, enc.Result.Capabilities.PositionEncoding)
+}
+
+func TestInitCapabilities(t *testing.T) {
+	params := LSPInit{
+		Capabilities: LSPClientCapabilities{
+			TextDocument: LSPTextDocumentClientCapabilities{
+				TextDocumentClientCapabilities: &lsp.TextDocumentClientCapabilities{
+					Hover: &lsp.HoverTextDocumentClientCapabilities{},
+				},
+				InlayHint: &InlayHintClientCapabilities{},
+			},
+			General: LSPInitGeneral{PositionEncodings: supportedEncodings},
+		},
+	}
+
+	data, err := json.Marshal(params)
+	assert.NoError(t, err)
+
+	var m struct {
+		Capabilities struct {
+			TextDocument map[string]interface{} `json:"textDocument"`
+			General      struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, []string{"utf-8", "utf-32", "utf-16"}, m.Capabilities.General.PositionEncodings)
+	assert.Contains(t, m.Capabilities.TextDocument, "hover")
+	assert.Contains(t, m.Capabilities.TextDocument, "inlayHint")
+}
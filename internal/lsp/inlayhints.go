@@ -0,0 +1,100 @@
+package lsp
+
+import (
+	"encoding/json"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Inlay hints were added in LSP 3.17, which go.lsp.dev/protocol doesn't
+// support yet, so the types used by micro are defined here
+
+const MethodTextDocumentInlayHint = "textDocument/inlayHint"
+
+type InlayHintClientCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+type InlayHintKind int
+
+const (
+	InlayHintKindType      InlayHintKind = 1
+	InlayHintKindParameter InlayHintKind = 2
+)
+
+// InlayHintLabel is the text of an inlay hint. Servers send either a string
+// or a list of label parts, which are joined together
+type InlayHintLabel string
+
+func (l *InlayHintLabel) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*l = InlayHintLabel(str)
+		return nil
+	}
+
+	var parts []struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+
+	str = ""
+	for _, p := range parts {
+		str += p.Value
+	}
+	*l = InlayHintLabel(str)
+	return nil
+}
+
+type InlayHint struct {
+	Position     lsp.Position   `json:"position"`
+	Label        InlayHintLabel `json:"label"`
+	Kind         InlayHintKind  `json:"kind,omitempty"`
+	PaddingLeft  bool           `json:"paddingLeft,omitempty"`
+	PaddingRight bool           `json:"paddingRight,omitempty"`
+
+	Server *Server `json:"-"`
+}
+
+type InlayHintParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Range        lsp.Range                  `json:"range"`
+}
+
+type RPCInlayHints = RPCResponse[[]InlayHint]
+
+// InlayHints returns the inlay hints the server has for the given range of
+// the file
+func (s *Server) InlayHints(filename string, r lsp.Range) ([]InlayHint, error) {
+	if !capabilityCheck(s.extraCapabilities.InlayHintProvider) {
+		return nil, ErrNotSupported
+	}
+
+	params := InlayHintParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filename),
+		},
+		Range: r,
+	}
+
+	resp, err := s.sendRequest(MethodTextDocumentInlayHint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return inlayHints(resp)
+}
+
+func inlayHints(resp []byte) ([]InlayHint, error) {
+	if isNullResult(resp) { return nil, nil }
+
+	var r RPCInlayHints
+	err := json.Unmarshal(resp, &r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Result, nil
+}
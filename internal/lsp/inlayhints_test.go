@@ -0,0 +1,26 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlayHints(t *testing.T) {
+	hints, err := inlayHints(nullResult)
+	assert.NoError(t, err)
+	assert.Nil(t, hints)
+
+	hints, err = inlayHints([]byte(`{"jsonrpc":"2.0","id":1,"result":[` +
+		`{"position":{"line":2,"character":5},"label":": int","kind":1,"paddingLeft":true},` +
+		`{"position":{"line":3,"character":9},"label":[{"value":"n"},{"value":":"}],"kind":2}]}`))
+	assert.NoError(t, err)
+	assert.Len(t, hints, 2)
+
+	assert.Equal(t, InlayHintLabel(": int"), hints[0].Label)
+	assert.Equal(t, InlayHintKindType, hints[0].Kind)
+	assert.True(t, hints[0].PaddingLeft)
+
+	assert.Equal(t, InlayHintLabel("n:"), hints[1].Label)
+	assert.Equal(t, uint32(9), hints[1].Position.Character)
+}
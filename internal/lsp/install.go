@@ -8,6 +8,7 @@ import (
 	"strings"
 	"log"
 	"reflect"
+	"regexp"
 	"fmt"
 	"runtime/debug"
 
@@ -21,13 +22,23 @@ import (
 var ErrManualInstall = errors.New("Requires manual installation")
 var ErrUnknownInstall = errors.New("Unknown installation method")
 
+// EmptyCommandError is returned when the command of a language server
+// resolves to nothing, which usually means its configuration is wrong
+type EmptyCommandError struct {
+	Name string
+}
+
+func (e *EmptyCommandError) Error() string {
+	return "command for " + e.Name + " is empty"
+}
+
 type Config struct {
 	LSPConfigs []LSPConfig
 }
 
 type LSPConfigStatic struct {
 	Name        string
-	Command     string 				`yaml:"command"`
+	Command     any 				`yaml:"command"`
 	Languages   []string            `yaml:"languages"`
 	Args        []string            `yaml:"args"`
 	IsInstalled []string			`yaml:"is_installed"`
@@ -35,6 +46,8 @@ type LSPConfigStatic struct {
 	Env         map[string]string 	`yaml:"env"`
 	Cwd         string 				`yaml:"cwd"`
 	Options     any					`yaml:"options"`
+	RootMarkers []string			`yaml:"rootmarkers"`
+	Files       string				`yaml:"files"`
 }
 
 type LSPConfig struct {
@@ -47,6 +60,8 @@ type LSPConfig struct {
 	Env			Runnable
 	Cwd			Runnable
 	Options     any
+	RootMarkers []string
+	Files       *regexp.Regexp
 }
 
 type Runnable interface {
@@ -266,7 +281,7 @@ func MakeRunnable(l LSPConfig, propname string, val any, strict bool) Runnable {
 		return MakeCommands( CAST(ctx, strarrarr) )
 	}
 
-	strarr, err := lspResolveArray(ctx, lspResolveString, true)
+	strarr, err := lspResolveArray(ctx, lspAnyResolver(lspResolveString, lspResolveEnvVar), true)
 	if err == nil {
 		CAST := castArray[string]
 		return &Command{ CAST(ctx, strarr) }
@@ -278,6 +293,12 @@ func MakeRunnable(l LSPConfig, propname string, val any, strict bool) Runnable {
 		return &Str{ CAST(ctx, str) }
 	}
 
+	envstr, err := lspResolveEnvVar(ctx)
+	if err == nil {
+		CAST := castValue[string]
+		return &Str{ CAST(ctx, envstr) }
+	}
+
 	fn, err := lspResolveFunction(ctx)
 	if err == nil {
 		CAST := castValue[func(...any)[]any]
@@ -399,7 +420,7 @@ func lspResolveMap(
 	t := reflect.TypeOf(ctx.from)
 	if t != nil && t.Kind() == reflect.Map {
 		dict := reflect.ValueOf(ctx.from)
-		var out_map map[string]any
+		out_map := make(map[string]any)
 		keys := dict.MapKeys()
 		for _, key := range keys {
 			if key.Type().Kind() != reflect.String {
@@ -415,7 +436,7 @@ func lspResolveMap(
 
 	lua_table, ok := ctx.from.(*lua.LTable)
 	if ok {
-		var out_map map[string]any
+		out_map := make(map[string]any)
 		var err error
 		lua_table.ForEach(func (key lua.LValue, val lua.LValue) {
 			if err != nil { return }
@@ -452,6 +473,36 @@ func lspResolveString(ctx ResolutionContext) (any, error) {
 	return "", ctx.Error("Expected a string")
 }
 
+// lspResolveEnvVar resolves { env: "NAME", default: "value" } to the value
+// of the environment variable NAME, or to the default if it is not set
+func lspResolveEnvVar(ctx ResolutionContext) (any, error) {
+	fields := make(map[string]any)
+	switch val := ctx.from.(type) {
+	case *lua.LTable:
+		val.ForEach(func(k, v lua.LValue) { fields[k.String()] = v })
+	default:
+		m := reflect.ValueOf(ctx.from)
+		if m.Kind() != reflect.Map { return nil, ctx.Error("Expected an environment variable") }
+		for _, k := range m.MapKeys() {
+			fields[fmt.Sprint(k.Interface())] = m.MapIndex(k).Interface()
+		}
+	}
+
+	name, ok := fields["env"]
+	if !ok { return nil, ctx.Error("Expected an environment variable") }
+	def, hasdef := fields["default"]
+	if len(fields) > 2 || (len(fields) == 2 && !hasdef) {
+		return nil, ctx.Error("Expected only 'env' and 'default'")
+	}
+
+	envname, err := lspResolveString(ctx.modified(name, ".env"))
+	if err != nil { return nil, err }
+	if v := os.Getenv(envname.(string)); v != "" { return v, nil }
+
+	if !hasdef { return "", nil }
+	return lspResolveString(ctx.modified(def, ".default"))
+}
+
 func lspResolveFunction(ctx ResolutionContext) (any, error) {
 	switch val := ctx.from.(type) {
 	case func(...any) []any:
@@ -480,7 +531,18 @@ func luaGet[K any](l LSPConfig, luafn *LUAFn, resolver Resolver, propname string
 	return castValue[K](ctx.modified(ctx.from, ":LUAGET:"), resolved), nil
 }
 
+// GetCmd returns the command used to start the server, or an
+// EmptyCommandError if it has no executable
 func (l LSPConfig) GetCmd(root string) (*Command, error) {
+	cmd, err := l.getCmd(root)
+	if err != nil { return nil, err }
+	if len(cmd.tokens) == 0 || strings.TrimSpace(cmd.tokens[0]) == "" {
+		return nil, &EmptyCommandError{l.Name}
+	}
+	return cmd, nil
+}
+
+func (l LSPConfig) getCmd(root string) (*Command, error) {
 	switch cmd := l.Command.(type) {
 	case *Command:
 		return cmd, nil
@@ -597,42 +659,129 @@ func RunnableString(r Runnable) string {
 	}
 }
 
+// LoadConfig parses the language servers in an lsp.yaml file. Entries that
+// fail to parse are skipped, and the returned error lists them
 func LoadConfig(data []byte) (*Config, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			str := string(debug.Stack())
-			log.Println("panic occurred:", err)
-			log.Println(str)
-		}
-	}()
-
-	var lsps []LSPConfigStatic
-	if err := yaml.Unmarshal(data, &lsps); err != nil {
+	var entries []interface{}
+	if err := yaml.Unmarshal(data, &entries); err != nil {
 		return nil, err
 	}
 
 	var conf Config
+	var failed []string
 
-	for _, lang := range lsps {
-		var l LSPConfig
-		var cmd []string
-		cmd = append(cmd, lang.Command)
-		cmd = append(cmd, lang.Args...)
-		l.Name = lang.Name
-		l.Languages = lang.Languages
-		l.IsValid = &Fn{func(...any) []any { return []any{ true } }}
-		l.Command = MakeRunnable(l, "Command", cmd, true)
-		l.Cwd = MakeRunnable(l, "Cwd", lang.Cwd, false)
-		l.Env = MakeRunnable(l, "Env", lang.Env, false)
-		l.Install = MakeRunnable(l, "Install", lang.Install, false)
-		l.IsInstalled = MakeRunnable(l, "IsInstall", lang.IsInstalled, false)
-		l.Options = lang.Options
+	for i, entry := range entries {
+		l, err := loadLanguage(entry)
+		if err != nil {
+			name := entryName(entry, i)
+			log.Println("Failed to load language server", name+":", err)
+			failed = append(failed, name+" ("+err.Error()+")")
+			continue
+		}
 		conf.LSPConfigs = append(conf.LSPConfigs, l)
 	}
 
+	if len(failed) > 0 {
+		return &conf, errors.New("Failed to load language servers: " + strings.Join(failed, ", "))
+	}
 	return &conf, nil
 }
 
+// entryName returns the name of an lsp.yaml entry for error messages
+func entryName(entry interface{}, i int) string {
+	if m, ok := entry.(map[interface{}]interface{}); ok {
+		if name, ok := m["name"]; ok { return fmt.Sprint(name) }
+	}
+	return fmt.Sprint("entry ", i+1)
+}
+
+// recoverError turns the panics of strict resolvers into an error. It must
+// be deferred directly
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		log.Println("panic occurred:", r)
+		log.Println(string(debug.Stack()))
+		*err = fmt.Errorf("%v", r)
+	}
+}
+
+func decodeEntry(entry interface{}) (LSPConfigStatic, error) {
+	var lang LSPConfigStatic
+	raw, err := yaml.Marshal(entry)
+	if err != nil { return lang, err }
+	err = yaml.Unmarshal(raw, &lang)
+	return lang, err
+}
+
+// commandTokens returns the command of an lsp.yaml entry followed by its
+// arguments
+func commandTokens(command any, args []string) []any {
+	var cmd []any
+	switch c := command.(type) {
+	case nil: cmd = append(cmd, "")
+	case []any: cmd = append(cmd, c...)
+	default: cmd = append(cmd, c)
+	}
+	for _, arg := range args { cmd = append(cmd, arg) }
+	return cmd
+}
+
+// loadLanguage builds the config of a single lsp.yaml entry
+func loadLanguage(entry interface{}) (l LSPConfig, err error) {
+	defer recoverError(&err)
+
+	lang, err := decodeEntry(entry)
+	if err != nil { return l, err }
+
+	l.Name = lang.Name
+	l.Languages = lang.Languages
+	l.IsValid = &Fn{func(...any) []any { return []any{ true } }}
+	l.Command = MakeRunnable(l, "Command", commandTokens(lang.Command, lang.Args), true)
+	l.Cwd = MakeRunnable(l, "Cwd", lang.Cwd, false)
+	l.Env = MakeRunnable(l, "Env", lang.Env, false)
+	l.Install = MakeRunnable(l, "Install", lang.Install, false)
+	l.IsInstalled = MakeRunnable(l, "IsInstall", lang.IsInstalled, false)
+	l.Options = lang.Options
+	l.RootMarkers = lang.RootMarkers
+	l.Files, err = compileFiles(lang.Files)
+	return l, err
+}
+
+// compileFiles compiles the files pattern of an lsp.yaml entry. Patterns
+// starting with "regex:" are regular expressions, others are globs where
+// ** matches any number of directories, including none. Globs match the end
+// of the path, so "*.ts" matches every TypeScript file
+func compileFiles(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" { return nil, nil }
+	if strings.HasPrefix(pattern, "regex:") {
+		return regexp.Compile(strings.TrimPrefix(pattern, "regex:"))
+	}
+
+	var re strings.Builder
+	re.WriteString("(^|/)")
+	glob := []rune(pattern)
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+2 < len(glob) && glob[i+1] == '*' && glob[i+2] == '/' {
+				re.WriteString("(.*/)?")
+				i += 2
+			} else if i+1 < len(glob) && glob[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
 func call(fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
 	if fn == lua.LNil { return nil, config.ErrNoSuchFunction }
 	err := ulua.L.CallByParam(lua.P{
@@ -646,7 +795,32 @@ func call(fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
 	return ret, nil
 }
 
-func (l LSPConfig) Valid_For(path string) bool {
+// FindRoot returns the project root of the file at path: the closest
+// directory containing one of the root markers of the language. If there
+// is none, fallback is returned
+func (l LSPConfig) FindRoot(path, fallback string) string {
+	if len(l.RootMarkers) == 0 || !filepath.IsAbs(path) { return fallback }
+
+	for dir := filepath.Dir(path); ; {
+		for _, marker := range l.RootMarkers {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir { return fallback }
+		dir = parent
+	}
+}
+
+// Valid_For reports whether the server should handle the file at path,
+// whose project root is root
+func (l LSPConfig) Valid_For(path, root string) bool {
+	if l.Files != nil && !l.Files.MatchString(filepath.ToSlash(path)) {
+		return false
+	}
+
 	is_valid, err := l.GetIsValid()
 	if err != nil {
 		log.Println(l.Name, "IsValid error (get):", err)
@@ -658,7 +832,7 @@ func (l LSPConfig) Valid_For(path string) bool {
 		return true
 	}
 
-	ok, err := is_valid.Run(l, path)
+	ok, err := is_valid.Run(l, path, root)
 	if err != nil {
 		log.Println(l.Name, "IsValid error:", err)
 		return false
@@ -682,50 +856,62 @@ func (l LSPConfig) Valid_For(path string) bool {
 	return false
 }
 
+// Installed reports whether the server is installed, logging the reason
+// if it isn't
 func (l LSPConfig) Installed() bool {
-	is_installed, err := l.GetIsInstalled()
+	err := l.CheckInstalled()
 	if err != nil {
-		log.Println(l.Name, "IsInstalled error (get):", err);
+		log.Println(l.Name, err)
 		return false
 	}
+	return true
+}
+
+// CheckInstalled returns an error explaining why the server is not
+// installed, or nil if it is
+func (l LSPConfig) CheckInstalled() error {
+	is_installed, err := l.GetIsInstalled()
+	if err != nil {
+		return fmt.Errorf("IsInstalled error (get): %w", err)
+	}
 
 	_, is_noop := is_installed.(*NoOp)
 	if is_noop {
 		cmd, err := l.GetCmd("")
 		if err != nil {
-			log.Println(l.Name, is_installed, "IsInstalled error (noop):", err)
-			return false
+			return fmt.Errorf("IsInstalled error (noop): %w", err)
 		}
-		if len(cmd.tokens) == 0 { return false }
 		_, err = exec.LookPath(cmd.tokens[0])
 		if err != nil {
-			log.Println(l.Name, "IsInstalled error (noop):", err);
-			return false
+			return fmt.Errorf("IsInstalled error (noop): %w", err)
 		}
-		return true
+		return nil
 	}
 
 	ok, err := is_installed.Run(l)
 	if err != nil {
-		log.Println(l.Name, "IsInstalled error:", err)
-		return false
+		return fmt.Errorf("IsInstalled error: %w", err)
 	}
 
 	if ok == nil {
-		log.Println(l.Name, "IsInstalled returns nil.")
-		return false
+		return errors.New("IsInstalled returns nil.")
 	}
 
 	okarr, ok_is_arr := ok.([]interface{})
 	if ok_is_arr && len(okarr) > 0 { ok = okarr[0] }
 
+	installed := false
 	switch val := ok.(type) {
-		case bool: return val
-		case lua.LValue: return lua.LVAsBool(val)
-		case lua.LBool: return lua.LVAsBool(val)
-		default: log.Println(l.Name, "Warning: IsInstalled returns incorrect type! Got: ", reflect.TypeOf(val), val, RunnableString(is_installed))
+		case bool: installed = val
+		case lua.LValue: installed = lua.LVAsBool(val)
+		case lua.LBool: installed = lua.LVAsBool(val)
+		default:
+			return fmt.Errorf("IsInstalled returns incorrect type! Got: %v %v %s", reflect.TypeOf(val), val, RunnableString(is_installed))
 	}
-	return false
+	if !installed {
+		return errors.New("not installed")
+	}
+	return nil
 }
 
 func (l LSPConfig) DoInstall() error {
@@ -0,0 +1,187 @@
+package lsp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmptyCommand(t *testing.T) {
+	conf, err := LoadConfig([]byte(`
+- name: broken
+  languages: [go]
+  command:
+`))
+	assert.NoError(t, err)
+	assert.Len(t, conf.LSPConfigs, 1)
+	l := conf.LSPConfigs[0]
+
+	_, err = l.GetCmd("")
+	var empty *EmptyCommandError
+	assert.True(t, errors.As(err, &empty))
+	assert.EqualError(t, err, "command for broken is empty")
+
+	err = l.CheckInstalled()
+	assert.True(t, errors.As(err, &empty))
+	assert.Contains(t, err.Error(), "command for broken is empty")
+	assert.False(t, l.Installed())
+}
+
+func TestGetCmd(t *testing.T) {
+	conf, err := LoadConfig([]byte(`
+- name: gopls
+  languages: [go]
+  command: gopls
+  args: [serve]
+`))
+	assert.NoError(t, err)
+	assert.Len(t, conf.LSPConfigs, 1)
+
+	cmd, err := conf.LSPConfigs[0].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gopls", "serve"}, cmd.tokens)
+}
+
+func TestLoadConfigSkipsBadEntries(t *testing.T) {
+	conf, err := LoadConfig([]byte(`
+- name: first
+  languages: [go]
+  command: first
+  args: 5
+- name: gopls
+  languages: [go]
+  command: gopls
+- languages: c
+  command: clangd
+`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	assert.Contains(t, err.Error(), "entry 3")
+	assert.NotContains(t, err.Error(), "gopls")
+
+	assert.Len(t, conf.LSPConfigs, 1)
+	assert.Equal(t, "gopls", conf.LSPConfigs[0].Name)
+}
+
+func TestGetLanguagesSharedEntry(t *testing.T) {
+	loaded, err := LoadConfig([]byte(`
+- name: clangd
+  languages: [c, cpp]
+  command: clangd
+`))
+	assert.NoError(t, err)
+
+	old := conf
+	conf = loaded
+	defer func() { conf = old }()
+
+	for _, ft := range []string{"c", "cpp"} {
+		langs := GetLanguages(ft)
+		assert.Len(t, langs, 1)
+		assert.Equal(t, "clangd", langs[0].Name)
+	}
+	assert.Empty(t, GetLanguages("go"))
+}
+
+func TestLoadInternalConfig(t *testing.T) {
+	conf, err := LoadConfig(servers_internal)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, conf.LSPConfigs)
+
+	var gopls *LSPConfig
+	for i, l := range conf.LSPConfigs {
+		if l.Name == "gopls" { gopls = &conf.LSPConfigs[i] }
+	}
+	if assert.NotNil(t, gopls) {
+		assert.True(t, gopls.Supports("go"))
+		cmd, err := gopls.GetCmd("")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"gopls", "serve"}, cmd.tokens)
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	data := []byte(`
+- name: gopls
+  languages: [go]
+  command: { env: MICRO_TEST_GOPLS, default: gopls }
+  args: [serve]
+`)
+
+	conf, err := LoadConfig(data)
+	assert.NoError(t, err)
+	cmd, err := conf.LSPConfigs[0].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gopls", "serve"}, cmd.tokens)
+
+	t.Setenv("MICRO_TEST_GOPLS", "/opt/gopls")
+	conf, err = LoadConfig(data)
+	assert.NoError(t, err)
+	cmd, err = conf.LSPConfigs[0].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/opt/gopls", "serve"}, cmd.tokens)
+
+	conf, err = LoadConfig([]byte(`
+- name: gopls
+  languages: [go]
+  command: [ "gopls", { env: MICRO_TEST_GOPLS_FLAG, default: "-v" } ]
+`))
+	assert.NoError(t, err)
+	cmd, err = conf.LSPConfigs[0].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gopls", "-v"}, cmd.tokens)
+}
+
+func TestFindRoot(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "cmd", "tool")
+	assert.NoError(t, os.MkdirAll(sub, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), nil, 0644))
+
+	l := LSPConfig{Name: "gopls", RootMarkers: []string{"go.work", "go.mod"}}
+	assert.Equal(t, root, l.FindRoot(filepath.Join(sub, "main.go"), "/fallback"))
+	assert.Equal(t, root, l.FindRoot(filepath.Join(root, "main.go"), "/fallback"))
+
+	other := t.TempDir()
+	assert.Equal(t, "/fallback", l.FindRoot(filepath.Join(other, "main.go"), "/fallback"))
+
+	l.RootMarkers = nil
+	assert.Equal(t, "/fallback", l.FindRoot(filepath.Join(sub, "main.go"), "/fallback"))
+}
+
+func TestValidForFiles(t *testing.T) {
+	conf, err := LoadConfig([]byte(`
+- name: deno
+  languages: [typescript]
+  command: deno
+  files: "deno/**/*.ts"
+- name: tsserver
+  languages: [typescript]
+  command: tsserver
+  files: "regex:^/src/[^/]+\\.tsx?$"
+- name: any
+  languages: [typescript]
+  command: any
+- name: broken
+  languages: [typescript]
+  command: broken
+  files: "regex:("
+`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+	assert.Len(t, conf.LSPConfigs, 3)
+
+	deno, tsserver, any := conf.LSPConfigs[0], conf.LSPConfigs[1], conf.LSPConfigs[2]
+	assert.True(t, deno.Valid_For("/home/me/deno/lib/mod.ts", "/home/me"))
+	assert.True(t, deno.Valid_For("/home/me/deno/mod.ts", "/home/me"))
+	assert.False(t, deno.Valid_For("/home/me/node/mod.ts", "/home/me"))
+	assert.False(t, deno.Valid_For("/home/me/nodeno/mod.ts", "/home/me"))
+
+	assert.True(t, tsserver.Valid_For("/src/app.tsx", "/src"))
+	assert.False(t, tsserver.Valid_For("/src/lib/app.ts", "/src"))
+
+	assert.True(t, any.Valid_For("/anywhere/app.ts", "/"))
+}
@@ -0,0 +1,294 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+)
+
+// mockHandler answers a request sent to a mock server. The result is sent
+// as the response, or the error as an error response. Returning errNoReply
+// leaves the request unanswered
+type mockHandler func(params json.RawMessage) (interface{}, error)
+
+var errNoReply = errors.New("no reply")
+
+// mockServer is an in-process language server that speaks the Content-Length
+// framing over a pair of pipes. Requests are answered by the handler of
+// their method, and notifications are recorded
+type mockServer struct {
+	capabilities string
+	handlers     map[string]mockHandler
+	out          io.WriteCloser
+
+	lock          sync.Mutex
+	notifications []string
+	// the params of the notifications, parallel to notifications
+	params []json.RawMessage
+}
+
+// isolateServers gives the test an empty servers map, and restores the
+// previous one when the test ends, so that servers started by other tests
+// and still shutting down are out of its reach
+func isolateServers(t *testing.T) {
+	slock.Lock()
+	saved := servers
+	servers = make(map[string]*Server)
+	slock.Unlock()
+	t.Cleanup(func() {
+		slock.Lock()
+		servers = saved
+		slock.Unlock()
+	})
+}
+
+// startMockServer connects a Server to a mock server that reports the given
+// capabilities, as JSON, and waits for the initialize handshake
+func startMockServer(t *testing.T, capabilities string, handlers map[string]mockHandler) (*Server, *mockServer) {
+	isolateServers(t)
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+
+	m := &mockServer{capabilities: capabilities, handlers: handlers, out: fromServer}
+	go m.serve(bufio.NewReader(toServer))
+
+	s := newServer(LSPConfig{Name: "mock-" + t.Name()}, t.TempDir(), t.Name())
+	s.connect(fromClient, toClient)
+	s.State = STATE_INITIALIZED
+	s.initialize()
+	t.Cleanup(func() {
+		// the server shuts down when it reads the end of the stream
+		fromServer.Close()
+		fromClient.Close()
+		slock.Lock()
+		delete(servers, s.language.Name+"-"+s.workspace)
+		slock.Unlock()
+	})
+
+	if !s.WaitReady(2 * time.Second) {
+		t.Fatal("initialization did not complete")
+	}
+	return s, m
+}
+
+func (m *mockServer) serve(r *bufio.Reader) {
+	for {
+		headers, err := readHeaders(r)
+		if err != nil { return }
+		n, _ := strconv.Atoi(headers["content-length"])
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil { return }
+
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil { return }
+
+		switch {
+		case msg.Method == "":
+			// a reply to a request of the server
+		case msg.ID == nil:
+			m.lock.Lock()
+			m.notifications = append(m.notifications, msg.Method)
+			m.params = append(m.params, msg.Params)
+			m.lock.Unlock()
+		case msg.Method == lsp.MethodInitialize:
+			m.reply(msg.ID, json.RawMessage(`{"capabilities":`+m.capabilities+`}`), nil)
+		case m.handlers[msg.Method] != nil:
+			result, err := m.handlers[msg.Method](msg.Params)
+			if err != errNoReply {
+				m.reply(msg.ID, result, err)
+			}
+		default:
+			m.reply(msg.ID, nil, fmt.Errorf("method %s not found", msg.Method))
+		}
+	}
+}
+
+func (m *mockServer) reply(id json.RawMessage, result interface{}, err error) {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result}
+	if err != nil {
+		delete(resp, "result")
+		resp["error"] = map[string]interface{}{"code": -32601, "message": err.Error()}
+	}
+	data, _ := json.Marshal(resp)
+	m.send(string(data))
+}
+
+// send writes a message from the server to the client
+func (m *mockServer) send(msg string) {
+	fmt.Fprintf(m.out, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+}
+
+// received returns the methods of the notifications the server received
+func (m *mockServer) received() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]string(nil), m.notifications...)
+}
+
+// paramsOf returns the params of the notifications of the method the server
+// received
+func (m *mockServer) paramsOf(method string) []json.RawMessage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var params []json.RawMessage
+	for i, n := range m.notifications {
+		if n == method { params = append(params, m.params[i]) }
+	}
+	return params
+}
+
+func TestMockHover(t *testing.T) {
+	s, m := startMockServer(t, `{"hoverProvider":true}`, map[string]mockHandler{
+		lsp.MethodTextDocumentHover: func(params json.RawMessage) (interface{}, error) {
+			var p lsp.HoverParams
+			json.Unmarshal(params, &p)
+			return map[string]interface{}{
+				"contents": map[string]string{"kind": "plaintext", "value": fmt.Sprintf("line %d", p.Position.Line)},
+			}, nil
+		},
+	})
+
+	info, err := s.Hover("/tmp/a.go", lsp.Position{Line: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "line 3", info)
+	assert.Contains(t, m.received(), lsp.MethodInitialized)
+
+	// capabilities the server didn't report aren't requested
+	_, err = s.GetDefinition("/tmp/a.go", lsp.Position{})
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestMockCompletionAndDefinition(t *testing.T) {
+	s, _ := startMockServer(t, `{"completionProvider":{},"definitionProvider":true}`, map[string]mockHandler{
+		lsp.MethodTextDocumentCompletion: func(json.RawMessage) (interface{}, error) {
+			return json.RawMessage(`{"isIncomplete":true,"items":[{"label":"Println"},{"label":"Printf"}]}`), nil
+		},
+		lsp.MethodTextDocumentDefinition: func(json.RawMessage) (interface{}, error) {
+			return json.RawMessage(`{"uri":"file:///tmp/b.go","range":{"start":{"line":2,"character":5},"end":{"line":2,"character":9}}}`), nil
+		},
+	})
+
+	res, err := s.Completion("/tmp/a.go", lsp.Position{})
+	assert.NoError(t, err)
+	assert.True(t, res.Incomplete)
+	assert.Len(t, res.Items, 2)
+
+	locs, err := s.GetDefinition("/tmp/a.go", lsp.Position{})
+	assert.NoError(t, err)
+	assert.Len(t, locs, 1)
+	assert.Equal(t, "/tmp/b.go", locs[0].URI.Filename())
+	assert.Equal(t, uint32(2), locs[0].Range.Start.Line)
+}
+
+func TestMockErrorAndTimeout(t *testing.T) {
+	old := requestTimeout
+	requestTimeout = 50 * time.Millisecond
+	defer func() { requestTimeout = old }()
+
+	s, _ := startMockServer(t, `{"hoverProvider":true,"definitionProvider":true}`, map[string]mockHandler{
+		lsp.MethodTextDocumentHover: func(json.RawMessage) (interface{}, error) {
+			return nil, errNoReply
+		},
+	})
+
+	_, err := s.Hover("/tmp/a.go", lsp.Position{})
+	assert.EqualError(t, err, "Request timed out")
+
+	// the mock server has no definition handler
+	_, err = s.GetDefinition("/tmp/a.go", lsp.Position{})
+	var rpcErr *RPCError
+	assert.ErrorAs(t, err, &rpcErr)
+}
+
+func TestMockReceive(t *testing.T) {
+	s, m := startMockServer(t, `{}`, nil)
+
+	s.DidOpen("/tmp/a.go", "go", "package a\n", 1)
+	m.send(`{"jsonrpc":"2.0","id":"edit-1","method":"workspace/applyEdit","params":{"edit":{"documentChanges":[` +
+		`{"kind":"rename","oldUri":"file:///tmp/a.go","newUri":"file:///tmp/b.go"}]}}}`)
+
+	select {
+	case req := <-EditRequests:
+		assert.Equal(t, s, req.Server)
+		assert.Len(t, req.Edit.DocumentChanges, 1)
+		assert.Equal(t, "/tmp/b.go", req.Edit.DocumentChanges[0].Rename.NewURI.Filename())
+	case <-time.After(time.Second):
+		t.Fatal("edit was not queued")
+	}
+	assert.Eventually(t, func() bool {
+		for _, method := range m.received() {
+			if method == lsp.MethodTextDocumentDidOpen { return true }
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMockFlushAndStop(t *testing.T) {
+	s, m := startMockServer(t, `{}`, nil)
+
+	// the exit notification is written before the writer is stopped
+	assert.NoError(t, s.sendNotification(lsp.MethodExit, nil))
+	s.flush(time.Second)
+	s.stopWriter()
+	assert.Eventually(t, func() bool {
+		for _, method := range m.received() {
+			if method == lsp.MethodExit { return true }
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	// messages sent after the writer stopped fail instead of blocking
+	assert.Error(t, s.enqueue(RPCNotification{}))
+}
+
+func TestMockDidChangeOrder(t *testing.T) {
+	s, m := startMockServer(t, `{}`, nil)
+
+	s.DidOpen("/tmp/a.go", "go", "", 1)
+	for i := 0; i < 50; i++ {
+		s.DidChange("/tmp/a.go", 0, []lsp.TextDocumentContentChangeEvent{{Text: strconv.Itoa(i)}})
+	}
+
+	var params []json.RawMessage
+	assert.Eventually(t, func() bool {
+		params = m.paramsOf(lsp.MethodTextDocumentDidChange)
+		return len(params) == 50
+	}, time.Second, 10*time.Millisecond)
+	// the versions reach the server in the order they were assigned
+	for i, p := range params {
+		var change lsp.DidChangeTextDocumentParams
+		json.Unmarshal(p, &change)
+		assert.Equal(t, int32(i+2), change.TextDocument.Version)
+	}
+}
+
+func TestMockExecuteCommand(t *testing.T) {
+	s, _ := startMockServer(t, `{"executeCommandProvider":{"commands":["echo"]}}`, map[string]mockHandler{
+		lsp.MethodWorkspaceExecuteCommand: func(params json.RawMessage) (interface{}, error) {
+			var p lsp.ExecuteCommandParams
+			json.Unmarshal(params, &p)
+			return map[string]interface{}{"command": p.Command, "args": p.Arguments}, nil
+		},
+	})
+
+	result, err := s.ExecuteCommand("echo", []interface{}{"a", 1})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"command":"echo","args":["a",1]}`, string(result))
+
+	s, _ = startMockServer(t, `{}`, nil)
+	_, err = s.ExecuteCommand("echo", nil)
+	assert.Equal(t, ErrNotSupported, err)
+}
@@ -1,11 +1,86 @@
 package lsp
 
 import (
+	"sync"
+
 	lsp "go.lsp.dev/protocol"
 	"go.lsp.dev/uri"
 )
 
+// DocumentContents returns the current text and version of an open file.
+// It is set by the editor, and used to reopen the documents of a server
+// after it restarts
+var DocumentContents func(filename string) (text string, version int32, ok bool)
+
+// openDocuments tracks the documents opened on a server, with their
+// language and the last version sent to the server
+type openDocuments struct {
+	lock     sync.Mutex
+	docs     map[string]string
+	versions map[string]int32
+}
+
+func (d *openDocuments) add(filename, language string, version int32) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.docs == nil {
+		d.docs = make(map[string]string)
+		d.versions = make(map[string]int32)
+	}
+	d.docs[filename] = language
+	d.versions[filename] = version
+}
+
+func (d *openDocuments) remove(filename string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.docs, filename)
+	delete(d.versions, filename)
+}
+
+// bump returns the next version of a document, which is at least min, and
+// records it as sent
+func (d *openDocuments) bump(filename string, min int32) int32 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.versions == nil { d.versions = make(map[string]int32) }
+	v := d.versions[filename] + 1
+	if min > v { v = min }
+	d.versions[filename] = v
+	return v
+}
+
+func (d *openDocuments) list() map[string]string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	docs := make(map[string]string, len(d.docs))
+	for f, l := range d.docs { docs[f] = l }
+	return docs
+}
+
+// reopenDocuments sends didOpen for every document that was open, with the
+// contents supplied by DocumentContents
+func (s *Server) reopenDocuments() {
+	if DocumentContents == nil { return }
+	for filename, language := range s.documents.list() {
+		text, version, ok := DocumentContents(filename)
+		if !ok {
+			s.documents.remove(filename)
+			continue
+		}
+		s.DidOpen(filename, language, text, version)
+	}
+}
+
+// NextVersion returns a new version for a document, higher than any version
+// sent for it so far
+func (s *Server) NextVersion(filename string) int32 {
+	return s.documents.bump(filename, 0)
+}
+
 func (s *Server) DidOpen(filename, language, text string, version int32) {
+	s.documents.add(filename, language, version)
+
 	doc := lsp.TextDocumentItem{
 		URI:        uri.File(filename),
 		LanguageID: lsp.LanguageIdentifier(language),
@@ -17,10 +92,12 @@ func (s *Server) DidOpen(filename, language, text string, version int32) {
 		TextDocument: doc,
 	}
 
-	go s.sendNotification(lsp.MethodTextDocumentDidOpen, params)
+	s.sendNotification(lsp.MethodTextDocumentDidOpen, params)
 }
 
 func (s *Server) DidSave(filename string) {
+	s.NextVersion(filename)
+
 	doc := lsp.TextDocumentIdentifier{
 		URI: uri.File(filename),
 	}
@@ -28,10 +105,15 @@ func (s *Server) DidSave(filename string) {
 	params := lsp.DidSaveTextDocumentParams{
 		TextDocument: doc,
 	}
-	go s.sendNotification(lsp.MethodTextDocumentDidSave, params)
+	s.sendNotification(lsp.MethodTextDocumentDidSave, params)
 }
 
+// DidChange notifies the server of changes to a document. Versions that
+// aren't higher than the last one sent are replaced by the next version, as
+// servers reject them
 func (s *Server) DidChange(filename string, version int32, changes []lsp.TextDocumentContentChangeEvent) {
+	version = s.documents.bump(filename, version)
+
 	doc := lsp.VersionedTextDocumentIdentifier{
 		TextDocumentIdentifier: lsp.TextDocumentIdentifier{
 			URI: uri.File(filename),
@@ -43,7 +125,7 @@ func (s *Server) DidChange(filename string, version int32, changes []lsp.TextDoc
 		TextDocument:   doc,
 		ContentChanges: changes,
 	}
-	go s.sendNotification(lsp.MethodTextDocumentDidChange, params)
+	s.sendNotification(lsp.MethodTextDocumentDidChange, params)
 }
 
 func (s *Server) DidClose(filename string) {
@@ -55,11 +137,14 @@ func (s *Server) DidClose(filename string) {
 		TextDocument: doc,
 	}
 
+	s.documents.remove(filename)
+
 	fileuri := uri.File(filename)
 	_, exists := s.diagnostics.Load(fileuri)
 	if exists {
 		s.diagnostics.Delete(fileuri)
 	}
+	s.diagnosticResults.Delete(fileuri)
 
-	go s.sendNotification(lsp.MethodTextDocumentDidClose, params)
+	s.sendNotification(lsp.MethodTextDocumentDidClose, params)
 }
@@ -0,0 +1,107 @@
+package lsp
+
+import (
+	"encoding/json"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Pull diagnostics were added in LSP 3.17, which go.lsp.dev/protocol doesn't
+// support yet, so the types used by micro are defined here
+
+const MethodTextDocumentDiagnostic = "textDocument/diagnostic"
+
+type DiagnosticClientCapabilities struct {
+	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+}
+
+type DiagnosticOptions struct {
+	Identifier string `json:"identifier,omitempty"`
+}
+
+type DocumentDiagnosticParams struct {
+	TextDocument     lsp.TextDocumentIdentifier `json:"textDocument"`
+	Identifier       string                     `json:"identifier,omitempty"`
+	PreviousResultID string                     `json:"previousResultId,omitempty"`
+}
+
+type DocumentDiagnosticReportKind string
+
+const (
+	// The report contains the full set of diagnostics
+	ReportFull DocumentDiagnosticReportKind = "full"
+	// The diagnostics didn't change since the report with the previous
+	// result id
+	ReportUnchanged DocumentDiagnosticReportKind = "unchanged"
+)
+
+type DocumentDiagnosticReport struct {
+	Kind     DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                       `json:"resultId,omitempty"`
+	Items    []lsp.Diagnostic             `json:"items,omitempty"`
+}
+
+type RPCDocumentDiagnostic = RPCResponse[DocumentDiagnosticReport]
+
+// SupportsPullDiagnostics returns true if the server answers
+// textDocument/diagnostic requests
+func (s *Server) SupportsPullDiagnostics() bool {
+	return s.extraCapabilities.DiagnosticProvider != nil
+}
+
+// PullDiagnostics requests the diagnostics of a file from servers that use
+// the pull model, and stores them like published diagnostics. If the server
+// reports that they didn't change, the stored diagnostics are returned
+func (s *Server) PullDiagnostics(filename string) ([]Diagnostic, error) {
+	if !s.SupportsPullDiagnostics() {
+		return nil, ErrNotSupported
+	}
+
+	fileuri := uri.File(filename)
+	params := DocumentDiagnosticParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: fileuri,
+		},
+		Identifier: s.extraCapabilities.DiagnosticProvider.Identifier,
+	}
+	if id, ok := s.diagnosticResults.Load(fileuri); ok {
+		params.PreviousResultID = id.(string)
+	}
+
+	resp, err := s.sendRequest(MethodTextDocumentDiagnostic, params)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := diagnosticReport(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if report.ResultID != "" {
+		s.diagnosticResults.Store(fileuri, report.ResultID)
+	} else {
+		s.diagnosticResults.Delete(fileuri)
+	}
+
+	if report.Kind == ReportUnchanged {
+		return s.loadDiagnostics(fileuri), nil
+	}
+
+	diags := convertDiagnostics(s, report.Items)
+	s.storeDiagnostics(fileuri, diags)
+	scheduleRedraw()
+	return diags, nil
+}
+
+func diagnosticReport(resp []byte) (DocumentDiagnosticReport, error) {
+	if isNullResult(resp) { return DocumentDiagnosticReport{Kind: ReportFull}, nil }
+
+	var r RPCDocumentDiagnostic
+	err := json.Unmarshal(resp, &r)
+	if err != nil {
+		return DocumentDiagnosticReport{}, err
+	}
+	return r.Result, nil
+}
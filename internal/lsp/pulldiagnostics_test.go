@@ -0,0 +1,66 @@
+package lsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullDiagnostics(t *testing.T) {
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+	defer fromClient.Close()
+	defer fromServer.Close()
+
+	s := &Server{
+		language:  &LSPConfig{Name: "mock-pull"},
+		stdin:     fromClient,
+		stdout:    bufio.NewReader(toClient),
+		responses: map[int]chan []byte{},
+		State:     STATE_RUNNING,
+	}
+	_, err := s.PullDiagnostics("/tmp/a.go")
+	assert.Equal(t, ErrNotSupported, err)
+
+	s.extraCapabilities.DiagnosticProvider = &DiagnosticOptions{Identifier: "mock"}
+	s.startWriter()
+	close(s.ready)
+	go s.receive()
+
+	// the mock server answers with a full report the first time, and
+	// reports that nothing changed when asked with the previous result id
+	previous := make(chan interface{}, 2)
+	go func() {
+		r := bufio.NewReader(toServer)
+		for {
+			m, err := readRPC(r)
+			if err != nil { return }
+			params := m["params"].(map[string]interface{})
+			previous <- params["previousResultId"]
+
+			resp := `{"jsonrpc":"2.0","id":%v,"result":{"kind":"unchanged","resultId":"1"}}`
+			if params["previousResultId"] == nil {
+				resp = `{"jsonrpc":"2.0","id":%v,"result":{"kind":"full","resultId":"1","items":[` +
+					`{"range":{"start":{"line":4,"character":0},"end":{"line":4,"character":1}},"message":"m"}]}}`
+			}
+			resp = fmt.Sprintf(resp, m["id"])
+			fmt.Fprintf(fromServer, "Content-Length: %d\r\n\r\n%s", len(resp), resp)
+		}
+	}()
+
+	diags, err := s.PullDiagnostics("/tmp/a.go")
+	assert.NoError(t, err)
+	assert.Len(t, diags, 1)
+	assert.Equal(t, uint32(4), diags[0].Range.Start.Line)
+	assert.Equal(t, s, diags[0].Server)
+	assert.Equal(t, diags, s.GetDiagnostics("/tmp/a.go"))
+	assert.Nil(t, <-previous)
+
+	diags, err = s.PullDiagnostics("/tmp/a.go")
+	assert.NoError(t, err)
+	assert.Len(t, diags, 1)
+	assert.Equal(t, "1", <-previous)
+}
@@ -96,26 +96,34 @@ type RPCLocationLinks = RPCResponse[[]lsp.LocationLink]
 type RPCRange = RPCResponse[lsp.Range]
 type RPCRangePlaceholder = RPCResponse[rangePlaceholder]
 type RPCRenameDefault = RPCResponse[renameDefault]
-type RPCRename = RPCResponse[lsp.WorkspaceEdit]
+type RPCRename = RPCResponse[WorkspaceEdit]
+type RPCSelectionRanges = RPCResponse[[]lsp.SelectionRange]
 
-func (s *Server) sendRequestChecked(method string, params interface{}) ([]byte, error) {
-	resp, err := s.sendRequest(method, params)
-	if err != nil {
-		return resp, err
-	}
+// MethodTextDocumentSelectionRange is missing from go.lsp.dev/protocol
+const MethodTextDocumentSelectionRange = "textDocument/selectionRange"
 
+// responseError returns the error of an error response, or nil if resp is a
+// result
+func responseError(resp []byte) error {
 	var rpcError RPCError
-	err = json.Unmarshal(resp, &rpcError)
+	err := json.Unmarshal(resp, &rpcError)
 	if err == nil && rpcError.LSPError != nil {
-		return resp, &rpcError
+		return &rpcError
 	}
+	return nil
+}
 
-	return resp, nil
+// isNullResult returns true if the response has a null or missing result,
+// which servers use to say that there is nothing to report
+func isNullResult(resp []byte) bool {
+	var r RPCResponse[json.RawMessage]
+	if err := json.Unmarshal(resp, &r); err != nil { return false }
+	return len(r.Result) == 0 || string(r.Result) == "null"
 }
 
 func sendUnmarshal[K any](s *Server, method string, params interface{}) (K, error) {
 	var empty K
-	resp, err := s.sendRequestChecked(method, params)
+	resp, err := s.sendRequest(method, params)
 	if err != nil { return empty, err }
 
 	var r RPCResponse[K]
@@ -182,9 +190,17 @@ func (s *Server) DocumentRangeFormat(filename string, r lsp.Range, options lsp.F
 	return unmarshalRangeFormat(s, params)
 }
 
-func (s *Server) Completion(filename string, pos lsp.Position) ([]lsp.CompletionItem, error) {
+// CompletionResult is the answer of a server to a completion request. If
+// Incomplete is true, the server only sent part of its completions and
+// expects to be asked again as the user keeps typing
+type CompletionResult struct {
+	Items      []lsp.CompletionItem
+	Incomplete bool
+}
+
+func (s *Server) Completion(filename string, pos lsp.Position) (CompletionResult, error) {
 	if !capabilityCheck(s.capabilities.CompletionProvider) {
-		return nil, ErrNotSupported
+		return CompletionResult{}, ErrNotSupported
 	}
 
 	cc := lsp.CompletionContext{
@@ -197,22 +213,28 @@ func (s *Server) Completion(filename string, pos lsp.Position) ([]lsp.Completion
 		TextDocumentPositionParams: docpos,
 		Context:                    &cc,
 	}
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentCompletion, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentCompletion, params)
 	if err != nil {
-		return nil, err
+		return CompletionResult{}, err
 	}
 
+	return completionItems(resp)
+}
+
+func completionItems(resp []byte) (CompletionResult, error) {
+	if isNullResult(resp) { return CompletionResult{}, nil }
+
 	var r RPCCompletion
-	err = json.Unmarshal(resp, &r)
+	err := json.Unmarshal(resp, &r)
 	if err == nil {
-		return r.Result.Items, nil
+		return CompletionResult{r.Result.Items, r.Result.IsIncomplete}, nil
 	}
 	var ra RPCCompletionAlt
 	err = json.Unmarshal(resp, &ra)
 	if err != nil {
-		return nil, err
+		return CompletionResult{}, err
 	}
-	return ra.Result, nil
+	return CompletionResult{Items: ra.Result}, nil
 }
 
 func (s *Server) extractString(value reflect.Value, original interface{}) (string, error) {
@@ -276,13 +298,19 @@ func (s *Server) Hover(filename string, pos lsp.Position) (string, error) {
 
 	params := positionParams(filename, pos)
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentHover, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentHover, params)
 	if err != nil {
 		return "", err
 	}
 
+	return s.hoverString(resp)
+}
+
+func (s *Server) hoverString(resp []byte) (string, error) {
+	if isNullResult(resp) { return "", nil }
+
 	var ra RPCHover
-	err = json.Unmarshal(resp, &ra)
+	err := json.Unmarshal(resp, &ra)
 	if err != nil {
 		return "", err
 	}
@@ -297,7 +325,7 @@ func (s *Server) GetDefinition(filename string, pos lsp.Position) ([]lsp.Locatio
 
 	params := positionParams(filename, pos)
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentDefinition, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentDefinition, params)
 	if err != nil {
 		return nil, err
 	}
@@ -312,7 +340,7 @@ func (s *Server) GetDeclaration(filename string, pos lsp.Position) ([]lsp.Locati
 
 	params := positionParams(filename, pos)
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentDeclaration, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentDeclaration, params)
 	if err != nil {
 		return nil, err
 	}
@@ -327,7 +355,7 @@ func (s *Server) GetTypeDefinition(filename string, pos lsp.Position) ([]lsp.Loc
 
 	params := positionParams(filename, pos)
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentTypeDefinition, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentTypeDefinition, params)
 	if err != nil {
 		return nil, err
 	}
@@ -347,7 +375,7 @@ func (s *Server) FindReferences(filename string, pos lsp.Position) ([]lsp.Locati
 		TextDocumentPositionParams: positionParams(filename, pos),
 	}
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentReferences, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentReferences, params)
 	if err != nil {
 		return nil, err
 	}
@@ -360,11 +388,16 @@ func (s *Server) GetRenameSymbol(filename string, pos lsp.Position) (RenameSymbo
 		return RenameSymbol{CanRename: false}, ErrNotSupported
 	}
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentPrepareRename, positionParams(filename, pos))
+	resp, err := s.sendRequest(lsp.MethodTextDocumentPrepareRename, positionParams(filename, pos))
 	if err != nil {
 		return RenameSymbol{CanRename: false}, err
 	}
 
+	// a null result means that there is nothing to rename at pos
+	if isNullResult(resp) {
+		return RenameSymbol{CanRename: false}, nil
+	}
+
 	var r RPCRange
 	err = json.Unmarshal(resp, &r)
 	if err == nil {
@@ -398,9 +431,9 @@ func (s *Server) GetRenameSymbol(filename string, pos lsp.Position) (RenameSymbo
 	return RenameSymbol{CanRename: false}, nil
 }
 
-func (s *Server) RenameSymbol(filename string, pos lsp.Position, new_name string) (lsp.WorkspaceEdit, error) {
+func (s *Server) RenameSymbol(filename string, pos lsp.Position, new_name string) (WorkspaceEdit, error) {
 	if !capabilityCheck(s.capabilities.RenameProvider) {
-		return lsp.WorkspaceEdit{}, ErrNotSupported
+		return WorkspaceEdit{}, ErrNotSupported
 	}
 
 	params := lsp.RenameParams {
@@ -408,20 +441,88 @@ func (s *Server) RenameSymbol(filename string, pos lsp.Position, new_name string
 		NewName: new_name,
 	}
 
-	resp, err := s.sendRequestChecked(lsp.MethodTextDocumentRename, params)
+	resp, err := s.sendRequest(lsp.MethodTextDocumentRename, params)
 	if err != nil {
-		return lsp.WorkspaceEdit{}, err
+		return WorkspaceEdit{}, err
 	}
 
 	var r RPCRename
 	err = json.Unmarshal(resp, &r)
 	if err != nil {
-		return lsp.WorkspaceEdit{}, err
+		return WorkspaceEdit{}, err
 	}
 
 	return r.Result, nil
 }
 
+// SelectionRanges returns, for each of the given positions, the innermost
+// syntactic range containing it, linked to its enclosing ranges
+func (s *Server) SelectionRanges(filename string, positions []lsp.Position) ([]lsp.SelectionRange, error) {
+	if !capabilityCheck(s.capabilities.SelectionRangeProvider) {
+		return nil, ErrNotSupported
+	}
+
+	params := lsp.SelectionRangeParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filename),
+		},
+		Positions: positions,
+	}
+
+	resp, err := s.sendRequest(MethodTextDocumentSelectionRange, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectionRanges(resp)
+}
+
+func selectionRanges(resp []byte) ([]lsp.SelectionRange, error) {
+	if isNullResult(resp) { return nil, nil }
+
+	var r RPCSelectionRanges
+	err := json.Unmarshal(resp, &r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Result, nil
+}
+
+// SelectionRangeChain flattens the parent chain of a selection range,
+// returning the ranges from the innermost to the outermost one
+func SelectionRangeChain(sr *lsp.SelectionRange) []lsp.Range {
+	var ranges []lsp.Range
+	for ; sr != nil; sr = sr.Parent {
+		ranges = append(ranges, sr.Range)
+	}
+	return ranges
+}
+
+// ExecuteCommand runs a command of the server, such as the ones attached to
+// code actions and completions, and returns its raw result
+func (s *Server) ExecuteCommand(command string, args []interface{}) (json.RawMessage, error) {
+	if s.capabilities.ExecuteCommandProvider == nil {
+		return nil, ErrNotSupported
+	}
+
+	params := lsp.ExecuteCommandParams{
+		Command:   command,
+		Arguments: args,
+	}
+
+	resp, err := s.sendRequest(lsp.MethodWorkspaceExecuteCommand, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var r RPCResponse[json.RawMessage]
+	err = json.Unmarshal(resp, &r)
+	if err != nil {
+		return nil, err
+	}
+	return r.Result, nil
+}
+
 func capabilityCheck(capability interface{}) bool {
 	b, ok := capability.(bool)
 	if ok {
@@ -440,6 +541,8 @@ func positionParams(filename string, pos lsp.Position) lsp.TextDocumentPositionP
 }
 
 func getLocations(resp []byte) ([]lsp.Location, error) {
+	if isNullResult(resp) { return nil, nil }
+
 	var r RPCLocation
 	err := json.Unmarshal(resp, &r)
 	if err == nil {
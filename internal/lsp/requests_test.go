@@ -0,0 +1,81 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var nullResult = []byte(`{"jsonrpc":"2.0","id":1,"result":null}`)
+
+func TestNullResult(t *testing.T) {
+	assert.True(t, isNullResult(nullResult))
+	assert.True(t, isNullResult([]byte(`{"jsonrpc":"2.0","id":1}`)))
+	assert.False(t, isNullResult([]byte(`{"jsonrpc":"2.0","id":1,"result":[]}`)))
+
+	locs, err := getLocations(nullResult)
+	assert.NoError(t, err)
+	assert.Nil(t, locs)
+
+	res, err := completionItems(nullResult)
+	assert.NoError(t, err)
+	assert.Nil(t, res.Items)
+
+	s := &Server{language: &LSPConfig{Name: "mock"}}
+	info, err := s.hoverString(nullResult)
+	assert.NoError(t, err)
+	assert.Equal(t, "", info)
+}
+
+func TestCompletionItems(t *testing.T) {
+	res, err := completionItems([]byte(`{"jsonrpc":"2.0","id":1,"result":` +
+		`{"isIncomplete":true,"items":[{"label":"foo"},{"label":"bar"}]}}`))
+	assert.NoError(t, err)
+	assert.True(t, res.Incomplete)
+	assert.Len(t, res.Items, 2)
+
+	// a plain array of items is always complete
+	res, err = completionItems([]byte(`{"jsonrpc":"2.0","id":1,"result":[{"label":"foo"}]}`))
+	assert.NoError(t, err)
+	assert.False(t, res.Incomplete)
+	assert.Equal(t, "foo", res.Items[0].Label)
+}
+
+func TestGetLocations(t *testing.T) {
+	locs, err := getLocations([]byte(`{"jsonrpc":"2.0","id":1,"result":` +
+		`{"uri":"file:///tmp/a.go","range":{"start":{"line":3,"character":1},"end":{"line":3,"character":4}}}}`))
+	assert.NoError(t, err)
+	assert.Len(t, locs, 1)
+	assert.Equal(t, uint32(3), locs[0].Range.Start.Line)
+}
+
+func TestSelectionRanges(t *testing.T) {
+	ranges, err := selectionRanges(nullResult)
+	assert.NoError(t, err)
+	assert.Nil(t, ranges)
+
+	ranges, err = selectionRanges([]byte(`{"jsonrpc":"2.0","id":1,"result":[` +
+		`{"range":{"start":{"line":1,"character":4},"end":{"line":1,"character":7}},"parent":` +
+		`{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":9}},"parent":` +
+		`{"range":{"start":{"line":0,"character":0},"end":{"line":3,"character":0}}}}}]}`))
+	assert.NoError(t, err)
+	assert.Len(t, ranges, 1)
+
+	chain := SelectionRangeChain(&ranges[0])
+	assert.Len(t, chain, 3)
+	assert.Equal(t, uint32(4), chain[0].Start.Character)
+	assert.Equal(t, uint32(9), chain[1].End.Character)
+	assert.Equal(t, uint32(3), chain[2].End.Line)
+}
+
+func TestResponseError(t *testing.T) {
+	assert.NoError(t, responseError([]byte(`{"jsonrpc":"2.0","id":1,"result":{"items":[]}}`)))
+	assert.NoError(t, responseError(nullResult))
+
+	err := responseError([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"unhandled method"}}`))
+	var rpcErr *RPCError
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, LSPError(MethodNotFound), rpcErr.LSPError.Code)
+		assert.EqualError(t, err, "MethodNotFound: unhandled method")
+	}
+}
@@ -0,0 +1,163 @@
+package lsp
+
+import (
+	"encoding/json"
+	"sort"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// SemanticTokensProviderOptions is the part of the server's semantic tokens
+// capability that micro needs: the legend used to decode the tokens
+type SemanticTokensProviderOptions struct {
+	Legend lsp.SemanticTokensLegend `json:"legend"`
+}
+
+type RPCSemanticTokens = RPCResponse[lsp.SemanticTokens]
+
+// SemanticToken is a decoded semantic token. Its position and length are
+// counted in the position encoding negotiated with the server
+type SemanticToken struct {
+	Line      uint32
+	StartChar uint32
+	Length    uint32
+	Type      lsp.SemanticTokenTypes
+	Modifiers []lsp.SemanticTokenModifiers
+}
+
+// semanticTokenGroups maps the standard token types to colorscheme groups.
+// Since colorscheme lookups fall back to the parent group, the more specific
+// groups don't need to be defined by every colorscheme
+var semanticTokenGroups = map[lsp.SemanticTokenTypes]string{
+	lsp.SemanticTokenNamespace:     "identifier.namespace",
+	lsp.SemanticTokenType:          "type",
+	lsp.SemanticTokenClass:         "identifier.class",
+	lsp.SemanticTokenEnum:          "type",
+	lsp.SemanticTokenInterface:     "type",
+	lsp.SemanticTokenStruct:        "type",
+	lsp.SemanticTokenTypeParameter: "type",
+	lsp.SemanticTokenParameter:     "identifier.var",
+	lsp.SemanticTokenVariable:      "identifier.var",
+	lsp.SemanticTokenProperty:      "identifier.var",
+	lsp.SemanticTokenEnumMember:    "constant",
+	lsp.SemanticTokenEvent:         "identifier",
+	lsp.SemanticTokenFunction:      "identifier.function",
+	lsp.SemanticTokenMethod:        "identifier.function",
+	lsp.SemanticTokenMacro:         "preproc",
+	lsp.SemanticTokenKeyword:       "statement",
+	lsp.SemanticTokenModifier:      "type.keyword",
+	lsp.SemanticTokenComment:       "comment",
+	lsp.SemanticTokenString:        "constant.string",
+	lsp.SemanticTokenNumber:        "constant.number",
+	lsp.SemanticTokenRegexp:        "constant.string",
+	lsp.SemanticTokenOperator:      "symbol.operator",
+	"decorator":                    "preproc",
+}
+
+// SemanticTokenGroup returns the colorscheme group used to highlight tokens
+// of the given type, or "" if the type is unknown
+func SemanticTokenGroup(t lsp.SemanticTokenTypes) string {
+	return semanticTokenGroups[t]
+}
+
+// semanticTokensCapabilities advertises support for all standard token types
+// and modifiers, in the relative format
+func semanticTokensCapabilities() *lsp.SemanticTokensClientCapabilities {
+	c := &lsp.SemanticTokensClientCapabilities{
+		Requests: lsp.SemanticTokensWorkspaceClientCapabilitiesRequests{
+			Full: true,
+		},
+		Formats: []lsp.TokenFormat{lsp.TokenFormatRelative},
+	}
+	for t := range semanticTokenGroups {
+		c.TokenTypes = append(c.TokenTypes, string(t))
+	}
+	sort.Strings(c.TokenTypes)
+	for _, m := range []lsp.SemanticTokenModifiers{
+		lsp.SemanticTokenModifierDeclaration, lsp.SemanticTokenModifierDefinition,
+		lsp.SemanticTokenModifierReadonly, lsp.SemanticTokenModifierStatic,
+		lsp.SemanticTokenModifierDeprecated, lsp.SemanticTokenModifierAbstract,
+		lsp.SemanticTokenModifierAsync, lsp.SemanticTokenModifierModification,
+		lsp.SemanticTokenModifierDocumentation, lsp.SemanticTokenModifierDefaultLibrary,
+	} {
+		c.TokenModifiers = append(c.TokenModifiers, string(m))
+	}
+	return c
+}
+
+// SemanticTokensLegend returns the legend the server uses to encode
+// semantic tokens
+func (s *Server) SemanticTokensLegend() lsp.SemanticTokensLegend {
+	if s.extraCapabilities.SemanticTokensProvider == nil {
+		return lsp.SemanticTokensLegend{}
+	}
+	return s.extraCapabilities.SemanticTokensProvider.Legend
+}
+
+// SemanticTokensFull returns the semantic tokens of the whole file, still
+// encoded. Use DecodeSemanticTokens with the server's legend to decode them
+func (s *Server) SemanticTokensFull(filename string) (lsp.SemanticTokens, error) {
+	if !capabilityCheck(s.capabilities.SemanticTokensProvider) {
+		return lsp.SemanticTokens{}, ErrNotSupported
+	}
+
+	params := lsp.SemanticTokensParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filename),
+		},
+	}
+
+	resp, err := s.sendRequest(lsp.MethodSemanticTokensFull, params)
+	if err != nil {
+		return lsp.SemanticTokens{}, err
+	}
+
+	return semanticTokens(resp)
+}
+
+func semanticTokens(resp []byte) (lsp.SemanticTokens, error) {
+	if isNullResult(resp) { return lsp.SemanticTokens{}, nil }
+
+	var r RPCSemanticTokens
+	err := json.Unmarshal(resp, &r)
+	if err != nil {
+		return lsp.SemanticTokens{}, err
+	}
+	return r.Result, nil
+}
+
+// DecodeSemanticTokens decodes the packed token data sent by a server. Each
+// token is five integers: the line delta, the start delta (relative to the
+// previous token if it's on the same line), the length, the index of the
+// type in the legend and a bitset of modifiers from the legend
+func DecodeSemanticTokens(data []uint32, legend lsp.SemanticTokensLegend) []SemanticToken {
+	tokens := make([]SemanticToken, 0, len(data)/5)
+
+	var line, start uint32
+	for i := 0; i+5 <= len(data); i += 5 {
+		dline, dstart := data[i], data[i+1]
+		if dline != 0 {
+			line += dline
+			start = dstart
+		} else {
+			start += dstart
+		}
+
+		tok := SemanticToken{
+			Line:      line,
+			StartChar: start,
+			Length:    data[i+2],
+		}
+		if t := data[i+3]; int(t) < len(legend.TokenTypes) {
+			tok.Type = legend.TokenTypes[t]
+		}
+		for m, mods := 0, data[i+4]; mods != 0 && m < len(legend.TokenModifiers); m, mods = m+1, mods>>1 {
+			if mods&1 != 0 {
+				tok.Modifiers = append(tok.Modifiers, legend.TokenModifiers[m])
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
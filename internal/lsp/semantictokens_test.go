@@ -0,0 +1,39 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestDecodeSemanticTokens(t *testing.T) {
+	legend := lsp.SemanticTokensLegend{
+		TokenTypes:     []lsp.SemanticTokenTypes{lsp.SemanticTokenVariable, lsp.SemanticTokenFunction},
+		TokenModifiers: []lsp.SemanticTokenModifiers{lsp.SemanticTokenModifierDeclaration, lsp.SemanticTokenModifierReadonly},
+	}
+
+	data := []uint32{
+		2, 5, 3, 0, 3, // line 2, char 5: readonly variable declaration
+		0, 10, 4, 1, 0, // line 2, char 15: function
+		1, 2, 7, 9, 0, // line 3, char 2: unknown type
+	}
+
+	tokens := DecodeSemanticTokens(data, legend)
+	assert.Equal(t, []SemanticToken{
+		{2, 5, 3, lsp.SemanticTokenVariable, []lsp.SemanticTokenModifiers{
+			lsp.SemanticTokenModifierDeclaration, lsp.SemanticTokenModifierReadonly}},
+		{2, 15, 4, lsp.SemanticTokenFunction, nil},
+		{3, 2, 7, "", nil},
+	}, tokens)
+
+	assert.Equal(t, "identifier.function", SemanticTokenGroup(tokens[1].Type))
+	assert.Equal(t, "", SemanticTokenGroup(tokens[2].Type))
+}
+
+func TestSemanticTokensNull(t *testing.T) {
+	st, err := semanticTokens(nullResult)
+	assert.NoError(t, err)
+	assert.Empty(t, st.Data)
+	assert.Empty(t, DecodeSemanticTokens(st.Data, lsp.SemanticTokensLegend{}))
+}
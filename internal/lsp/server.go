@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"fmt"
 	"runtime/debug"
@@ -29,6 +30,13 @@ type Diagnostic struct {
 	Server *Server
 }
 
+// Location is a location sent by a server. The server is kept since its
+// characters are counted in the encoding negotiated with it
+type Location struct {
+	lsp.Location
+	Server *Server
+}
+
 const (
 	STATE_CREATED STATE = iota
 	STATE_INITIALIZED
@@ -51,38 +59,61 @@ var slock sync.Mutex
 
 func init() {
 	servers = make(map[string]*Server)
+	redraw.Store(screen.Redraw)
 }
 
 func getServer(l LSPConfig, dir string) *Server {
+	slock.Lock()
+	defer slock.Unlock()
 	s, ok := servers[l.Name+"-"+dir]
 	if !ok { return nil }
 	return s
 }
 
-func GetOrStartServer(l LSPConfig, dir string, path string) *Server {
-	if !l.Valid_For(path) { return nil }
+// allServers returns the servers that were started. The servers are
+// copied, so that they can be used without holding slock
+func allServers() []*Server {
+	slock.Lock()
+	defer slock.Unlock()
+	list := make([]*Server, 0, len(servers))
+	for _, s := range servers {
+		list = append(list, s)
+	}
+	return list
+}
 
-	s := getServer(l, dir)
+// GetOrStartServer returns the server handling the file at path, starting
+// it if needed. Files in the same project root share a server; dir is used
+// as the root when none is found
+func GetOrStartServer(l LSPConfig, dir string, path string) (*Server, error) {
+	root := l.FindRoot(path, dir)
+	if !l.Valid_For(path, root) { return nil, nil }
+
+	s := getServer(l, root)
 	if s == nil {
 		var err error
-		s, err = startServer(l, dir)
-		if err == nil {
-			s.initialize()
-		} else {
+		s, err = startServer(l, root)
+		if err != nil {
 			log.Println(dir, l.Name, "failed to start server: ", err)
+			return nil, err
 		}
+		s.initialize()
 	} else if s.State == STATE_CREATED {
-		s.runCommand()
+		err := s.runCommand()
+		if err != nil {
+			s.Log("failed to restart server:", err)
+			return nil, err
+		}
 		s.initialize()
 	}
 
-	return s
+	return s, nil
 }
 
 func GetActiveServerNames() []string {
 	var activeServers []string
 
-	for _, server := range servers {
+	for _, server := range allServers() {
 		if server.State != STATE_CREATED {
 			activeServers = append(activeServers, server.language.Name)
 		}
@@ -92,7 +123,7 @@ func GetActiveServerNames() []string {
 }
 
 func ShutdownAllServers() {
-	for _, s := range servers {
+	for _, s := range allServers() {
 		if s.State != STATE_CREATED {
 			s.Shutdown()
 		}
@@ -106,11 +137,33 @@ type Server struct {
 	language     *LSPConfig
 	capabilities lsp.ServerCapabilities
 	root         string
+	workspace    string
 	lock         sync.Mutex
 	State        STATE
 	requestID    int
 	responses    map[int]chan ([]byte)
+	rlock        sync.Mutex
+	// wlock guards queue and stop, which are replaced when the server is
+	// restarted, and overflow
+	wlock        sync.Mutex
+	queue        chan interface{}
+	ready        chan struct{}
+	stop         chan struct{}
+	// overflow holds the messages that didn't fit in the queue, in order.
+	// feeding is true while a goroutine moves them to the queue
+	overflow     []interface{}
+	feeding      bool
 	diagnostics  sync.Map
+	documents    openDocuments
+	encoding     PositionEncodingKind
+
+	extraCapabilities LSPServerCapabilities
+	// the result id of the last diagnostics pulled for each file
+	diagnosticResults sync.Map
+	// the files the server asked to be notified about
+	watcher fileWatcher
+	// the name and version the server sent when it was initialized
+	info *lsp.ServerInfo
 }
 
 type RPCRequest struct {
@@ -132,10 +185,20 @@ type RPCInit struct {
 	Result     lsp.InitializeResult `json:"result"`
 }
 
+// RPCResult is used to find out what kind of message was received. The ID
+// is kept raw since requests from the server may use string IDs
 type RPCResult struct {
-	RPCVersion string `json:"jsonrpc"`
-	ID         int    `json:"id,omitempty"`
-	Method     string `json:"method,omitempty"`
+	RPCVersion string          `json:"jsonrpc"`
+	ID         json.RawMessage `json:"id,omitempty"`
+	Method     string          `json:"method,omitempty"`
+	Error      json.RawMessage `json:"error,omitempty"`
+}
+
+// RPCReply is a response to a request sent by the server
+type RPCReply struct {
+	RPCVersion string          `json:"jsonrpc"`
+	ID         json.RawMessage `json:"id"`
+	Result     interface{}     `json:"result"`
 }
 
 type RPCDiag struct {
@@ -145,6 +208,39 @@ type RPCDiag struct {
 	Params lsp.PublishDiagnosticsParams `json:"params"`
 }
 
+type RPCApplyEdit struct {
+	RPCVersion string          `json:"jsonrpc"`
+	ID         json.RawMessage `json:"id"`
+	Method     string          `json:"method"`
+	Params     struct {
+		Label string        `json:"label,omitempty"`
+		Edit  WorkspaceEdit `json:"edit"`
+	} `json:"params"`
+}
+
+// EditRequest is a workspace edit the server asked micro to apply. The
+// server waits for the result, which is sent by Done
+type EditRequest struct {
+	Server *Server
+	Edit   WorkspaceEdit
+	id     json.RawMessage
+}
+
+// Done replies to the server whether the edit was applied
+func (r EditRequest) Done(err error) {
+	resp := lsp.ApplyWorkspaceEditResponse{Applied: err == nil}
+	if err != nil { resp.FailureReason = err.Error() }
+	r.Server.sendReply(r.id, resp)
+}
+
+// EditRequests receives the workspace edits requested by servers. They are
+// applied by the main loop, since buffers can't be edited from the goroutine
+// that receives messages
+var EditRequests = make(chan EditRequest, 16)
+
+// errEditsPending is the failure reason of the edits that arrive while
+// EditRequests is full
+var errEditsPending = errors.New("too many workspace edits are pending")
 
 func env_to_strs(env map[string]string) []string {
 	var out []string
@@ -171,7 +267,14 @@ func (s *Server) state_guard(states ...STATE) error {
 		states_string += state.String()
 	}
 
-	return errors.New("Expected state to be " + states_string + ", but " + s.language.Name + " is " + s.State.String())
+	return errors.New("Expected state to be " + states_string + ", but " + s.name() + " is " + s.State.String())
+}
+
+// name returns the name of the language of the server, or a placeholder for
+// servers that were never configured, such as in tests
+func (s *Server) name() string {
+	if s.language == nil { return "the server" }
+	return s.language.Name
 }
 
 func (s *Server) runCommand() error {
@@ -210,23 +313,172 @@ func (s *Server) runCommand() error {
 	}
 
 	s.cmd = c
+	s.connect(stdin, stdout)
+
+	return nil
+}
+
+// connect sets the streams used to talk to the server and starts writing
+// the queued messages. It is separate from runCommand so that tests can
+// connect to a server that isn't a process
+func (s *Server) connect(stdin io.WriteCloser, stdout io.Reader) {
 	s.stdin = stdin
-	s.stdout = bufio.NewReader(stdout)
+	s.stdout = bufio.NewReaderSize(stdout, readerSize)
+	s.startWriter()
+}
+
+func (s *Server) startWriter() {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	s.queue = make(chan interface{}, 64)
+	s.ready = make(chan struct{})
+	s.stop = make(chan struct{})
+	s.overflow, s.feeding = nil, false
+	go s.writeLoop(s.queue, s.ready, s.stop)
+}
+
+// stopWriter stops the goroutine started by startWriter. Messages that are
+// still queued are dropped
+func (s *Server) stopWriter() {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.overflow, s.feeding = nil, false
+}
+
+// Initialized returns a channel that is closed once the initialize
+// handshake is done. Messages sent before that are held until then
+func (s *Server) Initialized() <-chan struct{} {
+	return s.ready
+}
+
+// Ready reports whether the initialize handshake is done
+func (s *Server) Ready() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitReady waits up to timeout for the initialize handshake to be done,
+// and reports whether it is
+func (s *Server) WaitReady(timeout time.Duration) bool {
+	select {
+	case <-s.Initialized():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// writeLoop writes the queued messages in order. Until the initialize
+// handshake is done, only the initialize request is written. Queued flush
+// channels are closed once the messages queued before them are written
+func (s *Server) writeLoop(queue chan interface{}, ready, stop chan struct{}) {
+	for {
+		select {
+		case m := <-queue:
+			if r, ok := m.(RPCRequest); !ok || r.Method != lsp.MethodInitialize {
+				select {
+				case <-ready:
+				case <-stop: return
+				}
+			}
+			if done, ok := m.(chan struct{}); ok {
+				close(done)
+				continue
+			}
+			err := s.send(m)
+			if err != nil { s.Log(err) }
+		case <-stop:
+			return
+		}
+	}
+}
 
+// enqueue queues a message to be written by writeLoop. It never blocks, since
+// it's called from the main loop: when the queue is full, the message is
+// added to the overflow, which a goroutine moves to the queue in order
+func (s *Server) enqueue(m interface{}) error {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+
+	if s.stop == nil { return errors.New(s.name() + " is not running") }
+	if len(s.overflow) == 0 {
+		select {
+		case s.queue <- m:
+			return nil
+		default:
+		}
+	}
+	s.overflow = append(s.overflow, m)
+	if !s.feeding {
+		s.feeding = true
+		go s.feedQueue(s.queue, s.stop)
+	}
 	return nil
 }
 
-func startServer(l LSPConfig, dir string) (*Server, error) {
-	s := new(Server)
+// feedQueue moves the overflow to the queue, until it is empty or the
+// writer is stopped
+func (s *Server) feedQueue(queue chan interface{}, stop chan struct{}) {
+	for {
+		s.wlock.Lock()
+		if s.stop != stop { s.wlock.Unlock(); return }
+		if len(s.overflow) == 0 {
+			s.feeding = false
+			s.wlock.Unlock()
+			return
+		}
+		m := s.overflow[0]
+		s.wlock.Unlock()
 
+		select {
+		case queue <- m:
+		case <-stop:
+			return
+		}
+
+		s.wlock.Lock()
+		if s.stop == stop { s.overflow = s.overflow[1:] }
+		s.wlock.Unlock()
+	}
+}
+
+// flushTimeout is how long the messages queued before stopping a server,
+// such as the exit notification, are given to be written
+const flushTimeout = time.Second
+
+// flush waits up to timeout for the messages queued so far to be written
+func (s *Server) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	if s.enqueue(done) != nil { return }
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func newServer(l LSPConfig, root, workspace string) *Server {
+	return &Server{
+		root:      root,
+		workspace: workspace,
+		language:  &l,
+		responses: make(map[int]chan []byte),
+	}
+}
+
+func startServer(l LSPConfig, dir string) (*Server, error) {
 	cwd, err := l.GetCwd()
 	if err != nil { return nil, err }
 	if len(cwd) == 0 { cwd = dir }
 
-	s.root = cwd
-	s.language = &l
-	s.responses = make(map[int]chan []byte)
-
+	s := newServer(l, cwd, dir)
 	err = s.runCommand()
 	if err != nil { return nil, err }
 	s.State = STATE_INITIALIZED
@@ -235,28 +487,51 @@ func startServer(l LSPConfig, dir string) (*Server, error) {
 }
 
 func (s *Server) Log(args ...any) {
-	tp := []any{"[lsp: "+s.GetLanguage().Name+"]"}
+	tp := []any{"[lsp: "+s.name()+"]"}
 	tp = append(tp, args...)
 	log.Println(tp...)
 }
 
-type PositionEncodingKind string
-
-const (
-	PEK_UTF8  PositionEncodingKind = "utf-8"
-	PEK_UTF16 PositionEncodingKind = "utf-16"
-	PEK_UTF32 PositionEncodingKind = "utf-32"
-)
-
 type LSPInitGeneral struct {
 	PositionEncodings []PositionEncodingKind `json:"positionEncodings,omitempty"`
 }
 
+// LSPTextDocumentClientCapabilities adds the LSP 3.17 text document
+// capabilities that go.lsp.dev/protocol doesn't know about
+type LSPTextDocumentClientCapabilities struct {
+	*lsp.TextDocumentClientCapabilities
+	InlayHint  *InlayHintClientCapabilities  `json:"inlayHint,omitempty"`
+	Diagnostic *DiagnosticClientCapabilities `json:"diagnostic,omitempty"`
+}
+
+// LSPClientCapabilities adds the LSP 3.17 capabilities, which
+// go.lsp.dev/protocol doesn't know about, to the client capabilities
+type LSPClientCapabilities struct {
+	lsp.ClientCapabilities
+	TextDocument LSPTextDocumentClientCapabilities `json:"textDocument"`
+	General      LSPInitGeneral                    `json:"general,omitempty"`
+}
+
 type LSPInit struct {
 	lsp.InitializeParams
-	General LSPInitGeneral `json:"general,omitempty"`
+	Capabilities LSPClientCapabilities `json:"capabilities"`
 }
 
+// LSPServerCapabilities holds the server capabilities that
+// go.lsp.dev/protocol doesn't know about
+type LSPServerCapabilities struct {
+	PositionEncoding       PositionEncodingKind           `json:"positionEncoding"`
+	InlayHintProvider      interface{}                    `json:"inlayHintProvider,omitempty"`
+	SemanticTokensProvider *SemanticTokensProviderOptions `json:"semanticTokensProvider,omitempty"`
+	DiagnosticProvider     *DiagnosticOptions             `json:"diagnosticProvider,omitempty"`
+}
+
+// RPCInitExtra is used to read the LSPServerCapabilities from the
+// initialize response
+type RPCInitExtra = RPCResponse[struct {
+	Capabilities LSPServerCapabilities `json:"capabilities"`
+}]
+
 // initialize performs the LSP initialization handshake
 // The directory must be an absolute path
 func (s *Server) initialize() {
@@ -288,15 +563,23 @@ func (s *Server) initialize() {
 				{ Name: path.Base(s.root), URI: string(uri.File(s.root)) },
 			},
 			InitializationOptions: options,
-			Capabilities: lsp.ClientCapabilities{
+		},
+		Capabilities: LSPClientCapabilities{
+			ClientCapabilities: lsp.ClientCapabilities{
 				Workspace: &lsp.WorkspaceClientCapabilities{
 					WorkspaceEdit: &lsp.WorkspaceClientCapabilitiesWorkspaceEdit{
 						DocumentChanges:    true,
 						ResourceOperations: []string{"create", "rename", "delete"},
 					},
 					ApplyEdit: true,
+					ExecuteCommand: &lsp.ExecuteCommandClientCapabilities{},
+					DidChangeWatchedFiles: &lsp.DidChangeWatchedFilesWorkspaceClientCapabilities{
+						DynamicRegistration: true,
+					},
 				},
-				TextDocument: &lsp.TextDocumentClientCapabilities{
+			},
+			TextDocument: LSPTextDocumentClientCapabilities{
+				TextDocumentClientCapabilities: &lsp.TextDocumentClientCapabilities{
 					PublishDiagnostics: &lsp.PublishDiagnosticsClientCapabilities{},
 					Formatting: &lsp.DocumentFormattingClientCapabilities{
 						DynamicRegistration: true,
@@ -325,40 +608,65 @@ func (s *Server) initialize() {
 						DynamicRegistration: true,
 						ContentFormat:       []lsp.MarkupKind{lsp.PlainText},
 					},
+					SelectionRange: &lsp.SelectionRangeClientCapabilities{},
+					SemanticTokens: semanticTokensCapabilities(),
 				},
+				InlayHint: &InlayHintClientCapabilities{},
+				Diagnostic: &DiagnosticClientCapabilities{},
+			},
+			General: LSPInitGeneral{
+				PositionEncodings: supportedEncodings,
 			},
-		},
-		General: LSPInitGeneral{
-			PositionEncodings: []PositionEncodingKind{PEK_UTF8},
 		},
 	}
 
-	servers[s.language.Name+"-"+s.root] = s
+	slock.Lock()
+	servers[s.language.Name+"-"+s.workspace] = s
+	slock.Unlock()
 	s.State = STATE_RUNNING
 
 	go s.receive()
 
-	s.lock.Lock()
+	// the initialize request is queued before returning, so that it is
+	// ahead of anything sent while the handshake is in progress
+	ready := s.ready
+	id, r, err := s.queueRequest(lsp.MethodInitialize, params)
+	if err != nil {
+		s.Log(err)
+		s.Murder()
+		return
+	}
+
 	go func() {
-		resp, err := s.sendRequest(lsp.MethodInitialize, params)
+		resp, err := s.awaitResponse(id, r)
 		if err != nil {
 			s.Log(err)
 			s.Murder()
-			s.lock.Unlock()
 			return
 		}
 
-		// todo parse capabilities
 		s.Log("<<<", string(resp))
 
 		var r RPCInit
 		json.Unmarshal(resp, &r)
-
-		s.lock.Unlock()
-		err = s.sendNotification(lsp.MethodInitialized, struct{}{})
-		if err != nil { s.Log(err) }
-
 		s.capabilities = r.Result.Capabilities
+		s.info = r.Result.ServerInfo
+
+		var extra RPCInitExtra
+		json.Unmarshal(resp, &extra)
+		s.extraCapabilities = extra.Result.Capabilities
+		s.encoding = negotiatedEncoding(s.extraCapabilities.PositionEncoding)
+		s.Log("Using position encoding", s.encoding)
+
+		// initialized must be the first message after the handshake, so
+		// it skips the queue, which is held until ready is closed
+		err = s.send(RPCNotification{
+			RPCVersion: "2.0",
+			Method:     lsp.MethodInitialized,
+			Params:     struct{}{},
+		})
+		if err != nil { s.Log(err) }
+		close(ready)
 	}()
 }
 
@@ -366,6 +674,29 @@ func (s *Server) GetLanguage() *LSPConfig {
 	return s.language
 }
 
+// Info returns the name and version the server reported when it was
+// initialized. Servers don't have to report them, in which case the name
+// of the language server configuration and an empty version are returned
+func (s *Server) Info() (name, version string) {
+	if s.info == nil || s.info.Name == "" {
+		return s.name(), ""
+	}
+	return s.info.Name, s.info.Version
+}
+
+// Status describes the server and its state, such as
+// "gopls v0.14.2 (running)"
+func (s *Server) Status() string {
+	name, version := s.Info()
+	if version != "" {
+		if !strings.HasPrefix(version, "v") && version[0] >= '0' && version[0] <= '9' {
+			version = "v" + version
+		}
+		name += " " + version
+	}
+	return name + " (" + s.State.String() + ")"
+}
+
 func (s *Server) GetCommand() *exec.Cmd {
 	return s.cmd
 }
@@ -374,6 +705,7 @@ func (s *Server) Shutdown() {
 	if s.state_guard(STATE_INITIALIZED, STATE_RUNNING) != nil { return }
 	s.sendRequest(lsp.MethodShutdown, nil)
 	s.sendNotification(lsp.MethodExit, nil)
+	s.flush(flushTimeout)
 	s.Murder()
 }
 
@@ -387,7 +719,9 @@ func (s *Server) Murder() {
 	}()
 
 	s.State = STATE_CREATED
-	if s.cmd.ProcessState.ExitCode() == -1 {
+	s.stopWriter()
+	s.watcher.stopPolling()
+	if s.cmd != nil && s.cmd.ProcessState.ExitCode() == -1 {
 		s.cmd.Process.Kill()
 	}
 	s.cmd = nil
@@ -398,9 +732,15 @@ func (s *Server) Restart() {
 	s.State = STATE_RESTARTING
 	s.sendRequest(lsp.MethodShutdown, nil)
 	s.sendNotification(lsp.MethodExit, nil)
+	s.flush(flushTimeout)
 	s.Murder()
-	s.runCommand()
+	if err := s.runCommand(); err != nil {
+		s.Log("failed to restart server:", err)
+		return
+	}
 	s.initialize()
+	// queued behind the initialize handshake
+	s.reopenDocuments()
 }
 
 func convertDiagnostics(s *Server, diags []lsp.Diagnostic) []Diagnostic {
@@ -413,7 +753,9 @@ func convertDiagnostics(s *Server, diags []lsp.Diagnostic) []Diagnostic {
 }
 
 func (s *Server) storeDiagnostics(uri uri.URI, diag []Diagnostic) {
+	slock.Lock()
 	s.diagnostics.Store(uri, diag)
+	slock.Unlock()
 }
 
 func (s *Server) loadDiagnostics(uri uri.URI) []Diagnostic {
@@ -422,6 +764,27 @@ func (s *Server) loadDiagnostics(uri uri.URI) []Diagnostic {
 	return diags.([]Diagnostic)
 }
 
+// redrawInterval is the minimum time between two redraws requested by
+// server messages, so that servers republishing diagnostics on every
+// keystroke don't cause a redraw storm
+const redrawInterval = 16 * time.Millisecond
+
+var redrawPending int32
+
+// redraw holds the func() called by the redraw timers. It's atomic so that
+// it can be replaced while timers are pending
+var redraw atomic.Value
+
+// scheduleRedraw coalesces redraw requests: at most one redraw is issued
+// per redrawInterval, no matter how many messages arrive in between
+func scheduleRedraw() {
+	if !atomic.CompareAndSwapInt32(&redrawPending, 0, 1) { return }
+	time.AfterFunc(redrawInterval, func() {
+		atomic.StoreInt32(&redrawPending, 0)
+		redraw.Load().(func())()
+	})
+}
+
 func (s *Server) receive() {
 	for s.State != STATE_CREATED {
 		resp, err := s.receiveMessage()
@@ -448,7 +811,9 @@ func (s *Server) receive() {
 		case lsp.MethodWindowLogMessage:
 			// TODO
 		case lsp.MethodClientRegisterCapability:
+			s.registerCapabilities(resp)
 		case lsp.MethodClientUnregisterCapability:
+			s.unregisterCapabilities(resp)
 		case lsp.MethodTextDocumentPublishDiagnostics:
 			var diag RPCDiag
 			err = json.Unmarshal(resp, &diag)
@@ -459,16 +824,70 @@ func (s *Server) receive() {
 			fileuri := uri.URI(string(diag.Params.URI))
 			s.Log("Got diagnostics", fileuri, diag.Params.Diagnostics)
 			s.storeDiagnostics(fileuri, convertDiagnostics(s, diag.Params.Diagnostics))
-		case "":
-			// Response
-			if _, ok := s.responses[r.ID]; ok {
-				s.Log("Got response for", r.ID)
-				s.responses[r.ID] <- resp
+		case lsp.MethodWorkspaceApplyEdit:
+			var edit RPCApplyEdit
+			err = json.Unmarshal(resp, &edit)
+			if err != nil {
+				s.Log("Apply edit error:", err)
+				s.sendReply(r.ID, lsp.ApplyWorkspaceEditResponse{
+					Applied:       false,
+					FailureReason: err.Error(),
+				})
+				continue
+			}
+			// The main loop replies once it applied the edit. Waiting for it
+			// here would stop the responses the main loop may be waiting for
+			req := EditRequest{Server: s, Edit: edit.Params.Edit, id: r.ID}
+			select {
+			case EditRequests <- req:
+			default:
+				req.Done(errEditsPending)
 			}
+		case "":
+			s.deliverResponse(r, resp)
 		}
 	}
 }
 
+func (s *Server) forgetResponse(id int) {
+	s.rlock.Lock()
+	delete(s.responses, id)
+	s.rlock.Unlock()
+}
+
+// deliverResponse passes a response, or an error response, to the request
+// waiting for it. Responses nobody is waiting for anymore are dropped
+func (s *Server) deliverResponse(r RPCResult, resp []byte) {
+	if len(r.ID) == 0 || string(r.ID) == "null" {
+		s.Log("Got response without id:", string(resp))
+		return
+	}
+	id, err := strconv.Atoi(string(r.ID))
+	if err != nil {
+		s.Log("Got response with unknown id", string(r.ID))
+		return
+	}
+	if len(r.Error) > 0 {
+		s.Log("Got error response for", id, string(r.Error))
+	} else {
+		s.Log("Got response for", id)
+	}
+
+	s.rlock.Lock()
+	ch, ok := s.responses[id]
+	s.rlock.Unlock()
+	if !ok {
+		s.Log("dropped late response for id", id)
+		return
+	}
+
+	select {
+	case ch <- resp:
+	default:
+		s.Log("dropped late response for id", id)
+	}
+}
+
 func Style(d *Diagnostic) tcell.Style {
 	switch d.Severity {
 	case lsp.DiagnosticSeverityInformation:
@@ -500,6 +919,52 @@ func (s *Server) DiagnosticsCount(filename string) int {
 	return len(diags)
 }
 
+// readerSize is the buffer size of the reader of the server's output. It
+// is also the longest header line accepted
+const readerSize = 64 * 1024
+
+// defaultMaxMessageSize is the largest message accepted from a server if
+// the lspmaxmessage option isn't set
+const defaultMaxMessageSize = 64 * 1024 * 1024
+
+// maxMessage is the largest message accepted from a server. It's set from
+// the main loop and read by the receive loops, so it's accessed atomically
+var maxMessage int64 = defaultMaxMessageSize
+
+// SetMaxMessageSize sets the largest message accepted from a server, in
+// megabytes, as done by the lspmaxmessage option
+func SetMaxMessageSize(mb float64) {
+	if mb > 0 { atomic.StoreInt64(&maxMessage, int64(mb*1024*1024)) }
+}
+
+func maxMessageSize() int {
+	return int(atomic.LoadInt64(&maxMessage))
+}
+
+// readHeaders reads the headers of a message, up to the blank line that
+// ends them. Blank lines before the headers are skipped. Since header names
+// are case insensitive, they are returned in lower case. Only the first
+// colon of a line separates the name from the value, and lines without one
+// are ignored. Lines longer than the buffer of r are an error
+func readHeaders(r *bufio.Reader) (map[string]string, error) {
+	headers := make(map[string]string)
+	for {
+		b, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull { return nil, errors.New("message header line too long") }
+		if err != nil { return nil, err }
+
+		line := strings.TrimSpace(string(b))
+		if line == "" {
+			if len(headers) == 0 { continue }
+			return headers, nil
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok { continue }
+		headers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+}
+
 func (s *Server) receiveMessage() (outbyte []byte, err error) {
 	defer func() {
 		if r:= recover(); r != nil {
@@ -507,24 +972,22 @@ func (s *Server) receiveMessage() (outbyte []byte, err error) {
 			err = fmt.Errorf("pkg: %v", r)
 			outbyte = nil
 		} else {
-			go screen.Redraw();
+			scheduleRedraw()
 		}
 	}()
 
-	n := -1
-	for {
-		b, err := s.stdout.ReadBytes('\n')
-		if err != nil { s.Log(err) ; return nil, err }
-
-		headerline := strings.TrimSpace(string(b))
-		if len(headerline) == 0 { break }
-
-		if strings.HasPrefix(headerline, "Content-Length:") {
-			split := strings.Split(headerline, ":")
-			if len(split) <= 1 { break }
-			n, err = strconv.Atoi(strings.TrimSpace(split[1]))
-			if err != nil { s.Log(err) ; return nil, err }
-		}
+	headers, err := readHeaders(s.stdout)
+	if err != nil { s.Log(err) ; return nil, err }
+
+	length, ok := headers["content-length"]
+	if !ok { return nil, errors.New("message without a Content-Length header") }
+	n, err := strconv.Atoi(length)
+	if err != nil { s.Log(err) ; return nil, err }
+	if max := maxMessageSize(); n > max {
+		// skip the message without allocating it, so the next one can
+		// still be read
+		if _, err := io.CopyN(io.Discard, s.stdout, int64(n)); err != nil { return nil, err }
+		return nil, fmt.Errorf("message of %d bytes is larger than the maximum of %d", n, max)
 	}
 
 	if n <= 0 {
@@ -548,20 +1011,30 @@ func (s *Server) sendNotification(method string, params interface{}) error {
 		Params:     params,
 	}
 
-	s.lock.Lock()
-	go s.sendMessageUnlock(m)
-	return nil
+	return s.enqueue(m)
 }
 
+// sendRequest sends a request and waits for its response. Error responses
+// are returned as an *RPCError, along with the response
 func (s *Server) sendRequest(method string, params interface{}) ([]byte, error) {
+	id, r, err := s.queueRequest(method, params)
+	if err != nil { return nil, err }
+	return s.awaitResponse(id, r)
+}
+
+// queueRequest queues a request and returns the channel its response will
+// be delivered on
+func (s *Server) queueRequest(method string, params interface{}) (int, chan []byte, error) {
 	if err := s.state_guard(STATE_INITIALIZED, STATE_RUNNING, STATE_RESTARTING) ; err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
+	s.rlock.Lock()
 	id := s.requestID
 	s.requestID++
-	r := make(chan []byte)
+	r := make(chan []byte, 1)
 	s.responses[id] = r
+	s.rlock.Unlock()
 
 	m := RPCRequest{
 		RPCVersion: "2.0",
@@ -570,19 +1043,28 @@ func (s *Server) sendRequest(method string, params interface{}) ([]byte, error)
 		Params:     params,
 	}
 
-	err := s.sendMessage(m)
+	err := s.enqueue(m)
 	if err != nil {
 		s.Log(err)
-		return nil, err
+		s.forgetResponse(id)
+		return 0, nil, err
 	}
+	return id, r, nil
+}
 
+// requestTimeout is how long to wait for the response to a request
+var requestTimeout = 5 * time.Second
+
+func (s *Server) awaitResponse(id int, r chan []byte) ([]byte, error) {
 	var bytes []byte
+	var err error
 	select {
 	case bytes = <-r:
-	case <-time.After(5 * time.Second):
+		err = responseError(bytes)
+	case <-time.After(requestTimeout):
 		err = errors.New("Request timed out")
 	}
-	delete(s.responses, id)
+	s.forgetResponse(id)
 
 	if err != nil { s.Log(err) }
 
@@ -611,7 +1093,24 @@ func (s *Server) sendMessage(m interface{}) error {
 	return err
 }
 
-func (s *Server) sendMessageUnlock(m interface{}) error {
+// sendReply answers a request sent by the server. The reply is sent in the
+// background so that the receive loop is never blocked waiting for the lock
+func (s *Server) sendReply(id json.RawMessage, result interface{}) {
+	m := RPCReply{
+		RPCVersion: "2.0",
+		ID:         id,
+		Result:     result,
+	}
+
+	go func() {
+		err := s.send(m)
+		if err != nil { s.Log(err) }
+	}()
+}
+
+// send writes a message right away. Use enqueue to keep messages in order
+func (s *Server) send(m interface{}) error {
+	s.lock.Lock()
 	defer s.lock.Unlock()
 	return s.sendMessage(m)
 }
@@ -0,0 +1,517 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestDiagnosticsRedrawDebounce(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 100; i++ {
+		msg := fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics",`+
+			`"params":{"uri":"file:///tmp/a.go","diagnostics":[{"range":{"start":{"line":%d,"character":0},`+
+			`"end":{"line":%d,"character":1}},"message":"m"}]}}`, i, i)
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+
+	var count int32
+	old := redraw.Load()
+	redraw.Store(func() { atomic.AddInt32(&count, 1) })
+	defer redraw.Store(old)
+
+	s := &Server{
+		language: &LSPConfig{Name: "mock"},
+		stdout:   bufio.NewReader(&buf),
+		State:    STATE_RUNNING,
+	}
+
+	start := time.Now()
+	s.receive()
+	elapsed := time.Since(start)
+	time.Sleep(4 * redrawInterval)
+
+	diags := s.GetDiagnostics("/tmp/a.go")
+	assert.Len(t, diags, 1)
+	assert.Equal(t, uint32(99), diags[0].Range.Start.Line)
+
+	n := int(atomic.LoadInt32(&count))
+	assert.GreaterOrEqual(t, n, 1)
+	assert.LessOrEqual(t, n, int(elapsed/redrawInterval)+2)
+}
+
+// syncBuffer collects the messages sent to a mock server
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Close() error { return nil }
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func rpcMessages(msgs ...string) *bufio.Reader {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return bufio.NewReader(&buf)
+}
+
+func TestReceiveResponseAndRequest(t *testing.T) {
+	stdin := &syncBuffer{}
+	s := &Server{
+		language:  &LSPConfig{Name: "mock"},
+		stdin:     stdin,
+		State:     STATE_RUNNING,
+		responses: map[int]chan []byte{},
+		stdout: rpcMessages(
+			`{"jsonrpc":"2.0","id":"req-1","method":"workspace/applyEdit","params":{"edit":{"changes":{"file:///tmp/a.go":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}},"newText":"x"}]}}}}`,
+			`{"jsonrpc":"2.0","id":7,"result":null}`,
+		),
+	}
+
+	r := make(chan []byte, 1)
+	s.responses[7] = r
+	s.receive()
+
+	select {
+	case resp := <-r:
+		assert.Contains(t, string(resp), `"id":7`)
+	default:
+		t.Error("response was not dispatched")
+	}
+
+	select {
+	case req := <-EditRequests:
+		assert.Equal(t, s, req.Server)
+		assert.Len(t, req.Edit.Changes, 1)
+		// the server only gets the reply once the edit was applied
+		time.Sleep(20 * time.Millisecond)
+		assert.NotContains(t, stdin.String(), `"id":"req-1"`)
+		req.Done(nil)
+	default:
+		t.Fatal("edit was not queued")
+	}
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stdin.String(), `"id":"req-1"`)
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, stdin.String(), `"applied":true`)
+}
+
+func TestReceiveEditsPending(t *testing.T) {
+	for len(EditRequests) < cap(EditRequests) {
+		EditRequests <- EditRequest{}
+	}
+	defer func() {
+		for len(EditRequests) > 0 {
+			<-EditRequests
+		}
+	}()
+
+	stdin := &syncBuffer{}
+	s := &Server{
+		language:  &LSPConfig{Name: "mock"},
+		stdin:     stdin,
+		State:     STATE_RUNNING,
+		responses: map[int]chan []byte{},
+		stdout: rpcMessages(
+			`{"jsonrpc":"2.0","id":"req-1","method":"workspace/applyEdit","params":{"edit":{}}}`,
+		),
+	}
+
+	// a full queue doesn't block the receive loop, the edit is refused
+	s.receive()
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stdin.String(), `"id":"req-1"`)
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, stdin.String(), `"applied":false`)
+	assert.Contains(t, stdin.String(), errEditsPending.Error())
+}
+
+func TestWriteLoopWaitsForInitialize(t *testing.T) {
+	stdin := &syncBuffer{}
+	s := &Server{
+		language: &LSPConfig{Name: "mock"},
+		stdin:    stdin,
+		queue:    make(chan interface{}, 8),
+		ready:    make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	go s.writeLoop(s.queue, s.ready, s.stop)
+	defer close(s.stop)
+
+	assert.NoError(t, s.enqueue(RPCRequest{RPCVersion: "2.0", Method: "initialize"}))
+	assert.NoError(t, s.enqueue(RPCNotification{RPCVersion: "2.0", Method: "textDocument/didOpen"}))
+
+	// the notification holds the queue until the handshake is done
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stdin.String(), `"initialize"`)
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.NotContains(t, stdin.String(), "didOpen")
+
+	assert.NoError(t, s.send(RPCNotification{RPCVersion: "2.0", Method: "initialized"}))
+	close(s.ready)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stdin.String(), "didOpen")
+	}, time.Second, 10*time.Millisecond)
+	out := stdin.String()
+	assert.Less(t, strings.Index(out, `"initialized"`), strings.Index(out, "didOpen"))
+}
+
+// readRPC reads one message sent to a mock server
+func readRPC(r *bufio.Reader) (map[string]interface{}, error) {
+	n := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil { return nil, err }
+		line = strings.TrimSpace(line)
+		if line == "" { break }
+		fmt.Sscanf(line, "Content-Length: %d", &n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil { return nil, err }
+
+	var m map[string]interface{}
+	err := json.Unmarshal(body, &m)
+	return m, err
+}
+
+func TestDidOpenWaitsForInitialized(t *testing.T) {
+	isolateServers(t)
+	toServer, fromClient := io.Pipe()
+	toClient, fromServer := io.Pipe()
+
+	s := &Server{
+		language:  &LSPConfig{Name: "mock-ready"},
+		root:      t.TempDir(),
+		stdin:     fromClient,
+		stdout:    bufio.NewReader(toClient),
+		responses: map[int]chan []byte{},
+		State:     STATE_INITIALIZED,
+	}
+	s.startWriter()
+	// initialize registers the server, and it shuts down when it reads the
+	// end of the stream
+	t.Cleanup(func() {
+		fromServer.Close()
+		fromClient.Close()
+		slock.Lock()
+		delete(servers, s.language.Name+"-"+s.workspace)
+		slock.Unlock()
+	})
+
+	// the mock server rejects notifications sent before initialized
+	rejected := make(chan string, 8)
+	opened := make(chan struct{})
+	go func() {
+		r := bufio.NewReader(toServer)
+		initialized := false
+		for {
+			m, err := readRPC(r)
+			if err != nil { return }
+			switch m["method"] {
+			case "initialize":
+				time.Sleep(50 * time.Millisecond)
+				resp := `{"jsonrpc":"2.0","id":0,"result":{"capabilities":{}}}`
+				fmt.Fprintf(fromServer, "Content-Length: %d\r\n\r\n%s", len(resp), resp)
+			case "initialized":
+				initialized = true
+			case "textDocument/didOpen":
+				if !initialized { rejected <- "didOpen" }
+				close(opened)
+			default:
+				if !initialized { rejected <- fmt.Sprint(m["method"]) }
+			}
+		}
+	}()
+
+	assert.False(t, s.Ready())
+	s.initialize()
+	s.DidOpen("/tmp/main.go", "go", "package main\n", 1)
+
+	select {
+	case <-s.Initialized():
+	case <-time.After(2 * time.Second):
+		t.Fatal("initialization did not complete")
+	}
+	assert.True(t, s.Ready())
+
+	select {
+	case <-opened:
+	case <-time.After(2 * time.Second):
+		t.Fatal("didOpen was not sent")
+	}
+	assert.Empty(t, rejected)
+}
+
+func TestDeliverResponse(t *testing.T) {
+	s := &Server{
+		language:  &LSPConfig{Name: "mock"},
+		responses: map[int]chan []byte{},
+	}
+	r := make(chan []byte, 1)
+	s.responses[3] = r
+
+	msg := func(raw string) (RPCResult, []byte) {
+		var res RPCResult
+		assert.NoError(t, json.Unmarshal([]byte(raw), &res))
+		return res, []byte(raw)
+	}
+
+	// unknown, late and duplicate responses must not block
+	s.deliverResponse(msg(`{"jsonrpc":"2.0","id":9,"result":null}`))
+	s.deliverResponse(msg(`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`))
+	s.deliverResponse(msg(`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"no"}}`))
+	s.deliverResponse(msg(`{"jsonrpc":"2.0","id":3,"result":null}`))
+
+	select {
+	case resp := <-r:
+		assert.Contains(t, string(resp), `"error"`)
+	default:
+		t.Error("error response was not delivered")
+	}
+	assert.Empty(t, r)
+}
+
+func TestRestartReopensDocuments(t *testing.T) {
+	s := &Server{
+		language: &LSPConfig{Name: "mock"},
+		State:    STATE_RUNNING,
+		queue:    make(chan interface{}, 8),
+		stop:     make(chan struct{}),
+	}
+
+	old := DocumentContents
+	defer func() { DocumentContents = old }()
+	DocumentContents = func(filename string) (string, int32, bool) {
+		if filename == "/tmp/a.go" { return "package a\n", 7, true }
+		return "", 0, false
+	}
+
+	opened := func() map[string]lsp.TextDocumentItem {
+		// notifications are queued in the background
+		docs := make(map[string]lsp.TextDocumentItem)
+		for {
+			select {
+			case m := <-s.queue:
+				n := m.(RPCNotification)
+				if n.Method == lsp.MethodTextDocumentDidOpen {
+					doc := n.Params.(lsp.DidOpenTextDocumentParams).TextDocument
+					docs[doc.URI.Filename()] = doc
+				}
+			case <-time.After(50 * time.Millisecond):
+				return docs
+			}
+		}
+	}
+
+	s.DidOpen("/tmp/a.go", "go", "", 1)
+	s.DidOpen("/tmp/b.go", "go", "", 1)
+	s.DidOpen("/tmp/c.go", "go", "", 1)
+	s.DidClose("/tmp/c.go")
+	assert.Len(t, opened(), 3)
+
+	s.reopenDocuments()
+	docs := opened()
+	assert.Len(t, docs, 1)
+	assert.Equal(t, "package a\n", docs["/tmp/a.go"].Text)
+	assert.Equal(t, int32(7), docs["/tmp/a.go"].Version)
+	assert.Equal(t, lsp.LanguageIdentifier("go"), docs["/tmp/a.go"].LanguageID)
+
+	// documents that aren't open in the editor anymore are forgotten
+	assert.Len(t, s.documents.list(), 1)
+}
+
+func TestDocumentVersions(t *testing.T) {
+	s := &Server{
+		language: &LSPConfig{Name: "mock"},
+		State:    STATE_RUNNING,
+		queue:    make(chan interface{}, 8),
+		stop:     make(chan struct{}),
+	}
+
+	s.DidOpen("/tmp/a.go", "go", "", 5)
+	assert.Equal(t, int32(6), s.NextVersion("/tmp/a.go"))
+
+	// stale versions are replaced, newer ones are kept
+	s.DidChange("/tmp/a.go", 6, nil)
+	assert.Equal(t, int32(8), s.NextVersion("/tmp/a.go"))
+	s.DidChange("/tmp/a.go", 20, nil)
+	s.DidSave("/tmp/a.go")
+	assert.Equal(t, int32(22), s.NextVersion("/tmp/a.go"))
+
+	s.DidClose("/tmp/a.go")
+	assert.Equal(t, int32(1), s.NextVersion("/tmp/a.go"))
+}
+
+func TestEnqueueOverflow(t *testing.T) {
+	s := &Server{
+		language: &LSPConfig{Name: "mock"},
+		State:    STATE_RUNNING,
+		queue:    make(chan interface{}, 2),
+		stop:     make(chan struct{}),
+	}
+
+	// nothing reads the queue yet, but sending doesn't block
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			s.sendNotification(strconv.Itoa(i), nil)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("sending blocked on a full queue")
+	}
+
+	// the messages that didn't fit reach the queue in order
+	for i := 0; i < 10; i++ {
+		select {
+		case m := <-s.queue:
+			assert.Equal(t, strconv.Itoa(i), m.(RPCNotification).Method)
+		case <-time.After(time.Second):
+			t.Fatal("message", i, "was not queued")
+		}
+	}
+
+	// the overflow is dropped when the writer stops
+	for i := 0; i < 4; i++ {
+		s.sendNotification("late", nil)
+	}
+	s.stopWriter()
+	assert.Error(t, s.sendNotification("stopped", nil))
+}
+
+func TestServerInfo(t *testing.T) {
+	s := &Server{language: &LSPConfig{Name: "go"}, State: STATE_RUNNING}
+	name, version := s.Info()
+	assert.Equal(t, "go", name)
+	assert.Equal(t, "", version)
+	assert.Equal(t, "go (running)", s.Status())
+
+	var r RPCInit
+	json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{},`+
+		`"serverInfo":{"name":"gopls","version":"0.14.2"}}}`), &r)
+	s.info = r.Result.ServerInfo
+	name, version = s.Info()
+	assert.Equal(t, "gopls", name)
+	assert.Equal(t, "0.14.2", version)
+	assert.Equal(t, "gopls v0.14.2 (running)", s.Status())
+
+	s.info.Version = "devel"
+	assert.Equal(t, "gopls devel (running)", s.Status())
+}
+
+func TestReceiveMessageHeaders(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"result":null}`
+	stream := "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + body +
+		// lower case names, extra whitespace and blank lines between messages
+		fmt.Sprintf("\r\n  content-length :  %d  \r\n", len(body)) +
+		"X-Note: a:b:c\r\n" +
+		"garbage\r\n\r\n" + body +
+		"Content-Type: application/vscode-jsonrpc\r\n\r\n"
+
+	s := &Server{
+		language: &LSPConfig{Name: "mock-headers"},
+		stdout:   bufio.NewReader(strings.NewReader(stream)),
+	}
+	msg, err := s.receiveMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(msg))
+
+	msg, err = s.receiveMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(msg))
+
+	_, err = s.receiveMessage()
+	assert.Error(t, err)
+	_, err = s.receiveMessage()
+	assert.Equal(t, io.EOF, err)
+
+	headers, err := readHeaders(bufio.NewReader(strings.NewReader("A: 1\r\nX-Note: a:b\r\n\r\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "x-note": "a:b"}, headers)
+}
+
+func TestReceiveMessageTooLarge(t *testing.T) {
+	body := `{"jsonrpc":"2.0","id":1,"result":null}`
+	huge := strings.Repeat("x", 2*1024*1024)
+	stream := fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(huge), huge) +
+		fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body) +
+		"X-Long: " + strings.Repeat("y", readerSize) + "\r\n\r\n"
+
+	SetMaxMessageSize(1)
+	t.Cleanup(func() { SetMaxMessageSize(defaultMaxMessageSize / (1024 * 1024)) })
+
+	s := &Server{
+		language: &LSPConfig{Name: "mock-large"},
+		stdout:   bufio.NewReaderSize(strings.NewReader(stream), readerSize),
+	}
+	_, err := s.receiveMessage()
+	assert.Error(t, err)
+
+	// the large message is skipped, so the next one is read normally
+	msg, err := s.receiveMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(msg))
+
+	_, err = s.receiveMessage()
+	assert.EqualError(t, err, "message header line too long")
+}
+
+func TestUnconfiguredServer(t *testing.T) {
+	s := &Server{}
+	name, version := s.Info()
+	assert.Equal(t, "the server", name)
+	assert.Equal(t, "", version)
+	assert.Error(t, s.sendNotification(lsp.MethodExit, nil))
+}
+
+func TestServersLock(t *testing.T) {
+	isolateServers(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s := &Server{language: &LSPConfig{Name: "locked"}, workspace: strconv.Itoa(i)}
+			slock.Lock()
+			servers[s.language.Name+"-"+s.workspace] = s
+			slock.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		GetActiveServerNames()
+		getServer(LSPConfig{Name: "locked"}, strconv.Itoa(i))
+	}
+	<-done
+	assert.NotNil(t, getServer(LSPConfig{Name: "locked"}, "99"))
+}
@@ -1,102 +1,92 @@
 package lsp
 
 var servers_internal = []byte(`
-- name: [ "rls", ]
+- name: "rls"
   languages: [ "rust", ]
-  command: [ "rls", ]
+  command: "rls"
   install: [ [ "rustup", "update", ], [ "rustup", "component", "add", "rls", "rust-analysis", "rust-src", ], ]
+  rootmarkers: [ "Cargo.toml", ]
 
-- name: [ "typescript-language-server", ]
-  languages: [ "javascript", ]
-  command: [ "typescript-language-server", ]
+- name: "typescript-language-server"
+  languages: [ "javascript", "typescript", ]
+  command: "typescript-language-server"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "typescript-language-server", ], ]
+  rootmarkers: [ "tsconfig.json", "jsconfig.json", "package.json", ]
 
-- name: [ "typescript-language-server", ]
-  languages: [ "typescript", ]
-  command: [ "typescript-language-server", ]
-  args: [ "--stdio", ]
-  install: [ [ "npm", "install", "-g", "typescript-language-server", ], ]
-
-- name: [ "html-languageserver", ]
+- name: "html-languageserver"
   languages: [ "html", ]
-  command: [ "html-languageserver", ]
+  command: "html-languageserver"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "vscode-html-languageserver-bin", ], ]
 
-- name: [ "ocaml-language-server", ]
+- name: "ocaml-language-server"
   languages: [ "ocaml", ]
-  command: [ "ocaml-language-server", ]
+  command: "ocaml-language-server"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "ocaml-language-server", ], ]
 
-- name: [ "pyls", ]
+- name: "pyls"
   languages: [ "python", ]
-  command: [ "pyls", ]
+  command: "pyls"
   install: [ [ "pip", "install", "python-language-server", ], ]
+  rootmarkers: [ "pyproject.toml", "setup.py", "setup.cfg", ]
 
-- name: [ "clangd", ]
-  languages: [ "c", ]
-  command: [ "clangd", ]
-  args: [ ]
-
-- name: [ "clangd", ]
-  languages: [ "cpp", ]
-  command: [ "clangd", ]
+- name: "clangd"
+  languages: [ "c", "cpp", ]
+  command: "clangd"
   args: [ ]
+  rootmarkers: [ "compile_commands.json", "compile_flags.txt", ".git", ]
 
-- name: [ "hie", ]
+- name: "hie"
   languages: [ "haskell", ]
-  command: [ "hie", ]
+  command: "hie"
   args: [ "--lsp", ]
 
-- name: [ "gopls", ]
+- name: "gopls"
   languages: [ "go", ]
-  command: [ "gopls", ]
+  command: "gopls"
   args: [ "serve", ]
   install: [ [ "go", "get", "-u", "golang.org/x/tools/gopls", ], ]
+  rootmarkers: [ "go.work", "go.mod", ]
 
-- name: [ "dart_language_server", ]
+- name: "dart_language_server"
   languages: [ "dart", ]
-  command: [ "dart_language_server", ]
+  command: "dart_language_server"
   install: [ [ "pub", "global", "activate", "dart_language_server", ], ]
+  rootmarkers: [ "pubspec.yaml", ]
 
-- name: [ "solargraph", ]
+- name: "solargraph"
   languages: [ "ruby", ]
-  command: [ "solargraph", ]
+  command: "solargraph"
   args: [ "stdio", ]
   install: [ [ "gem", "install", "solargraph", ], ]
+  rootmarkers: [ "Gemfile", ]
 
-- name: [ "css-languageserver", ]
-  languages: [ "css", ]
-  command: [ "css-languageserver", ]
-  args: [ "--stdio", ]
-  install: [ [ "npm", "install", "-g", "vscode-css-languageserver-bin", ], ]
-
-- name: [ "css-languageserver", ]
-  languages: [ "scss", ]
-  command: [ "css-languageserver", ]
+- name: "css-languageserver"
+  languages: [ "css", "scss", ]
+  command: "css-languageserver"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "vscode-css-languageserver-bin", ], ]
 
-- name: [ "vim-language-server", ]
+- name: "vim-language-server"
   languages: [ "viml", ]
-  command: [ "vim-language-server", ]
+  command: "vim-language-server"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "vim-language-server", ], ]
 
-- name: [ "purescript-language-server", ]
+- name: "purescript-language-server"
   languages: [ "purescript", ]
-  command: [ "purescript-language-server", ]
+  command: "purescript-language-server"
   args: [ "--stdio", ]
   install: [ [ "npm", "install", "-g", "purescript-language-server", ], ]
 
-- name: [ "svls", ]
+- name: "svls"
   languages: [ "verilog", ]
-  command: [ "svls", ]
+  command: "svls"
   install: [ [ "cargo", "install", "svls", ], ]
 
-- name: [ "serve-d", ]
+- name: "serve-d"
   languages: [ "d", ]
-  command: [ "serve-d", ]
+  command: "serve-d"
 `)
@@ -0,0 +1,395 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// Servers ask to be told about changes to files matching glob patterns by
+// registering workspace/didChangeWatchedFiles with client/registerCapability.
+// micro doesn't depend on a file system notification library such as
+// fsnotify, so the files aren't actually watched: the workspace is polled
+// for changes to the watched files instead, every watchInterval. Changes
+// are reported late, and a change undone between two scans isn't seen.
+// Scans run on their own goroutine, so that they never hold up the
+// messages of the server
+
+// watchInterval is the time between two scans of the workspace
+const watchInterval = 2 * time.Second
+
+// scanLimit is the number of files and directories a scan visits at most,
+// so that a workspace rooted at a large directory, like the home directory,
+// doesn't keep micro busy. Files after the limit aren't watched
+const scanLimit = 20000
+
+// skippedDirs are the directories that scans don't descend into, besides
+// hidden ones. They hold dependencies and build output, which are large and
+// rarely edited by hand
+var skippedDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"target":       true,
+	"__pycache__":  true,
+}
+
+var errScanLimit = errors.New("scan limit reached")
+
+type RPCRegistration struct {
+	RPCVersion string          `json:"jsonrpc"`
+	ID         json.RawMessage `json:"id"`
+	Method     string          `json:"method"`
+	Params     struct {
+		Registrations []struct {
+			ID              string          `json:"id"`
+			Method          string          `json:"method"`
+			RegisterOptions json.RawMessage `json:"registerOptions,omitempty"`
+		} `json:"registrations"`
+	} `json:"params"`
+}
+
+type RPCUnregistration struct {
+	RPCVersion string                   `json:"jsonrpc"`
+	ID         json.RawMessage          `json:"id"`
+	Method     string                   `json:"method"`
+	Params     lsp.UnregistrationParams `json:"params"`
+}
+
+// GlobPattern is the pattern of a file system watcher. Since LSP 3.17 it
+// can be relative to a base directory instead of the workspace
+type GlobPattern struct {
+	BaseURI uri.URI
+	Pattern string
+}
+
+func (g *GlobPattern) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &g.Pattern); err == nil { return nil }
+
+	var rel struct {
+		BaseURI json.RawMessage `json:"baseUri"`
+		Pattern string          `json:"pattern"`
+	}
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return err
+	}
+	g.Pattern = rel.Pattern
+
+	// the base is either a URI or a workspace folder
+	var folder lsp.WorkspaceFolder
+	if err := json.Unmarshal(rel.BaseURI, &g.BaseURI); err != nil {
+		if err := json.Unmarshal(rel.BaseURI, &folder); err != nil {
+			return err
+		}
+		g.BaseURI = uri.URI(folder.URI)
+	}
+	return nil
+}
+
+type FileSystemWatcher struct {
+	GlobPattern GlobPattern   `json:"globPattern"`
+	Kind        lsp.WatchKind `json:"kind,omitempty"`
+}
+
+type DidChangeWatchedFilesRegistrationOptions struct {
+	Watchers []FileSystemWatcher `json:"watchers"`
+}
+
+// watchAll is the default kind of a watcher: create, change and delete
+const watchAll = int(lsp.WatchKindCreate) | int(lsp.WatchKindChange) | int(lsp.WatchKindDelete)
+
+// fileWatch is a compiled FileSystemWatcher
+type fileWatch struct {
+	base string
+	re   *regexp.Regexp
+	// a bitset of lsp.WatchKind, which is a float in go.lsp.dev/protocol
+	kind int
+}
+
+func (w fileWatch) matches(root, path string) bool {
+	if w.base != "" {
+		rel, err := filepath.Rel(w.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") { return false }
+		return w.re.MatchString(filepath.ToSlash(rel))
+	}
+
+	if w.re.MatchString(filepath.ToSlash(path)) { return true }
+	rel, err := filepath.Rel(root, path)
+	return err == nil && w.re.MatchString(filepath.ToSlash(rel))
+}
+
+// globRegexp converts an LSP glob pattern to a regular expression. Globs
+// support *, ? and [] within a path segment, ** for any number of segments
+// and {} for alternatives
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	group := 0
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					re.WriteString("(?:.*/)?")
+				} else {
+					re.WriteString(".*")
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '{':
+			group++
+			re.WriteString("(?:")
+		case '}':
+			if group == 0 {
+				re.WriteString(`\}`)
+				continue
+			}
+			group--
+			re.WriteString(")")
+		case ',':
+			if group == 0 {
+				re.WriteString(",")
+				continue
+			}
+			re.WriteString("|")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") { class = "^" + class[1:] }
+			re.WriteString("[" + class + "]")
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// watchSet maps registration ids to their watches
+type watchSet map[string][]fileWatch
+
+// fileWatcher keeps the watches registered by a server and the modification
+// times of the files they matched in the last scan
+type fileWatcher struct {
+	lock    sync.Mutex
+	watches watchSet
+	// files is nil until the first scan after the watches changed
+	files map[string]time.Time
+	// gen counts the changes to the watches, so that scans that started
+	// before a change are dropped
+	gen  int
+	stop chan struct{}
+}
+
+// register adds the watchers of a registration. If they are the first ones,
+// polling must be started, and it returns the channel that stops it.
+// Otherwise it returns nil. The files the watches match are only known
+// after the next poll
+func (w *fileWatcher) register(root, id string, opts DidChangeWatchedFilesRegistrationOptions) chan struct{} {
+	var watches []fileWatch
+	for _, fw := range opts.Watchers {
+		re, err := globRegexp(fw.GlobPattern.Pattern)
+		if err != nil { continue }
+		kind := int(fw.Kind)
+		if kind == 0 { kind = watchAll }
+		base := ""
+		if fw.GlobPattern.BaseURI != "" { base = fw.GlobPattern.BaseURI.Filename() }
+		watches = append(watches, fileWatch{base, re, kind})
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	first := len(w.watches) == 0
+	if w.watches == nil { w.watches = make(watchSet) }
+	w.watches[id] = watches
+	// files that start being watched now aren't reported as created
+	w.files = nil
+	w.gen++
+	if !first { return nil }
+
+	// the channel is created here rather than by the poller, so that
+	// stopPolling never misses a poller that is about to start
+	if w.stop != nil { close(w.stop) }
+	w.stop = make(chan struct{})
+	return w.stop
+}
+
+// unregister removes the watchers of a registration. It returns true if no
+// watchers are left, in which case polling must be stopped
+func (w *fileWatcher) unregister(id string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, ok := w.watches[id]; !ok { return false }
+	delete(w.watches, id)
+	w.gen++
+	return len(w.watches) == 0
+}
+
+// scan returns the modification times of the files under root matched by
+// any watch. Hidden directories, such as .git, and skippedDirs are skipped
+func (ws watchSet) scan(root string) map[string]time.Time {
+	files := make(map[string]time.Time)
+	visited := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil { return nil }
+		if visited++; visited > scanLimit { return errScanLimit }
+		if d.IsDir() {
+			if path == root { return nil }
+			if strings.HasPrefix(d.Name(), ".") || skippedDirs[d.Name()] { return filepath.SkipDir }
+			return nil
+		}
+		if ws.kindFor(root, path) == 0 { return nil }
+		if info, err := d.Info(); err == nil {
+			files[path] = info.ModTime()
+		}
+		return nil
+	})
+	return files
+}
+
+// kindFor returns the kinds of events the watches want for the file
+func (ws watchSet) kindFor(root, path string) int {
+	kind := 0
+	for _, watches := range ws {
+		for _, fw := range watches {
+			if fw.matches(root, path) { kind |= fw.kind }
+		}
+	}
+	return kind
+}
+
+// poll scans root again and returns the changes since the previous scan.
+// The lock isn't held during the scan, so that registrations don't wait
+// for it
+func (w *fileWatcher) poll(root string) []*lsp.FileEvent {
+	w.lock.Lock()
+	watches := make(watchSet, len(w.watches))
+	for id, fws := range w.watches {
+		watches[id] = fws
+	}
+	gen := w.gen
+	w.lock.Unlock()
+
+	files := watches.scan(root)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if gen != w.gen { return nil }
+	if w.files == nil {
+		w.files = files
+		return nil
+	}
+
+	var events []*lsp.FileEvent
+	report := func(path string, t lsp.FileChangeType, k lsp.WatchKind) {
+		if watches.kindFor(root, path)&int(k) == 0 { return }
+		events = append(events, &lsp.FileEvent{Type: t, URI: uri.File(path)})
+	}
+	for path, mod := range files {
+		old, ok := w.files[path]
+		if !ok {
+			report(path, lsp.FileChangeTypeCreated, lsp.WatchKindCreate)
+		} else if !mod.Equal(old) {
+			report(path, lsp.FileChangeTypeChanged, lsp.WatchKindChange)
+		}
+	}
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			report(path, lsp.FileChangeTypeDeleted, lsp.WatchKindDelete)
+		}
+	}
+	w.files = files
+	sort.Slice(events, func(i, j int) bool { return events[i].URI < events[j].URI })
+	return events
+}
+
+// stopPolling stops the goroutine started by watchFiles, if it is running
+func (w *fileWatcher) stopPolling() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// registerCapabilities handles client/registerCapability. Only file
+// watches are supported, other registrations are acknowledged and ignored
+func (s *Server) registerCapabilities(resp []byte) {
+	var r RPCRegistration
+	if err := json.Unmarshal(resp, &r); err != nil {
+		s.Log("Registration error:", err)
+		return
+	}
+	s.sendReply(r.ID, nil)
+
+	for _, reg := range r.Params.Registrations {
+		if reg.Method != lsp.MethodWorkspaceDidChangeWatchedFiles { continue }
+		var opts DidChangeWatchedFilesRegistrationOptions
+		if err := json.Unmarshal(reg.RegisterOptions, &opts); err != nil {
+			s.Log("Registration error:", err)
+			continue
+		}
+		if stop := s.watcher.register(s.root, reg.ID, opts); stop != nil {
+			go s.watchFiles(stop)
+		}
+	}
+}
+
+// unregisterCapabilities handles client/unregisterCapability
+func (s *Server) unregisterCapabilities(resp []byte) {
+	var r RPCUnregistration
+	if err := json.Unmarshal(resp, &r); err != nil {
+		s.Log("Unregistration error:", err)
+		return
+	}
+	s.sendReply(r.ID, nil)
+
+	for _, unreg := range r.Params.Unregisterations {
+		if unreg.Method != lsp.MethodWorkspaceDidChangeWatchedFiles { continue }
+		if s.watcher.unregister(unreg.ID) {
+			s.watcher.stopPolling()
+		}
+	}
+}
+
+// watchFiles polls the workspace and sends the changes to the watched files
+// to the server, until stop is closed when the watches are removed or the
+// server stops
+func (s *Server) watchFiles(stop chan struct{}) {
+	// the first poll only records the files that are watched
+	s.watcher.poll(s.root)
+
+	t := time.NewTicker(watchInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			events := s.watcher.poll(s.root)
+			if len(events) == 0 { continue }
+			s.sendNotification(lsp.MethodWorkspaceDidChangeWatchedFiles, lsp.DidChangeWatchedFilesParams{
+				Changes: events,
+			})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package lsp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	cases := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/main.go", true},
+		{"**/*.go", "a/main.gox", false},
+		{"*.go", "a/main.go", false},
+		{"**/*.{go,mod}", "go.mod", true},
+		{"**/go.{mod,sum}", "x/go.work", false},
+		{"file.[0-9]", "file.3", true},
+		{"file.[!0-9]", "file.3", false},
+		{"?.txt", "a.txt", true},
+		{"a.b", "axb", false},
+	}
+	for _, c := range cases {
+		re, err := globRegexp(c.glob)
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, re.MatchString(c.path), c.glob+" "+c.path)
+	}
+}
+
+func TestWatchedFiles(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(text), 0644)
+		return path
+	}
+	mod := write("go.mod", "module a")
+	gone := write("sub/b.go", "package b")
+	write(".git/x.go", "")
+	write("node_modules/m/x.go", "")
+
+	var opts DidChangeWatchedFilesRegistrationOptions
+	err := json.Unmarshal([]byte(`{"watchers":[
+		{"globPattern":"**/*.go"},
+		{"globPattern":{"baseUri":"`+string(uri.File(root))+`","pattern":"go.mod"},"kind":2}
+	]}`), &opts)
+	assert.NoError(t, err)
+
+	var w fileWatcher
+	stop := w.register(root, "1", opts)
+	assert.NotNil(t, stop)
+	// the first poll records the watched files
+	assert.Empty(t, w.poll(root))
+	assert.Len(t, w.files, 2)
+	assert.Empty(t, w.poll(root))
+
+	created := write("c.go", "package c")
+	os.Remove(gone)
+	os.Chtimes(mod, time.Now(), time.Now().Add(time.Hour))
+	write("go.sum", "")
+
+	events := w.poll(root)
+	assert.Equal(t, []*lsp.FileEvent{
+		{Type: lsp.FileChangeTypeCreated, URI: uri.File(created)},
+		{Type: lsp.FileChangeTypeChanged, URI: uri.File(mod)},
+		{Type: lsp.FileChangeTypeDeleted, URI: uri.File(gone)},
+	}, events)
+
+	// go.mod is only watched for changes
+	os.Remove(mod)
+	assert.Empty(t, w.poll(root))
+
+	// files matched by a new registration aren't reported as created
+	var more DidChangeWatchedFilesRegistrationOptions
+	json.Unmarshal([]byte(`{"watchers":[{"globPattern":"**/go.sum"}]}`), &more)
+	assert.Nil(t, w.register(root, "2", more))
+	assert.Empty(t, w.poll(root))
+	assert.Empty(t, w.poll(root))
+
+	assert.False(t, w.unregister("1"))
+	assert.True(t, w.unregister("2"))
+	assert.False(t, w.unregister("2"))
+
+	// stopping closes the channel returned by the first registration
+	w.stopPolling()
+	select {
+	case <-stop:
+	default:
+		t.Error("polling was not stopped")
+	}
+}
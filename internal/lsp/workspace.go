@@ -0,0 +1,161 @@
+package lsp
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A workspace can override the global lsp.yaml with its own
+// .micro/lsp.yaml. Entries with the name of a known server override its
+// command, args and env, and add to its languages. Other entries add new
+// servers for the workspace
+var workspaceConfigFile = filepath.Join(".micro", "lsp.yaml")
+
+type workspaceConfig struct {
+	modTime time.Time
+	conf    *Config
+}
+
+var workspaceConfigs = make(map[string]*workspaceConfig)
+var wlock sync.Mutex
+
+// WorkspaceConfig returns the global config merged with the overrides of
+// the workspace at root. Errors in the workspace config are only returned
+// the first time it is loaded
+func WorkspaceConfig(root string) (*Config, error) {
+	filename := filepath.Join(root, workspaceConfigFile)
+	info, err := os.Stat(filename)
+	if err != nil { return conf, nil }
+
+	wlock.Lock()
+	defer wlock.Unlock()
+
+	if wc, ok := workspaceConfigs[root]; ok && wc.modTime.Equal(info.ModTime()) {
+		return wc.conf, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil { return conf, err }
+
+	merged, err := MergeConfig(conf, data)
+	if merged == nil { merged = conf }
+	workspaceConfigs[root] = &workspaceConfig{info.ModTime(), merged}
+	if err != nil {
+		return merged, errors.New(filename + ": " + err.Error())
+	}
+	return merged, nil
+}
+
+// WorkspaceRoot returns the root of the workspace of the file at path, as
+// found by the root markers of the global servers of its filetype, or dir
+// if none of them finds one
+func WorkspaceRoot(filetype, path, dir string) string {
+	for _, l := range GetLanguages(filetype) {
+		if root := l.FindRoot(path, dir); root != dir { return root }
+	}
+	return dir
+}
+
+// GetWorkspaceLanguages is like GetLanguages, but uses the config of the
+// workspace at root
+func GetWorkspaceLanguages(filetype, root string) ([]LSPConfig, error) {
+	c, err := WorkspaceConfig(root)
+	if c == nil { return nil, err }
+
+	var out []LSPConfig
+	for _, l := range c.LSPConfigs {
+		if !l.Supports(filetype) { continue }
+		out = append(out, l)
+	}
+	return out, err
+}
+
+// MergeConfig returns a copy of base with the entries of an lsp.yaml file
+// merged over it. Entries that fail to parse are skipped, and the returned
+// error lists them
+func MergeConfig(base *Config, data []byte) (*Config, error) {
+	var entries []interface{}
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	var merged Config
+	if base != nil {
+		merged.LSPConfigs = append(merged.LSPConfigs, base.LSPConfigs...)
+	}
+	var failed []string
+
+	for i, entry := range entries {
+		err := merged.mergeEntry(entry)
+		if err != nil {
+			name := entryName(entry, i)
+			log.Println("Failed to load language server", name+":", err)
+			failed = append(failed, name+" ("+err.Error()+")")
+		}
+	}
+
+	if len(failed) > 0 {
+		return &merged, errors.New("Failed to load language servers: " + strings.Join(failed, ", "))
+	}
+	return &merged, nil
+}
+
+func (c *Config) mergeEntry(entry interface{}) error {
+	lang, err := decodeEntry(entry)
+	if err != nil { return err }
+
+	for i := range c.LSPConfigs {
+		if c.LSPConfigs[i].Name == lang.Name {
+			l, err := overrideLanguage(c.LSPConfigs[i], lang)
+			if err != nil { return err }
+			c.LSPConfigs[i] = l
+			return nil
+		}
+	}
+
+	l, err := loadLanguage(entry)
+	if err != nil { return err }
+	c.LSPConfigs = append(c.LSPConfigs, l)
+	return nil
+}
+
+// overrideLanguage returns a copy of base with the fields set in lang
+// replacing its own. The languages of lang are added to the ones of base
+func overrideLanguage(base LSPConfig, lang LSPConfigStatic) (l LSPConfig, err error) {
+	defer recoverError(&err)
+
+	l = base
+	l.Languages = append([]string(nil), base.Languages...)
+	for _, language := range lang.Languages {
+		if !l.Supports(language) {
+			l.Languages = append(l.Languages, language)
+		}
+	}
+
+	if lang.Command != nil || lang.Args != nil {
+		// the command and the args are overridden separately
+		command, args := lang.Command, lang.Args
+		if command == nil || args == nil {
+			cmd, err := base.GetCmd("")
+			if err != nil && command == nil { return l, err }
+			if command == nil { command = cmd.tokens[0] }
+			if args == nil && cmd != nil { args = cmd.tokens[1:] }
+		}
+		l.Command = MakeRunnable(l, "Command", commandTokens(command, args), true)
+	}
+	if lang.Env != nil { l.Env = MakeRunnable(l, "Env", lang.Env, false) }
+	if lang.Cwd != "" { l.Cwd = MakeRunnable(l, "Cwd", lang.Cwd, false) }
+	if lang.Install != nil { l.Install = MakeRunnable(l, "Install", lang.Install, false) }
+	if lang.IsInstalled != nil { l.IsInstalled = MakeRunnable(l, "IsInstall", lang.IsInstalled, false) }
+	if lang.Options != nil { l.Options = lang.Options }
+	if lang.RootMarkers != nil { l.RootMarkers = lang.RootMarkers }
+	if lang.Files != "" { l.Files, err = compileFiles(lang.Files) }
+	return l, err
+}
@@ -0,0 +1,109 @@
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const globalTestConfig = `
+- name: gopls
+  languages: [go]
+  command: gopls
+  args: [serve]
+- name: clangd
+  languages: [c]
+  command: clangd
+`
+
+func TestMergeConfig(t *testing.T) {
+	base, err := LoadConfig([]byte(globalTestConfig))
+	assert.NoError(t, err)
+
+	merged, err := MergeConfig(base, []byte(`
+- name: gopls
+  languages: [gomod]
+  args: [serve, -rpc.trace]
+  env: { GOFLAGS: -tags=integration }
+- name: pylsp
+  languages: [python]
+  command: pylsp
+`))
+	assert.NoError(t, err)
+	assert.Len(t, merged.LSPConfigs, 3)
+
+	gopls := merged.LSPConfigs[0]
+	assert.Equal(t, []string{"go", "gomod"}, gopls.Languages)
+	cmd, err := gopls.GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gopls", "serve", "-rpc.trace"}, cmd.tokens)
+	env, err := gopls.GetEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"GOFLAGS": "-tags=integration"}, env)
+
+	clangd, err := merged.LSPConfigs[1].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"clangd"}, clangd.tokens)
+	assert.Equal(t, "pylsp", merged.LSPConfigs[2].Name)
+
+	// the base config is left untouched
+	assert.Equal(t, []string{"go"}, base.LSPConfigs[0].Languages)
+	cmd, err = base.LSPConfigs[0].GetCmd("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gopls", "serve"}, cmd.tokens)
+}
+
+func TestGetWorkspaceLanguages(t *testing.T) {
+	base, err := LoadConfig([]byte(globalTestConfig))
+	assert.NoError(t, err)
+	old := conf
+	conf = base
+	defer func() { conf = old }()
+
+	root := t.TempDir()
+	langs, err := GetWorkspaceLanguages("go", root)
+	assert.NoError(t, err)
+	assert.Len(t, langs, 1)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(root, ".micro"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, workspaceConfigFile), []byte(`
+- name: gopls
+  command: /opt/gopls
+`), 0644))
+
+	langs, err = GetWorkspaceLanguages("go", root)
+	assert.NoError(t, err)
+	if assert.Len(t, langs, 1) {
+		cmd, err := langs[0].GetCmd("")
+		assert.NoError(t, err)
+		// the args of the global config are kept
+		assert.Equal(t, []string{"/opt/gopls", "serve"}, cmd.tokens)
+	}
+}
+
+func TestWorkspaceRoot(t *testing.T) {
+	base, err := LoadConfig([]byte(`
+- name: gopls
+  languages: [go]
+  command: gopls
+  rootmarkers: [go.mod]
+- name: clangd
+  languages: [c]
+  command: clangd
+`))
+	assert.NoError(t, err)
+	old := conf
+	conf = base
+	defer func() { conf = old }()
+
+	root := t.TempDir()
+	sub := filepath.Join(root, "pkg")
+	assert.NoError(t, os.Mkdir(sub, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), nil, 0644))
+
+	assert.Equal(t, root, WorkspaceRoot("go", filepath.Join(sub, "a.go"), sub))
+	// clangd has no root markers
+	assert.Equal(t, sub, WorkspaceRoot("c", filepath.Join(sub, "a.c"), sub))
+}
@@ -0,0 +1,139 @@
+package lsp
+
+import (
+	"encoding/json"
+	"sort"
+
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// WorkspaceEdit is lsp.WorkspaceEdit with document changes that can also be
+// resource operations, which micro advertises support for. The type of
+// go.lsp.dev/protocol only decodes text document edits
+type WorkspaceEdit struct {
+	Changes         map[uri.URI][]lsp.TextEdit `json:"changes,omitempty"`
+	DocumentChanges []DocumentChange           `json:"documentChanges,omitempty"`
+}
+
+// DocumentChange is an entry of the document changes of a workspace edit.
+// Exactly one of the fields is set
+type DocumentChange struct {
+	Edit   *lsp.TextDocumentEdit
+	Create *lsp.CreateFile
+	Rename *lsp.RenameFile
+	Delete *lsp.DeleteFile
+}
+
+func (c *DocumentChange) UnmarshalJSON(data []byte) error {
+	var op struct {
+		Kind lsp.ResourceOperationKind `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &op); err != nil {
+		return err
+	}
+
+	*c = DocumentChange{}
+	switch op.Kind {
+	case lsp.CreateResourceOperation:
+		c.Create = &lsp.CreateFile{}
+		return json.Unmarshal(data, c.Create)
+	case lsp.RenameResourceOperation:
+		c.Rename = &lsp.RenameFile{}
+		return json.Unmarshal(data, c.Rename)
+	case lsp.DeleteResourceOperation:
+		c.Delete = &lsp.DeleteFile{}
+		return json.Unmarshal(data, c.Delete)
+	}
+	c.Edit = &lsp.TextDocumentEdit{}
+	return json.Unmarshal(data, c.Edit)
+}
+
+func (c DocumentChange) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.Create != nil:
+		return json.Marshal(c.Create)
+	case c.Rename != nil:
+		return json.Marshal(c.Rename)
+	case c.Delete != nil:
+		return json.Marshal(c.Delete)
+	}
+	return json.Marshal(c.Edit)
+}
+
+// FileEdit is one of the text edits of a workspace edit, with the file it
+// applies to
+type FileEdit struct {
+	URI uri.URI
+	lsp.TextEdit
+}
+
+// FileEdits lists the text edits of a workspace edit, both from Changes and
+// DocumentChanges, sorted by file and position. Resource operations aren't
+// included
+func FileEdits(edit WorkspaceEdit) []FileEdit {
+	var edits []FileEdit
+	for u, changes := range edit.Changes {
+		for _, e := range changes {
+			edits = append(edits, FileEdit{u, e})
+		}
+	}
+	for _, change := range edit.DocumentChanges {
+		if change.Edit == nil { continue }
+		for _, e := range change.Edit.Edits {
+			edits = append(edits, FileEdit{change.Edit.TextDocument.URI, e})
+		}
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		a, b := edits[i], edits[j]
+		if a.URI != b.URI { return a.URI < b.URI }
+		if a.Range.Start.Line != b.Range.Start.Line { return a.Range.Start.Line < b.Range.Start.Line }
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+	return edits
+}
+
+// ResourceOperations lists the create, rename and delete operations of a
+// workspace edit, in the order they are applied
+func ResourceOperations(edit WorkspaceEdit) []DocumentChange {
+	var ops []DocumentChange
+	for _, change := range edit.DocumentChanges {
+		if change.Edit == nil { ops = append(ops, change) }
+	}
+	return ops
+}
+
+// FilterWorkspaceEdit returns the workspace edit with only the text edits
+// for which keep returns true and the resource operations for which keepOp
+// returns true. Files left without edits are dropped
+func FilterWorkspaceEdit(edit WorkspaceEdit, keep func(FileEdit) bool, keepOp func(DocumentChange) bool) WorkspaceEdit {
+	filter := func(u uri.URI, edits []lsp.TextEdit) []lsp.TextEdit {
+		var kept []lsp.TextEdit
+		for _, e := range edits {
+			if keep(FileEdit{u, e}) { kept = append(kept, e) }
+		}
+		return kept
+	}
+
+	var out WorkspaceEdit
+	for u, changes := range edit.Changes {
+		kept := filter(u, changes)
+		if len(kept) == 0 { continue }
+		if out.Changes == nil { out.Changes = make(map[uri.URI][]lsp.TextEdit) }
+		out.Changes[u] = kept
+	}
+	for _, change := range edit.DocumentChanges {
+		if change.Edit == nil {
+			if keepOp(change) { out.DocumentChanges = append(out.DocumentChanges, change) }
+			continue
+		}
+		kept := filter(change.Edit.TextDocument.URI, change.Edit.Edits)
+		if len(kept) == 0 { continue }
+		out.DocumentChanges = append(out.DocumentChanges, DocumentChange{Edit: &lsp.TextDocumentEdit{
+			TextDocument: change.Edit.TextDocument,
+			Edits:        kept,
+		}})
+	}
+	return out
+}
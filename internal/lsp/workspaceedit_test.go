@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestFileEdits(t *testing.T) {
+	a, b := uri.File("/tmp/a.go"), uri.File("/tmp/b.go")
+	edit := func(line uint32) lsp.TextEdit {
+		return lsp.TextEdit{
+			Range:   lsp.Range{Start: lsp.Position{Line: line}, End: lsp.Position{Line: line, Character: 3}},
+			NewText: "new",
+		}
+	}
+	version := int32(4)
+	we := WorkspaceEdit{
+		Changes: map[uri.URI][]lsp.TextEdit{b: {edit(7), edit(2)}},
+		DocumentChanges: []DocumentChange{{Edit: &lsp.TextDocumentEdit{
+			TextDocument: lsp.OptionalVersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: a},
+				Version:                &version,
+			},
+			Edits: []lsp.TextEdit{edit(5)},
+		}}},
+	}
+
+	edits := FileEdits(we)
+	assert.Equal(t, []FileEdit{{a, edit(5)}, {b, edit(2)}, {b, edit(7)}}, edits)
+
+	// dropping the only edit of a file drops the file
+	all := func(DocumentChange) bool { return true }
+	filtered := FilterWorkspaceEdit(we, func(e FileEdit) bool { return e.URI == b && e.Range.Start.Line == 7 }, all)
+	assert.Empty(t, filtered.DocumentChanges)
+	assert.Equal(t, map[uri.URI][]lsp.TextEdit{b: {edit(7)}}, filtered.Changes)
+
+	filtered = FilterWorkspaceEdit(we, func(e FileEdit) bool { return e.URI == a }, all)
+	assert.Nil(t, filtered.Changes)
+	assert.Equal(t, we.DocumentChanges, filtered.DocumentChanges)
+}
+
+func TestDocumentChanges(t *testing.T) {
+	data := `{"documentChanges":[
+		{"textDocument":{"uri":"file:///tmp/a.go","version":1},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"newText":"b"}]},
+		{"kind":"rename","oldUri":"file:///tmp/a.go","newUri":"file:///tmp/b.go"},
+		{"kind":"create","uri":"file:///tmp/c.go","options":{"ignoreIfExists":true}},
+		{"kind":"delete","uri":"file:///tmp/d"}
+	]}`
+
+	var we WorkspaceEdit
+	assert.NoError(t, json.Unmarshal([]byte(data), &we))
+	assert.Len(t, we.DocumentChanges, 4)
+	assert.Equal(t, "b", we.DocumentChanges[0].Edit.Edits[0].NewText)
+	assert.Equal(t, uri.File("/tmp/b.go"), we.DocumentChanges[1].Rename.NewURI)
+	assert.True(t, we.DocumentChanges[2].Create.Options.IgnoreIfExists)
+	assert.Equal(t, uri.File("/tmp/d"), we.DocumentChanges[3].Delete.URI)
+
+	assert.Equal(t, we.DocumentChanges[1:], ResourceOperations(we))
+
+	// resource operations are filtered apart from the text edits
+	filtered := FilterWorkspaceEdit(we, func(FileEdit) bool { return false }, func(c DocumentChange) bool { return c.Delete == nil })
+	assert.Equal(t, we.DocumentChanges[1:3], filtered.DocumentChanges)
+
+	out, err := json.Marshal(we)
+	assert.NoError(t, err)
+	var again WorkspaceEdit
+	assert.NoError(t, json.Unmarshal(out, &again))
+	assert.Equal(t, we, again)
+}
+
+func TestResourceOnlyEdit(t *testing.T) {
+	// a rename of a symbol that only renames its file
+	data := `{"documentChanges":[{"kind":"rename","oldUri":"file:///tmp/a.go","newUri":"file:///tmp/b.go"}]}`
+	var we WorkspaceEdit
+	assert.NoError(t, json.Unmarshal([]byte(data), &we))
+
+	assert.Empty(t, FileEdits(we))
+	ops := ResourceOperations(we)
+	if assert.Len(t, ops, 1) {
+		assert.Equal(t, uri.File("/tmp/a.go"), ops[0].Rename.OldURI)
+	}
+
+	// nothing is left when nothing is kept
+	none := FilterWorkspaceEdit(we, func(FileEdit) bool { return false }, func(DocumentChange) bool { return false })
+	assert.Equal(t, WorkspaceEdit{}, none)
+	kept := FilterWorkspaceEdit(we, func(FileEdit) bool { return false }, func(DocumentChange) bool { return true })
+	assert.Equal(t, we, kept)
+}
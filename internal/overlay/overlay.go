@@ -8,13 +8,18 @@ import (
 	"github.com/zyedidia/micro/v2/internal/config"
 	"github.com/zyedidia/micro/v2/internal/buffer"
 	"github.com/zyedidia/tcell/v2"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type BufWindow interface {
 	CursorVisual() Loc
 	IsActive() bool
+	IsClosed() bool
 	LocToVisual(int, int) Loc
+	LocVisible(int, int) bool
 }
 
 // OpenBehavior describes What happens when opening an overlay
@@ -53,6 +58,7 @@ func (c CursorAnchor) ScreenPos() Loc {
 }
 
 func (c CursorAnchor) Visible() bool {
+	if windowClosed(c.Window) { return false }
 	is_active := c.Window.IsActive()
 	is_current := c.Window == GetCurrentBufWindow()
 	return is_active && is_current
@@ -64,10 +70,32 @@ func (a Anchor) ScreenPos() Loc {
 	return l
 }
 
+// Visible returns false when the anchor location is scrolled out of the
+// window, so that the overlay disappears with the text it belongs to
 func (a Anchor) Visible() bool {
+	if windowClosed(a.Window) { return false }
+	if !a.Window.LocVisible(a.loc.X, a.loc.Y) { return false }
 	return a.Window.IsActive() && GetCurrentBufWindow() == a.Window
 }
 
+// windowClosed returns true if the window is gone, in which case anything
+// anchored to it must not be drawn
+func windowClosed(w BufWindow) bool {
+	return w == nil || w.IsClosed()
+}
+
+// anchorClosed returns true if the overlay is anchored to a window that
+// has since been closed
+func (o *Overlay) anchorClosed() bool {
+	switch p := o.Pos.(type) {
+	case Anchor:
+		return windowClosed(p.Window)
+	case CursorAnchor:
+		return windowClosed(p.Window)
+	}
+	return false
+}
+
 func (l V2) ScreenPos() Loc {
 	return l.Loc
 }
@@ -83,10 +111,46 @@ type Overlay struct {
 	Draw func(*Overlay)
 	EventHandler func(*Overlay, tcell.Event) bool
 	CleanupHandler func(*Overlay)
+
+	// Hidden overlays are neither drawn nor sent events, but keep their
+	// state until they are shown again
+	Hidden bool
+
+	// Modal overlays consume every event that reaches them, so the overlays
+	// below them and the editor don't receive any input while they are open
+	Modal bool
+
+	// Draggable overlays with a static position can be moved by dragging
+	// their top row, which acts as a title bar
+	Draggable bool
+	// while the overlay is being dragged, the position of the mouse relative
+	// to its top left corner
+	grab *Loc
+
+	// order in which the overlay was registered, used for stacking
+	seq uint64
 }
 
 var Overlays = make(map[string][]*Overlay)
 
+// overlaySeq is incremented every time an overlay is registered
+var overlaySeq uint64
+
+// StackedOverlays returns all registered overlays ordered from bottom to
+// top. Overlays are stacked in the order they were opened, regardless of
+// their ID, so the most recently opened overlay is always drawn last and
+// receives events first.
+func StackedOverlays() []*Overlay {
+	var stack []*Overlay
+	for _, overlays := range Overlays {
+		stack = append(stack, overlays...)
+	}
+	sort.Slice(stack, func(i, j int) bool {
+		return stack[i].seq < stack[j].seq
+	})
+	return stack
+}
+
 // Returns a slice of overlays with the given ID
 func FindOverlays(ID string) []*Overlay {
 	o, ok := Overlays[ID]
@@ -155,19 +219,38 @@ func (o *Overlay) Remove() {
 			id_overlays[len(id_overlays)-1] = nil
 			id_overlays = id_overlays[:len(id_overlays)-1]
 			Overlays[o.ID] = id_overlays
+			o.cleanup()
 			return
 		}
 	}
 }
 
+// Hide stops the overlay from being drawn and receiving events, without
+// removing it
+func (o *Overlay) Hide() {
+	o.Hidden = true
+}
+
+// Show undoes Hide
+func (o *Overlay) Show() {
+	o.Hidden = false
+}
+
+// cleanup runs the overlay's CleanupHandler, if any
+func (o *Overlay) cleanup() {
+	if o.CleanupHandler != nil { o.CleanupHandler(o) }
+}
+
+// Resize sets the size of the overlay, clamped to the screen. The height is
+// limited to the space below the anchor, but overlays that are too wide for
+// the space to the right of it are shifted left instead (see ScreenPos)
 func (o *Overlay) Resize(width int, height int) {
 	maxw, maxh := screen.Screen.Size()
-	sp := o.ScreenPos()
-	maxw = util.Max(maxw - sp.X, 0)
+	sp := o.Pos.ScreenPos()
 	maxh = util.Max(maxh - sp.Y, 0)
 
-	o.Size.X = util.Min(width, maxw)
-	o.Size.Y = util.Min(height, maxh)
+	o.Size.X = util.Clamp(width, 0, maxw)
+	o.Size.Y = util.Clamp(height, 0, maxh)
 }
 
 func (o *Overlay) SetAnchor(Window BufWindow, loc Loc) {
@@ -183,11 +266,52 @@ func (o *Overlay) SetCursorAnchor(Window BufWindow) {
 }
 
 func (o *Overlay) HandleEvent(event tcell.Event) bool {
+	if o.handleDrag(event) { return true }
 	if o.EventHandler != nil { return o.EventHandler(o, event) }
 	return false
 }
 
+// SetDraggable sets whether the overlay can be moved with the mouse. Only
+// overlays with a static position can be dragged
+func (o *Overlay) SetDraggable(draggable bool) {
+	o.Draggable = draggable
+	if !draggable { o.grab = nil }
+}
+
+// handleDrag moves a draggable overlay when its top row is dragged with the
+// left button. The overlay is kept inside the screen
+func (o *Overlay) handleDrag(event tcell.Event) bool {
+	e, ok := event.(*tcell.EventMouse)
+	if !ok || !o.Draggable { return false }
+	if _, ok := o.Pos.(V2); !ok { return false }
+
+	mx, my := e.Position()
+	if e.Buttons() != tcell.Button1 {
+		if o.grab == nil { return false }
+		o.grab = nil
+		return true
+	}
+
+	pos := o.ScreenPos()
+	if o.grab == nil {
+		if my != pos.Y || !o.Contains(mx, my) { return false }
+		o.grab = &Loc{X: mx - pos.X, Y: my - pos.Y}
+		return true
+	}
+
+	w, h := screen.Screen.Size()
+	o.Pos = V2{Loc{
+		X: util.Clamp(mx - o.grab.X, 0, util.Max(w - o.Size.X, 0)),
+		Y: util.Clamp(my - o.grab.Y, 0, util.Max(h - o.Size.Y, 0)),
+	}}
+	screen.Redraw()
+	return true
+}
+
 func registerOverlay(o *Overlay) {
+	overlaySeq++
+	o.seq = overlaySeq
+
 	arr, ok := Overlays[o.ID]
 	if !ok { arr = make([]*Overlay, 0) }
 	arr = append(arr, o)
@@ -196,24 +320,39 @@ func registerOverlay(o *Overlay) {
 
 // Removes all overlays with a given ID
 func RemoveOverlaysByID(ID string) {
+	overlays := Overlays[ID]
 	delete(Overlays, ID)
+	for _, o := range overlays { o.cleanup() }
 }
 
 // Completely removes all overlays
 func RemoveAllOverlays() {
+	old := Overlays
 	Overlays = make(map[string][]*Overlay, len(Overlays))
+	for _, overlays := range old {
+		for _, o := range overlays { o.cleanup() }
+	}
 }
 
-// ScreenPos returns the screen-space coordinate of the
-// anchor of the overlay.
+// ScreenPos returns the screen-space coordinate of the top left corner of
+// the overlay. This is the position of its anchor, unless the overlay
+// would go past the right edge of the screen, in which case it is moved
+// left just enough to fit.
 func (o *Overlay) ScreenPos() Loc {
-	return o.Pos.ScreenPos()
+	l := o.Pos.ScreenPos()
+	w, _ := screen.Screen.Size()
+	if l.X + o.Size.X > w {
+		l.X = util.Max(w - o.Size.X, 0)
+	}
+	return l
 }
 
+// Contains returns true if the screen cell (x, y) is covered by the
+// overlay, treating it as the half-open rectangle [pos, pos+size)
 func (o *Overlay) Contains(x int, y int) bool {
 	pos := o.ScreenPos()
-	x_overlap := x >= pos.X && x <= pos.X + o.Size.X
-	y_overlap := y >= pos.Y && y <= pos.Y + o.Size.Y
+	x_overlap := x >= pos.X && x < pos.X + o.Size.X
+	y_overlap := y >= pos.Y && y < pos.Y + o.Size.Y
 	return x_overlap && y_overlap
 }
 
@@ -221,26 +360,39 @@ func (o *Overlay) Display() {
 	o.Draw(o)
 }
 
+// DisplayOverlays draws all visible overlays that aren't hidden, bottom to
+// top. Overlays anchored to a window that was closed are removed.
 func DisplayOverlays() {
-	for _, overlays := range Overlays {
-		for _, overlay := range overlays {
-			if !overlay.Pos.Visible() { continue }
-			overlay.Display()
+	for _, overlay := range StackedOverlays() {
+		if overlay.anchorClosed() {
+			overlay.Remove()
+			continue
 		}
+		if overlay.Hidden || !overlay.Pos.Visible() { continue }
+		overlay.Display()
 	}
 }
 
+// HandleOverlayEvent passes the event to the visible overlays that aren't
+// hidden, top to bottom, until one of them consumes it. Modal overlays
+// consume all user input, other events such as resizes go past them
 func HandleOverlayEvent(ev tcell.Event) bool {
-	event_handled := false
-	for _, overlays := range Overlays {
-		for _, overlay := range overlays {
-			if !overlay.Pos.Visible() { continue }
-			event_handled = overlay.HandleEvent(ev)
-			if event_handled { break }
-		}
-		if event_handled { break }
+	stack := StackedOverlays()
+	for i := len(stack)-1; i >= 0; i-- {
+		overlay := stack[i]
+		if overlay.Hidden || !overlay.Pos.Visible() { continue }
+		if overlay.HandleEvent(ev) || overlay.Modal && isInput(ev) { return true }
+	}
+	return false
+}
+
+// isInput returns true for the events that come from the user
+func isInput(ev tcell.Event) bool {
+	switch ev.(type) {
+	case *tcell.EventKey, *tcell.EventMouse, *tcell.EventPaste, *tcell.EventRaw:
+		return true
 	}
-	return event_handled
+	return false
 }
 
 func DrawClear(x1, y1, w, h int, style tcell.Style) {
@@ -253,42 +405,101 @@ func DrawClear(x1, y1, w, h int, style tcell.Style) {
 	}
 }
 
+// DrawTextOption changes how DrawText handles text that doesn't fit in
+// its rectangle
+type DrawTextOption int
+
+const (
+	// DTEllipsis draws an ellipsis at the end of the last line when the
+	// text is cut off
+	DTEllipsis DrawTextOption = iota
+)
+
 // Draws text, sized to the given rectangle, and returns the
-// amount of lines required.
-func DrawText(text string, x1, y1, w, h int, style tcell.Style) int {
-	tabsize := int(config.GlobalSettings["tabsize"].(float64))
+// amount of lines required. Tabs are aligned to multiples of tabsize,
+// or of the global tabsize if it is 0.
+func DrawText(text string, x1, y1, w, h, tabsize int, style tcell.Style, opts ...DrawTextOption) int {
+	lines, _ := DrawTextTruncated(text, x1, y1, w, h, tabsize, style, opts...)
+	return lines
+}
+
+// DrawTextTruncated is like DrawText, but also returns true if the text
+// didn't fit in the rectangle.
+func DrawTextTruncated(text string, x1, y1, w, h, tabsize int, style tcell.Style, opts ...DrawTextOption) (int, bool) {
+	tabsize = effectiveTabSize(tabsize)
 	x := x1
 	y := y1
 	x2 := x1+w
 	y2 := y1+h
 
-	if y >= y2 { return 0 }
+	if y >= y2 { return 0, len(text) > 0 }
 
 	DrawClear(x1, y, w, 1, style)
 
+	truncated := false
+	lineEnd := x
 	for _, r := range text {
 		rw := 1
 		if r == '\t' {
-			rw = tabsize
+			rw = tabsize - (x-x1)%tabsize
 		} else {
 			rw = runewidth.RuneWidth(r)
 		}
 
 		if r == '\n' || x+rw > x2 {
+			lineEnd = x
 			x = x1
 			y++
 			if y < y2 {
 				DrawClear(x1, y, w, 1, style)
 			}
 			if r == '\n' { continue }
+			if r == '\t' { rw = tabsize }
+		}
+		if y >= y2 {
+			truncated = true
+			break
 		}
-		if y >= y2 { break }
 
-		screen.SetContent(x, y, r, nil, style)
+		if r == '\t' {
+			DrawClear(x, y, rw, 1, style)
+		} else {
+			screen.SetContent(x, y, r, nil, style)
+		}
 		x += rw
 	}
 
-	return (y - y1) + 1
+	if truncated && w > 0 {
+		for _, opt := range opts {
+			if opt == DTEllipsis {
+				screen.SetContent(util.Min(lineEnd, x2-1), y2-1, '…', nil, style)
+			}
+		}
+	}
+
+	return (y - y1) + 1, truncated
+}
+
+// effectiveTabSize returns tabsize, or the global tabsize if it is 0
+func effectiveTabSize(tabsize int) int {
+	if tabsize > 0 { return tabsize }
+	return int(config.GlobalSettings["tabsize"].(float64))
+}
+
+// windowTabSize returns the tabsize of the buffer shown in the window an
+// overlay is anchored to, or 0 if it isn't anchored to a window
+func windowTabSize(op OverlayPosition) int {
+	var w BufWindow
+	switch p := op.(type) {
+	case Anchor:
+		w = p.Window
+	case CursorAnchor:
+		w = p.Window
+	}
+	if t, ok := w.(interface{ TabSize() int }); ok {
+		return t.TabSize()
+	}
+	return 0
 }
 
 type SelectOption interface {
@@ -301,6 +512,58 @@ type SelectMenuOption[K any] struct {
 }
 func (m SelectMenuOption[any]) Label() string { return m.Text }
 
+// ActionOption is an option that runs its own action when it is chosen,
+// instead of the callback of the menu
+type ActionOption interface {
+	SelectOption
+	Action()
+}
+
+// MenuAction is an option with its own action, for context menus.
+// Disabled actions are shown dimmed and can't be chosen
+type MenuAction struct {
+	Text     string
+	Run      func()
+	Disabled bool
+}
+func (a MenuAction) Label() string { return a.Text }
+func (a MenuAction) Action() { a.Run() }
+func (a MenuAction) Enabled() bool { return !a.Disabled }
+
+// optionEnabled returns false for options that have an Enabled method
+// returning false
+func optionEnabled(opt SelectOption) bool {
+	e, ok := opt.(interface{ Enabled() bool })
+	return !ok || e.Enabled()
+}
+
+// SelectTrigger tells how the option of a menu was chosen
+type SelectTrigger int
+
+const (
+	// Enter or a left click
+	SelectPrimary SelectTrigger = iota
+	// Tab or a right click, for a secondary action on the option
+	SelectSecondary
+)
+
+// chooseOption runs the action of an option chosen with the primary
+// trigger if it has one, or calls onSelect
+func chooseOption[K SelectOption](opt K, t SelectTrigger, onSelect func(K, SelectTrigger)) {
+	if a, ok := any(opt).(ActionOption); ok && t == SelectPrimary {
+		a.Action()
+	} else if onSelect != nil {
+		onSelect(opt, t)
+	}
+}
+
+// primaryOnly adapts the callback of the simple menus, which can only be
+// chosen with the primary trigger
+func primaryOnly[K SelectOption](onSelect func(K)) func(K, SelectTrigger) {
+	if onSelect == nil { return nil }
+	return func(opt K, t SelectTrigger) { onSelect(opt) }
+}
+
 func Text_MaxLine_TotalLines(s string) (int, int) {
 	l := 0
 	cur := 0
@@ -312,21 +575,30 @@ func Text_MaxLine_TotalLines(s string) (int, int) {
 			lines++
 			continue
 		}
-		cur++
+		cur += runewidth.RuneWidth(ch)
 	}
 	if cur > l { l = cur }
 	return l, lines
 }
 
-func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth int) (string, int, int) {
+// WrapBreakChars are the characters after which a word may be wrapped, in
+// addition to whitespace. They let long tokens such as URLs and signatures
+// wrap at sensible points instead of being split anywhere
+var WrapBreakChars = "/.:,;(&?=|"
+
+// Text_Wrapped_MaxLineWidth_TotalLines wraps s to lines of at most maxwidth
+// columns and returns the result, its widest line and its number of lines.
+// Lines are wrapped at whitespace or after one of WrapBreakChars, and words
+// longer than maxwidth are split
+func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth, tabsize int) (string, int, int) {
 	l := 0
 	cur := 0
 	lines := 1
-	tabsize := int(config.GlobalSettings["tabsize"].(float64))
-	tabstr := strings.Repeat(" ", tabsize)
+	tabsize = effectiveTabSize(tabsize)
 
 	out := strings.Builder{}
 	word := ""
+	wordw := 0
 
 	for _, ch := range s {
 		switch ch {
@@ -334,7 +606,7 @@ func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth int) (string, int,
 		case '\n':
 			// Flush word
 			out.WriteString(word)
-			word = ""
+			word, wordw = "", 0
 
 			// Update max line length and line count
 			if cur > l { l = cur }
@@ -348,20 +620,22 @@ func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth int) (string, int,
 		case '\t':
 			// Flush word
 			out.WriteString(word)
-			word = ""
+			word, wordw = "", 0
 
-			if cur + tabsize > maxwidth {
+			tabw := tabsize - cur%tabsize
+			if cur + tabw > maxwidth {
 				// Update max line length and line count
 				if cur > l { l = cur }
 				cur = 0
 				lines++
+				tabw = tabsize
 				// Insert newline char into string
 				out.WriteRune('\n')
 			}
 
 			// Insert tab into string
-			cur += tabsize
-			out.WriteString(tabstr)
+			cur += tabw
+			out.WriteString(strings.Repeat(" ", tabw))
 			continue
 
 		default:
@@ -371,37 +645,45 @@ func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth int) (string, int,
 			if ws {
 				// Flush word
 				out.WriteString(word)
-				word = ""
-			} else {
-				if len(word) + rw > maxwidth {
-					// Flush word
-					out.WriteString(word)
-					word = ""
-
-					// Update max line length and line count
-					if cur > l { l = cur }
-					cur = 0
-					lines++
-
-					// Insert newline char into string
-					out.WriteRune('\n')
-				}
+				word, wordw = "", 0
+			} else if wordw + rw > maxwidth {
+				// Flush word
+				out.WriteString(word)
+				word, wordw = "", 0
 
-				word += string(ch)
+				// Update max line length and line count
+				if cur > l { l = cur }
+				cur = 0
+				lines++
+
+				// Insert newline char into string
+				out.WriteRune('\n')
 			}
 
 			if cur + rw > maxwidth {
-				// Update max line length and line count
-				if cur > l { l = cur }
-				cur = len(word)
+				// The start of the current word moves to the next line
+				// and whitespace at the end of the line is dropped
+				if cur - wordw > l { l = cur - wordw }
+				cur = wordw
 				lines++
 				// Insert newline char into string
 				out.WriteRune('\n')
+				if ws { continue }
 			} else if (ws) {
 				out.WriteRune(ch)
 			}
 
+			if !ws {
+				word += string(ch)
+				wordw += rw
+			}
 			cur += rw
+
+			if strings.ContainsRune(WrapBreakChars, ch) {
+				// Flush word, so that it can wrap here
+				out.WriteString(word)
+				word, wordw = "", 0
+			}
 		}
 	}
 
@@ -411,30 +693,80 @@ func Text_Wrapped_MaxLineWidth_TotalLines(s string, maxwidth int) (string, int,
 	return out.String(), l, lines
 }
 
+// TooltipSection is a part of a tooltip made of several sections. The
+// header, if any, is drawn with HeaderStyle above the text of the section
+type TooltipSection struct {
+	Header      string
+	HeaderStyle tcell.Style
+	Text        string
+}
+
+type tooltipRow struct {
+	text  string
+	style tcell.Style
+}
+
+// appendWrapped appends the lines of text, wrapped to width, to rows
+func appendWrapped(rows []tooltipRow, text string, width, tabsize int, style tcell.Style) []tooltipRow {
+	if text == "" { return rows }
+	wrapped, _, _ := Text_Wrapped_MaxLineWidth_TotalLines(text, width, tabsize)
+	for _, line := range strings.Split(wrapped, "\n") {
+		rows = append(rows, tooltipRow{line, style})
+	}
+	return rows
+}
+
 func Tooltip(text string, op OverlayPosition) {
-	maxw, lines := Text_MaxLine_TotalLines(text)
-	wrapped, wraph := "", 0
+	SectionTooltip([]TooltipSection{{Text: text}}, op)
+}
+
+// SectionTooltip shows a tooltip made of the given sections, one below the
+// other
+func SectionTooltip(sections []TooltipSection, op OverlayPosition) {
+	maxw, lines := 0, 0
+	for _, s := range sections {
+		for _, text := range []string{s.Header, s.Text} {
+			if text == "" { continue }
+			w, h := Text_MaxLine_TotalLines(text)
+			maxw = util.Max(maxw, w)
+			lines += h
+		}
+	}
+	var rows []tooltipRow
 
 	scroll := 0
 	scrollSpeed := int(config.GlobalSettings["scrollspeed"].(float64))
+	tabsize := windowTabSize(op)
 
 	NewOverlay(
 		"tooltip", op, Loc{maxw+2, lines}, OBReplace,
 
 		func (o *Overlay) {
-			wrapped, _, wraph = Text_Wrapped_MaxLineWidth_TotalLines(text, o.Size.X-2)
-			o.Resize(maxw+2, wraph)
-
 			style := config.DefStyle.Reverse(true)
 			if s, ok := config.Colorscheme["tooltip"] ; ok {
 				style = s
 			}
 
-			scrolled := strings.Join(strings.Split(wrapped, "\n")[scroll:], "\n")
+			rows = rows[:0]
+			for _, s := range sections {
+				rows = appendWrapped(rows, s.Header, o.Size.X-2, tabsize, s.HeaderStyle)
+				rows = appendWrapped(rows, s.Text, o.Size.X-2, tabsize, style)
+			}
+			o.Resize(maxw+2, len(rows))
 
 			loc := o.ScreenPos()
 			DrawClear(loc.X, loc.Y, o.Size.X, o.Size.Y, style)
-			DrawText(scrolled, loc.X+1, loc.Y, o.Size.X-1, o.Size.Y, style)
+			for i := 0; i < o.Size.Y && scroll+i < len(rows); i++ {
+				row := rows[scroll+i]
+				text := row.text
+				if i == o.Size.Y-1 && scroll+i+1 < len(rows) {
+					// Let DrawText see the next row, so that it marks
+					// the tooltip as truncated
+					text += "\n" + rows[scroll+i+1].text
+				}
+				DrawClear(loc.X, loc.Y+i, o.Size.X, 1, row.style)
+				DrawText(text, loc.X+1, loc.Y+i, o.Size.X-1, 1, tabsize, row.style, DTEllipsis)
+			}
 		},
 
 		func (o *Overlay, ev tcell.Event) bool {
@@ -446,8 +778,8 @@ func Tooltip(text string, op OverlayPosition) {
 				mx, my := e.Position()
 				if o.Contains(mx, my) {
 					b := e.Buttons()
-					maxScroll := wraph - o.Size.Y + 1
-					if wraph <= o.Size.Y {
+					maxScroll := len(rows) - o.Size.Y + 1
+					if len(rows) <= o.Size.Y {
 						maxScroll = 0
 					}
 
@@ -466,14 +798,51 @@ func Tooltip(text string, op OverlayPosition) {
 	)
 }
 
+// SelectMenu shows a list of options, and calls onSelect with the option
+// chosen with Enter or a click. Options that are an ActionOption run their
+// own action instead
 func SelectMenu[K SelectOption](options []K, onSelect func(K), op OverlayPosition) {
+	selectMenu(options, primaryOnly(onSelect), false, op)
+}
+
+// SelectMenuTriggered is like SelectMenu, but options can also be chosen
+// with Tab or a right click, which onSelect is told about
+func SelectMenuTriggered[K SelectOption](options []K, onSelect func(K, SelectTrigger), op OverlayPosition) {
+	selectMenu(options, onSelect, true, op)
+}
+
+// ContextMenu shows a menu of actions, which is closed by Escape or a
+// click outside of it
+func ContextMenu(actions []MenuAction, op OverlayPosition) {
+	o := selectMenu(actions, nil, false, op)
+	o.Modal = true
+	handle := o.EventHandler
+	o.EventHandler = func(o *Overlay, ev tcell.Event) bool {
+		switch e := ev.(type) {
+		case *tcell.EventKey:
+			if e.Key() == tcell.KeyEscape || e.Key() == tcell.KeyCtrlC {
+				o.Remove()
+				return true
+			}
+		case *tcell.EventMouse:
+			mx, my := e.Position()
+			if e.Buttons() != tcell.ButtonNone && !o.Contains(mx, my) {
+				o.Remove()
+				return true
+			}
+		}
+		return handle(o, ev)
+	}
+}
+
+func selectMenu[K SelectOption](options []K, onSelect func(K, SelectTrigger), secondary bool, op OverlayPosition) *Overlay {
 	option := 0
 	mx, my := 0, 0
 
 	scroll := 0
 	height := util.Min(len(options), 10)
 
-	NewOverlay(
+	return NewOverlay(
 		"select_menu", op, Loc{20, height}, OBReplace,
 
 		func (o *Overlay) {
@@ -497,11 +866,10 @@ func SelectMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 				opt := options[optindex]
 				y_start := y + offset
 
-				if optindex == option {
-					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, rev)
-				} else {
-					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, def)
-				}
+				style := def
+				if optindex == option { style = rev }
+				if !optionEnabled(opt) { style = style.Dim(true) }
+				offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, windowTabSize(o.Pos), style)
 
 				if contains_mouse && my >= y_start && my < y+offset {
 					contains_mouse = false
@@ -515,8 +883,14 @@ func SelectMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 			switch e := ev.(type) {
 			case *tcell.EventKey:
 				if e.Key() == tcell.KeyEnter {
-					onSelect(options[option])
+					if !optionEnabled(options[option]) { return true }
+					o.Remove()
+					chooseOption(options[option], SelectPrimary, onSelect)
+					return true
+				} else if e.Key() == tcell.KeyTab && secondary {
+					if !optionEnabled(options[option]) { return true }
 					o.Remove()
+					chooseOption(options[option], SelectSecondary, onSelect)
 					return true
 				} else if e.Key() == tcell.KeyUp {
 					option = (option-1+len(options)) % len(options)
@@ -531,9 +905,14 @@ func SelectMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 				mx, my = e.Position()
 				if !o.Contains(mx, my) { return false }
 				b := e.Buttons()
-				if b == tcell.Button1 {
-					onSelect(options[option])
+				if (b == tcell.Button1 || b == tcell.Button2) && !optionEnabled(options[option]) {
+					return true
+				} else if b == tcell.Button1 {
 					o.Remove()
+					chooseOption(options[option], SelectPrimary, onSelect)
+				} else if b == tcell.Button2 && secondary {
+					o.Remove()
+					chooseOption(options[option], SelectSecondary, onSelect)
 				} else if b == tcell.WheelUp {
 					scroll = util.Clamp(scroll-1, 0, len(options)-10)
 				} else if b == tcell.WheelDown {
@@ -547,7 +926,18 @@ func SelectMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 	)
 }
 
+// SearchMenu is like SelectMenu, with a line above the options to type in
 func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPosition) {
+	searchMenu(options, primaryOnly(onSelect), false, op)
+}
+
+// SearchMenuTriggered is like SearchMenu, but options can also be chosen
+// with Tab or a right click, which onSelect is told about
+func SearchMenuTriggered[K SelectOption](options []K, onSelect func(K, SelectTrigger), op OverlayPosition) {
+	searchMenu(options, onSelect, true, op)
+}
+
+func searchMenu[K SelectOption](options []K, onSelect func(K, SelectTrigger), secondary bool, op OverlayPosition) {
 	search_buffer := buffer.NewBufferFromString("", "", buffer.BTScratch)
 	option := 0
 
@@ -569,7 +959,7 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 				rev = style.Reverse(true)
 			}
 
-			DrawText(search_buffer.Line(0), loc.X, loc.Y, o.Size.X, 1, def)
+			DrawText(search_buffer.Line(0), loc.X, loc.Y, o.Size.X, 1, windowTabSize(o.Pos), def)
 
 			x := loc.X
 			y := loc.Y+1
@@ -581,9 +971,9 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 				y_start := y + offset
 
 				if optindex == option {
-					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, rev)
+					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, windowTabSize(o.Pos), rev)
 				} else {
-					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, def)
+					offset += DrawText(opt.Label(), x, y+offset, o.Size.X, o.Size.Y-offset, windowTabSize(o.Pos), def)
 				}
 
 				if contains_mouse && my >= y_start && my < y+offset {
@@ -597,8 +987,12 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 			switch e := ev.(type) {
 			case *tcell.EventKey:
 				if e.Key() == tcell.KeyEnter {
-					onSelect(options[option])
 					o.Remove()
+					chooseOption(options[option], SelectPrimary, onSelect)
+					return true
+				} else if e.Key() == tcell.KeyTab && secondary {
+					o.Remove()
+					chooseOption(options[option], SelectSecondary, onSelect)
 					return true
 				} else if e.Key() == tcell.KeyUp {
 					option = (option-1+len(options)) % len(options)
@@ -608,10 +1002,6 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 					option = (option+1) % len(options)
 					scroll = util.Clamp(option-5, 0, len(options)-10)
 					return true
-				} else if e.Key() == tcell.KeyEnter {
-					onSelect(options[option])
-					o.Remove()
-					return true
 				} else if e.Key() == tcell.KeyRune {
 					for _, c := range search_buffer.GetCursors() {
 						search_buffer.SetCurCursor(c.Num)
@@ -630,8 +1020,11 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 				if !o.Contains(mx, my) { return false }
 				b := e.Buttons()
 				if my > o.Pos.ScreenPos().Y && b == tcell.Button1 {
-					onSelect(options[option])
 					o.Remove()
+					chooseOption(options[option], SelectPrimary, onSelect)
+				} else if my > o.Pos.ScreenPos().Y && b == tcell.Button2 && secondary {
+					o.Remove()
+					chooseOption(options[option], SelectSecondary, onSelect)
 				} else if b == tcell.WheelUp {
 					scroll = util.Clamp(scroll-1, 0, len(options)-10)
 				} else if b == tcell.WheelDown {
@@ -647,3 +1040,466 @@ func SearchMenu[K SelectOption](options []K, onSelect func(K), op OverlayPositio
 		search_buffer.Close()
 	}
 }
+
+// Preview is the text shown beside the selected option of a PreviewMenu.
+// The line at index Focus is highlighted, if it exists
+type Preview struct {
+	Lines []string
+	Focus int
+}
+
+// PreviewMenu is like SelectMenu, but also shows a preview of the selected
+// option on the right of the list. Previews are only computed once, when
+// their option is first selected
+func PreviewMenu[K SelectOption](options []K, preview func(K) Preview, onSelect func(K), op OverlayPosition) {
+	option := 0
+	scroll := 0
+	height := util.Min(len(options), 10)
+
+	listw := 0
+	for _, opt := range options {
+		listw = util.Max(listw, runewidth.StringWidth(opt.Label()))
+	}
+	listw = util.Min(listw+1, 50)
+	previeww := 60
+
+	previews := make(map[int]Preview)
+	getPreview := func(i int) Preview {
+		p, ok := previews[i]
+		if !ok {
+			p = preview(options[i])
+			previews[i] = p
+		}
+		return p
+	}
+
+	moveTo := func(i int) {
+		option = (i + len(options)) % len(options)
+		scroll = util.Clamp(option-5, 0, util.Max(len(options)-10, 0))
+	}
+
+	NewOverlay(
+		"preview_menu", op, Loc{X: listw+previeww, Y: height}, OBReplace,
+
+		func (o *Overlay) {
+			def, rev := menuStyles()
+			tip := config.DefStyle.Reverse(true)
+			if s, ok := config.Colorscheme["tooltip"]; ok {
+				tip = s
+			}
+			tabsize := windowTabSize(o.Pos)
+
+			p := getPreview(option)
+			o.Resize(listw+previeww, util.Max(height, len(p.Lines)))
+			lw := util.Min(listw, o.Size.X)
+
+			loc := o.ScreenPos()
+			DrawClear(loc.X, loc.Y, lw, o.Size.Y, def)
+			for i := 0; i < o.Size.Y && scroll+i < len(options); i++ {
+				style := def
+				if scroll+i == option { style = rev }
+				DrawText(options[scroll+i].Label(), loc.X, loc.Y+i, lw, 1, tabsize, style, DTEllipsis)
+			}
+
+			DrawClear(loc.X+lw, loc.Y, o.Size.X-lw, o.Size.Y, tip)
+			for i, line := range p.Lines {
+				if i >= o.Size.Y { break }
+				style := tip
+				if i == p.Focus { style = rev }
+				DrawText(line, loc.X+lw+1, loc.Y+i, o.Size.X-lw-1, 1, tabsize, style, DTEllipsis)
+			}
+		},
+
+		func (o *Overlay, ev tcell.Event) bool {
+			switch e := ev.(type) {
+			case *tcell.EventKey:
+				switch e.Key() {
+				case tcell.KeyEnter:
+					o.Remove()
+					onSelect(options[option])
+				case tcell.KeyUp:
+					moveTo(option-1)
+				case tcell.KeyDown:
+					moveTo(option+1)
+				case tcell.KeyEscape, tcell.KeyCtrlC:
+					o.Remove()
+				default:
+					return false
+				}
+				return true
+			case *tcell.EventMouse:
+				mx, my := e.Position()
+				if !o.Contains(mx, my) { return false }
+				loc := o.ScreenPos()
+				switch e.Buttons() {
+				case tcell.Button1:
+					if i := scroll + my - loc.Y; mx < loc.X+listw && i < len(options) {
+						moveTo(i)
+					}
+				case tcell.WheelUp:
+					scroll = util.Clamp(scroll-1, 0, util.Max(len(options)-10, 0))
+				case tcell.WheelDown:
+					scroll = util.Clamp(scroll+1, 0, util.Max(len(options)-10, 0))
+				}
+				return true
+			}
+			return false
+		},
+	)
+}
+
+// MultiSelectMenu opens a modal list of options that are all checked at
+// first. Space or a click toggles the selected option, Enter calls onConfirm
+// with the checked options in order and Escape closes the menu without
+// calling it. The title is shown above the options. Nothing is opened when
+// there are no options
+func MultiSelectMenu[K SelectOption](title string, options []K, onConfirm func([]K), op OverlayPosition) {
+	if len(options) == 0 { return }
+
+	option := 0
+	scroll := 0
+	height := util.Min(len(options), 10)
+
+	checked := make([]bool, len(options))
+	width := runewidth.StringWidth(title)
+	for i, opt := range options {
+		checked[i] = true
+		width = util.Max(width, runewidth.StringWidth(opt.Label())+4)
+	}
+	width = util.Min(width+2, 80)
+
+	moveTo := func(i int) {
+		option = (i + len(options)) % len(options)
+		scroll = util.Clamp(option-5, 0, util.Max(len(options)-10, 0))
+	}
+
+	o := NewOverlay(
+		"multi_select_menu", op, Loc{X: width, Y: height+1}, OBReplace,
+
+		func (o *Overlay) {
+			def, rev := menuStyles()
+			tabsize := windowTabSize(o.Pos)
+			loc := o.ScreenPos()
+			DrawClear(loc.X, loc.Y, o.Size.X, o.Size.Y, def)
+
+			DrawText(title, loc.X+1, loc.Y, o.Size.X-1, 1, tabsize, def.Bold(true), DTEllipsis)
+			for i := 0; i+1 < o.Size.Y && scroll+i < len(options); i++ {
+				style := def
+				if scroll+i == option { style = rev }
+				box := "[ ] "
+				if checked[scroll+i] { box = "[x] " }
+				DrawText(box+options[scroll+i].Label(), loc.X, loc.Y+1+i, o.Size.X, 1, tabsize, style, DTEllipsis)
+			}
+		},
+
+		func (o *Overlay, ev tcell.Event) bool {
+			switch e := ev.(type) {
+			case *tcell.EventKey:
+				switch {
+				case e.Key() == tcell.KeyEnter:
+					var selected []K
+					for i, opt := range options {
+						if checked[i] { selected = append(selected, opt) }
+					}
+					// removed first so that the callback can open another overlay
+					o.Remove()
+					onConfirm(selected)
+				case e.Key() == tcell.KeyUp:
+					moveTo(option-1)
+				case e.Key() == tcell.KeyDown:
+					moveTo(option+1)
+				case e.Key() == tcell.KeyRune && e.Rune() == ' ':
+					checked[option] = !checked[option]
+				case e.Key() == tcell.KeyEscape || e.Key() == tcell.KeyCtrlC:
+					o.Remove()
+				}
+				return true
+			case *tcell.EventMouse:
+				mx, my := e.Position()
+				if !o.Contains(mx, my) { return true }
+				switch e.Buttons() {
+				case tcell.Button1:
+					if i := scroll + my - o.ScreenPos().Y - 1; i >= scroll && i < len(options) {
+						moveTo(i)
+						checked[i] = !checked[i]
+					}
+				case tcell.WheelUp:
+					scroll = util.Clamp(scroll-1, 0, util.Max(len(options)-10, 0))
+				case tcell.WheelDown:
+					scroll = util.Clamp(scroll+1, 0, util.Max(len(options)-10, 0))
+				}
+				return true
+			}
+			return false
+		},
+	)
+	o.Modal = true
+}
+
+// PeekLine is a line shown by Peek. Styles maps character indices to the
+// style used from that character on, like the matches of the highlighter
+type PeekLine struct {
+	Text   string
+	Styles map[int]tcell.Style
+}
+
+// drawPeekLine draws a line in at most w cells, starting with the style
+// base, and ends it with an ellipsis if it doesn't fit
+func drawPeekLine(l PeekLine, x, y, w, tabsize int, base tcell.Style) {
+	style := base
+	col := 0
+	text := l.Text
+	for i := 0; len(text) > 0; i++ {
+		r, combc, size := util.DecodeCharacterInString(text)
+		text = text[size:]
+		if s, ok := l.Styles[i]; ok { style = s }
+
+		rw := runewidth.RuneWidth(r)
+		if r == '\t' {
+			r, combc, rw = ' ', nil, tabsize-col%tabsize
+		}
+		if col+rw > w {
+			screen.SetContent(x+w-1, y, '…', nil, style)
+			return
+		}
+		for j := 0; j < rw; j++ {
+			if j == 0 {
+				screen.SetContent(x+col, y, r, combc, style)
+			} else {
+				screen.SetContent(x+col+j, y, ' ', nil, style)
+			}
+		}
+		col += rw
+	}
+}
+
+// Peek shows lines of a file below a title, without leaving the current
+// buffer. first is the number of the first line, starting at 0, and the
+// number of the line at index focus is highlighted. Up, Down, PageUp and
+// PageDown scroll the lines, Enter closes the overlay and calls onEnter,
+// Escape closes it and other keys close it and are handled as usual
+func Peek(title string, lines []PeekLine, first, focus int, onEnter func(), op OverlayPosition) {
+	scroll := 0
+	height := util.Min(len(lines), 12)
+	tabsize := effectiveTabSize(windowTabSize(op))
+
+	numw := len(strconv.Itoa(first+len(lines))) + 1
+	width := runewidth.StringWidth(title) + 2
+	for _, l := range lines {
+		w := runewidth.StringWidth(l.Text) + strings.Count(l.Text, "\t")*(tabsize-1)
+		width = util.Max(width, numw+w+1)
+	}
+	width = util.Min(width, 80)
+
+	maxScroll := util.Max(len(lines)-height, 0)
+	scrollTo := func(i int) {
+		scroll = util.Clamp(i, 0, maxScroll)
+	}
+
+	NewOverlay(
+		"peek", op, Loc{X: width, Y: height+1}, OBReplace,
+
+		func (o *Overlay) {
+			def, rev := menuStyles()
+			loc := o.ScreenPos()
+
+			DrawClear(loc.X, loc.Y, o.Size.X, 1, def)
+			DrawText(title, loc.X+1, loc.Y, o.Size.X-1, 1, tabsize, def, DTEllipsis)
+
+			DrawClear(loc.X, loc.Y+1, o.Size.X, o.Size.Y-1, config.DefStyle)
+			for i := 0; i < o.Size.Y-1 && scroll+i < len(lines); i++ {
+				style := def
+				if scroll+i == focus { style = rev }
+				num := fmt.Sprintf("%*d ", numw-1, first+scroll+i+1)
+				DrawText(num, loc.X, loc.Y+1+i, numw, 1, tabsize, style)
+				drawPeekLine(lines[scroll+i], loc.X+numw, loc.Y+1+i, o.Size.X-numw, tabsize, config.DefStyle)
+			}
+		},
+
+		func (o *Overlay, ev tcell.Event) bool {
+			switch e := ev.(type) {
+			case *tcell.EventKey:
+				switch e.Key() {
+				case tcell.KeyUp:
+					scrollTo(scroll-1)
+				case tcell.KeyDown:
+					scrollTo(scroll+1)
+				case tcell.KeyPgUp:
+					scrollTo(scroll-height)
+				case tcell.KeyPgDn:
+					scrollTo(scroll+height)
+				case tcell.KeyEnter:
+					o.Remove()
+					onEnter()
+				case tcell.KeyEscape, tcell.KeyCtrlC:
+					o.Remove()
+				default:
+					o.Remove()
+					return false
+				}
+				return true
+			case *tcell.EventMouse:
+				mx, my := e.Position()
+				if !o.Contains(mx, my) {
+					o.Remove()
+					return false
+				}
+				switch e.Buttons() {
+				case tcell.WheelUp:
+					scrollTo(scroll-1)
+				case tcell.WheelDown:
+					scrollTo(scroll+1)
+				}
+				return true
+			}
+			return false
+		},
+	)
+}
+
+// menuStyles returns the default and highlighted styles used by menus
+func menuStyles() (tcell.Style, tcell.Style) {
+	def := config.DefStyle.Reverse(true)
+	rev := config.DefStyle
+	if style, ok:= config.Colorscheme["statusline"]; ok {
+		def = style
+		rev = style.Reverse(true)
+	}
+	return def, rev
+}
+
+// ConfirmMenu opens a modal overlay asking a yes/no question. Pressing y
+// calls onYes, pressing n or Escape calls onNo. Either callback may be nil
+func ConfirmMenu(prompt string, onYes, onNo func(), op OverlayPosition) {
+	text := prompt + " (y/n)"
+
+	o := NewOverlay(
+		"confirm_menu", op, Loc{X: runewidth.StringWidth(text)+2, Y: 1}, OBReplace,
+
+		func (o *Overlay) {
+			def, _ := menuStyles()
+			loc := o.ScreenPos()
+			DrawClear(loc.X, loc.Y, o.Size.X, o.Size.Y, def)
+			DrawText(text, loc.X+1, loc.Y, o.Size.X-1, o.Size.Y, windowTabSize(o.Pos), def, DTEllipsis)
+		},
+
+		func (o *Overlay, ev tcell.Event) bool {
+			e, ok := ev.(*tcell.EventKey)
+			if !ok { return false }
+
+			var f func()
+			switch {
+			case e.Key() == tcell.KeyRune && (e.Rune() == 'y' || e.Rune() == 'Y'):
+				f = onYes
+			case e.Key() == tcell.KeyRune && (e.Rune() == 'n' || e.Rune() == 'N'):
+				f = onNo
+			case e.Key() == tcell.KeyEscape || e.Key() == tcell.KeyCtrlC:
+				f = onNo
+			default:
+				return true
+			}
+
+			// removed first so that the callback can open another overlay
+			o.Remove()
+			if f != nil { f() }
+			return true
+		},
+	)
+	o.Modal = true
+}
+
+// PromptMenu opens a modal overlay asking for a line of text, starting with
+// initial. Enter calls onSubmit with the text, Escape closes the prompt
+// without calling it
+func PromptMenu(prompt, initial string, onSubmit func(string), op OverlayPosition) {
+	PromptMenuValidate(prompt, initial, func(text string) error {
+		onSubmit(text)
+		return nil
+	}, op)
+}
+
+// PromptMenuValidate is like PromptMenu, but if onSubmit returns an error
+// the prompt stays open and shows the error below the input until it is
+// edited
+func PromptMenuValidate(prompt, initial string, onSubmit func(string) error, op OverlayPosition) {
+	input_buffer := buffer.NewBufferFromString(initial, "", buffer.BTScratch)
+	input_buffer.GetActiveCursor().End()
+
+	prompt += " "
+	width := util.Max(runewidth.StringWidth(prompt+initial), 20) + 2
+	var submitErr error
+
+	o := NewOverlay(
+		"prompt_menu", op, Loc{X: width, Y: 1}, OBReplace,
+
+		func (o *Overlay) {
+			def, rev := menuStyles()
+			loc := o.ScreenPos()
+			DrawClear(loc.X, loc.Y, o.Size.X, o.Size.Y, def)
+
+			line := input_buffer.Line(0)
+			DrawText(prompt+line, loc.X+1, loc.Y, o.Size.X-1, 1, windowTabSize(o.Pos), def)
+
+			c := input_buffer.GetActiveCursor()
+			cx := loc.X + 1 + runewidth.StringWidth(prompt + util.SliceStartStr(line, c.X))
+			if cx < loc.X + o.Size.X {
+				r, combc, _ := util.DecodeCharacterInString(util.SliceEndStr(line, c.X) + " ")
+				screen.SetContent(cx, loc.Y, r, combc, rev)
+			}
+
+			if submitErr != nil && o.Size.Y > 1 {
+				errStyle := def
+				if s, ok := config.Colorscheme["error-message"]; ok { errStyle = s }
+				DrawText(submitErr.Error(), loc.X+1, loc.Y+1, o.Size.X-1, 1, windowTabSize(o.Pos), errStyle, DTEllipsis)
+			}
+		},
+
+		func (o *Overlay, ev tcell.Event) bool {
+			e, ok := ev.(*tcell.EventKey)
+			if !ok { return false }
+
+			c := input_buffer.GetActiveCursor()
+			switch e.Key() {
+			case tcell.KeyEnter:
+				if submitErr = onSubmit(input_buffer.Line(0)); submitErr != nil {
+					o.Resize(util.Max(width, runewidth.StringWidth(submitErr.Error())+2), 2)
+					return true
+				}
+				o.Remove()
+				return true
+			case tcell.KeyEscape, tcell.KeyCtrlC:
+				o.Remove()
+				return true
+			case tcell.KeyLeft:
+				c.Left()
+			case tcell.KeyRight:
+				c.Right()
+			case tcell.KeyHome, tcell.KeyCtrlA:
+				c.Start()
+			case tcell.KeyEnd, tcell.KeyCtrlE:
+				c.End()
+			case tcell.KeyBackspace, tcell.KeyBackspace2:
+				if c.X > 0 { input_buffer.Remove(c.Loc.Move(-1, input_buffer), c.Loc) }
+			case tcell.KeyDelete:
+				if c.X < util.CharacterCountInString(input_buffer.Line(0)) {
+					input_buffer.Remove(c.Loc, c.Loc.Move(1, input_buffer))
+				}
+			case tcell.KeyRune:
+				input_buffer.Insert(c.Loc, string(e.Rune()))
+			default:
+				return true
+			}
+
+			if submitErr != nil {
+				submitErr = nil
+				o.Resize(width, 1)
+			}
+			return true
+		},
+	)
+	o.Modal = true
+
+	o.CleanupHandler = func(o *Overlay) {
+		input_buffer.Close()
+	}
+}
@@ -0,0 +1,651 @@
+package overlay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zyedidia/micro/v2/internal/config"
+	. "github.com/zyedidia/micro/v2/internal/loc"
+	"github.com/zyedidia/micro/v2/internal/screen"
+	ulua "github.com/zyedidia/micro/v2/internal/lua"
+	"github.com/zyedidia/tcell/v2"
+)
+
+func init() {
+	ulua.L = lua.NewState()
+	config.InitGlobalSettings()
+	screen.InitSimScreen()
+}
+
+func TestOverlayStacking(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	var hits []string
+	handler := func(o *Overlay, ev tcell.Event) bool {
+		hits = append(hits, o.ID)
+		return true
+	}
+
+	NewOverlayStatic("select_menu", Loc{X: 0, Y: 0}, Loc{X: 10, Y: 10}, OBAdd, func(*Overlay) {}, handler)
+	NewOverlayStatic("tooltip", Loc{X: 5, Y: 5}, Loc{X: 10, Y: 10}, OBAdd, func(*Overlay) {}, handler)
+
+	ev := tcell.NewEventMouse(6, 6, tcell.Button1, tcell.ModNone, "")
+	for i := 0; i < 100; i++ {
+		assert.True(t, HandleOverlayEvent(ev))
+	}
+	for _, id := range hits {
+		assert.Equal(t, "tooltip", id)
+	}
+
+	stack := StackedOverlays()
+	assert.Equal(t, 2, len(stack))
+	assert.Equal(t, "select_menu", stack[0].ID)
+	assert.Equal(t, "tooltip", stack[1].ID)
+
+	// Replacing an overlay moves it to the top
+	NewOverlayStatic("select_menu", Loc{X: 0, Y: 0}, Loc{X: 10, Y: 10}, OBReplace, func(*Overlay) {}, handler)
+	hits = nil
+	HandleOverlayEvent(ev)
+	assert.Equal(t, []string{"select_menu"}, hits)
+}
+
+type fakeWindow struct {
+	closed bool
+	// the visible lines, all of them if both are 0
+	top, bottom int
+}
+
+func (w *fakeWindow) CursorVisual() Loc        { return Loc{X: 0, Y: 0} }
+func (w *fakeWindow) IsActive() bool           { return !w.closed }
+func (w *fakeWindow) IsClosed() bool           { return w.closed }
+func (w *fakeWindow) LocToVisual(x, y int) Loc { return Loc{X: x, Y: y} }
+func (w *fakeWindow) LocVisible(x, y int) bool {
+	return w.top == w.bottom || (y >= w.top && y < w.bottom)
+}
+
+func TestOverlayClosedWindow(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	w := &fakeWindow{}
+	GetCurrentBufWindow = func() BufWindow { return w }
+
+	cleaned := false
+	o := NewOverlayAnchored("popup", w, Loc{X: 0, Y: 0}, Loc{X: 5, Y: 1}, OBAdd, func(*Overlay) {}, nil)
+	o.CleanupHandler = func(*Overlay) { cleaned = true }
+	assert.True(t, o.Pos.Visible())
+
+	w.closed = true
+	assert.False(t, o.Pos.Visible())
+	assert.False(t, CursorAnchor{w}.Visible())
+	assert.False(t, CursorAnchor{nil}.Visible())
+
+	DisplayOverlays()
+	assert.Equal(t, 0, len(FindOverlays("popup")))
+	assert.True(t, cleaned)
+}
+
+func TestOverlayAnchorScrolledAway(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	w := &fakeWindow{top: 0, bottom: 10}
+	GetCurrentBufWindow = func() BufWindow { return w }
+
+	drawn := 0
+	NewOverlayAnchored("popup", w, Loc{X: 0, Y: 5}, Loc{X: 5, Y: 1}, OBAdd, func(*Overlay) { drawn++ }, nil)
+	DisplayOverlays()
+	assert.Equal(t, 1, drawn)
+
+	// the overlay is hidden while its line is scrolled out of the window,
+	// and comes back when it is scrolled into view again
+	w.top, w.bottom = 6, 16
+	DisplayOverlays()
+	assert.Equal(t, 1, drawn)
+	assert.False(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, "")))
+
+	w.top, w.bottom = 2, 12
+	DisplayOverlays()
+	assert.Equal(t, 2, drawn)
+}
+
+func TestOverlayContains(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	o := NewOverlayStatic("box", Loc{X: 10, Y: 5}, Loc{X: 4, Y: 3}, OBAdd, func(*Overlay) {}, nil)
+
+	// corners
+	assert.True(t, o.Contains(10, 5))
+	assert.True(t, o.Contains(13, 5))
+	assert.True(t, o.Contains(10, 7))
+	assert.True(t, o.Contains(13, 7))
+
+	// just outside each edge
+	assert.False(t, o.Contains(9, 6))
+	assert.False(t, o.Contains(14, 6))
+	assert.False(t, o.Contains(11, 4))
+	assert.False(t, o.Contains(11, 8))
+}
+
+func TestDrawTextTabs(t *testing.T) {
+	runeAt := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+
+	DrawText("a\tb", 0, 0, 20, 1, 2, tcell.StyleDefault)
+	assert.Equal(t, 'a', runeAt(0, 0))
+	assert.Equal(t, ' ', runeAt(1, 0))
+	assert.Equal(t, 'b', runeAt(2, 0))
+
+	DrawText("a\tb", 0, 0, 20, 1, 8, tcell.StyleDefault)
+	assert.Equal(t, ' ', runeAt(2, 0))
+	assert.Equal(t, 'b', runeAt(8, 0))
+
+	// without a tabsize, the global one is used
+	defer func(old interface{}) { config.GlobalSettings["tabsize"] = old }(config.GlobalSettings["tabsize"])
+	config.GlobalSettings["tabsize"] = float64(4)
+	DrawText("\tb", 0, 0, 20, 1, 0, tcell.StyleDefault)
+	assert.Equal(t, 'b', runeAt(4, 0))
+
+	wrapped, width, _ := Text_Wrapped_MaxLineWidth_TotalLines("a\tb", 20, 2)
+	assert.Equal(t, "a b", wrapped)
+	assert.Equal(t, 3, width)
+	wrapped, _, _ = Text_Wrapped_MaxLineWidth_TotalLines("a\tb", 20, 8)
+	assert.Equal(t, "a       b", wrapped)
+}
+
+func TestWrapWideRunes(t *testing.T) {
+	// every character is two columns wide and three bytes long
+	wrapped, width, lines := Text_Wrapped_MaxLineWidth_TotalLines("中文中文中文中文", 10, 4)
+	assert.Equal(t, "中文中文中\n文中文", wrapped)
+	assert.Equal(t, 10, width)
+	assert.Equal(t, 2, lines)
+
+	wrapped, width, lines = Text_Wrapped_MaxLineWidth_TotalLines("ab 中文 文中", 8, 4)
+	assert.Equal(t, "ab 中文 \n文中", wrapped)
+	assert.Equal(t, 8, width)
+	assert.Equal(t, 2, lines)
+
+	wrapped, width, lines = Text_Wrapped_MaxLineWidth_TotalLines("abc 中文中文", 8, 4)
+	assert.Equal(t, "abc \n中文中文", wrapped)
+	assert.Equal(t, 8, width)
+	assert.Equal(t, 2, lines)
+}
+
+func TestOverlayShiftsLeft(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	w, _ := screen.Screen.Size()
+
+	o := NewOverlayStatic("box", Loc{X: w - 10, Y: 5}, Loc{X: 20, Y: 3}, OBAdd, func(*Overlay) {}, nil)
+	assert.Equal(t, Loc{X: 20, Y: 3}, o.Size)
+	assert.Equal(t, Loc{X: w - 20, Y: 5}, o.ScreenPos())
+	assert.True(t, o.Contains(w-1, 5))
+	assert.False(t, o.Contains(w-21, 5))
+
+	// an overlay that fits stays at its anchor
+	o.Resize(5, 3)
+	assert.Equal(t, Loc{X: w - 10, Y: 5}, o.ScreenPos())
+
+	// wider than the screen
+	o.Resize(w+10, 3)
+	assert.Equal(t, w, o.Size.X)
+	assert.Equal(t, Loc{X: 0, Y: 5}, o.ScreenPos())
+}
+
+func TestOverlayHide(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	var drawn, handled []string
+	draw := func(o *Overlay) { drawn = append(drawn, o.ID) }
+	handler := func(o *Overlay, ev tcell.Event) bool {
+		handled = append(handled, o.ID)
+		return true
+	}
+
+	NewOverlayStatic("completion", Loc{X: 0, Y: 0}, Loc{X: 10, Y: 10}, OBAdd, draw, handler)
+	confirm := NewOverlayStatic("confirm", Loc{X: 0, Y: 0}, Loc{X: 10, Y: 10}, OBAdd, draw, handler)
+	completion := FindOverlays("completion")[0]
+
+	completion.Hide()
+	DisplayOverlays()
+	assert.Equal(t, []string{"confirm"}, drawn)
+
+	confirm.Remove()
+	ev := tcell.NewEventMouse(1, 1, tcell.Button1, tcell.ModNone, "")
+	assert.False(t, HandleOverlayEvent(ev))
+	assert.Empty(t, handled)
+
+	completion.Show()
+	drawn = nil
+	DisplayOverlays()
+	assert.Equal(t, []string{"completion"}, drawn)
+	assert.True(t, HandleOverlayEvent(ev))
+	assert.Equal(t, []string{"completion"}, handled)
+}
+
+func TestDrawTextEllipsis(t *testing.T) {
+	runeAt := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+
+	lines, truncated := DrawTextTruncated("abcdefgh", 0, 0, 4, 2, 4, tcell.StyleDefault)
+	assert.Equal(t, 2, lines)
+	assert.False(t, truncated)
+	assert.Equal(t, 'h', runeAt(3, 1))
+
+	_, truncated = DrawTextTruncated("abcdefghij", 0, 0, 4, 2, 4, tcell.StyleDefault, DTEllipsis)
+	assert.True(t, truncated)
+	assert.Equal(t, 'g', runeAt(2, 1))
+	assert.Equal(t, '…', runeAt(3, 1))
+
+	// the ellipsis goes right after a short last line
+	_, truncated = DrawTextTruncated("ab\ncd\nef", 0, 0, 4, 2, 4, tcell.StyleDefault, DTEllipsis)
+	assert.True(t, truncated)
+	assert.Equal(t, 'd', runeAt(1, 1))
+	assert.Equal(t, '…', runeAt(2, 1))
+
+	// without the option, nothing marks the cut
+	DrawText("abcdefghij", 0, 0, 4, 2, 4, tcell.StyleDefault)
+	assert.Equal(t, 'h', runeAt(3, 1))
+}
+
+func TestWrapBreakChars(t *testing.T) {
+	wrapped, width, lines := Text_Wrapped_MaxLineWidth_TotalLines(
+		"see https://example.com/some/long/path/to/a/page for details", 20, 4)
+	assert.Equal(t, "see https://example.\ncom/some/long/path/\nto/a/page for \ndetails", wrapped)
+	assert.Equal(t, 20, width)
+	assert.Equal(t, 4, lines)
+
+	wrapped, _, _ = Text_Wrapped_MaxLineWidth_TotalLines(
+		"func Lookup(name string, options map[string]interface{}) error", 30, 4)
+	assert.Equal(t, "func Lookup(name string, \noptions \nmap[string]interface{}) error", wrapped)
+
+	// words without break characters are still split when too long
+	wrapped, _, _ = Text_Wrapped_MaxLineWidth_TotalLines("averyveryverylongidentifier", 10, 4)
+	assert.Equal(t, "averyveryv\nerylongide\nntifier", wrapped)
+
+	old := WrapBreakChars
+	defer func() { WrapBreakChars = old }()
+	WrapBreakChars = ""
+	wrapped, _, _ = Text_Wrapped_MaxLineWidth_TotalLines("see https://example.com/some/long", 20, 4)
+	assert.Equal(t, "see \nhttps://example.com/\nsome/long", wrapped)
+}
+
+func TestConfirmMenu(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	below := 0
+	NewOverlayStatic("popup", Loc{X: 0, Y: 0}, Loc{X: 10, Y: 10}, OBAdd, func(*Overlay) {}, func(_ *Overlay, ev tcell.Event) bool {
+		if _, ok := ev.(*tcell.EventResize); ok { return false }
+		below++
+		return true
+	})
+
+	answer := ""
+	confirm := func() {
+		ConfirmMenu("Apply?", func() { answer = "yes" }, func() { answer = "no" }, V2{Loc{X: 0, Y: 0}})
+	}
+
+	confirm()
+	// the modal menu swallows events it doesn't handle
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone, "")))
+	assert.True(t, HandleOverlayEvent(tcell.NewEventMouse(1, 1, tcell.Button1, tcell.ModNone, "")))
+	assert.Equal(t, 0, below)
+	assert.Equal(t, "", answer)
+	// resizes go past the modal menu to the rest of micro
+	assert.False(t, HandleOverlayEvent(tcell.NewEventResize(80, 24)))
+
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModNone, ""))
+	assert.Equal(t, "yes", answer)
+	assert.Equal(t, 0, len(FindOverlays("confirm_menu")))
+
+	confirm()
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone, ""))
+	assert.Equal(t, "no", answer)
+	assert.Equal(t, 0, len(FindOverlays("confirm_menu")))
+
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone, ""))
+	assert.Equal(t, 1, below)
+}
+
+func TestPromptMenu(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	submitted := ""
+	PromptMenu("Rename to:", "foo", func(s string) { submitted = s }, V2{Loc{X: 0, Y: 0}})
+	DisplayOverlays()
+
+	for _, ev := range []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyHome, 0, tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyDelete, 0, tcell.ModNone, ""),
+	} {
+		assert.True(t, HandleOverlayEvent(ev))
+	}
+	DisplayOverlays()
+
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, ""))
+	assert.Equal(t, "aox", submitted)
+	assert.Equal(t, 0, len(FindOverlays("prompt_menu")))
+
+	submitted = ""
+	PromptMenu("Rename to:", "foo", func(s string) { submitted = s }, V2{Loc{X: 0, Y: 0}})
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone, ""))
+	assert.Equal(t, "", submitted)
+	assert.Equal(t, 0, len(FindOverlays("prompt_menu")))
+}
+
+func TestPromptMenuValidate(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	var submitted []string
+	PromptMenuValidate("Goto:", "x", func(s string) error {
+		submitted = append(submitted, s)
+		if s == "x" { return errors.New("Invalid line number: x") }
+		return nil
+	}, V2{Loc{X: 0, Y: 0}})
+
+	enter := tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, "")
+	HandleOverlayEvent(enter)
+	o := FindOverlays("prompt_menu")
+	assert.Equal(t, 1, len(o))
+	assert.Equal(t, 2, o[0].Size.Y)
+	DisplayOverlays()
+
+	// editing the input hides the error
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone, ""))
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, '4', tcell.ModNone, ""))
+	assert.Equal(t, 1, o[0].Size.Y)
+
+	HandleOverlayEvent(enter)
+	assert.Equal(t, []string{"x", "4"}, submitted)
+	assert.Equal(t, 0, len(FindOverlays("prompt_menu")))
+}
+
+func TestSectionTooltip(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	red := tcell.StyleDefault.Foreground(tcell.ColorRed)
+	SectionTooltip([]TooltipSection{
+		{Header: "error: vet", HeaderStyle: red, Text: "first"},
+		{Header: "hint", HeaderStyle: red, Text: "second\nline"},
+	}, V2{Loc{X: 0, Y: 0}})
+	DisplayOverlays()
+
+	o := FindOverlays("tooltip")[0]
+	assert.Equal(t, Loc{X: 12, Y: 5}, o.Size)
+
+	r, _, style, _ := screen.Screen.GetContent(1, 0)
+	assert.Equal(t, 'e', r)
+	assert.Equal(t, red, style)
+	r, _, style, _ = screen.Screen.GetContent(1, 1)
+	assert.Equal(t, 'f', r)
+	assert.NotEqual(t, red, style)
+	r, _, _, _ = screen.Screen.GetContent(1, 4)
+	assert.Equal(t, 'l', r)
+
+	// any key closes the tooltip
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone, ""))
+	assert.Empty(t, FindOverlays("tooltip"))
+}
+
+func TestPreviewMenu(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	options := []SelectMenuOption[int]{{Value: 1, Text: "one"}, {Value: 2, Text: "two"}}
+	previewed := map[int]int{}
+	preview := func(o SelectMenuOption[int]) Preview {
+		previewed[o.Value]++
+		return Preview{Lines: []string{"a", "b", "c"}, Focus: o.Value}
+	}
+	selected := 0
+	PreviewMenu(options, preview, func(o SelectMenuOption[int]) { selected = o.Value }, V2{Loc{X: 0, Y: 0}})
+
+	DisplayOverlays()
+	DisplayOverlays()
+	o := FindOverlays("preview_menu")[0]
+	assert.Equal(t, 3, o.Size.Y)
+	assert.Equal(t, map[int]int{1: 1}, previewed)
+
+	// the preview is drawn right of the list, with the focused line highlighted
+	r, _, style, _ := screen.Screen.GetContent(5, 1)
+	assert.Equal(t, 'b', r)
+	_, _, other, _ := screen.Screen.GetContent(5, 0)
+	assert.NotEqual(t, other, style)
+
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone, "")))
+	DisplayOverlays()
+	assert.Equal(t, map[int]int{1: 1, 2: 1}, previewed)
+
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, "")))
+	assert.Equal(t, 2, selected)
+	assert.Empty(t, FindOverlays("preview_menu"))
+}
+
+func TestPeek(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	red := config.DefStyle.Foreground(tcell.ColorRed)
+	var lines []PeekLine
+	for i := 0; i < 20; i++ {
+		lines = append(lines, PeekLine{Text: "line"})
+	}
+	lines[3] = PeekLine{Text: "func\tf()", Styles: map[int]tcell.Style{0: red, 4: config.DefStyle}}
+
+	jumped := false
+	Peek("a.go:14", lines, 10, 3, func() { jumped = true }, V2{Loc{X: 0, Y: 0}})
+	DisplayOverlays()
+	o := FindOverlays("peek")[0]
+	assert.Equal(t, 13, o.Size.Y)
+
+	runeAt := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+	styleAt := func(x, y int) tcell.Style {
+		_, _, s, _ := screen.Screen.GetContent(x, y)
+		return s
+	}
+
+	// the title is followed by the numbered lines, with the focused line's
+	// number highlighted and the styles of the line applied
+	assert.Equal(t, 'a', runeAt(1, 0))
+	assert.Equal(t, '1', runeAt(0, 1))
+	assert.Equal(t, '4', runeAt(1, 4))
+	assert.NotEqual(t, styleAt(0, 1), styleAt(0, 4))
+	assert.Equal(t, 'f', runeAt(3, 4))
+	assert.Equal(t, red, styleAt(3, 4))
+	assert.Equal(t, 'f', runeAt(11, 4))
+	assert.Equal(t, config.DefStyle, styleAt(11, 4))
+
+	// the lines scroll, up to the last one
+	for i := 0; i < 20; i++ {
+		assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone, "")))
+	}
+	DisplayOverlays()
+	assert.Equal(t, '9', runeAt(1, 1))
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyPgUp, 0, tcell.ModNone, "")))
+	DisplayOverlays()
+	assert.Equal(t, '1', runeAt(0, 1))
+	assert.Equal(t, '1', runeAt(1, 1))
+
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, "")))
+	assert.True(t, jumped)
+	assert.Empty(t, FindOverlays("peek"))
+
+	// other keys close the overlay and are handled as usual
+	Peek("a.go:14", lines, 10, 3, func() {}, V2{Loc{X: 0, Y: 0}})
+	assert.False(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone, "")))
+	assert.Empty(t, FindOverlays("peek"))
+}
+
+func TestMultiSelectMenu(t *testing.T) {
+	defer RemoveAllOverlays()
+	runeAt := func(x, y int) rune {
+		r, _, _, _ := screen.Screen.GetContent(x, y)
+		return r
+	}
+
+	options := []SelectMenuOption[int]{{1, "one"}, {2, "two"}, {3, "three"}}
+	var selected []int
+	confirmed := false
+	open := func() {
+		MultiSelectMenu("Apply", options, func(opts []SelectMenuOption[int]) {
+			confirmed = true
+			selected = nil
+			for _, o := range opts {
+				selected = append(selected, o.Value)
+			}
+		}, V2{Loc{X: 0, Y: 0}})
+	}
+
+	open()
+	DisplayOverlays()
+	assert.Equal(t, '[', runeAt(0, 1))
+	assert.Equal(t, 'x', runeAt(1, 1))
+
+	// the second option is unchecked with space
+	for _, ev := range []tcell.Event{
+		tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone, ""),
+		tcell.NewEventKey(tcell.KeyRune, 'q', tcell.ModNone, ""),
+	} {
+		assert.True(t, HandleOverlayEvent(ev))
+	}
+	DisplayOverlays()
+	assert.Equal(t, ' ', runeAt(1, 2))
+
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, ""))
+	assert.True(t, confirmed)
+	assert.Equal(t, []int{1, 3}, selected)
+	assert.Empty(t, FindOverlays("multi_select_menu"))
+
+	confirmed = false
+	open()
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone, ""))
+	assert.False(t, confirmed)
+	assert.Empty(t, FindOverlays("multi_select_menu"))
+
+	// an empty list opens nothing
+	options = nil
+	open()
+	assert.Empty(t, FindOverlays("multi_select_menu"))
+	assert.False(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone, "")))
+	assert.False(t, confirmed)
+}
+
+func TestSelectMenuActions(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	ran := ""
+	options := []SelectOption{
+		MenuAction{Text: "copy", Run: func() { ran = "copy" }},
+		SelectMenuOption[int]{Value: 2, Text: "two"},
+	}
+	var chosen SelectOption
+	var trigger SelectTrigger
+	SelectMenuTriggered(options, func(o SelectOption, t SelectTrigger) {
+		chosen, trigger = o, t
+	}, V2{Loc{X: 0, Y: 0}})
+
+	// Enter runs the action of the option instead of the callback
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, ""))
+	assert.Equal(t, "copy", ran)
+	assert.Nil(t, chosen)
+	assert.Empty(t, FindOverlays("select_menu"))
+
+	SelectMenuTriggered(options, func(o SelectOption, t SelectTrigger) {
+		chosen, trigger = o, t
+	}, V2{Loc{X: 0, Y: 0}})
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone, ""))
+	assert.Equal(t, "copy", chosen.Label())
+	assert.Equal(t, SelectSecondary, trigger)
+
+	// the simple form ignores the secondary trigger
+	selected := 0
+	SearchMenu([]SelectMenuOption[int]{{Value: 1, Text: "one"}}, func(o SelectMenuOption[int]) {
+		selected = o.Value
+	}, V2{Loc{X: 0, Y: 0}})
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone, ""))
+	assert.Equal(t, 0, selected)
+	assert.NotEmpty(t, FindOverlays("search_menu"))
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, ""))
+	assert.Equal(t, 1, selected)
+}
+
+func TestContextMenu(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	ran := ""
+	actions := []MenuAction{
+		{Text: "Cut", Run: func() { ran = "cut" }, Disabled: true},
+		{Text: "Paste", Run: func() { ran = "paste" }},
+	}
+	enter := tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone, "")
+
+	// disabled actions can't be chosen
+	ContextMenu(actions, V2{Loc{X: 0, Y: 0}})
+	assert.True(t, HandleOverlayEvent(enter))
+	assert.Equal(t, "", ran)
+	assert.NotEmpty(t, FindOverlays("select_menu"))
+
+	HandleOverlayEvent(tcell.NewEventKey(tcell.KeyDown, 0, tcell.ModNone, ""))
+	HandleOverlayEvent(enter)
+	assert.Equal(t, "paste", ran)
+	assert.Empty(t, FindOverlays("select_menu"))
+
+	// the menu is closed by Escape or a click outside of it, which doesn't
+	// reach the buffer
+	ContextMenu(actions, V2{Loc{X: 0, Y: 0}})
+	assert.True(t, HandleOverlayEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone, "")))
+	assert.Empty(t, FindOverlays("select_menu"))
+
+	ContextMenu(actions, V2{Loc{X: 0, Y: 0}})
+	assert.True(t, HandleOverlayEvent(tcell.NewEventMouse(40, 10, tcell.Button1, tcell.ModNone, "")))
+	assert.Empty(t, FindOverlays("select_menu"))
+}
+
+func TestOverlayDrag(t *testing.T) {
+	defer RemoveAllOverlays()
+
+	events := 0
+	o := NewOverlayStatic("panel", Loc{X: 2, Y: 2}, Loc{X: 5, Y: 3}, OBAdd, func(*Overlay) {}, func(*Overlay, tcell.Event) bool {
+		events++
+		return true
+	})
+	mouse := func(x, y int, b tcell.ButtonMask) {
+		HandleOverlayEvent(tcell.NewEventMouse(x, y, b, tcell.ModNone, ""))
+	}
+
+	// overlays aren't draggable by default
+	mouse(3, 2, tcell.Button1)
+	mouse(6, 5, tcell.Button1)
+	assert.Equal(t, V2{Loc{X: 2, Y: 2}}, o.Pos)
+	assert.Equal(t, 2, events)
+
+	// only the top row grabs the overlay
+	o.SetDraggable(true)
+	mouse(3, 3, tcell.Button1)
+	mouse(6, 6, tcell.Button1)
+	mouse(6, 6, tcell.ButtonNone)
+	assert.Equal(t, V2{Loc{X: 2, Y: 2}}, o.Pos)
+
+	events = 0
+	mouse(3, 2, tcell.Button1)
+	mouse(6, 5, tcell.Button1)
+	mouse(6, 5, tcell.ButtonNone)
+	assert.Equal(t, V2{Loc{X: 5, Y: 5}}, o.Pos)
+	assert.Equal(t, 0, events)
+
+	// the overlay stays inside the screen
+	w, h := screen.Screen.Size()
+	mouse(5, 5, tcell.Button1)
+	mouse(w+10, h+10, tcell.Button1)
+	mouse(0, 0, tcell.ButtonNone)
+	assert.Equal(t, V2{Loc{X: w - 5, Y: h - 3}}, o.Pos)
+}
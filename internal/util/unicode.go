@@ -26,6 +26,13 @@ func isMark(r rune) bool {
 	return unicode.In(r, unicode.Mark)
 }
 
+// IsMark returns true if r is a combining code point. A mark is rendered
+// as part of the preceding character and does not count as a character
+// of its own
+func IsMark(r rune) bool {
+	return isMark(r)
+}
+
 // DecodeCharacter returns the next character from an array of bytes
 // A character is a rune along with any accompanying combining runes
 func DecodeCharacter(b []byte) (rune, []rune, int) {
@@ -153,7 +153,10 @@ func SliceVisualEnd(b []byte, n, tabsize int) ([]byte, int, int) {
 		}
 		width += w
 		b = b[size:]
-		i++
+		// a mark without a base character is not counted (see CharacterCount)
+		if !IsMark(r) {
+			i++
+		}
 	}
 	return b, n - width, i
 }
@@ -467,6 +470,17 @@ func IsNonAlphaNumeric(c rune) bool {
 	return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '_'
 }
 
+// NonWordCharFunc returns a function like IsNonAlphaNumeric that also
+// treats every rune in extra as part of a word
+func NonWordCharFunc(extra string) func(rune) bool {
+	if extra == "" {
+		return IsNonAlphaNumeric
+	}
+	return func(c rune) bool {
+		return IsNonAlphaNumeric(c) && !strings.ContainsRune(extra, c)
+	}
+}
+
 // IsAutocomplete returns whether a character should begin an autocompletion.
 func IsAutocomplete(c rune) bool {
 	return !unicode.IsSpace(c) || !IsNonAlphaNumeric(c)
@@ -31,3 +31,29 @@ func TestSliceVisualEnd(t *testing.T) {
 	assert.Equal(t, []byte("ello"), slc)
 	assert.Equal(t, 0, n)
 }
+
+func TestNonWordCharFunc(t *testing.T) {
+	isSep := NonWordCharFunc("")
+	assert.True(t, isSep('-'))
+	assert.False(t, isSep('_'))
+	assert.False(t, isSep('a'))
+
+	isSep = NonWordCharFunc("-$")
+	assert.False(t, isSep('-'))
+	assert.False(t, isSep('$'))
+	assert.True(t, isSep('.'))
+}
+
+func TestSliceVisualEndCombining(t *testing.T) {
+	s := []byte("e\u0301abc")
+	slc, n, i := SliceVisualEnd(s, 1, 4)
+	assert.Equal(t, []byte("abc"), slc)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, i)
+
+	s = []byte("\u0301abc")
+	slc, n, i = SliceVisualEnd(s, 1, 4)
+	assert.Equal(t, []byte("bc"), slc)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, i)
+}